@@ -20,6 +20,11 @@ fi
 
 for Dir in $(find ./* -maxdepth 10 -type d );
 do
+	# Submodules are tested separately.
+	if [[ -f $Dir/go.mod ]] || [[ $Dir == ./raft/* ]];
+	then
+		continue
+	fi
 	if ls $Dir/*.go &> /dev/null;
 	then
 		echo $Dir
@@ -0,0 +1,67 @@
+package logdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestAppendEntries_Allocs(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "append_entries_allocs", DefaultChunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assertSetSync(t, db, -1)
+
+	entries := make([][]byte, 64)
+	for i := range entries {
+		entries[i] = make([]byte, 64)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		assertAppendEntries(t, db, entries)
+	})
+	assert.True(t, allocs < 16, "expected a constant number of allocations per batch, got %v", allocs)
+}
+
+func benchAppendEntries(b *testing.B, syncEvery int) {
+	path := fmt.Sprintf("test_db/bench_append_entries_%v", syncEvery)
+	_ = os.RemoveAll(path)
+	defer os.RemoveAll(path)
+
+	db, err := Open(path, DefaultChunkSize, true)
+	if err != nil {
+		b.Fatal("could not open database:", err)
+	}
+	defer db.Close()
+	if err := db.SetSync(syncEvery); err != nil {
+		b.Fatal("could not set sync period:", err)
+	}
+
+	entries := make([][]byte, 64)
+	for i := range entries {
+		entries[i] = make([]byte, 64)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.AppendEntries(entries); err != nil {
+			b.Fatal("could not append:", err)
+		}
+		if db.NewestID() > 100000 {
+			b.StopTimer()
+			if err := db.Forget(db.NewestID()); err != nil {
+				b.Fatal("could not forget:", err)
+			}
+			b.StartTimer()
+		}
+	}
+}
+
+func BenchmarkAppendEntries_NoSync(b *testing.B) {
+	benchAppendEntries(b, -1)
+}
+
+func BenchmarkAppendEntries_Sync(b *testing.B) {
+	benchAppendEntries(b, 0)
+}
@@ -0,0 +1,9 @@
+//go:build 386 || amd64p32 || arm || armbe || mips || mipsle || mips64p32 || mips64p32le || ppc || riscv || s390 || sparc
+// +build 386 amd64p32 arm armbe mips mipsle mips64p32 mips64p32le ppc riscv s390 sparc
+
+package logdb
+
+// The largest chunk size. Chunks are mapped into memory, and on 32-bit platforms the address space is small,
+// so this is kept well below the limit of the offsets stored for entries. Chunks which there is not the
+// address space left to map are read without mmap.
+const maxChunkSize = 256 * 1024 * 1024
@@ -0,0 +1,9 @@
+//go:build !386 && !amd64p32 && !arm && !armbe && !mips && !mipsle && !mips64p32 && !mips64p32le && !ppc && !riscv && !s390 && !sparc
+// +build !386,!amd64p32,!arm,!armbe,!mips,!mipsle,!mips64p32,!mips64p32le,!ppc,!riscv,!s390,!sparc
+
+package logdb
+
+import "math"
+
+// The largest chunk size. Entries are located by their offsets in the chunk, which are stored as 'int32'.
+const maxChunkSize = math.MaxInt32
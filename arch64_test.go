@@ -0,0 +1,7 @@
+//go:build !386 && !amd64p32 && !arm && !armbe && !mips && !mipsle && !mips64p32 && !mips64p32le && !ppc && !riscv && !s390 && !sparc
+// +build !386,!amd64p32,!arm,!armbe,!mips,!mipsle,!mips64p32,!mips64p32le,!ppc,!riscv,!s390,!sparc
+
+package logdb
+
+// A chunk size large enough that the tests never fill a chunk.
+const bigChunkSize = 1024 * 1024 * 1024
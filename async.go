@@ -0,0 +1,71 @@
+package logdb
+
+import "context"
+
+// AppendAsync appends an entry without waiting for it to be synced. Once the entry has been synced, or if it
+// could not be appended, 'cb' is called with its ID and the error, if there was one, as 'Append' would return
+// them. This lets one goroutine keep many appends in flight, such as to pipeline writes from a replication
+// stream.
+//
+// The entry is appended before this returns, in the same way as by 'Append' (so it blocks for disk space if
+// 'SetBlockOnNoSpace' has been used), so reads see it straight away: only the sync is left to a background
+// goroutine. That runs while there are callbacks waiting, and syncs once for each batch of entries appended
+// while it was busy (whatever 'SetSync' says), unless another sync covered them first, so a callback is only
+// called once its entry is durable. The callbacks of a batch are called in the order their entries were
+// appended, from the background goroutine and without the lock held, so a slow callback holds up later
+// batches.
+func (db *ChunkDB) AppendAsync(entry []byte, cb func(id uint64, err error)) {
+	// The entry is queued while the write lock is held, so that the queue is in the order the entries were
+	// appended, but the queue lock is only held to add it, so that a slow append does not hold up others.
+	db.appendContextThen(context.Background(), [][]byte{entry}, 0, nil, func(id, syncs uint64, err error) {
+		db.queueAsync(asyncAppend{id: id, syncs: syncs, err: err, cb: cb})
+	})
+}
+
+////////// HELPERS //////////
+
+// An entry appended by 'AppendAsync', waiting to be synced: its ID, the number of syncs finished before it was
+// appended, and the error if it could not be appended.
+type asyncAppend struct {
+	id    uint64
+	syncs uint64
+	err   error
+	cb    func(uint64, error)
+}
+
+// Add an entry appended by 'AppendAsync' to the queue, starting the goroutine to sync it if need be.
+func (db *ChunkDB) queueAsync(queued asyncAppend) {
+	db.asyncLock.Lock()
+	defer db.asyncLock.Unlock()
+
+	db.asyncQueue = append(db.asyncQueue, queued)
+	if !db.asyncRunning {
+		db.asyncRunning = true
+		go db.syncQueued()
+	}
+}
+
+// Sync batches of entries appended by 'AppendAsync', and call their callbacks, until there are none left.
+func (db *ChunkDB) syncQueued() {
+	for {
+		db.asyncLock.Lock()
+		batch := db.asyncQueue
+		db.asyncQueue = nil
+		if len(batch) == 0 {
+			db.asyncRunning = false
+			db.asyncLock.Unlock()
+			return
+		}
+		db.asyncLock.Unlock()
+
+		// A sync which finished after the last entry was appended covers them all.
+		serr := db.commit(batch[len(batch)-1].syncs)
+		for _, queued := range batch {
+			err := queued.err
+			if err == nil {
+				err = serr
+			}
+			queued.cb(queued.id, err)
+		}
+	}
+}
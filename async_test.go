@@ -0,0 +1,90 @@
+package logdb
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestAppendAsync(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "append_async", chunkSize).(*ChunkDB)
+	assert.Nil(t, db.SetSync(-1), "expected no error in set sync")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var ids []uint64
+	for i := 0; i < numEntries; i++ {
+		wg.Add(1)
+		db.AppendAsync([]byte(fmt.Sprintf("entry-%v", i)), func(id uint64, err error) {
+			defer wg.Done()
+			assert.Nil(t, err, "expected no error in append")
+			mu.Lock()
+			ids = append(ids, id)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		assert.Equal(t, uint64(i+1), id, "expected the callbacks to be called in order")
+	}
+	db.rwlock.RLock()
+	assert.Equal(t, uint64(0), db.sinceLastSync, "expected every entry to be synced")
+	db.rwlock.RUnlock()
+	for i := 0; i < numEntries; i++ {
+		assert.Equal(t, []byte(fmt.Sprintf("entry-%v", i)), assertGet(t, db, uint64(i+1)), "expected the entry")
+	}
+
+	// Entries are visible as soon as they are appended, before they are synced.
+	done := make(chan struct{})
+	db.AppendAsync([]byte("read your writes"), func(uint64, error) { close(done) })
+	assert.Equal(t, uint64(numEntries+1), db.NewestID(), "expected the entry to be visible straight away")
+	assert.Equal(t, []byte("read your writes"), assertGet(t, db, uint64(numEntries+1)), "expected the entry")
+	<-done
+
+	// Entries appended once the database is closed fail.
+	assertClose(t, db)
+	errs := make(chan error, 1)
+	db.AppendAsync([]byte("closed"), func(_ uint64, err error) { errs <- err })
+	assert.Equal(t, ErrClosed, <-errs, "expected an error once closed")
+}
+
+func TestAppendAsync_Blocked(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "append_async_blocked", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assertAppend(t, db, []byte("first"))
+
+	space := make(chan struct{})
+	allocate = func(file *os.File, size int64) error {
+		select {
+		case <-space:
+			return preallocate(file, size)
+		default:
+			return syscall.ENOSPC
+		}
+	}
+	defer func() { allocate = preallocate }()
+	blocked := make(chan struct{})
+	var once sync.Once
+	db.SetBlockOnNoSpace(time.Millisecond, func() { once.Do(func() { close(blocked) }) })
+
+	// An entry which needs a new chunk blocks for disk space, but does not hold up one which fits.
+	ids := make(chan uint64, 2)
+	go db.AppendAsync(make([]byte, chunkSize), func(id uint64, err error) {
+		assert.Nil(t, err, "expected no error in blocked append")
+		ids <- id
+	})
+	<-blocked
+	db.AppendAsync([]byte("second"), func(id uint64, err error) {
+		assert.Nil(t, err, "expected no error in append")
+		ids <- id
+	})
+	assert.Equal(t, uint64(2), <-ids, "expected the entry which fits to be appended first")
+	close(space)
+	assert.Equal(t, uint64(3), <-ids, "expected the blocked entry to be appended once there is space")
+}
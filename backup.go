@@ -0,0 +1,372 @@
+package logdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Name of the directory chunk data files are linked into while a backup copies them.
+const backupLinks = ".backup"
+
+// Number of times a backup is attempted if the database is rolled back while it is being copied.
+const maxBackupAttempts = 3
+
+// Backup is the thread-safe version of 'LockFreeChunkDB.Backup'. Other operations are only blocked while the
+// chunk files are linked, not while they are copied, so appends can continue during a backup.
+func (db *ChunkDB) Backup(dst string) error {
+	return db.LockFreeChunkDB.backup(dst, db.rwlock.RLock, db.rwlock.RUnlock)
+}
+
+// BackupTo is the thread-safe version of 'LockFreeChunkDB.BackupTo'. As with 'Backup', appends can continue
+// while the archive is written.
+func (db *ChunkDB) BackupTo(w io.Writer) error {
+	return db.LockFreeChunkDB.backupTo(w, db.rwlock.RLock, db.rwlock.RUnlock)
+}
+
+// Backup writes a copy of the database, as it is when this is called, to a new directory, which can then be
+// opened as a database in its own right. Entries which have not been synced are included, and all the files
+// written are synced.
+//
+// The chunk data files are first hard linked into the ".backup" subdirectory, so that they are not deleted by a
+// 'Forget' while being copied; then the entries are copied, with the metadata written from the in-memory state,
+// and checked against their checksums. A 'Rollback' or 'Truncate' while copying can overwrite entries being
+// copied, so if this happens, the backup is retried a few times before giving up.
+//
+// Returns a 'PathError' value if the destination could not be created (including if it already exists), a
+// 'ChecksumError' value if an entry is corrupt, 'ErrBackupInterrupted' if the database was rolled back during
+// every attempt, a 'ReadError' or 'WriteError' value if the backup could not be written, and 'ErrClosed' if the
+// handle is closed. If the backup fails, the destination is removed.
+func (db *LockFreeChunkDB) Backup(dst string) error {
+	return db.backup(dst, func() {}, func() {})
+}
+
+// BackupTo writes a copy of the database, as it is when this is called, to a stream as a tar archive, from which
+// 'Restore' can recreate the database. Only the used portion of each chunk is included, and sealed chunks are
+// sealed again when restored.
+//
+// This works as 'Backup' does, except that, as the archive cannot be rewritten, a rollback while it is being
+// written is not retried. The archive ends with a marker, so 'Restore' rejects an archive which is incomplete
+// for any reason.
+//
+// Returns a 'ChecksumError' value if an entry is corrupt, 'ErrBackupInterrupted' if the database was rolled
+// back while the archive was written, a 'ReadError' or 'WriteError' value if the archive could not be written,
+// and 'ErrClosed' if the handle is closed.
+func (db *LockFreeChunkDB) BackupTo(w io.Writer) error {
+	return db.backupTo(w, func() {}, func() {})
+}
+
+////////// HELPERS //////////
+
+// The state of a database at the point a backup was started.
+type snapshot struct {
+	// Directory the chunk data files are linked into.
+	dir string
+
+	chunkSize    uint32
+	chunkEntries uint32
+	oldest       uint64
+	commitPoint  uint64
+	generations  []generationRecord
+	chunks       []snapshotChunk
+
+	// Limits how quickly entries are copied.
+	limit *rateLimiter
+}
+
+// A chunk of a snapshot: the name of its data file (which is also the name of the link), and its entries.
+type snapshotChunk struct {
+	name    string
+	oldest  uint64
+	ends    []int32
+	sums    []uint32
+	labels  []Label
+	headers [][]byte
+}
+
+// Back up the database, calling 'lock' and 'unlock' around the parts which need a consistent view.
+func (db *LockFreeChunkDB) backup(dst string, lock, unlock func()) error {
+	for attempt := 1; ; attempt++ {
+		if err := os.Mkdir(dst, os.ModeDir|0755); err != nil {
+			return &PathError{err}
+		}
+
+		lock()
+		snap, err := db.snapshot()
+		unlock()
+		if err != nil {
+			_ = os.RemoveAll(dst)
+			return err
+		}
+		err = snap.write(dirSink(dst))
+		_ = os.RemoveAll(snap.dir)
+		if err == nil {
+			return nil
+		}
+		_ = os.RemoveAll(dst)
+
+		// A checksum mismatch is only down to a concurrent rollback if the generation has changed.
+		if !errors.Is(err, ErrChecksumMismatch) {
+			return err
+		}
+		lock()
+		rolledBack := db.Generation() != snap.generation()
+		unlock()
+		if !rolledBack {
+			return err
+		}
+		if attempt == maxBackupAttempts {
+			return ErrBackupInterrupted
+		}
+	}
+}
+
+// Take a snapshot of the database, linking the chunk data files. Assumes a lock (read or write) is held.
+func (db *LockFreeChunkDB) snapshot() (*snapshot, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	// Syncing deletes chunk files, so hold the sync lock while linking them.
+	db.slock.Lock()
+	defer db.slock.Unlock()
+
+	if err := os.MkdirAll(db.path+"/"+backupLinks, os.ModeDir|0755); err != nil {
+		return nil, &WriteError{err}
+	}
+	dir, err := ioutil.TempDir(db.path+"/"+backupLinks, "")
+	if err != nil {
+		return nil, &WriteError{err}
+	}
+
+	snap := &snapshot{
+		dir:          dir,
+		chunkSize:    db.chunkSize,
+		chunkEntries: db.chunkEntries,
+		oldest:       db.oldest,
+		commitPoint:  db.commitPoint,
+		generations:  append([]generationRecord(nil), db.generations...),
+		limit:        newRateLimiter(db.backupRate),
+	}
+	for _, c := range db.chunks {
+		if c.delete || c.next() <= db.oldest {
+			continue
+		}
+		name := filepath.Base(c.path)
+		if err := os.Link(c.path, dir+"/"+name); err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, &WriteError{err}
+		}
+		snap.chunks = append(snap.chunks, snapshotChunk{
+			name:    name,
+			oldest:  c.oldest,
+			ends:    append([]int32(nil), c.ends...),
+			sums:    append([]uint32(nil), c.sums...),
+			labels:  append([]Label(nil), c.labels...),
+			headers: append([][]byte(nil), c.headers...),
+		})
+	}
+	return snap, nil
+}
+
+// Get the generation of the database when the snapshot was taken.
+func (s *snapshot) generation() uint64 {
+	if len(s.generations) == 0 {
+		return 0
+	}
+	return s.generations[len(s.generations)-1].Generation
+}
+
+// Back up the database to an archive, calling 'lock' and 'unlock' around the parts which need a consistent view.
+func (db *LockFreeChunkDB) backupTo(w io.Writer, lock, unlock func()) error {
+	lock()
+	snap, err := db.snapshot()
+	unlock()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(snap.dir)
+
+	archive := archiveSink{tar.NewWriter(w)}
+	err = snap.write(archive)
+	if errors.Is(err, ErrChecksumMismatch) {
+		lock()
+		rolledBack := db.Generation() != snap.generation()
+		unlock()
+		if rolledBack {
+			return ErrBackupInterrupted
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := archive.file(archiveEnd, uint64(len(snap.chunks))); err != nil {
+		return err
+	}
+	if err := archive.tw.Close(); err != nil {
+		return &WriteError{err}
+	}
+	return nil
+}
+
+// Write out the database files for a snapshot.
+func (s *snapshot) write(sink backupSink) error {
+	if err := sink.file("version", latestVersion); err != nil {
+		return err
+	}
+	if err := sink.file("chunk_size", s.chunkSize); err != nil {
+		return err
+	}
+	if err := sink.file("chunk_entries", s.chunkEntries); err != nil {
+		return err
+	}
+	if err := sink.file("oldest", s.oldest); err != nil {
+		return err
+	}
+	if s.commitPoint > 0 {
+		if err := sink.file("commit_point", s.commitPoint); err != nil {
+			return err
+		}
+	}
+	if len(s.generations) > 0 {
+		if err := sink.file(generationFile, s.generations); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, s.chunkSize)
+	for i, sc := range s.chunks {
+		c, err := s.readChunk(sc, buf)
+		if err != nil {
+			return err
+		}
+		if len(sc.ends) > 0 {
+			s.limit.wait(int(sc.ends[len(sc.ends)-1]), nil)
+		}
+		if err := sink.chunk(sc.name, c, i == len(s.chunks)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy the entries of one chunk of a snapshot into a buffer the size of a chunk, and check them against their
+// checksums. The returned chunk has no path.
+func (s *snapshot) readChunk(sc snapshotChunk, buf []byte) (*chunk, error) {
+	var used int32
+	if len(sc.ends) > 0 {
+		used = sc.ends[len(sc.ends)-1]
+	}
+	file, err := os.Open(s.dir + "/" + sc.name)
+	if err != nil {
+		return nil, &ReadError{err}
+	}
+	_, err = file.ReadAt(buf[:used], 0)
+	_ = file.Close()
+	if err != nil {
+		return nil, &ReadError{err}
+	}
+	for i := int(used); i < len(buf); i++ {
+		buf[i] = 0
+	}
+
+	c := &chunk{bytes: buf, ends: sc.ends, sums: sc.sums, labels: sc.labels, headers: sc.headers, oldest: sc.oldest}
+	if err := checkChunkSums(sc.name, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Check every entry of a chunk against its checksum, returning a 'ChecksumError' value for the first which does
+// not match.
+func checkChunkSums(name string, c *chunk) error {
+	var start int32
+	for i, end := range c.ends {
+		if !sumMatches(c.bytes[start:end], c.sums[i]) {
+			return &ChecksumError{ChunkFilePath: name, ID: c.oldest + uint64(i)}
+		}
+		start = end
+	}
+	return nil
+}
+
+// Where a backup is written. Files are given by their name in the database directory; chunks are given by the
+// name of their data file (without any shard directory) and contents.
+type backupSink interface {
+	// Write a file, encoding the value as 'writeFile' does.
+	file(name string, data interface{}) error
+
+	// Write the data and metadata files of a chunk. The final chunk is not sealed.
+	chunk(name string, c *chunk, final bool) error
+}
+
+// Writes a backup into a database directory.
+type dirSink string
+
+func (dst dirSink) file(name string, data interface{}) error {
+	if err := writeFile(string(dst)+"/"+name, data); err != nil {
+		return &WriteError{err}
+	}
+	return nil
+}
+
+func (dst dirSink) chunk(name string, c *chunk, final bool) error {
+	dir := chunkDir(string(dst), name)
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return &WriteError{err}
+	}
+	c.path = dir + "/" + name
+	if err := writeFile(c.path, c.bytes); err != nil {
+		return &WriteError{err}
+	}
+	if err := writeFile(c.metaFilePath(), encodeMetadata(nil, c.ends, c.sums, c.labels, c.headers, 0)); err != nil {
+		return &WriteError{err}
+	}
+	if !final {
+		if err := c.seal(); err != nil {
+			return &WriteError{err}
+		}
+	}
+	return nil
+}
+
+// Name of the file which marks the end of a backup archive, holding the number of chunks.
+const archiveEnd = "backup_end"
+
+// Writes a backup as a tar archive.
+type archiveSink struct{ tw *tar.Writer }
+
+func (a archiveSink) file(name string, data interface{}) error {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, data); err != nil {
+		return &WriteError{err}
+	}
+	return a.write(name, buf.Bytes())
+}
+
+func (a archiveSink) chunk(name string, c *chunk, final bool) error {
+	var used int32
+	if len(c.ends) > 0 {
+		used = c.ends[len(c.ends)-1]
+	}
+	if err := a.write(name, c.bytes[:used]); err != nil {
+		return err
+	}
+	return a.write(metaFilePath(name), encodeMetadata(nil, c.ends, c.sums, c.labels, c.headers, 0))
+}
+
+// Write one file to the archive.
+func (a archiveSink) write(name string, data []byte) error {
+	if err := a.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+		return &WriteError{err}
+	}
+	if _, err := a.tw.Write(data); err != nil {
+		return &WriteError{err}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package logdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestBackup(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "backup", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	filldb(t, db, numEntries)
+	assertForget(t, db, 20)
+	assertRollback(t, db, 200)
+	if err := db.SetCommitPoint(150); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "test_db/backup_copy"
+	_ = os.RemoveAll(path)
+	assert.Nil(t, db.Backup(path), "expected no error in backup")
+	fis, err := ioutil.ReadDir("test_db/backup/" + backupLinks)
+	assert.Nil(t, err, "expected the links directory to exist")
+	assert.Equal(t, 0, len(fis), "expected the links to be removed")
+
+	err = db.Backup(path)
+	assert.True(t, errors.As(err, new(*PathError)), "expected an existing destination to be an error, got: %s", err)
+
+	copied, err := OpenWithOptions(path, WithVerify(VerifyAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, copied)
+	assert.Equal(t, db.OldestID(), copied.OldestID(), "expected the same oldest entry")
+	assert.Equal(t, db.NewestID(), copied.NewestID(), "expected the same newest entry")
+	assert.Equal(t, db.CommitPoint(), copied.CommitPoint(), "expected the same commit point")
+	assert.Equal(t, db.Generation(), copied.Generation(), "expected the same generation")
+	for id := db.OldestID(); id <= db.NewestID(); id++ {
+		assert.Equal(t, assertGet(t, db, id), assertGet(t, copied, id), "expected the same entries")
+	}
+}
+
+func TestBackup_Concurrent(t *testing.T) {
+	db := WrapForConcurrency(assertOpen(t, dbTypes["lock free chunkdb"], true, "backup_concurrent", chunkSize).(*LockFreeChunkDB))
+	defer assertClose(t, db)
+	entry := func(id uint64) []byte { return []byte(fmt.Sprintf("entry-%v", id)) }
+	for id := uint64(1); id <= numEntries; id++ {
+		assertAppend(t, db, entry(id))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for id := uint64(numEntries + 1); id <= 4*numEntries; id++ {
+			assertAppend(t, db, entry(id))
+		}
+	}()
+	path := "test_db/backup_concurrent_copy"
+	_ = os.RemoveAll(path)
+	err := db.Backup(path)
+	wg.Wait()
+	assert.Nil(t, err, "expected no error in backup")
+
+	copied, err := OpenWithOptions(path, WithVerify(VerifyAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, copied)
+	assert.True(t, copied.NewestID() >= numEntries, "expected every entry from before the backup")
+	for id := copied.OldestID(); id <= copied.NewestID(); id++ {
+		assert.Equal(t, entry(id), assertGet(t, copied, id), "expected the same entries")
+	}
+}
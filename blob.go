@@ -0,0 +1,306 @@
+package logdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// A BlobDB wraps a 'LogDB' to store entries larger than a threshold in files of their own, in a separate
+// directory, with only a reference to the file stored in the underlying 'LogDB'. This keeps chunks small when
+// a few entries are much larger than the rest, rather than forcing a chunk size big enough for the largest.
+// Blob files are written and synced before the reference is appended, and deleted when the entry is removed
+// by 'Forget', 'Rollback', 'Truncate', or 'Downsample'. This is transparent to 'Get'.
+//
+// Blob files are named after the SHA-256 hash of their contents, so identical entries share a file, which is
+// only deleted once the last entry referring to it has been removed.
+//
+// Every entry is prefixed with a flag byte saying whether it is stored inline or in a blob, so a 'BlobDB' can
+// only read entries which were written through one.
+type BlobDB struct {
+	LogDB
+
+	// The directory blob files are stored in, and the size above which an entry is stored in one.
+	Dir       string
+	Threshold int
+
+	// The number of entries referring to each blob file, by name.
+	mutex sync.Mutex
+	refs  map[string]int
+}
+
+// Blobs creates a 'BlobDB', creating the blob directory if it does not exist. The directory must only be used
+// for the blob files of this database.
+//
+// Every entry is read to count the references to each blob file, and any blob file with none (left behind if
+// the process died between writing a blob file and appending its entry, between removing an entry and deleting
+// its blob file, or if the entry was dropped by downsampling the underlying 'LogDB' directly) is deleted.
+//
+// Returns a 'PathError' value if the directory could not be created, a 'ReadError' value if it could not be
+// read, a 'WriteError' value if an unreferenced blob file could not be deleted, and the same errors as 'Get'.
+func Blobs(logdb LogDB, dir string, threshold int) (*BlobDB, error) {
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return nil, &PathError{err}
+	}
+	db := &BlobDB{LogDB: logdb, Dir: dir, Threshold: threshold, refs: make(map[string]int)}
+
+	if oldest := logdb.OldestID(); oldest > 0 {
+		for id := oldest; id <= logdb.NewestID(); id++ {
+			bs, err := logdb.Get(id)
+			if err == ErrDownsampled {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(bs) > 0 && bs[0] == blobFile {
+				db.refs[string(bs[1:])]++
+			}
+		}
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, &ReadError{err}
+	}
+	for _, fi := range fis {
+		if db.refs[fi.Name()] == 0 {
+			if err := os.Remove(dir + "/" + fi.Name()); err != nil {
+				return nil, &WriteError{err}
+			}
+		}
+	}
+	return db, nil
+}
+
+// Append implements the 'LogDB' interface.
+func (db *BlobDB) Append(entry []byte) (uint64, error) {
+	return db.AppendEntries([][]byte{entry})
+}
+
+// AppendEntries implements the 'LogDB' interface. If a blob file cannot be written, a 'WriteError' value is
+// returned. If the entries cannot be appended, any blob files written for them are deleted.
+func (db *BlobDB) AppendEntries(entries [][]byte) (uint64, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	refs := make([][]byte, len(entries))
+	var blobs []string
+	for i, entry := range entries {
+		if len(entry) <= db.Threshold {
+			refs[i] = append([]byte{blobInline}, entry...)
+			continue
+		}
+		name, err := db.writeBlob(entry)
+		if err != nil {
+			_ = db.removeBlobs(blobs)
+			return 0, &WriteError{err}
+		}
+		blobs = append(blobs, name)
+		refs[i] = append([]byte{blobFile}, name...)
+	}
+
+	id, err := db.LogDB.AppendEntries(refs)
+	if err != nil {
+		_ = db.removeBlobs(blobs)
+		return id, err
+	}
+	for _, name := range blobs {
+		db.refs[name]++
+	}
+	return id, nil
+}
+
+// Get implements the 'LogDB' interface. If a blob file cannot be read, a 'ReadError' value is returned, and if
+// the entry was not written through a 'BlobDB', a 'DecodeError' value.
+func (db *BlobDB) Get(id uint64) ([]byte, error) {
+	bs, err := db.LogDB.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) == 0 {
+		return nil, &DecodeError{ID: id, Err: errBadBlobRef}
+	}
+	switch bs[0] {
+	case blobInline:
+		return bs[1:], nil
+	case blobFile:
+		blob, err := ioutil.ReadFile(db.Dir + "/" + string(bs[1:]))
+		if err != nil {
+			return nil, &ReadError{err}
+		}
+		return blob, nil
+	default:
+		return nil, &DecodeError{ID: id, Err: errBadBlobRef}
+	}
+}
+
+// Forget implements the 'LogDB' interface. The blob files of forgotten entries are deleted, and a 'WriteError'
+// value is returned if that fails.
+func (db *BlobDB) Forget(newOldestID uint64) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	blobs := db.blobsBetween(db.LogDB.OldestID(), newOldestID-1)
+	if err := db.LogDB.Forget(newOldestID); err != nil {
+		return err
+	}
+	return db.release(blobs)
+}
+
+// Rollback implements the 'LogDB' interface. The blob files of rolled back entries are deleted, and a
+// 'WriteError' value is returned if that fails.
+func (db *BlobDB) Rollback(newNewestID uint64) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	blobs := db.blobsBetween(newNewestID+1, db.LogDB.NewestID())
+	if err := db.LogDB.Rollback(newNewestID); err != nil {
+		return err
+	}
+	return db.release(blobs)
+}
+
+// Truncate implements the 'LogDB' interface. The blob files of removed entries are deleted, and a 'WriteError'
+// value is returned if that fails.
+func (db *BlobDB) Truncate(newOldestID, newNewestID uint64) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	blobs := append(db.blobsBetween(db.LogDB.OldestID(), newOldestID-1), db.blobsBetween(newNewestID+1, db.LogDB.NewestID())...)
+	if err := db.LogDB.Truncate(newOldestID, newNewestID); err != nil {
+		return err
+	}
+	return db.release(blobs)
+}
+
+// Downsample thins out the entries of the underlying 'LogDB', as 'LockFreeChunkDB.Downsample' does, and deletes
+// the blob files of the entries dropped. 'keep' is given the entry as 'Get' would return it, so the blob file of
+// each entry stored in one is read; if that fails, the entry is kept.
+//
+// Returns 'ErrNotPermitted' if the underlying 'LogDB' cannot be downsampled, a 'WriteError' value if a blob file
+// could not be deleted, and the same errors as 'LockFreeChunkDB.Downsample'.
+func (db *BlobDB) Downsample(before uint64, keep func(id uint64, entry []byte) bool) error {
+	ddb, ok := db.LogDB.(downsampleDB)
+	if !ok {
+		return ErrNotPermitted
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var blobs []string
+	err := ddb.Downsample(before, func(id uint64, bs []byte) bool {
+		if len(bs) == 0 {
+			return keep(id, bs)
+		}
+		switch bs[0] {
+		case blobInline:
+			return keep(id, bs[1:])
+		case blobFile:
+			blob, err := ioutil.ReadFile(db.Dir + "/" + string(bs[1:]))
+			if err != nil {
+				return true
+			}
+			if keep(id, blob) {
+				return true
+			}
+			blobs = append(blobs, string(bs[1:]))
+			return false
+		default:
+			return keep(id, bs)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return db.release(blobs)
+}
+
+// StoredSize implements the 'SizedDB' interface: it is the size of the entry as stored in the underlying
+// 'LogDB', which for an entry in a blob file is the size of the reference.
+func (db *BlobDB) StoredSize(id uint64) (uint64, error) {
+	return storedSize(db.LogDB, id)
+}
+
+////////// HELPERS //////////
+
+// The flag bytes marking an entry as stored inline, or as a reference to a blob file.
+const (
+	blobInline = byte(0)
+	blobFile   = byte(1)
+)
+
+// A database which can be downsampled, such as a 'ChunkDB'.
+type downsampleDB interface {
+	Downsample(before uint64, keep func(id uint64, entry []byte) bool) error
+}
+
+// An entry which has neither flag byte.
+var errBadBlobRef = errors.New("not a blob entry")
+
+// Write an entry to its blob file, unless it is already referred to, returning its name. Assumes the mutex is
+// held.
+func (db *BlobDB) writeBlob(entry []byte) (string, error) {
+	sum := sha256.Sum256(entry)
+	name := hex.EncodeToString(sum[:]) + ".blob"
+	if db.refs[name] > 0 {
+		return name, nil
+	}
+	if err := writeFile(db.Dir+"/"+name, entry); err != nil {
+		_ = os.Remove(db.Dir + "/" + name)
+		return "", err
+	}
+	return name, nil
+}
+
+// Find the names of the blob files of the entries in a range, limited to the entries in the database. Entries
+// which cannot be read are skipped.
+func (db *BlobDB) blobsBetween(first, last uint64) []string {
+	var blobs []string
+	if oldest := db.LogDB.OldestID(); first < oldest {
+		first = oldest
+	}
+	if newest := db.LogDB.NewestID(); last > newest {
+		last = newest
+	}
+	if first == 0 {
+		return nil
+	}
+	for id := first; id <= last; id++ {
+		bs, err := db.LogDB.Get(id)
+		if err == nil && len(bs) > 0 && bs[0] == blobFile {
+			blobs = append(blobs, string(bs[1:]))
+		}
+	}
+	return blobs
+}
+
+// Drop a reference to each of the blob files, deleting those with none left. Assumes the mutex is held.
+func (db *BlobDB) release(blobs []string) error {
+	var unused []string
+	for _, name := range blobs {
+		if db.refs[name]--; db.refs[name] <= 0 {
+			delete(db.refs, name)
+			unused = append(unused, name)
+		}
+	}
+	return db.removeBlobs(unused)
+}
+
+// Delete blob files which are not referred to, returning the first error. Assumes the mutex is held.
+func (db *BlobDB) removeBlobs(blobs []string) error {
+	var first error
+	for _, name := range blobs {
+		if db.refs[name] > 0 {
+			continue
+		}
+		if err := os.Remove(db.Dir + "/" + name); err != nil && !os.IsNotExist(err) && first == nil {
+			first = &WriteError{err}
+		}
+	}
+	return first
+}
@@ -0,0 +1,102 @@
+package logdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestBlob_RoundTrip(t *testing.T) {
+	_ = os.RemoveAll("test_db/blob_round_trip_blobs")
+	db := assertOpen(t, dbTypes["chunkdb"], true, "blob_round_trip", chunkSize)
+	defer assertClose(t, db)
+	bdb, err := Blobs(db, "test_db/blob_round_trip_blobs", 16)
+	assert.Nil(t, err, "expected no error creating blob database")
+
+	small := []byte("small")
+	big := bytes.Repeat([]byte("big"), 1000)
+	bigger := bytes.Repeat([]byte("bigger"), 1000)
+	assert.Equal(t, uint64(1), assertAppendEntries(t, bdb, [][]byte{small, big, small, bigger}), "expected the first ID")
+	assert.Equal(t, small, assertGet(t, bdb, 1), "expected the inline entry")
+	assert.Equal(t, big, assertGet(t, bdb, 2), "expected the blob entry")
+	assert.True(t, len(assertGet(t, db, 2)) < chunkSize, "expected a reference in the underlying database")
+	assert.Equal(t, 2, countBlobs(t, bdb.Dir), "expected a file per big entry")
+
+	assertForget(t, bdb, 3)
+	assert.Equal(t, 1, countBlobs(t, bdb.Dir), "expected the forgotten blob to be deleted")
+	assertRollback(t, bdb, 3)
+	assert.Equal(t, 0, countBlobs(t, bdb.Dir), "expected the rolled back blob to be deleted")
+	assert.Equal(t, small, assertGet(t, bdb, 3), "expected the remaining entry")
+}
+
+func countBlobs(t *testing.T, dir string) int {
+	fis, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err, "expected no error reading blob directory")
+	return len(fis)
+}
+
+func TestBlob_Dedup(t *testing.T) {
+	_ = os.RemoveAll("test_db/blob_dedup_blobs")
+	db := assertOpen(t, dbTypes["chunkdb"], true, "blob_dedup", chunkSize)
+	defer assertClose(t, db)
+	bdb, err := Blobs(db, "test_db/blob_dedup_blobs", 16)
+	assert.Nil(t, err, "expected no error creating blob database")
+
+	big := bytes.Repeat([]byte("big"), 1000)
+	other := bytes.Repeat([]byte("other"), 1000)
+	assertAppendEntries(t, bdb, [][]byte{big, big, other})
+	assertAppend(t, bdb, big)
+	assert.Equal(t, 2, countBlobs(t, bdb.Dir), "expected identical entries to share a file")
+
+	assertForget(t, bdb, 3)
+	assert.Equal(t, 2, countBlobs(t, bdb.Dir), "expected the shared file to be kept")
+	assert.Equal(t, big, assertGet(t, bdb, 4), "expected the shared entry")
+
+	// Reopening counts the references again, and deletes unreferenced files.
+	assert.Nil(t, ioutil.WriteFile(bdb.Dir+"/orphan.blob", big, 0644), "expected no error writing orphan")
+	bdb, err = Blobs(db, bdb.Dir, 16)
+	assert.Nil(t, err, "expected no error reopening blob database")
+	assert.Equal(t, 2, countBlobs(t, bdb.Dir), "expected the orphan to be deleted")
+
+	assertRollback(t, bdb, 3)
+	assert.Equal(t, 1, countBlobs(t, bdb.Dir), "expected the last reference to delete the file")
+	assert.Equal(t, other, assertGet(t, bdb, 3), "expected the remaining entry")
+}
+
+func TestBlob_Downsample(t *testing.T) {
+	_ = os.RemoveAll("test_db/blob_downsample_blobs")
+	db := assertOpen(t, dbTypes["chunkdb"], true, "blob_downsample", chunkSize)
+	defer assertClose(t, db)
+	bdb, err := Blobs(db, "test_db/blob_downsample_blobs", 16)
+	assert.Nil(t, err, "expected no error creating blob database")
+
+	// Each big entry is different, so has a file of its own.
+	for i := 0; i < 40; i++ {
+		assertAppend(t, bdb, bytes.Repeat([]byte{byte(i)}, 100))
+	}
+	assert.Equal(t, 40, countBlobs(t, bdb.Dir), "expected a file per big entry")
+
+	var seen [][]byte
+	var dropped int
+	assert.Nil(t, bdb.Downsample(30, func(id uint64, entry []byte) bool {
+		seen = append(seen, entry)
+		if id%2 == 0 {
+			return true
+		}
+		dropped++
+		return false
+	}), "expected no error in downsample")
+	assert.True(t, dropped > 0, "expected some entries to be dropped")
+	assert.Equal(t, bytes.Repeat([]byte{0}, 100), seen[0], "expected the entry as 'Get' returns it")
+	assert.Equal(t, 40-dropped, countBlobs(t, bdb.Dir), "expected the files of dropped entries to be deleted")
+	_, err = bdb.Get(1)
+	assert.Equal(t, ErrDownsampled, err, "expected the entry to be dropped")
+
+	// Dropped entries are skipped when reopening.
+	_, err = Blobs(db, bdb.Dir, 16)
+	assert.Nil(t, err, "expected no error reopening blob database")
+	assert.Equal(t, 40-dropped, countBlobs(t, bdb.Dir), "expected no referenced files to be deleted")
+}
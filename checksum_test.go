@@ -0,0 +1,133 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestChecksum_Corruption(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "checksum_corruption", chunkSize).(*ChunkDB)
+	filldb(t, db, numEntries)
+	assertSync(t, db)
+	assert.Nil(t, db.VerifyIntegrity(), "expected no corruption")
+
+	// Flip a byte of the first entry of the final chunk behind the database's back. Sealed chunks would also be
+	// caught by their seal.
+	final := db.chunks[len(db.chunks)-1]
+	id := final.oldest
+	file, err := os.OpenFile(final.path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{'x'}, 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	_, err = db.Get(id)
+	assert.Equal(t, ErrChecksumMismatch, err, "expected checksum mismatch")
+	assertGet(t, db, id+1)
+
+	r, err := db.GetRaw(id)
+	assert.Nil(t, err, "expected no error until the entry is read")
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, ErrChecksumMismatch, err, "expected checksum mismatch from raw read")
+
+	err = db.VerifyIntegrity()
+	var cerr *ChecksumError
+	assert.True(t, errors.As(err, &cerr), "expected checksum error, got: %s", err)
+	assert.Equal(t, id, cerr.ID, "expected the corrupt entry to be reported")
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "expected checksum error to wrap ErrChecksumMismatch")
+
+	it, err := db.Scan(id, id+1)
+	assert.Nil(t, err, "expected no error in scan")
+	assert.False(t, it.Next(), "expected scan to stop at the corrupt entry")
+	assert.Equal(t, ErrChecksumMismatch, it.Err(), "expected checksum mismatch from scan")
+	assertClose(t, db)
+
+	_, err = OpenWithOptions("test_db/checksum_corruption", WithVerify(VerifyAll))
+	assert.True(t, errors.As(err, new(*ChecksumError)), "expected checksum error on open, got: %s", err)
+}
+
+func TestChecksum_Migrate(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "checksum_migrate", chunkSize)
+	vs := filldb(t, db, numEntries)
+	assertClose(t, db)
+	path := "test_db/checksum_migrate"
+	downgradeToVersion0(t, path)
+
+	_, err := Open(path, 0, false)
+	assert.Equal(t, ErrNeedsMigration, err, "expected an old database to need migrating")
+
+	from, err := Migrate(path)
+	assert.Nil(t, err, "expected no error in migrate, got: %s", err)
+	assert.Equal(t, uint16(0), from, "expected to upgrade from version 0")
+
+	db, err = OpenWithOptions(path, WithVerify(VerifyAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, db)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after migrating")
+	}
+}
+
+////////// HELPERS //////////
+
+// Rewrite the files of a closed database in the version 0 format, which has no checksums.
+func downgradeToVersion0(t *testing.T, path string) {
+	err := filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !isBasenameChunkDataFile(fi.Name()) {
+			return err
+		}
+		c := &chunk{path: file}
+		mfile, err := os.Open(c.metaFilePath())
+		if err != nil {
+			return err
+		}
+		c.ends, _, err = readMetadata(mfile)
+		_ = mfile.Close()
+		if err != nil {
+			return err
+		}
+		if c.bytes, err = ioutil.ReadFile(file); err != nil {
+			return err
+		}
+
+		var buf []byte
+		for i, end := range c.ends {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(i))
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(end))
+		}
+		if err := os.Remove(c.metaFilePath()); err != nil {
+			return err
+		}
+		if err := writeFile(c.metaFilePath(), buf); err != nil {
+			return err
+		}
+		if _, err := os.Stat(c.sealFilePath()); err != nil {
+			return nil
+		}
+		rec, err := c.sealRecord()
+		if err != nil {
+			return err
+		}
+		return writeFile(c.sealFilePath(), rec)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path + "/chunk_entries"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(path+"/version", uint16(0)); err != nil {
+		t.Fatal(err)
+	}
+}
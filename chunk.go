@@ -1,31 +1,44 @@
 package logdb
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 // Filename-related constants.
 const (
 	chunkPrefix      = "chunk"
 	metaSuffix       = "meta"
+	sealSuffix       = "seal"
+	tmpSuffix        = ".tmp"
 	sep              = "_"
 	initialChunkFile = chunkPrefix + sep + "0" + sep + "1"
 	initialMetaFile  = initialChunkFile + sep + metaSuffix
+	shardPrefix      = "shard"
 )
 
-// A chunk is one memory-mapped file.
+// Chunk files are spread over subdirectories once there are many of them, so that no one directory grows too
+// large. Chunk number 'n' lives in the database directory if 'n < chunksPerDir', and in the subdirectory
+// "shard<n / chunksPerDir>" otherwise. This is a variable so that the tests can lower it.
+var chunksPerDir uint64 = 1000
+
+// A chunk is one memory-mapped file, or an unmapped one if there was not the address space to map it.
 type chunk struct {
 	// Path to the data file. The metadata file name and oldest entry ID are derived from this.
 	path string
 
 	// The memory-mapped data file. The 'bytes' slice is produced by mmaping the fd in the 'mmapf' file,
-	// meaning that it can be fsynced easily.
+	// meaning that it can be fsynced easily. If there was not the address space to map it, 'bytes' is nil, and
+	// the data is read from (and written to) 'mmapf' instead: use 'readAt' and 'slice' to read it.
 	bytes []byte
 	mmapf *os.File
 
@@ -33,6 +46,15 @@ type chunk struct {
 	// in the segment 'bytes[prior end:end]', with the 'prior end' for the first entry being 0.
 	ends []int32
 
+	// CRC-32 (Castagnoli) checksums of the entries, in the same order as 'ends'.
+	sums []uint32
+
+	// Labels of the entries, in the same order as 'ends', or nil if no entry has a label.
+	labels []Label
+
+	// Headers of the entries, in the same order as 'ends', or nil if no entry has a header.
+	headers [][]byte
+
 	// ID of the oldest entry in the chunk. This can be determined from the filename, but it's cheaper to
 	// store it here.
 	oldest uint64
@@ -41,6 +63,101 @@ type chunk struct {
 	// indicates that the chunk needs to be deleted at the next sync.
 	newFrom int
 	delete  bool
+
+	// Set once the files of a chunk marked for deletion have been deleted.
+	removed bool
+
+	// Reused between syncs to encode the metadata, to avoid allocating.
+	metaBuf []byte
+
+	// A sealed chunk has had a new chunk created after it, and so will not be written to again (unless a
+	// rollback unseals it). Its files are read-only, and the data file is mapped read-only.
+	sealed bool
+}
+
+// Get the start and end offsets of an entry in a chunk, which must contain it.
+func (c *chunk) find(id uint64) (*chunk, int32, int32) {
+	off := id - c.oldest
+	start := int32(0)
+	if off > 0 {
+		start = c.ends[off-1]
+	}
+	return c, start, c.ends[off]
+}
+
+// Check an entry read from a chunk, which must contain it, against its checksum. Chunks from before disk format
+// version 1 have no checksums, so their entries are not checked.
+//
+// Returns 'ErrDownsampled' if the entry has been dropped, and 'ErrChecksumMismatch' if the entry does not match.
+func (c *chunk) check(id uint64, entry []byte) error {
+	if c.sums == nil {
+		return nil
+	}
+	sum := c.sums[id-c.oldest]
+	if len(entry) == 0 && sum == droppedSum {
+		return ErrDownsampled
+	}
+	if checksum(entry) != sum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Copy the data of a chunk from offset 'off' into 'buf', reading it from the data file if the chunk is not
+// mapped.
+func (c *chunk) readAt(buf []byte, off int32) error {
+	if c.bytes != nil {
+		copy(buf, c.bytes[off:])
+		return nil
+	}
+	if _, err := c.mmapf.ReadAt(buf, int64(off)); err != nil {
+		return &ReadError{err}
+	}
+	return nil
+}
+
+// Get a reader over the data of a chunk from offset 'start' to 'end', which is read from the data file as the
+// reader is read. The data is checked against the checksum of entry 'id' when the end is reached.
+func (c *chunk) reader(id uint64, start, end int32) io.Reader {
+	r := &entryReader{r: io.NewSectionReader(c.mmapf, int64(start), int64(end-start))}
+	if c.sums != nil {
+		r.hash = crc32.New(castagnoli)
+		r.sum = c.sums[id-c.oldest]
+	}
+	return r
+}
+
+// A reader over an entry which checks its checksum, if it has one, once it has all been read.
+type entryReader struct {
+	r    io.Reader
+	hash hash.Hash32
+	sum  uint32
+}
+
+func (r *entryReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.hash != nil {
+		_, _ = r.hash.Write(p[:n])
+	}
+	switch {
+	case err == io.EOF:
+		if r.hash != nil && r.hash.Sum32() != r.sum {
+			return n, ErrChecksumMismatch
+		}
+	case err != nil:
+		return n, &ReadError{err}
+	}
+	return n, err
+}
+
+// Get the data of a chunk from offset 'start' to 'end'. If the chunk is mapped, this is a slice of the
+// mapping, so must not be modified or kept once the chunk may have been closed.
+func (c *chunk) slice(start, end int32) ([]byte, error) {
+	if c.bytes != nil {
+		return c.bytes[start:end:end], nil
+	}
+	buf := make([]byte, end-start)
+	return buf, c.readAt(buf, start)
 }
 
 // Get the next entry ID in a chunk.
@@ -48,12 +165,45 @@ func (c *chunk) next() uint64 {
 	return c.oldest + uint64(len(c.ends))
 }
 
+// Unmap a chunk and close its data file, if they are open, without syncing it.
+func (c *chunk) close() {
+	if c.bytes != nil {
+		_ = syscall.Munmap(c.bytes)
+		c.bytes = nil
+	}
+	if c.mmapf != nil {
+		_ = c.mmapf.Close()
+		c.mmapf = nil
+	}
+}
+
 // Delete the files associated with a chunk.
 func (c *chunk) closeAndRemove() error {
 	if err := closeAndRemove(c.mmapf); err != nil {
 		return err
 	}
-	return os.Remove(c.metaFilePath())
+	if err := os.Remove(c.metaFilePath()); err != nil {
+		return err
+	}
+	if err := os.Remove(c.sealFilePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Remove the shard directory once its last chunk is gone. This fails harmlessly if it is not empty.
+	if dir := filepath.Dir(c.path); isBasenameShardDir(filepath.Base(dir)) {
+		_ = os.Remove(dir)
+	}
+	return nil
+}
+
+// Get the directory which the chunk data file with the given basename belongs in.
+//
+// This does no validation, the basename must be a valid chunk data file name.
+func chunkDir(basedir, basename string) string {
+	num, _ := strconv.ParseUint(strings.Split(basename, sep)[1], 10, 0)
+	if num < chunksPerDir {
+		return basedir
+	}
+	return fmt.Sprintf("%s/%s%v", basedir, shardPrefix, num/chunksPerDir)
 }
 
 // Get the data file path associated with a chunk meta file path.
@@ -71,6 +221,16 @@ func (c *chunk) metaFilePath() string {
 	return metaFilePath(c.path)
 }
 
+// Get the seal file path associated with a chunk data file path.
+func sealFilePath(dataFilePath string) string {
+	return dataFilePath + sep + sealSuffix
+}
+
+// Get the seal file path associated with a chunk.
+func (c *chunk) sealFilePath() string {
+	return sealFilePath(c.path)
+}
+
 // Check if a file basename is a chunk data file.
 //
 // A valid chunk filename consists of the chunkPrefix followed by one or more digits, with no leading zeroes.
@@ -115,6 +275,25 @@ func isBasenameChunkMetaFile(basename string) bool {
 	return strings.HasSuffix(basename, suff) && isBasenameChunkDataFile(strings.TrimSuffix(basename, suff))
 }
 
+// Check if a file basename is a chunk seal file.
+//
+// A valid chunk seal filename consists of a valid chunk data filename followed by the seal suffix.
+func isBasenameChunkSealFile(basename string) bool {
+	suff := sep + sealSuffix
+	return strings.HasSuffix(basename, suff) && isBasenameChunkDataFile(strings.TrimSuffix(basename, suff))
+}
+
+// Check if a file basename is a shard directory.
+//
+// A valid shard directory name consists of the shardPrefix followed by one or more digits.
+func isBasenameShardDir(basename string) bool {
+	if !strings.HasPrefix(basename, shardPrefix) {
+		return false
+	}
+	_, err := strconv.ParseUint(strings.TrimPrefix(basename, shardPrefix), 10, 0)
+	return err == nil
+}
+
 // Given a chunk, get the filename of the next chunk.
 //
 // This function panics if the chunk path is invalid. This should never happen unless openChunkSliceDB or
@@ -158,6 +337,12 @@ func createChunkFiles(dataFilePath string, chunkSize uint32, oldest uint64) erro
 
 // Open a chunk file
 func openChunkFile(basedir string, fi os.FileInfo, priorChunk *chunk, chunkSize uint32) (chunk, error) {
+	return openChunk(basedir, fi, priorChunk, chunkSize, true, latestVersion)
+}
+
+// Open a chunk file, with metadata in the given disk format version. If 'writable' is false, the data file is
+// mapped read-only even if the chunk is not sealed.
+func openChunk(basedir string, fi os.FileInfo, priorChunk *chunk, chunkSize uint32, writable bool, version uint16) (chunk, error) {
 	chunk := chunk{path: basedir + "/" + fi.Name()}
 	// Get the oldest ID from the file name
 	if !isBasenameChunkDataFile(fi.Name()) {
@@ -168,23 +353,36 @@ func openChunkFile(basedir string, fi os.FileInfo, priorChunk *chunk, chunkSize
 	oldnum, _ := strconv.ParseUint(nameBits[2], 10, 0)
 	chunk.oldest = uint64(oldnum)
 
-	// mmap the data file
-	mmapf, bytes, err := mmap(chunk.path)
+	// A chunk with a seal file is sealed, and so is mapped read-only.
+	if _, err := os.Stat((&chunk).sealFilePath()); err == nil {
+		chunk.sealed = true
+	}
+
+	// mmap the data file. If there is not the address space left to map it, as happens on 32-bit platforms once
+	// there are enough chunks, the data file is read and written with pread and pwrite instead.
+	mmapf, bytes, err := mmap(chunk.path, writable && !chunk.sealed)
+	size := int64(len(bytes))
+	if mmapf != nil && errors.Is(err, syscall.ENOMEM) {
+		var fi os.FileInfo
+		if fi, err = mmapf.Stat(); err == nil {
+			size = fi.Size()
+		}
+	}
 	if err != nil {
 		return chunk, &ReadError{err}
 	}
-	if uint32(len(bytes)) != chunkSize {
+	chunk.bytes = bytes
+	chunk.mmapf = mmapf
+	if size != int64(chunkSize) {
 		return chunk, &FormatError{
 			FilePath: chunk.path,
 			Err: &ChunkSizeError{
 				ChunkFilePath: chunk.path,
 				Expected:      chunkSize,
-				Actual:        uint32(len(bytes)),
+				Actual:        uint32(size),
 			},
 		}
 	}
-	chunk.bytes = bytes
-	chunk.mmapf = mmapf
 
 	// read the ending address metadata
 	mfile, err := os.Open((&chunk).metaFilePath())
@@ -192,7 +390,7 @@ func openChunkFile(basedir string, fi os.FileInfo, priorChunk *chunk, chunkSize
 		return chunk, &ReadError{err}
 	}
 	defer mfile.Close()
-	ends, err := readMetadata(mfile)
+	m, err := readMetadataRecords(mfile, version)
 	if err != nil {
 		return chunk, &FormatError{
 			FilePath: (&chunk).metaFilePath(),
@@ -202,7 +400,10 @@ func openChunkFile(basedir string, fi os.FileInfo, priorChunk *chunk, chunkSize
 			},
 		}
 	}
-	chunk.ends = ends
+	chunk.ends = m.ends
+	chunk.sums = m.sums
+	chunk.labels = m.labels
+	chunk.headers = m.headers
 
 	// Chunk oldest/next IDs must match: there can be no gaps!
 	if priorChunk != nil && chunk.oldest != priorChunk.next() {
@@ -220,75 +421,196 @@ func openChunkFile(basedir string, fi os.FileInfo, priorChunk *chunk, chunkSize
 }
 
 // Write a chunk to disk.
-func (c *chunk) sync() error {
+//
+// If 'rewrite' is true, the metadata file is rewritten in full rather than appended to. The data and metadata are
+// flushed with the given functions.
+func (c *chunk) sync(rewrite bool, flush func(*chunk) error, flushMeta func(*os.File) error) error {
 	// To ensure ACID, sync the data first and only then the metadata. This means that if there is a failure
 	// between the two syncs, even if the newly-written data is corrupt, there will be no metadata referring
 	// to it, and so it will be invisible to the database when next opened.
-	if err := fsync(c.mmapf); err != nil {
+	if err := failpoint(FailpointBeforeDataSync); err != nil {
+		return err
+	}
+	if err := flush(c); err != nil {
+		return err
+	}
+	if err := failpoint(FailpointBeforeMetaWrite); err != nil {
 		return err
 	}
 
 	// Construct the metadata as a buffer. This is done rather than appending to the output file directly
 	// because individual "write" syscalls with a small enough buffer (which this will be for any reasonable
 	// syncing period) are atomic. Multiple appends would have the possibility of failure in the middle.
-	buf := new(bytes.Buffer)
-	for i := c.newFrom; i < len(c.ends); i++ {
-		if err := binary.Write(buf, binary.LittleEndian, int32(i)); err != nil {
+	//
+	// When rewriting, the atomicity comes from writing a new file and renaming it over the old one instead.
+	from := c.newFrom
+	if rewrite {
+		from = 0
+	}
+	c.metaBuf = encodeMetadata(c.metaBuf[:0], c.ends, c.sums, c.labels, c.headers, from)
+	buf := c.metaBuf
+
+	// Write the new end points.
+	if rewrite {
+		tmpPath := c.metaFilePath() + tmpSuffix
+		if err := writeFileWith(tmpPath, buf, flushMeta); err != nil {
 			return err
 		}
-		if err := binary.Write(buf, binary.LittleEndian, c.ends[i]); err != nil {
+		if err := os.Rename(tmpPath, c.metaFilePath()); err != nil {
 			return err
 		}
-	}
-
-	// Write the new end points.
-	if err := appendFile(c.metaFilePath(), buf.Bytes()); err != nil {
+	} else if err := appendFileWith(c.metaFilePath(), buf, flushMeta); err != nil {
 		return err
 	}
 	c.newFrom = len(c.ends)
 
-	return nil
+	return failpoint(FailpointAfterMetaWrite)
+}
+
+// Compute the checksum of an entry.
+func checksum(entry []byte) uint32 {
+	return crc32.Checksum(entry, castagnoli)
+}
+
+// The checksum recorded for an entry dropped by 'Downsample', which takes up no space in the data file. As an
+// empty entry has a checksum of 0, this cannot be mistaken for an entry which was appended.
+const droppedSum uint32 = 0xFFFFFFFF
+
+// Check if an entry matches the checksum recorded for it, or has been dropped.
+func sumMatches(entry []byte, sum uint32) bool {
+	return checksum(entry) == sum || len(entry) == 0 && sum == droppedSum
 }
 
-// Read a chunk metadata file.
+// CRC-32 (Castagnoli) is used for entry checksums as it is computed in hardware on most platforms.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// The index in each metadata record is the bottom 24 bits of its first word, as the top 8 bits are the label.
+// The largest index marks a header record instead, so a chunk holds one entry fewer than the index can count.
+const (
+	indexMask       = 1<<24 - 1
+	headerMarker    = indexMask
+	maxChunkEntries = headerMarker
+)
+
+// Encode the metadata records for the entries from index 'from' onwards, appending them to 'buf'. 'labels' and
+// 'headers' may be nil, if no entry has a label or a header.
+func encodeMetadata(buf []byte, ends []int32, sums []uint32, labels []Label, headers [][]byte, from int) []byte {
+	for i := from; i < len(ends); i++ {
+		idx := uint32(i)
+		if labels != nil {
+			idx |= uint32(labels[i]) << 24
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, idx)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(ends[i]))
+		buf = binary.LittleEndian.AppendUint32(buf, sums[i])
+		if headers != nil && len(headers[i]) > 0 {
+			buf = binary.LittleEndian.AppendUint32(buf, headerMarker|uint32(len(headers[i]))<<24)
+			buf = append(buf, headers[i]...)
+		}
+	}
+	return buf
+}
+
+// Read a chunk metadata file, ignoring the labels and headers.
+func readMetadata(r io.Reader) ([]int32, []uint32, error) {
+	m, err := readMetadataRecords(r, latestVersion)
+	return m.ends, m.sums, err
+}
+
+// Read a chunk metadata file, including the labels and headers, which are nil if no entry has one.
 //
-// Metadata is in the format [index int32][end int32], it ends at EOF. If the indices go backwards, that means
-// entries have been rolled back
-func readMetadata(r io.Reader) ([]int32, error) {
-	var ends []int32
-	var idx, this int32
+// Metadata is in the format [label uint8][index uint24][end int32][checksum uint32], it ends at EOF. If the
+// indices go backwards, that means entries have been rolled back. A record may be followed by a header record,
+// in the format [length uint8][0xFFFFFF uint24][header], which gives the header of the entry.
+func readFullMetadata(r io.Reader) (metadata, error) {
+	return readMetadataRecords(r, latestVersion)
+}
+
+// The contents of a chunk metadata file.
+type metadata struct {
+	ends    []int32
+	sums    []uint32
+	labels  []Label
+	headers [][]byte
+}
+
+// Read a chunk metadata file in the given disk format version. Before version 1, records have no checksum field,
+// and the checksums are nil. Before version 2, the index is the whole first field, and the label is always 0.
+// Before version 3, there are no header records.
+func readMetadataRecords(r io.Reader, version uint16) (metadata, error) {
+	var m metadata
+	var word uint32
+	var this int32
+	var sum uint32
 
 	for {
-		// Read the index into the ends slice.
-		if err := binary.Read(r, binary.LittleEndian, &idx); err != nil {
+		// Read the index into the ends slice, and the label.
+		if err := binary.Read(r, binary.LittleEndian, &word); err != nil {
 			if err == io.EOF {
 				break
 			}
-			return ends, err
+			return m, err
+		}
+		idx := int32(word & indexMask)
+		label := Label(word >> 24)
+		if version < 2 {
+			idx = int32(word)
+			label = 0
+		}
+
+		// A header record gives the header of the entry before it. The label is its length.
+		if version >= 3 && idx == headerMarker && len(m.ends) > 0 {
+			header := make([]byte, label)
+			if _, err := io.ReadFull(r, header); err != nil {
+				return m, err
+			}
+			if m.headers == nil {
+				m.headers = make([][]byte, len(m.ends), cap(m.ends))
+			}
+			m.headers[len(m.ends)-1] = header
+			continue
 		}
-		if idx > int32(len(ends)) {
-			return ends, &MetaContinuityError{
-				Expected: int32(len(ends)),
+
+		if idx > int32(len(m.ends)) {
+			return m, &MetaContinuityError{
+				Expected: int32(len(m.ends)),
 				Actual:   idx,
 			}
 		}
 
-		// Read the offset. If this fails, it means that syncing failed between the two writes.
+		// Read the offset and checksum. If this fails, it means that syncing failed between the writes.
 		if err := binary.Read(r, binary.LittleEndian, &this); err != nil {
-			return ends, err
+			return m, err
+		}
+		if version >= 1 {
+			if err := binary.Read(r, binary.LittleEndian, &sum); err != nil {
+				return m, err
+			}
 		}
 
 		// Check the offset is geq the prior offset.
-		if idx > 0 && this < ends[idx-1] {
-			return ends, &MetaOffsetError{
-				Expected: int32(ends[idx-1]),
+		if idx > 0 && this < m.ends[idx-1] {
+			return m, &MetaOffsetError{
+				Expected: int32(m.ends[idx-1]),
 				Actual:   this,
 			}
 		}
 
 		// Pop entries from the "ends" slice so that the current index is one past the end, and append it.
-		ends = append(ends[0:idx], this)
+		m.ends = append(m.ends[0:idx], this)
+		if version >= 1 {
+			m.sums = append(m.sums[0:idx], sum)
+		}
+		if label != 0 && m.labels == nil {
+			m.labels = make([]Label, idx, cap(m.ends))
+		}
+		if m.labels != nil {
+			m.labels = append(m.labels[0:idx], label)
+		}
+		if m.headers != nil {
+			m.headers = append(m.headers[0:idx], nil)
+		}
 	}
 
-	return ends, nil
+	return m, nil
 }
@@ -3,14 +3,14 @@ package logdb
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"testing"
 	"testing/quick"
 
-	"github.com/hashicorp/errwrap"
-	"github.com/stretchr/testify/assert"
+	"github.com/barrucadu/logdb/internal/assert"
 )
 
 /* ***** Filenames */
@@ -83,55 +83,71 @@ func TestChunk_Filenames_MetaFilePath(t *testing.T) {
 /* ***** Metadata */
 
 func TestChunk_Metadata_Works(t *testing.T) {
-	metadata := makeMetadata(t, []int32{0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5})
-	ends, err := readMetadata(metadata)
+	metadata := makeMetadata(t, []int32{0, 0, 10, 1, 1, 11, 2, 2, 12, 3, 3, 13, 4, 4, 14, 5, 5, 15})
+	ends, sums, err := readMetadata(metadata)
 	assert.Nil(t, err, "failed to read metadata: %s", err)
 	assert.Equal(t, []int32{0, 1, 2, 3, 4, 5}, ends, "ends")
+	assert.Equal(t, []uint32{10, 11, 12, 13, 14, 15}, sums, "sums")
 }
 
 func TestChunk_Metadata_NonContiguousIndices(t *testing.T) {
-	metadata := makeMetadata(t, []int32{0, 0, 1, 1, 5, 2})
-	_, err := readMetadata(metadata)
-	assert.True(t, errwrap.ContainsType(err, new(MetaContinuityError)), "expected continuity error")
+	metadata := makeMetadata(t, []int32{0, 0, 10, 1, 1, 11, 5, 2, 12})
+	_, _, err := readMetadata(metadata)
+	assert.True(t, errors.As(err, new(*MetaContinuityError)), "expected continuity error")
 }
 
 func TestChunk_Metadata_NonIncreasingEnds(t *testing.T) {
-	metadata := makeMetadata(t, []int32{0, 0, 1, 1, 2, 0})
-	_, err := readMetadata(metadata)
-	assert.True(t, errwrap.ContainsType(err, new(MetaOffsetError)), "expected offset error")
+	metadata := makeMetadata(t, []int32{0, 0, 10, 1, 1, 11, 2, 0, 12})
+	_, _, err := readMetadata(metadata)
+	assert.True(t, errors.As(err, new(*MetaOffsetError)), "expected offset error")
 }
 
 func TestChunk_Metadata_Rollback(t *testing.T) {
-	metadata := makeMetadata(t, []int32{0, 0, 1, 1, 0, 1})
-	ends, err := readMetadata(metadata)
+	metadata := makeMetadata(t, []int32{0, 0, 10, 1, 1, 11, 0, 1, 12})
+	ends, sums, err := readMetadata(metadata)
 	assert.Nil(t, err, "failed to read metadata: %s", err)
 	assert.Equal(t, []int32{1}, ends, "failed to apply rollback, got: %v", ends)
+	assert.Equal(t, []uint32{12}, sums, "failed to apply rollback, got: %v", sums)
 }
 
 func TestChunk_Metadata_Incomplete(t *testing.T) {
-	metadata := makeMetadata(t, []int32{0, 0, 1})
-	ends, err := readMetadata(metadata)
+	metadata := makeMetadata(t, []int32{0, 0, 10, 1})
+	ends, _, err := readMetadata(metadata)
+	assert.NotNil(t, err, "expected to not parse that, got: %v", ends)
+}
+
+func TestChunk_Metadata_IncompleteChecksum(t *testing.T) {
+	metadata := makeMetadata(t, []int32{0, 0, 10, 1, 1})
+	ends, _, err := readMetadata(metadata)
 	assert.NotNil(t, err, "expected to not parse that, got: %v", ends)
 }
 
 func TestChunk_Metadata_IncompleteRollback(t *testing.T) {
-	metadata := makeMetadata(t, []int32{0, 0, 1, 1, 0})
-	ends, err := readMetadata(metadata)
+	metadata := makeMetadata(t, []int32{0, 0, 10, 1, 1, 11, 0})
+	ends, _, err := readMetadata(metadata)
 	assert.NotNil(t, err, "expected to not parse that, got: %v", ends)
 }
 
+func TestChunk_Metadata_Version0(t *testing.T) {
+	metadata := makeMetadata(t, []int32{0, 0, 1, 1, 2, 2, 1, 3})
+	m, err := readMetadataRecords(metadata, 0)
+	assert.Nil(t, err, "failed to read metadata: %s", err)
+	assert.Equal(t, []int32{0, 3}, m.ends, "ends")
+	assert.Equal(t, 0, len(m.sums), "expected no sums")
+}
+
 /* ***** Opening */
 
 func TestChunk_Open_BadFilePath(t *testing.T) {
 	dir, fi := makeFile(t, "open_bad_file_path", "file", 1)
 	_, err := openChunkFile(dir, fi, nil, 0)
-	assert.True(t, errwrap.ContainsType(err, new(ChunkFileNameError)), "expected chunk file name error, got: %s", err)
+	assert.True(t, errors.As(err, new(*ChunkFileNameError)), "expected chunk file name error, got: %s", err)
 }
 
 func TestChunk_Open_BadBasedir(t *testing.T) {
 	dir, fi := makeFile(t, "open_bad_basedir", initialChunkFile, 1)
 	_, err := openChunkFile(dir+"incorrect!", fi, nil, 500)
-	assert.True(t, errwrap.ContainsType(err, new(ReadError)), "expected read error, got: %s", err)
+	assert.True(t, errors.As(err, new(*ReadError)), "expected read error, got: %s", err)
 }
 
 func TestChunk_Open_Directory(t *testing.T) {
@@ -145,19 +161,20 @@ func TestChunk_Open_Directory(t *testing.T) {
 	}
 
 	_, err = openChunkFile("test_db/open_directory", fi, nil, 500)
-	assert.True(t, errwrap.ContainsType(err, new(ReadError)), "expected read error, got: %s", err)
+	assert.True(t, errors.As(err, new(*ReadError)), "expected read error, got: %s", err)
 }
 
 func TestChunk_Open_BadSize(t *testing.T) {
 	dir, fi := makeFile(t, "open_bad_size", initialChunkFile, 1)
 	_, err := openChunkFile(dir, fi, nil, 500)
-	assert.True(t, errwrap.ContainsType(err, new(ChunkSizeError)), "expected chunk size error, got: %s", err)
+	assert.True(t, errors.As(err, new(*ChunkSizeError)), "expected chunk size error, got: %s", err)
 }
 
 func TestChunk_Open_BadMetadata(t *testing.T) {
 	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "open_bad_metadata", chunkSize)
 	filldb(t, db, numEntries)
 	assertClose(t, db)
+	unprotect(t, "test_db/open_bad_metadata/"+initialMetaFile)
 	if err := createFile("test_db/open_bad_metadata/"+initialMetaFile, 3); err != nil {
 		t.Fatal("could not truncate metadata file:", err)
 	}
@@ -168,13 +185,13 @@ func TestChunk_Open_BadMetadata(t *testing.T) {
 	}
 
 	_, err = openChunkFile("test_db/open_bad_metadata", fi, nil, chunkSize)
-	assert.True(t, errwrap.ContainsType(err, new(ChunkMetaError)), "expected chunk meta error, got: %s", err)
+	assert.True(t, errors.As(err, new(*ChunkMetaError)), "expected chunk meta error, got: %s", err)
 }
 
 func TestChunk_Open_MissingMetadata(t *testing.T) {
 	dir, fi := makeFile(t, "open_missing_metadata", initialChunkFile, chunkSize)
 	_, err := openChunkFile(dir, fi, nil, chunkSize)
-	assert.True(t, errwrap.ContainsType(err, new(ReadError)), "expected read error, got: %s", err)
+	assert.True(t, errors.As(err, new(*ReadError)), "expected read error, got: %s", err)
 }
 
 func TestChunk_Open_BadContinuity(t *testing.T) {
@@ -188,7 +205,7 @@ func TestChunk_Open_BadContinuity(t *testing.T) {
 	}
 
 	_, err = openChunkFile("test_db/open_bad_continuity", fi, &chunk{oldest: 90}, chunkSize)
-	assert.True(t, errwrap.ContainsType(err, new(ChunkContinuityError)), "expected chunk continuity error, got: %s", err)
+	assert.True(t, errors.As(err, new(*ChunkContinuityError)), "expected chunk continuity error, got: %s", err)
 }
 
 /// HELPERS
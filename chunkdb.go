@@ -1,15 +1,23 @@
 package logdb
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-const latestVersion = uint16(0)
+// The disk format version written by this library. Version 1 added a checksum to each chunk metadata record,
+// version 2 stored the entry label in the top 8 bits of the index field, version 3 added header records for entry
+// headers, and version 4 made the chunk entries file required.
+const latestVersion = uint16(4)
 
 ////////// LOG-STRUCTURED DATABASE //////////
 
@@ -19,8 +27,8 @@ const latestVersion = uint16(0)
 // data.
 //
 // In addition to implementing the behaviour specified in the 'LogDB', 'PersistDB', 'BoundedDB', and 'CloseDB'
-// interfaces, a 'Sync' is always performed if an entire chunk is forgotten, rolled back, or truncated; or if
-// an append creates a new on-disk chunk.
+// interfaces, a 'Sync' is always performed if an entire chunk is forgotten (unless deletions are being batched,
+// see 'SetForgetBatch'), rolled back, or truncated; or if an append creates a new on-disk chunk.
 type ChunkDB struct {
 	// The underlying 'LockFreeChunkDB'. This is not safe for concurrent use with the 'ChunkDB'.
 	*LockFreeChunkDB
@@ -32,6 +40,20 @@ type ChunkDB struct {
 	// the necessary write locks. This would complicate locking but allow for more concurrent reading, and
 	// so may be better under some work loads.
 	rwlock sync.RWMutex
+
+	// If nonzero, appends which fail with 'ErrNoSpace' are retried this often, after calling 'noSpaceHook'.
+	noSpaceRetry time.Duration
+	noSpaceHook  func()
+
+	// If true, appends sync after releasing the write lock, one at a time, holding 'commitLock'. See
+	// 'SetGroupCommit'.
+	groupCommit bool
+	commitLock  sync.Mutex
+
+	// Entries appended by 'AppendAsync' which are waiting to be synced, and whether a goroutine is syncing them.
+	asyncLock    sync.Mutex
+	asyncQueue   []asyncAppend
+	asyncRunning bool
 }
 
 // A LockFreeChunkDB is a 'ChunkDB' with no internal locks. It is NOT safe for concurrent use.
@@ -41,7 +63,12 @@ type LockFreeChunkDB struct {
 
 	// Lock file used to prevent multiple simultaneous open handles: concurrent use of one handle is fine,
 	// multiple handles is not. This file is locked exclusive, not shared.
-	lockfile *os.File
+	//
+	// In network filesystem mode, flock is not used: 'heartbeat' holds a lock file instead, and 'lockfile'
+	// is nil. Metadata files are also rewritten in full rather than appended to.
+	lockfile  *os.File
+	heartbeat *heartbeatLock
+	nfs       bool
 
 	// Flag indicating that the handle has been closed. This is used to give 'ErrClosed' errors.
 	closed bool
@@ -49,6 +76,9 @@ type LockFreeChunkDB struct {
 	// Size of individual chunks. Entries are not split over chunks, and so they cannot be bigger than this.
 	chunkSize uint32
 
+	// If nonzero, the most entries a chunk may hold.
+	chunkEntries uint32
+
 	// Chunks, in order.
 	chunks []*chunk
 
@@ -64,10 +94,12 @@ type LockFreeChunkDB struct {
 	// Data syncing: 'syncEvery' is how many changes (entries appended/truncated) to allow before syncing,
 	// 'sinceLastSync' keeps track of this, and 'syncDirty' is the set of chunks to sync. When syncing,
 	// first chunks are deleted newest-first, then data is flushed oldest-first. This is to maintain
-	// consistency,
+	// consistency. 'lastSync' is when the last sync finished, and 'syncs' is how many syncs have finished.
 	syncEvery     int
 	sinceLastSync uint64
 	syncDirty     map[*chunk]struct{}
+	lastSync      time.Time
+	syncs         uint64
 
 	// Concurrent syncing/reading is safe, but syncing/writing and syncing/syncing is not. To prevent the
 	// first, syncing claims a read lock. To prevent the latter, a special sync lock is used. Claiming a
@@ -75,6 +107,91 @@ type LockFreeChunkDB struct {
 	//
 	// This is inside LockFreeChunkDB because picking it out would be a real pain. TODO: fix :(
 	slock sync.Mutex
+
+	// Latency histograms for appending, getting, syncing, and creating new chunks.
+	latencies *latencies
+
+	// If not nil, counts of appends, gets, syncs, and so on are reported to this.
+	metrics MetricsCollector
+
+	// Operations taking at least 'slowThreshold' are logged to 'logger', if both are set.
+	logger        Logger
+	slowThreshold time.Duration
+
+	// Hooks called as chunks are created, sealed, opened, and deleted.
+	hooks ChunkHooks
+
+	// Chunk deletions caused by forgetting are batched until at least 'forgetBatch' chunks are awaiting
+	// deletion (or the next sync); 'pendingDeletes' keeps track of this.
+	forgetBatch    int
+	pendingDeletes int
+
+	// What was found when the database was opened.
+	report OpenReport
+
+	// Entries up to and including the commit point can only be rolled back by force.
+	commitPoint uint64
+
+	// Every rollback which has removed entries, in order, for 'Generation' and 'RolledBackTo'.
+	generations []generationRecord
+
+	// If nonzero, writes are refused once a newer fencing token has been written to disk.
+	fence uint64
+
+	// If not nil, invariant violations are reported to 'invariants', with 'chunkStates' used to check the
+	// transitions of chunks between checks.
+	invariants  func(*InvariantError)
+	chunkStates map[*chunk]chunkState
+
+	// Thresholds to warn about, and which of them were exceeded at the last check.
+	softLimits   SoftLimits
+	softExceeded [numSoftLimits]bool
+
+	// Removing more than 'truncateLimit' entries at once requires confirmation, with the token of the
+	// truncation awaiting confirmation in 'pendingTruncate'.
+	truncateLimit   uint64
+	pendingTruncate *pendingTruncate
+
+	// If nonzero, deleted chunks are moved to the trash, and deleted from there after this long.
+	trashGrace time.Duration
+
+	// If nonzero, entries removed by a rollback are kept in 'redo' for this long after 'redoAt', with the
+	// first having had the ID 'redoFirst'.
+	redoWindow time.Duration
+	redo       []redoEntry
+	redoFirst  uint64
+	redoAt     time.Time
+
+	// When free space on the volume is low, the oldest chunks are forgotten according to this.
+	emergency EmergencyRetention
+
+	// If nonzero, the most heap memory the in-memory state should use.
+	memoryLimit uint64
+
+	// If nonzero, the most bytes of entries a backup copies per second.
+	backupRate uint64
+
+	// How long entries with each label are kept, applied by 'Compact'.
+	labelRetention map[Label]time.Duration
+
+	// Set once a warning has been logged that the chunk size is pathological for the entries being stored.
+	warnedChunkSize bool
+
+	// How much verification to perform when opening, how to recover, and how to write entries, kept for
+	// 'Reopen'.
+	verify      VerifyLevel
+	recovery    RecoveryPolicy
+	writePath   WritePath
+	relaxedSync bool
+	durability  Durability
+
+	// The O_DSYNC file descriptor entries are written to with 'DurabilityDsync', and the chunk it is of.
+	dsyncf     *os.File
+	dsyncChunk *chunk
+
+	// Flag indicating that the files of the active chunk have gone missing. This is used to give
+	// 'ErrChunkMissing' errors until the database is reopened.
+	missing bool
 }
 
 // Open a 'LockFreeChunkDB' database.
@@ -91,18 +208,42 @@ type LockFreeChunkDB struct {
 // If the 'create' flag is true and the database doesn't already exist, the database is created using the given
 // chunk size. If the database does exist, the chunk size parameter is ignored, and detected automatically from
 // the chunk files.
+//
+// This is equivalent to 'OpenWithOptions' with the 'WithChunkSize' and 'WithCreate' options.
 func Open(path string, chunkSize uint32, create bool) (*LockFreeChunkDB, error) {
+	return OpenWithOptions(path, WithChunkSize(chunkSize), WithCreate(create))
+}
+
+// OpenWithOptions opens a 'LockFreeChunkDB' database, as 'Open' does, but configured by the given options.
+// Options are applied in order, so if the same option is given more than once, the last one wins.
+func OpenWithOptions(path string, opts ...Option) (*LockFreeChunkDB, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	// Check if it already exists.
+	var db *LockFreeChunkDB
+	var err error
 	if stat, _ := os.Stat(path); stat != nil {
 		if !stat.IsDir() {
 			return nil, ErrNotDirectory
 		}
-		return opendb(path)
+		db, err = opendb(path, o)
+	} else if o.create {
+		db, err = createdb(path, o)
+	} else {
+		return nil, ErrPathDoesntExist
 	}
-	if create {
-		return createdb(path, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.applySettings(db); err != nil {
+		_ = db.Close()
+		return nil, err
 	}
-	return nil, ErrPathDoesntExist
+	return db, nil
 }
 
 // Wrap a 'LockFreeChunkDB' into a 'ChunkDB', which is safe for concurrent use. The underlying
@@ -111,32 +252,66 @@ func WrapForConcurrency(db *LockFreeChunkDB) *ChunkDB {
 	return &ChunkDB{LockFreeChunkDB: db}
 }
 
+// Append implements the 'LogDB', 'PersistDB', 'BoundedDB', and 'CloseDB' interfaces.
+//
+// If 'SetBlockOnNoSpace' has been used, this blocks while the disk is full.
+func (db *ChunkDB) Append(entry []byte) (uint64, error) {
+	return db.AppendContext(context.Background(), entry)
+}
+
 // Append implements the 'LogDB', 'PersistDB', 'BoundedDB', and 'CloseDB' interfaces.
 func (db *LockFreeChunkDB) Append(entry []byte) (uint64, error) {
 	return db.AppendEntries([][]byte{entry})
 }
 
 // AppendEntries implements the 'LogDB', 'PersistDB', 'BoundedDB', and 'CloseDB' interfaces.
+//
+// If 'SetBlockOnNoSpace' has been used, this blocks while the disk is full.
 func (db *ChunkDB) AppendEntries(entries [][]byte) (uint64, error) {
-	db.rwlock.Lock()
-	defer db.rwlock.Unlock()
-
-	return db.LockFreeChunkDB.AppendEntries(entries)
+	return db.AppendEntriesContext(context.Background(), entries)
 }
 
 // AppendEntries implements the 'LogDB', 'PersistDB', 'BoundedDB', and 'CloseDB' interfaces.
 func (db *LockFreeChunkDB) AppendEntries(entries [][]byte) (uint64, error) {
-	defer func() { db.newest = db.next() - 1 }()
+	return db.appendEntries(entries, 0, nil)
+}
+
+// Append entries with a label.
+func (db *LockFreeChunkDB) appendEntries(entries [][]byte, label Label, headers [][]byte) (uint64, error) {
+	return db.appendEntriesThen(entries, label, headers, db.periodicSync)
+}
+
+// Append entries with a label and, if 'headers' is not nil, a header each, then sync with the given function,
+// unless it is nil. Assumes a write lock is held.
+func (db *LockFreeChunkDB) appendEntriesThen(entries [][]byte, label Label, headers [][]byte, sync func() error) (_ uint64, err error) {
+	start := time.Now()
+	originalNewest := db.next() - 1
+	defer func() {
+		db.newest = db.next() - 1
+		db.observe(&db.latencies.append, start, "append", originalNewest+1, originalNewest+uint64(len(entries)), db.activeChunkPath())
+		db.countError(err)
+	}()
 
 	if db.closed {
 		return 0, ErrClosed
 	}
-
-	originalNewest := db.next() - 1
+	if err := db.checkFence(); err != nil {
+		return 0, err
+	}
+	if db.missing {
+		return 0, ErrChunkMissing
+	}
+	if headers != nil && len(headers) != len(entries) {
+		return 0, ErrHeaderCount
+	}
 
 	var appended bool
-	for _, entry := range entries {
-		if err := db.append(entry); err != nil {
+	for i, entry := range entries {
+		var header []byte
+		if headers != nil {
+			header = headers[i]
+		}
+		if err := db.append(entry, label, header); err != nil {
 			// Rollback on error if we've already appended some entries.
 			if appended {
 				if rerr := db.rollback(originalNewest); rerr != nil {
@@ -147,8 +322,12 @@ func (db *LockFreeChunkDB) AppendEntries(entries [][]byte) (uint64, error) {
 		}
 		appended = true
 	}
+	db.count(MetricAppends, uint64(len(entries)))
 
-	return originalNewest + 1, db.periodicSync()
+	if sync == nil {
+		return originalNewest + 1, db.checked("append", nil)
+	}
+	return originalNewest + 1, db.checked("append", sync())
 }
 
 // Get implements the 'LogDB' and 'CloseDB' interfaces.
@@ -159,8 +338,20 @@ func (db *ChunkDB) Get(id uint64) ([]byte, error) {
 	return db.LockFreeChunkDB.Get(id)
 }
 
-// Get implements the 'LogDB' and 'CloseDB' interfaces.
-func (db *LockFreeChunkDB) Get(id uint64) ([]byte, error) {
+// Get implements the 'LogDB' and 'CloseDB' interfaces. Returns 'ErrDownsampled' if the entry has been dropped by
+// 'Downsample'.
+//
+// Chunk data files are memory-mapped, so this makes no syscalls unless there was not the address space to map
+// the chunk. The entry is copied out of the mapping, as the mapping is removed when its chunk is deleted; use
+// 'GetEntries' to copy many entries at once.
+func (db *LockFreeChunkDB) Get(id uint64) (_ []byte, err error) {
+	began := time.Now()
+	var path string
+	defer func() {
+		db.observe(&db.latencies.get, began, "get", id, id, path)
+		db.countError(err)
+	}()
+
 	if db.closed {
 		return nil, ErrClosed
 	}
@@ -170,34 +361,130 @@ func (db *LockFreeChunkDB) Get(id uint64) ([]byte, error) {
 		return nil, ErrIDOutOfRange
 	}
 
-	// Binary search through chunks for the one containing the ID.
-	lo := 0
-	hi := len(db.chunks)
-	mid := hi / 2
-	for ; !(db.chunks[mid].oldest <= id && id < db.chunks[mid].next()); mid = (hi + lo) / 2 {
-		if hi < lo {
-			panic("hi < lo")
+	// Return a copy of the relevant byte slice.
+	chunk, start, end := db.find(id)
+	path = chunk.path
+	out := make([]byte, end-start)
+	if err := chunk.readAt(out, start); err != nil {
+		return nil, err
+	}
+	if err := chunk.check(id, out); err != nil {
+		return nil, err
+	}
+	db.count(MetricGets, 1)
+	return out, nil
+}
+
+// GetEntries is the thread-safe version of 'LockFreeChunkDB.GetEntries'.
+func (db *ChunkDB) GetEntries(start, end uint64) ([][]byte, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.GetEntries(start, end)
+}
+
+// GetEntries gets the entries from 'start' to 'end', inclusive. This is much cheaper than calling 'Get' for
+// each entry: the entries of each chunk are copied in one go, and share one allocation.
+//
+// Entries which have been dropped by 'Downsample' are nil.
+//
+// Returns 'ErrIDOutOfRange' if any of the entries do not exist (including if 'end' is older than 'start'),
+// 'ErrChecksumMismatch' if an entry is corrupt, and 'ErrClosed' if the handle is closed.
+func (db *LockFreeChunkDB) GetEntries(start, end uint64) (_ [][]byte, err error) {
+	began := time.Now()
+	defer db.observe(nil, began, "get", start, end, db.path)
+	defer func() { db.countError(err) }()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if start < db.oldest || end >= db.next() || end < start || len(db.chunks) == 0 {
+		return nil, ErrIDOutOfRange
+	}
+
+	out := make([][]byte, 0, end-start+1)
+	for i := db.findChunk(start); start <= end; i++ {
+		chunk := db.chunks[i]
+		last := chunk.next() - 1
+		if last > end {
+			last = end
 		}
-		if db.chunks[mid].next() <= id {
-			lo = mid + 1
-		} else if db.chunks[mid].oldest > id {
-			hi = mid - 1
+
+		// Copy all the entries wanted from this chunk at once, and then split them up.
+		_, from, _ := chunk.find(start)
+		_, _, to := chunk.find(last)
+		buf := make([]byte, to-from)
+		if err := chunk.readAt(buf, from); err != nil {
+			return nil, err
 		}
+		for id := start; id <= last; id++ {
+			_, s, e := chunk.find(id)
+			entry := buf[s-from : e-from : e-from]
+			if err := chunk.check(id, entry); err == ErrDownsampled {
+				entry = nil
+			} else if err != nil {
+				return nil, err
+			}
+			out = append(out, entry)
+		}
+		start = last + 1
 	}
+	db.count(MetricGets, uint64(len(out)))
+	return out, nil
+}
+
+// GetRaw implements the 'RawDB' interface.
+func (db *ChunkDB) GetRaw(id uint64) (io.Reader, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.GetRaw(id)
+}
 
-	// Calculate the start and end offset, and return a copy of the relevant byte slice.
-	chunk := db.chunks[mid]
-	off := id - chunk.oldest
-	start := int32(0)
-	if off > 0 {
-		start = chunk.ends[off-1]
+// GetRaw implements the 'RawDB' interface. Entries are stored unchanged, so this reads the same bytes as 'Get',
+// but from the chunk data file as the reader is read, rather than copying the entry up front. The checksum is
+// checked as the end of the entry is reached, so the final read returns 'ErrChecksumMismatch' if the entry is
+// corrupt. If the chunk is deleted before the entry has been read, reading fails.
+//
+// Returns 'ErrDownsampled' if the entry has been dropped by 'Downsample', and the same errors as 'Get'.
+func (db *LockFreeChunkDB) GetRaw(id uint64) (io.Reader, error) {
+	if db.closed {
+		return nil, ErrClosed
 	}
-	end := chunk.ends[off]
-	out := make([]byte, end-start)
-	for i := start; i < end; i++ {
-		out[i-start] = chunk.bytes[i]
+	if id < db.oldest || id >= db.next() || len(db.chunks) == 0 {
+		return nil, ErrIDOutOfRange
 	}
-	return out, nil
+
+	c, start, end := db.find(id)
+	if start == end {
+		if err := c.check(id, nil); err != nil {
+			return nil, err
+		}
+	}
+	db.count(MetricGets, 1)
+	return c.reader(id, start, end), nil
+}
+
+// StoredSize implements the 'SizedDB' interface.
+func (db *ChunkDB) StoredSize(id uint64) (uint64, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.StoredSize(id)
+}
+
+// StoredSize implements the 'SizedDB' interface. Entries are stored unchanged, so this is the length of the
+// entry, not counting the metadata record or any unused space at the end of its chunk.
+func (db *LockFreeChunkDB) StoredSize(id uint64) (uint64, error) {
+	if db.closed {
+		return 0, ErrClosed
+	}
+	if id < db.oldest || id >= db.next() || len(db.chunks) == 0 {
+		return 0, ErrIDOutOfRange
+	}
+
+	_, start, end := db.find(id)
+	return uint64(end - start), nil
 }
 
 // Forget implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces.
@@ -209,11 +496,19 @@ func (db *ChunkDB) Forget(newOldestID uint64) error {
 }
 
 // Forget implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces.
-func (db *LockFreeChunkDB) Forget(newOldestID uint64) error {
+func (db *LockFreeChunkDB) Forget(newOldestID uint64) (err error) {
+	defer db.observe(nil, time.Now(), "forget", db.oldest, newOldestID, db.path)
+	defer func() { db.countError(err) }()
 	if db.closed {
 		return ErrClosed
 	}
-	return db.forget(newOldestID)
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	if err := db.checkInterlock(newOldestID, db.newest); err != nil {
+		return err
+	}
+	return db.checked("forget", db.forget(newOldestID))
 }
 
 // Rollback implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces.
@@ -224,13 +519,25 @@ func (db *ChunkDB) Rollback(newNewestID uint64) error {
 	return db.LockFreeChunkDB.Rollback(newNewestID)
 }
 
-// Rollback implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces.
-func (db *LockFreeChunkDB) Rollback(newNewestID uint64) error {
+// Rollback implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces. It returns 'ErrBelowCommitPoint' if
+// entries at or before the commit point would be removed: see 'SetCommitPoint'.
+func (db *LockFreeChunkDB) Rollback(newNewestID uint64) (err error) {
+	defer db.observe(nil, time.Now(), "rollback", newNewestID, db.newest, db.path)
+	defer func() { db.countError(err) }()
 	defer func() { db.newest = db.next() - 1 }()
 	if db.closed {
 		return ErrClosed
 	}
-	return db.rollback(newNewestID)
+	if newNewestID < db.commitPoint && newNewestID < db.newest {
+		return ErrBelowCommitPoint
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	if err := db.checkInterlock(db.oldest, newNewestID); err != nil {
+		return err
+	}
+	return db.checked("rollback", db.softRollback(newNewestID))
 }
 
 // Truncate implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces.
@@ -241,19 +548,22 @@ func (db *ChunkDB) Truncate(newOldestID, newNewestID uint64) error {
 	return db.LockFreeChunkDB.Truncate(newOldestID, newNewestID)
 }
 
-// Truncate implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces.
-func (db *LockFreeChunkDB) Truncate(newOldestID, newNewestID uint64) error {
+// Truncate implements the 'LogDB', 'PersistDB', and 'CloseDB' interfaces. It returns 'ErrBelowCommitPoint' as
+// 'Rollback' does.
+func (db *LockFreeChunkDB) Truncate(newOldestID, newNewestID uint64) (err error) {
+	defer db.observe(nil, time.Now(), "truncate", newOldestID, newNewestID, db.path)
+	defer func() { db.countError(err) }()
 	defer func() { db.newest = db.next() - 1 }()
 	if db.closed {
 		return ErrClosed
 	}
-	if newNewestID < newOldestID {
-		return ErrIDOutOfRange
+	if err := db.checkFence(); err != nil {
+		return err
 	}
-	if err := db.forget(newOldestID); err != nil {
+	if err := db.checkInterlock(newOldestID, newNewestID); err != nil {
 		return err
 	}
-	return db.rollback(newNewestID)
+	return db.checked("truncate", db.truncate(newOldestID, newNewestID))
 }
 
 // OldestID implements the 'LogDB' interface.
@@ -290,6 +600,39 @@ func (db *LockFreeChunkDB) SetSync(every int) error {
 	return db.periodicSync()
 }
 
+// SetForgetBatch configures how many chunks may await deletion after a 'Forget' or 'Truncate' before a sync is
+// performed. See 'LockFreeChunkDB.SetForgetBatch' for details.
+func (db *ChunkDB) SetForgetBatch(chunks int) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.SetForgetBatch(chunks)
+}
+
+// SetForgetBatch configures how many chunks may await deletion after a 'Forget' or 'Truncate' before a sync is
+// performed. By default, a sync is performed as soon as a chunk is entirely forgotten, which, if the oldest
+// entry is advanced frequently by small amounts, causes many small syncs.
+//
+// With batching, forgotten chunks remain on disk until enough are awaiting deletion, or until the next periodic
+// or explicit sync. Entries in them cannot be retrieved, but if the program dies before the chunks are
+// deleted, the entries will still be there when the database is next opened. <=1 disables batching.
+//
+// Returns 'ErrClosed' if the handle is closed, and a 'SyncError' value if this triggered an immediate
+// synchronisation which failed.
+func (db *LockFreeChunkDB) SetForgetBatch(chunks int) error {
+	if db.closed {
+		return ErrClosed
+	}
+	db.forgetBatch = chunks
+	if db.pendingDeletes > 0 && db.pendingDeletes >= db.forgetBatch {
+		if err := db.sync(); err != nil {
+			return err
+		}
+		db.prune()
+	}
+	return nil
+}
+
 // Sync implements the 'PersistDB' and 'CloseDB' interface.
 func (db *ChunkDB) Sync() error {
 	db.rwlock.RLock()
@@ -303,7 +646,9 @@ func (db *LockFreeChunkDB) Sync() error {
 	if db.closed {
 		return ErrClosed
 	}
-	return db.sync()
+	err := db.sync()
+	db.countError(err)
+	return db.checked("sync", err)
 }
 
 // MaxEntrySize implements the 'BoundedDB' interface.
@@ -332,9 +677,10 @@ func (db *LockFreeChunkDB) Close() error {
 	for _, c := range db.chunks {
 		_ = c.mmapf.Close()
 	}
+	db.closeDsync()
 
 	// Then release the lock
-	funlock(db.lockfile)
+	_ = unlockdb(db.lockfile, db.heartbeat)
 
 	// Mark the databse as closed, so any further attempts to use
 	// this handle will be errored.
@@ -346,7 +692,13 @@ func (db *LockFreeChunkDB) Close() error {
 ////////// HELPERS //////////
 
 // Create a database. It is an error to call this function if the database directory already exists.
-func createdb(path string, chunkSize uint32) (*LockFreeChunkDB, error) {
+func createdb(path string, o options) (*LockFreeChunkDB, error) {
+	start := time.Now()
+	chunkSize := o.chunkSize
+	if chunkSize > maxChunkSize {
+		return nil, ErrChunkSizeTooBig
+	}
+
 	// Create the directory.
 	if err := os.MkdirAll(path, os.ModeDir|0755); err != nil {
 		return nil, &PathError{err}
@@ -357,8 +709,8 @@ func createdb(path string, chunkSize uint32) (*LockFreeChunkDB, error) {
 		return nil, &WriteError{err}
 	}
 
-	// Lock the "version" file.
-	lockfile, err := flock(path + "/version")
+	// Lock the database.
+	lockfile, heartbeat, err := lockdb(path, o.nfs)
 	if err != nil {
 		return nil, &LockError{err}
 	}
@@ -368,6 +720,11 @@ func createdb(path string, chunkSize uint32) (*LockFreeChunkDB, error) {
 		return nil, &WriteError{err}
 	}
 
+	// Write the chunk entries file, which is 0 if there is no limit.
+	if err := writeFile(path+"/chunk_entries", o.chunkEntries); err != nil {
+		return nil, &WriteError{err}
+	}
+
 	// Write the "oldest" file.
 	if err := writeFile(path+"/oldest", uint64(0)); err != nil {
 		return nil, &WriteError{err}
@@ -377,14 +734,29 @@ func createdb(path string, chunkSize uint32) (*LockFreeChunkDB, error) {
 		path:      path,
 		closed:    false,
 		lockfile:  lockfile,
+		heartbeat: heartbeat,
+		nfs:       o.nfs,
+		verify:    o.verify,
+		recovery:  o.recovery,
+		writePath: o.writePath,
 		chunkSize: chunkSize,
 		syncEvery: 256,
 		syncDirty: make(map[*chunk]struct{}),
+		latencies: new(latencies),
+		hooks:     o.hooks,
+		report:    OpenReport{Created: true, Duration: time.Since(start)},
+
+		relaxedSync:  o.relaxedSync,
+		durability:   o.durability,
+		chunkEntries: o.chunkEntries,
 	}, nil
 }
 
 // Open an existing database. It is an error to call this function if the database directory does not exist.
-func opendb(path string) (*LockFreeChunkDB, error) {
+func opendb(path string, o options) (db *LockFreeChunkDB, err error) {
+	start := time.Now()
+	var report OpenReport
+
 	// Read the "version" file.
 	var version uint16
 	if err := readFile(path+"/version", &version); err != nil {
@@ -392,53 +764,114 @@ func opendb(path string) (*LockFreeChunkDB, error) {
 	}
 
 	// Check the version.
-	if version != 0 {
-		return nil, ErrUnknownVersion
+	if err := checkFormat(version); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path + "/" + migrateDir); err == nil {
+		return nil, ErrMigrationIncomplete
 	}
 
-	// Lock the "version" file.
-	lockfile, err := flock(path + "/version")
+	// Lock the database, and release the lock again if opening fails, closing any chunks which were opened.
+	lockfile, heartbeat, err := lockdb(path, o.nfs)
 	if err != nil {
 		return nil, &LockError{err}
 	}
+	var chunks []*chunk
+	defer func() {
+		if err != nil {
+			for _, c := range chunks {
+				c.close()
+			}
+			_ = unlockdb(lockfile, heartbeat)
+		}
+	}()
 
 	// Read the "chunk_size" file.
 	var chunkSize uint32
 	if err := readFile(path+"/chunk_size", &chunkSize); err != nil {
 		return nil, &ReadError{err}
 	}
+	if chunkSize > maxChunkSize {
+		return nil, ErrChunkSizeTooBig
+	}
 
-	// Get all the chunk files.
-	var chunkFiles []os.FileInfo
-	var metaFiles []os.FileInfo
-	fis, err := ioutil.ReadDir(path)
-	if err != nil {
+	// Read the "chunk_entries" file.
+	var chunkEntries uint32
+	if err := readFile(path+"/chunk_entries", &chunkEntries); err != nil {
 		return nil, &ReadError{err}
 	}
-	for _, fi := range fis {
-		if !fi.IsDir() && isBasenameChunkDataFile(fi.Name()) {
-			chunkFiles = append(chunkFiles, fi)
-		}
+
+	// Complete any import which was interrupted after being committed, and discard any which was not.
+	if _, err := os.Stat(path + "/" + importDir + "/" + importCommit); err == nil {
+		report.recover("completed interrupted import")
+	} else if _, err := os.Stat(path + "/" + importDir); err == nil {
+		report.recover("discarded uncommitted import")
 	}
-	for _, fi := range fis {
-		if !fi.IsDir() && isBasenameChunkMetaFile(fi.Name()) {
-			metaFiles = append(metaFiles, fi)
-		}
+	if err := finishImport(path); err != nil {
+		return nil, &ReadError{err}
 	}
 
-	sort.Sort(fileInfoSlice(chunkFiles))
+	// Similarly for any compaction.
+	if _, err := os.Stat(path + "/" + compactDir + "/" + compactCommit); err == nil {
+		report.recover("completed interrupted compaction")
+	} else if _, err := os.Stat(path + "/" + compactDir); err == nil {
+		report.recover("discarded uncommitted compaction")
+	}
+	if err := finishCompact(path); err != nil {
+		return nil, &ReadError{err}
+	}
+
+	// Remove the links of any backup which was interrupted.
+	if _, err := os.Stat(path + "/" + backupLinks); err == nil {
+		report.recover("removed links of interrupted backup")
+		if err := os.RemoveAll(path + "/" + backupLinks); err != nil {
+			return nil, &DeleteError{err}
+		}
+	}
 
-	if len(metaFiles) > 0 {
-		// There may be metadata files without accompanying
-		// data files, if the program died while deleting.
-		// Delete such files.
-		for _, fi := range metaFiles {
-			if _, err := os.Stat(path + "/" + dataFilePath(fi.Name())); err != nil {
-				_ = os.Remove(path + "/" + fi.Name())
+	// Get all the chunk files. These are in the database directory and in any shard directories; 'dirs' maps the
+	// name of each data file to the directory it is in.
+	//
+	// There may be metadata and seal files without accompanying data files, if the program died while deleting,
+	// and partially-written metadata files from a whole-file rewrite. Delete such files.
+	var chunkFiles []os.FileInfo
+	dirs := make(map[string]string)
+	scan := []string{path}
+	for i := 0; i < len(scan); i++ {
+		dir := scan[i]
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, &ReadError{err}
+		}
+		for _, fi := range fis {
+			name := fi.Name()
+			switch {
+			case fi.IsDir() && dir == path && isBasenameShardDir(name):
+				scan = append(scan, path+"/"+name)
+			case !fi.IsDir() && isBasenameChunkDataFile(name):
+				chunkFiles = append(chunkFiles, fi)
+				dirs[name] = dir
+			case !fi.IsDir() && isBasenameChunkMetaFile(name):
+				if _, err := os.Stat(dir + "/" + dataFilePath(name)); err != nil {
+					_ = os.Remove(dir + "/" + name)
+					report.Removed = append(report.Removed, dir+"/"+name)
+				}
+			case !fi.IsDir() && isBasenameChunkSealFile(name):
+				if _, err := os.Stat(dir + "/" + strings.TrimSuffix(name, sep+sealSuffix)); err != nil {
+					_ = os.Remove(dir + "/" + name)
+					report.Removed = append(report.Removed, dir+"/"+name)
+				}
+			case !fi.IsDir() && strings.HasSuffix(name, sep+metaSuffix+tmpSuffix):
+				_ = os.Remove(dir + "/" + name)
+				report.Removed = append(report.Removed, dir+"/"+name)
+			case dir != path || !reportKnownFiles[name]:
+				report.Ignored = append(report.Ignored, dir+"/"+name)
 			}
 		}
 	}
 
+	sort.Sort(fileInfoSlice(chunkFiles))
+
 	if len(chunkFiles) > 0 {
 		// There may be a gap in the chunk files, if the program died while deleting them. Because
 		// files are deleted newest-first, the newest contiguous sequence of chunks is what should
@@ -454,10 +887,12 @@ func opendb(path string) (*LockFreeChunkDB, error) {
 			// backwards, these should decrease by 1 every time with no gaps. If there is a gap,
 			// we can enter chunk deleting mode.
 			if priorCID > 0 && cid < priorCID-1 {
-				filePath := path + "/" + chunkFiles[i].Name()
+				filePath := dirs[chunkFiles[i].Name()] + "/" + chunkFiles[i].Name()
 				metaPath := metaFilePath(filePath)
 				_ = os.Remove(filePath)
 				_ = os.Remove(metaPath)
+				_ = os.Remove(sealFilePath(filePath))
+				report.Gaps = append(report.Gaps, filePath)
 			} else {
 				priorCID = cid
 				first = i
@@ -468,58 +903,203 @@ func opendb(path string) (*LockFreeChunkDB, error) {
 		// The final chunk may be zero-size, if the program died between the file being created and it
 		// being sized. If it is, delete it. Similarly, the final chunk may have no metadata file.
 		final := chunkFiles[len(chunkFiles)-1]
-		filePath := path + "/" + final.Name()
+		filePath := dirs[final.Name()] + "/" + final.Name()
 		metaPath := metaFilePath(filePath)
 		if _, err := os.Stat(metaPath); final.Size() == 0 || err != nil {
 			_ = os.Remove(filePath)
 			_ = os.Remove(metaPath)
+			_ = os.Remove(sealFilePath(filePath))
 			chunkFiles = chunkFiles[:len(chunkFiles)-1]
+			report.Removed = append(report.Removed, filePath)
+			report.recover("removed incomplete final chunk " + filePath)
 		}
 	}
 
-	// Populate the chunk slice.
-	chunks := make([]*chunk, len(chunkFiles))
-	var prior *chunk
-	var empty bool
-	for i, fi := range chunkFiles {
+	// Populate the chunk slice. If a chunk cannot be loaded, the recovery policy decides which chunks to cut off.
+	chunks = make([]*chunk, 0, len(chunkFiles))
+	var discardDir string
+	discard := func(file string) error {
+		if discardDir == "" {
+			discardDir = fmt.Sprintf("%s/%s/%v", path, repairDir, time.Now().UnixNano())
+		}
+		report.recover("moved chunk " + file + " to " + discardDir)
+		return discardChunk(file, discardDir)
+	}
+	for i := 0; i < len(chunkFiles); i++ {
+		fi := chunkFiles[i]
+		var prior *chunk
+		if len(chunks) > 0 {
+			prior = chunks[len(chunks)-1]
+		}
+
 		// Normally a chunk contains at least one entry. This may only false for the final chunk. So if
-		// we have a chunk file to process and the 'empty' flag is set, then we have an error.
-		if empty {
-			return nil, &FormatError{
+		// we have a chunk file to process and the prior chunk is empty, then we have an error.
+		var c chunk
+		var err error
+		var corrupt bool
+		if prior != nil && len(prior.ends) == 0 {
+			err = &FormatError{
 				FilePath: prior.metaFilePath(),
 				Err:      ErrEmptyNonfinalChunk,
 			}
+		} else if c, err = openChunkFile(dirs[fi.Name()], fi, prior, chunkSize); err != nil {
+			corrupt = !errors.As(err, new(*ChunkContinuityError))
+			c.close()
+		}
+		if err == nil {
+			chunks = append(chunks, &c)
+			continue
 		}
 
-		c, err := openChunkFile(path, fi, prior, chunkSize)
-		if err != nil {
+		switch o.recovery {
+		case RecoverTruncate:
+			// Cut off this chunk and every one after it.
+			report.recover(fmt.Sprintf("truncated at chunk %s: %v", dirs[fi.Name()]+"/"+fi.Name(), err))
+			for _, fi := range chunkFiles[i:] {
+				if err := discard(dirs[fi.Name()] + "/" + fi.Name()); err != nil {
+					return nil, &WriteError{err}
+				}
+			}
+			chunkFiles = chunkFiles[:i]
+		case RecoverForget:
+			// Cut off every chunk before this one, and this one too if it is corrupt, rather than not following
+			// on from the chunk before it. Otherwise, try again without the chunks before it.
+			report.recover(fmt.Sprintf("forgot up to chunk %s: %v", dirs[fi.Name()]+"/"+fi.Name(), err))
+			for _, c := range chunks {
+				c.close()
+				if err := discard(c.path); err != nil {
+					return nil, &WriteError{err}
+				}
+			}
+			chunks = chunks[:0]
+			if corrupt {
+				if err := discard(dirs[fi.Name()] + "/" + fi.Name()); err != nil {
+					return nil, &WriteError{err}
+				}
+			} else {
+				i--
+			}
+		default:
 			return nil, err
 		}
-		chunks[i] = &c
-		prior = &c
-		empty = len(c.ends) == 0
+	}
+	if o.hooks.Opened != nil {
+		for _, c := range chunks {
+			o.hooks.Opened(c.info())
+		}
+	}
+
+	// Check that no sealed chunk has been modified since it was sealed (unless verification is disabled), and
+	// make sure that they are read-only: the process may have died between writing the seal file and changing
+	// the permissions.
+	// The final chunk may also be sealed, if the process died before its successor was created, in which
+	// case it is unsealed so that it can be written to.
+	for i, c := range chunks {
+		if o.verify != VerifyNone {
+			if err := c.checkSeal(false); err != nil {
+				return nil, err
+			}
+		}
+		if !c.sealed {
+			continue
+		}
+		if i == len(chunks)-1 {
+			err = c.unseal()
+			report.recover("unsealed final chunk " + c.path)
+		} else {
+			err = c.makeReadOnly()
+		}
+		if err != nil {
+			return nil, &ReadError{err}
+		}
 	}
 
 	// If we cannot read the "oldest" file OR the oldest entry according to the metadata is older than the
 	// oldest entry we actually have, bump it up to the newer one. This could happen if a chunk is forgotten
 	// and then the program crashes before the "oldest" file gets rewritten.
+	//
+	// Similarly, if the newest chunks have been lost, the oldest entry may be newer than the next entry; in
+	// which case, bring it back down.
 	var oldest uint64
 	if err := readFile(path+"/oldest", &oldest); err != nil || (len(chunks) > 0 && oldest < chunks[0].oldest) {
-		oldest = chunks[0].oldest
+		oldest = 0
+		if len(chunks) > 0 {
+			oldest = chunks[0].oldest
+		}
+		report.recover(fmt.Sprintf("raised oldest entry ID to %v", oldest))
+	}
+	if len(chunks) > 0 && oldest > chunks[len(chunks)-1].next() {
+		oldest = chunks[len(chunks)-1].next()
+		report.recover(fmt.Sprintf("lowered oldest entry ID to %v", oldest))
 	}
 
-	db := &LockFreeChunkDB{
+	// Read the "commit_point" file, which only exists if a commit point has been set.
+	var commitPoint uint64
+	if _, err := os.Stat(path + "/commit_point"); err == nil {
+		if err := readFile(path+"/commit_point", &commitPoint); err != nil {
+			return nil, &ReadError{err}
+		}
+	}
+	generations, err := readGenerations(path)
+	if err != nil {
+		return nil, &ReadError{err}
+	}
+
+	db = &LockFreeChunkDB{
 		path:      path,
 		closed:    false,
 		lockfile:  lockfile,
+		heartbeat: heartbeat,
+		nfs:       o.nfs,
 		chunkSize: chunkSize,
 		chunks:    chunks,
 		oldest:    oldest,
 		syncEvery: 100,
 		syncDirty: make(map[*chunk]struct{}),
+		latencies: new(latencies),
+		hooks:     o.hooks,
+		verify:    o.verify,
+		recovery:  o.recovery,
+		writePath: o.writePath,
+
+		relaxedSync:  o.relaxedSync,
+		durability:   o.durability,
+		chunkEntries: chunkEntries,
 	}
 	db.newest = db.next() - 1
+	db.commitPoint = commitPoint
+	db.generations = generations
+
+	for _, c := range chunks {
+		report.Chunks = append(report.Chunks, c.info())
+	}
+	report.OldestID = oldest
+	report.NextID = db.next()
+
+	// Perform whatever further verification has been asked for. If it fails, the chunks are closed as for any
+	// other error, and 'db' is discarded.
+	switch o.verify {
+	case VerifyFinalChunk:
+		if len(chunks) > 0 {
+			var prior *chunk
+			if len(chunks) > 1 {
+				prior = chunks[len(chunks)-2]
+			}
+			if errs := db.verifyChunk(chunks[len(chunks)-1], prior); len(errs) > 0 {
+				return nil, &VerifyError{errs}
+			}
+		}
+	case VerifyAll:
+		if err := db.Verify(); err != nil {
+			return nil, err
+		}
+		if err := db.VerifyIntegrity(); err != nil {
+			return nil, err
+		}
+	}
 
+	report.Duration = time.Since(start)
+	db.report = report
 	return db, nil
 }
 
@@ -531,28 +1111,65 @@ func (db *LockFreeChunkDB) next() uint64 {
 	return db.chunks[len(db.chunks)-1].next()
 }
 
-// Append an entry to the database, creating a new chunk if necessary, and incrementing the dirty counter.
-// Assumes a write lock is held.
-func (db *LockFreeChunkDB) append(entry []byte) error {
+// Find the chunk containing an entry, and the start and end offsets of the entry within it. Assumes a read
+// lock is held, and that the ID is in range.
+func (db *LockFreeChunkDB) find(id uint64) (*chunk, int32, int32) {
+	return db.chunks[db.findChunk(id)].find(id)
+}
+
+// Find the index of the chunk containing an entry. Assumes a lock (read or write) is held, and that the ID is
+// in range.
+func (db *LockFreeChunkDB) findChunk(id uint64) int {
+	// Binary search through chunks for the one containing the ID.
+	lo := 0
+	hi := len(db.chunks)
+	mid := hi / 2
+	for ; !(db.chunks[mid].oldest <= id && id < db.chunks[mid].next()); mid = (hi + lo) / 2 {
+		if hi < lo {
+			panic("hi < lo")
+		}
+		if db.chunks[mid].next() <= id {
+			lo = mid + 1
+		} else if db.chunks[mid].oldest > id {
+			hi = mid - 1
+		}
+	}
+	return mid
+}
+
+// Append an entry to the database with a label, creating a new chunk if necessary, and incrementing the dirty
+// counter. Assumes a write lock is held.
+func (db *LockFreeChunkDB) append(entry []byte, label Label, header []byte) error {
 	if uint32(len(entry)) > db.chunkSize {
 		return ErrTooBig
 	}
+	if len(header) > MaxHeaderSize {
+		return ErrHeaderTooBig
+	}
 
 	// If there are no chunks, create a new one.
 	if len(db.chunks) == 0 {
 		if err := db.newChunk(); err != nil {
-			return &WriteError{err}
+			return &WriteError{noSpace(err)}
 		}
 	}
 
 	lastChunk := db.chunks[len(db.chunks)-1]
 
-	// If the last chunk doesn't have the space for this entry, create a new one.
+	// If the last chunk doesn't have the space for this entry, or is full, create a new one.
 	if len(lastChunk.ends) > 0 {
 		lastEnd := lastChunk.ends[len(lastChunk.ends)-1]
-		if db.chunkSize-uint32(lastEnd) < uint32(len(entry)) {
+		tooBig := db.chunkSize-uint32(lastEnd) < uint32(len(entry))
+		if tooBig {
+			db.checkChunkSize(lastChunk, uint32(len(entry)))
+		}
+		if tooBig || db.chunkFull(len(lastChunk.ends)) {
+			// Rolling over syncs the active chunk, which would recreate the metadata file of a missing chunk.
+			if err := db.checkActiveChunk(); err != nil {
+				return err
+			}
 			if err := db.newChunk(); err != nil {
-				return &WriteError{err}
+				return &WriteError{noSpace(err)}
 			}
 			lastChunk = db.chunks[len(db.chunks)-1]
 		}
@@ -564,10 +1181,25 @@ func (db *LockFreeChunkDB) append(entry []byte) error {
 		start = lastChunk.ends[len(lastChunk.ends)-1]
 	}
 	end := start + int32(len(entry))
-	for i, b := range entry {
-		lastChunk.bytes[start+int32(i)] = b
+	if db.durability == DurabilityDsync {
+		// As the file is mapped shared, the written data is visible through the mapping too.
+		if err := db.writeDsync(lastChunk, entry, start); err != nil {
+			return db.writeFailed(err)
+		}
+	} else if db.writePath == WritePathWrite || lastChunk.bytes == nil {
+		// As the file is mapped shared, the written data is visible through the mapping too, if it is mapped.
+		if _, err := lastChunk.mmapf.WriteAt(entry, int64(start)); err != nil {
+			return db.writeFailed(err)
+		}
+	} else {
+		for i, b := range entry {
+			lastChunk.bytes[start+int32(i)] = b
+		}
 	}
 	lastChunk.ends = append(lastChunk.ends, end)
+	lastChunk.sums = append(lastChunk.sums, checksum(entry))
+	lastChunk.appendLabel(label)
+	lastChunk.appendHeader(header)
 
 	// If this is the first entry ever, set the oldest ID to 1 (IDs start from 1, not 0)
 	if db.oldest == 0 {
@@ -585,22 +1217,45 @@ func (db *LockFreeChunkDB) append(entry []byte) error {
 // A chunk cannot be empty, so it is only valid to call this if an entry is going to be inserted into the chunk
 // immediately.
 func (db *LockFreeChunkDB) newChunk() error {
+	defer db.observe(&db.latencies.rollover, time.Now(), "chunk rollover", db.next(), db.next(), db.path)
+
+	if err := db.emergencyForget(); err != nil {
+		return err
+	}
+
 	// As the chunk oldest ID is stored in the filename, we need to sync the prior chunk before creating the
 	// new one. Otherwise if the process dies before the next sync, there will be a chunk ID discontinuity.
+	// Once synced, the prior chunk will not be written to again, so it is sealed.
 	if len(db.chunks) > 0 {
-		if err := db.syncOne(db.chunks[len(db.chunks)-1]); err != nil {
+		prior := db.chunks[len(db.chunks)-1]
+		if err := db.syncOne(prior); err != nil {
 			return err
 		}
+		if err := prior.seal(); err != nil {
+			return &SyncError{err}
+		}
+		if db.hooks.Sealed != nil {
+			db.hooks.Sealed(prior.info())
+		}
 	}
 
-	chunkFile := db.path + "/" + initialChunkFile
+	if err := failpoint(FailpointChunkRollover); err != nil {
+		return err
+	}
+
+	name := initialChunkFile
 
 	// Filename is "chunk-<1 + last chunk file name>_<next id>"
 	if len(db.chunks) > 0 {
-		chunkFile = db.path + "/" + db.chunks[len(db.chunks)-1].nextDataFileName(db.next())
+		name = db.chunks[len(db.chunks)-1].nextDataFileName(db.next())
 	}
 
-	// Create the files for a new chunk.
+	// Create the files for a new chunk, and the shard directory if this is the first chunk in it.
+	dir := chunkDir(db.path, name)
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return err
+	}
+	chunkFile := dir + "/" + name
 	err := createChunkFiles(chunkFile, db.chunkSize, db.next())
 	if err != nil {
 		return err
@@ -615,15 +1270,34 @@ func (db *LockFreeChunkDB) newChunk() error {
 	if len(db.chunks) > 0 {
 		prior = db.chunks[len(db.chunks)-1]
 	}
-	c, err := openChunkFile(db.path, fi, prior, db.chunkSize)
+	c, err := openChunkFile(dir, fi, prior, db.chunkSize)
 	if err != nil {
 		return err
 	}
 	db.chunks = append(db.chunks, &c)
+	db.count(MetricChunkRollovers, 1)
+	db.enforceMemoryLimit()
+	if db.hooks.Created != nil {
+		db.hooks.Created(c.info())
+	}
 
 	return nil
 }
 
+// Perform a 'Truncate', respecting the commit point. Assumes a write lock is held.
+func (db *LockFreeChunkDB) truncate(newOldestID, newNewestID uint64) error {
+	if newNewestID < newOldestID {
+		return ErrIDOutOfRange
+	}
+	if newNewestID < db.commitPoint && newNewestID < db.newest {
+		return ErrBelowCommitPoint
+	}
+	if err := db.forget(newOldestID); err != nil {
+		return err
+	}
+	return db.softRollback(newNewestID)
+}
+
 // Remove entries from the beginning of the log, performing a sync if necessary. Assumes a write lock is held.
 func (db *LockFreeChunkDB) forget(newOldestID uint64) error {
 	if newOldestID < db.oldest {
@@ -637,21 +1311,23 @@ func (db *LockFreeChunkDB) forget(newOldestID uint64) error {
 	db.sinceLastSync += newOldestID - db.oldest
 	db.oldest = newOldestID
 
-	// Mark too-old chunks for deletion.
-	var first int
-	for first = 0; first < len(db.chunks) && db.chunks[first].next() <= newOldestID; first++ {
+	// Mark too-old chunks for deletion. Chunks may already be marked, if deletions are being batched.
+	for first := 0; first < len(db.chunks) && db.chunks[first].next() <= newOldestID; first++ {
 		c := db.chunks[first]
-		db.syncDirty[c] = struct{}{}
-		c.delete = true
+		if !c.delete {
+			db.syncDirty[c] = struct{}{}
+			c.delete = true
+			db.pendingDeletes++
+		}
 	}
 
-	// If this deleted any chunks, perform a sync.
-	if first > 0 {
+	// If enough chunks are awaiting deletion, perform a sync.
+	if db.pendingDeletes > 0 && db.pendingDeletes >= db.forgetBatch {
 		if err := db.sync(); err != nil {
 			return err
 		}
-		db.chunks = db.chunks[first:]
 	}
+	db.prune()
 
 	// Perform a periodic sync.
 	return db.periodicSync()
@@ -678,10 +1354,24 @@ func (db *LockFreeChunkDB) rollback(newNewestID uint64) error {
 		db.syncDirty[c] = struct{}{}
 		if newNextID <= c.oldest {
 			c.ends = nil
+			c.sums = nil
+			c.labels = nil
+			c.headers = nil
 			c.delete = true
 		} else {
+			// This chunk becomes the newest, so it must be writable again.
+			if err := c.unseal(); err != nil {
+				return &SyncError{err}
+			}
 			toRemove := c.next() - newNextID
 			c.ends = c.ends[0 : uint64(len(c.ends))-toRemove]
+			c.sums = c.sums[0:len(c.ends)]
+			if c.labels != nil {
+				c.labels = c.labels[0:len(c.ends)]
+			}
+			if c.headers != nil {
+				c.headers = c.headers[0:len(c.ends)]
+			}
 			if len(c.ends) < c.newFrom {
 				// Force the new last entry to be written out again.
 				c.newFrom = len(c.ends) - 1
@@ -698,24 +1388,50 @@ func (db *LockFreeChunkDB) rollback(newNewestID uint64) error {
 		}
 		db.chunks = db.chunks[:last]
 	}
+	db.prune()
 
 	// Perform a periodic sync
 	return db.periodicSync()
 }
 
+// Drop chunks which have been deleted from the front of the chunk slice. As syncing may happen with only a read
+// lock held, deleted chunks are not dropped by the sync itself. Assumes a write lock is held.
+func (db *LockFreeChunkDB) prune() {
+	for len(db.chunks) > 0 && db.chunks[0].removed {
+		db.chunks = db.chunks[1:]
+	}
+}
+
 // Perform a sync only if needed. Assumes a lock (read or write) is held.
 func (db *LockFreeChunkDB) periodicSync() error {
-	if db.syncEvery >= 0 && db.sinceLastSync > uint64(db.syncEvery) {
+	if db.syncDue() {
 		return db.sync()
 	}
 	return nil
 }
 
+// Check if enough changes have been made since the last sync that a periodic sync is needed. Assumes a lock
+// (read or write) is held.
+func (db *LockFreeChunkDB) syncDue() bool {
+	return db.syncEvery >= 0 && db.sinceLastSync > uint64(db.syncEvery)
+}
+
 // Perform a sync immediately. Assumes a lock (read or write) is held.
 func (db *LockFreeChunkDB) sync() error {
 	// Suboptimal!
 	db.slock.Lock()
 	defer db.slock.Unlock()
+	defer db.observe(&db.latencies.sync, time.Now(), "sync", db.oldest, db.newest, db.path)
+
+	// Syncing would recreate the metadata file of a missing chunk, with no data file to go with it.
+	if err := db.checkActiveChunk(); err != nil {
+		return err
+	}
+
+	// Another process has the lock, and may be writing to the same files.
+	if db.heartbeat != nil && db.heartbeat.isLost() {
+		return &LockError{ErrLockLost}
+	}
 
 	// Produce a sorted list of chunks to sync.
 	dirtyChunks := make([]*chunk, len(db.syncDirty))
@@ -731,28 +1447,64 @@ func (db *LockFreeChunkDB) sync() error {
 	// smaller entries are written into chunk N, the "next" of chunk N might be greater than the "oldest" of
 	// chunk N+1. By deleting first, we avoid this situation.
 	var toSync []*chunk
+	var trash string
 	for _, c := range dirtyChunks {
 		if c.delete {
-			if err := c.closeAndRemove(); err != nil {
+			if c.removed {
+				continue
+			}
+			var err error
+			if db.trashGrace > 0 {
+				if trash == "" {
+					if trash, err = db.newTrashDir(); err != nil {
+						return &SyncError{&DeleteError{err}}
+					}
+				}
+				err = c.closeAndTrash(trash)
+			} else {
+				err = c.closeAndRemove()
+			}
+			if err != nil {
 				return &SyncError{&DeleteError{err}}
 			}
+			c.removed = true
+			db.count(MetricChunkDeletions, 1)
+			if db.hooks.Deleted != nil {
+				db.hooks.Deleted(c.info())
+			}
 		} else {
 			toSync = append([]*chunk{c}, toSync...)
 		}
 	}
 	for _, c := range toSync {
-		if err := c.sync(); err != nil {
+		if err := c.sync(db.nfs, db.flushChunk, db.flushFile); err != nil {
 			return &SyncError{err}
 		}
 	}
 
 	// Write the oldest entry ID.
-	if err := writeFile(db.path+"/oldest", db.oldest); err != nil {
+	if err := writeFileWith(db.path+"/oldest", db.oldest, db.flushFile); err != nil {
 		return &SyncError{err}
 	}
+	if db.durability == DurabilityFull {
+		if err := fsyncDir(db.path); err != nil {
+			return &SyncError{err}
+		}
+	}
+
+	// Delete chunks which have been in the trash for long enough.
+	if db.trashGrace > 0 {
+		if err := db.purgeTrash(); err != nil {
+			return &SyncError{&DeleteError{err}}
+		}
+	}
 
 	db.syncDirty = make(map[*chunk]struct{})
 	db.sinceLastSync = 0
+	db.pendingDeletes = 0
+	db.lastSync = time.Now()
+	db.syncs++
+	db.count(MetricSyncs, 1)
 
 	return nil
 }
@@ -767,7 +1519,7 @@ func (db *LockFreeChunkDB) syncOne(c *chunk) error {
 		return nil
 	}
 
-	if err := c.sync(); err != nil {
+	if err := c.sync(db.nfs, db.flushChunk, db.flushFile); err != nil {
 		return &SyncError{err}
 	}
 
@@ -775,3 +1527,64 @@ func (db *LockFreeChunkDB) syncOne(c *chunk) error {
 
 	return nil
 }
+
+// Flush the data file of a chunk to disk, as configured by the durability, the write path, and
+// 'WithRelaxedSync'.
+func (db *LockFreeChunkDB) flushChunk(c *chunk) error {
+	switch {
+	case db.durability == DurabilityDsync || db.durability == DurabilityNone:
+		return nil
+	case db.durability == DurabilityFull:
+		return fullFsync(c.mmapf)
+	case db.writePath == WritePathMsync && c.bytes != nil:
+		return msyncBytes(c.bytes)
+	case db.relaxedSync:
+		return relaxedFsync(c.mmapf)
+	default:
+		return fsync(c.mmapf)
+	}
+}
+
+// Flush a file other than a chunk data file to disk, as configured by the durability.
+func (db *LockFreeChunkDB) flushFile(file *os.File) error {
+	switch db.durability {
+	case DurabilityNone:
+		return nil
+	case DurabilityFull:
+		return fullFsync(file)
+	default:
+		return fsync(file)
+	}
+}
+
+// Write an entry to the data file of a chunk through an O_DSYNC file descriptor, which is opened on the first
+// write to each chunk. Assumes a write lock is held.
+func (db *LockFreeChunkDB) writeDsync(c *chunk, entry []byte, off int32) error {
+	if err := db.openDsync(c); err != nil {
+		return err
+	}
+	_, err := db.dsyncf.WriteAt(entry, int64(off))
+	return err
+}
+
+// Open the O_DSYNC file descriptor for the data file of a chunk, closing the one for any other chunk.
+func (db *LockFreeChunkDB) openDsync(c *chunk) error {
+	if db.dsyncChunk == c {
+		return nil
+	}
+	db.closeDsync()
+	f, err := os.OpenFile(c.path, os.O_WRONLY|dsyncFlag, 0644)
+	if err != nil {
+		return err
+	}
+	db.dsyncf, db.dsyncChunk = f, c
+	return nil
+}
+
+// Close the O_DSYNC file descriptor, if there is one.
+func (db *LockFreeChunkDB) closeDsync() {
+	if db.dsyncf != nil {
+		_ = db.dsyncf.Close()
+		db.dsyncf, db.dsyncChunk = nil, nil
+	}
+}
@@ -1,11 +1,11 @@
 package logdb
 
 import (
+	"errors"
 	"os"
 	"testing"
 
-	"github.com/hashicorp/errwrap"
-	"github.com/stretchr/testify/assert"
+	"github.com/barrucadu/logdb/internal/assert"
 )
 
 // These all test the 'LockFreeChunkDB' loading error cases, so there's no need to try other databases.
@@ -18,13 +18,44 @@ func TestChunkDB_NoOpenFile(t *testing.T) {
 	createErr := assertOpenError(t, true, "no_open_file")
 	openErr := assertOpenError(t, false, "no_open_file")
 
-	assert.True(t, errwrap.ContainsType(createErr, ErrNotDirectory))
-	assert.True(t, errwrap.ContainsType(openErr, ErrNotDirectory))
+	assert.True(t, errors.Is(createErr, ErrNotDirectory))
+	assert.True(t, errors.Is(openErr, ErrNotDirectory))
 }
 
 func TestChunkDB_NoOpenMissing(t *testing.T) {
 	openErr := assertOpenError(t, false, "no_open_missing")
-	assert.True(t, errwrap.ContainsType(openErr, ErrPathDoesntExist))
+	assert.True(t, errors.Is(openErr, ErrPathDoesntExist))
+}
+
+func TestChunkDB_NoOpenClosesChunks(t *testing.T) {
+	before := countFDs(t)
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "no_open_closes_chunks", chunkSize)
+	filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	// Make the commit point unreadable, so that opening fails after every chunk has been opened.
+	if err := os.Mkdir("test_db/no_open_closes_chunks/commit_point", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		_ = assertOpenError(t, false, "no_open_closes_chunks")
+	}
+	assert.Equal(t, before, countFDs(t), "expected the chunks to be closed")
+}
+
+// Count the file descriptors the process has open, skipping the test if this is not possible.
+func countFDs(t *testing.T) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open file descriptors:", err)
+	}
+	return len(fds)
+}
+
+func TestChunkDB_NoOpenChunkSizeTooBig(t *testing.T) {
+	_, err := Open("test_db/no_open_chunk_size_too_big", maxChunkSize+1, true)
+	assert.Equal(t, ErrChunkSizeTooBig, err, "expected the chunk size to be rejected")
 }
 
 func TestChunkDB_NoConcurrentOpen(t *testing.T) {
@@ -56,7 +87,7 @@ func TestChunkDB_NoOpenBadFiles(t *testing.T) {
 
 	err := assertOpenError(t, false, "no_open_bad_files")
 
-	assert.True(t, errwrap.ContainsType(err, ErrUnknownVersion))
+	assert.True(t, errors.Is(err, ErrUnknownVersion))
 }
 
 func TestChunkDB_CorruptOldest(t *testing.T) {
@@ -87,11 +118,12 @@ func TestChunkDB_NoEmptyNonfinalChunk(t *testing.T) {
 	filldb(t, db, numEntries)
 	assertClose(t, db)
 
+	unprotect(t, "test_db/no_empty_nonfinal_chunk/"+initialMetaFile)
 	if err := createFile("test_db/no_empty_nonfinal_chunk/"+initialMetaFile, 0); err != nil {
 		t.Fatal("failed to truncate meta file to 0 bytes:", err)
 	}
 
-	assert.True(t, errwrap.ContainsType(assertOpenError(t, false, "no_empty_nonfinal_chunk"), ErrEmptyNonfinalChunk))
+	assert.True(t, errors.Is(assertOpenError(t, false, "no_empty_nonfinal_chunk"), ErrEmptyNonfinalChunk))
 }
 
 func TestChunkDB_ZeroSizeFinalChunk(t *testing.T) {
@@ -110,6 +142,7 @@ func TestChunkDB_NoOpenZeroSizeNonfinalChunk(t *testing.T) {
 	filldb(t, db, numEntries)
 	assertClose(t, db)
 
+	unprotect(t, "test_db/no_open_zero_size_nonfinal_chunk/"+initialChunkFile)
 	if err := createFile("test_db/no_open_zero_size_nonfinal_chunk/"+initialChunkFile, 0); err != nil {
 		t.Fatal("failed to truncate chunk file to 0 bytes:", err)
 	}
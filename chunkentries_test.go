@@ -0,0 +1,70 @@
+package logdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestChunkEntries(t *testing.T) {
+	path := "test_db/chunk_entries"
+	_ = os.RemoveAll(path)
+
+	db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(chunkSize), WithChunkEntries(4))
+	assert.Nil(t, err, "expected no error in open")
+	vs := filldb(t, db, 10)
+	assert.Equal(t, 3, len(db.chunks), "expected chunks to be rolled over by entries")
+	assertClose(t, db)
+
+	db, err = OpenWithOptions(path)
+	assert.Nil(t, err, "expected no error in reopen")
+	defer assertClose(t, db)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after reopening")
+	}
+	assertAppend(t, db, []byte("entry-10"))
+	assertAppend(t, db, []byte("entry-11"))
+	assertAppend(t, db, []byte("entry-12"))
+	assert.Equal(t, 4, len(db.chunks), "expected the limit to persist")
+}
+
+func TestChunkEntries_Missing(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "chunk_entries_missing", chunkSize)
+	assertClose(t, db)
+	path := "test_db/chunk_entries_missing"
+
+	assert.Nil(t, os.Remove(path+"/chunk_entries"), "expected no error removing the chunk entries file")
+	_, err := Open(path, 0, false)
+	var rerr *ReadError
+	assert.True(t, errors.As(err, &rerr), "expected a database without the chunk entries file to not open, got: %s", err)
+}
+
+func TestChunkEntries_Migrate(t *testing.T) {
+	for _, limit := range []uint32{0, 4} {
+		path := "test_db/chunk_entries_migrate"
+		_ = os.RemoveAll(path)
+		db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(chunkSize), WithChunkEntries(limit))
+		assert.Nil(t, err, "expected no error in open")
+		vs := filldb(t, db, 10)
+		assertClose(t, db)
+
+		// Before version 4, the chunk entries file only exists if there is a limit.
+		if limit == 0 {
+			assert.Nil(t, os.Remove(path+"/chunk_entries"), "expected no error removing the chunk entries file")
+		}
+		assert.Nil(t, writeFile(path+"/version", uint16(3)), "expected no error writing version")
+		from, err := Migrate(path)
+		assert.Nil(t, err, "expected no error in migrate")
+		assert.Equal(t, uint16(3), from, "expected to upgrade from version 3")
+
+		db, err = OpenWithOptions(path)
+		assert.Nil(t, err, "expected no error in open after migrating")
+		assert.Equal(t, limit, db.chunkEntries, "expected the limit to be kept")
+		for i, v := range vs {
+			assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after migrating")
+		}
+		assertClose(t, db)
+	}
+}
@@ -0,0 +1,73 @@
+package logdb
+
+// SuggestChunkSize suggests a chunk size for a database which will hold entries like the sample, so that each
+// chunk holds about 'targetEntriesPerChunk' entries. If the sample entries are all the same size, the suggestion
+// is a multiple of that size, so that no space is wasted. Otherwise, it is a multiple of 4KiB, and at least
+// twice the size of the largest entry, so that at most half of a chunk is wasted by an entry not fitting.
+//
+// Returns 'DefaultChunkSize' if the sample is empty or the target is not positive.
+func SuggestChunkSize(sampleEntries [][]byte, targetEntriesPerChunk int) uint32 {
+	if len(sampleEntries) == 0 || targetEntriesPerChunk <= 0 {
+		return DefaultChunkSize
+	}
+
+	var total, largest uint64
+	fixed := true
+	for _, entry := range sampleEntries {
+		size := uint64(len(entry))
+		total += size
+		if size > largest {
+			largest = size
+		}
+		fixed = fixed && size == uint64(len(sampleEntries[0]))
+	}
+	if largest == 0 {
+		return DefaultChunkSize
+	}
+
+	var size uint64
+	if fixed {
+		size = largest * uint64(targetEntriesPerChunk)
+		if size > maxChunkSize {
+			size = maxChunkSize / largest * largest
+		}
+	} else {
+		mean := (total + uint64(len(sampleEntries)) - 1) / uint64(len(sampleEntries))
+		size = mean * uint64(targetEntriesPerChunk)
+		if size < 2*largest {
+			size = 2 * largest
+		}
+		size = (size + pageSize - 1) / pageSize * pageSize
+		if size > maxChunkSize {
+			size = maxChunkSize / pageSize * pageSize
+		}
+	}
+	if size < largest {
+		size = largest
+	}
+	return uint32(size)
+}
+
+////////// HELPERS //////////
+
+// Chunk sizes which are not a multiple of a fixed entry size are rounded up to a multiple of this.
+const pageSize = 4096
+
+// The fewest entries a chunk should hold before the chunk size is considered too small for the entries.
+const minEntriesPerChunk = 4
+
+// Log a warning, once, if a chunk which has been filled shows that the chunk size is pathological for the
+// entries being stored: if it holds very few entries, or if more than half of it is unused because the next
+// entry did not fit. Assumes a write lock is held.
+func (db *LockFreeChunkDB) checkChunkSize(filled *chunk, next uint32) {
+	if db.logger == nil || db.warnedChunkSize || len(filled.ends) == 0 {
+		return
+	}
+	used := uint32(filled.ends[len(filled.ends)-1])
+	if len(filled.ends) >= minEntriesPerChunk && db.chunkSize-used <= db.chunkSize/2 {
+		return
+	}
+
+	db.warnedChunkSize = true
+	db.logger.Printf("logdb: chunk %s holds %v entries using %v of %v bytes, and the next entry is %v bytes: the chunk size is too small for these entries (see 'SuggestChunkSize')", filled.path, len(filled.ends), used, db.chunkSize, next)
+}
@@ -0,0 +1,40 @@
+package logdb
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestSuggestChunkSize(t *testing.T) {
+	fixed := [][]byte{make([]byte, 100), make([]byte, 100)}
+	assert.Equal(t, uint32(100*1000), SuggestChunkSize(fixed, 1000), "expected a multiple of the fixed entry size")
+
+	varying := [][]byte{make([]byte, 10), make([]byte, 30), make([]byte, 20)}
+	assert.Equal(t, uint32(pageSize), SuggestChunkSize(varying, 100), "expected rounding up to a page")
+	assert.Equal(t, uint32(2*pageSize), SuggestChunkSize(varying, 300), "expected rounding up to a page")
+
+	huge := [][]byte{make([]byte, 10), make([]byte, 10000)}
+	assert.Equal(t, uint32(5*pageSize), SuggestChunkSize(huge, 2), "expected room for twice the largest entry")
+
+	assert.Equal(t, uint32(DefaultChunkSize), SuggestChunkSize(nil, 100), "expected the default for no sample")
+	assert.Equal(t, uint32(DefaultChunkSize), SuggestChunkSize(fixed, 0), "expected the default for no target")
+}
+
+func TestChunkSize_Warning(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "chunk_size_warning", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	buf := new(bytes.Buffer)
+	db.SetLogger(log.New(buf, "", 0))
+
+	for i := 0; i < 5; i++ {
+		assertAppend(t, db, make([]byte, chunkSize/2+1))
+	}
+	logged := buf.String()
+	assert.True(t, strings.Contains(logged, "the chunk size is too small for these entries"), "expected a warning, got: %s", logged)
+	assert.Equal(t, 1, strings.Count(logged, "\n"), "expected only one warning, got: %s", logged)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
 	"os"
@@ -16,22 +17,124 @@ const (
 )
 
 func main() {
-	if len(os.Args) < 3 || (os.Args[1] != "check" && os.Args[1] != "dump" && os.Args[1] != "fuzz") {
-		fmt.Printf("usage: %v [check | dump | fuzz] <database-path>\n", os.Args[0])
+	commands := map[string]bool{"cat": true, "check": true, "chunks": true, "dump": true, "fuzz": true, "migrate": true, "repair": true, "stats": true, "verify": true}
+	if len(os.Args) < 3 || !commands[os.Args[1]] {
+		fmt.Printf("usage: %v [cat | check | chunks | dump | fuzz | migrate | repair | stats | verify] <database-path>\n", os.Args[0])
+		fmt.Printf("       %v cat <database-path> [--from N] [--to M] [--raw]\n", os.Args[0])
 		os.Exit(1)
 	}
 
 	switch os.Args[1] {
+	case "cat":
+		cat(os.Args[2], os.Args[3:])
 	case "check":
 		check(os.Args[2])
+	case "chunks":
+		chunks(os.Args[2])
 	case "dump":
 		dump(os.Args[2])
+	case "migrate":
+		migrate(os.Args[2])
 	case "fuzz":
 		fuzz(os.Args[2])
+	case "repair":
+		repair(os.Args[2])
+	case "stats":
+		stats(os.Args[2])
+	case "verify":
+		verify(os.Args[2])
 	}
 }
 
+// cat prints a range of entries. It opens the database with a 'Reader', so it can be used while another
+// process is writing to it, and does not change anything, even if the database needs recovery.
+func cat(path string, args []string) {
+	flags := flag.NewFlagSet("cat", flag.ExitOnError)
+	from := flags.Uint64("from", 0, "ID of the first entry to print (default: the oldest)")
+	to := flags.Uint64("to", 0, "ID of the last entry to print (default: the newest)")
+	raw := flags.Bool("raw", false, "print the entries as they are, one per line, without their IDs")
+	_ = flags.Parse(args)
+
+	r, err := logdb.OpenReader(path)
+	if err != nil {
+		fmt.Printf("could not open database in %s: %s\n", path, err)
+		os.Exit(1)
+	}
+	defer r.Close()
+
+	if *from == 0 {
+		*from = r.OldestID()
+	}
+	if *to == 0 {
+		*to = r.NewestID()
+	}
+	for i := *from; i <= *to && i != 0; i++ {
+		v, err := r.Get(i)
+		if err == logdb.ErrDownsampled {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not read entry %v: %s\n", i, err)
+			os.Exit(1)
+		}
+		if *raw {
+			_, _ = os.Stdout.Write(append(v, '\n'))
+		} else {
+			fmt.Printf("%v: %q\n", i, v)
+		}
+	}
+}
+
+func chunks(path string) {
+	db, err := logdb.Open(path, 0, false)
+	if err != nil {
+		fmt.Printf("could not open database in %s: %s\n", path, err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	fmt.Printf("chunk size: %v\n", db.Config().ChunkSize)
+	for _, c := range db.OpenReport().Chunks {
+		fmt.Printf("%s: entries [%v,%v), %v entries\n", c.DataFilePath, c.OldestID, c.NextID, c.NextID-c.OldestID)
+	}
+}
+
+func stats(path string) {
+	db, err := logdb.Open(path, 0, false)
+	if err != nil {
+		fmt.Printf("could not open database in %s: %s\n", path, err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	s := db.Stats()
+	fmt.Printf("entries:    %v\n", s.Entries)
+	fmt.Printf("oldest:     %v\n", s.OldestID)
+	fmt.Printf("newest:     %v\n", s.NewestID)
+	fmt.Printf("chunks:     %v\n", s.Chunks)
+	fmt.Printf("disk bytes: %v\n", s.DiskBytes)
+	fmt.Printf("live bytes: %v\n", s.LiveBytes)
+}
+
+func verify(path string) {
+	db, err := logdb.OpenWithOptions(path, logdb.WithVerify(logdb.VerifyAll))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	for _, step := range db.OpenReport().Recovery {
+		fmt.Printf("recovered: %s\n", step)
+	}
+	fmt.Println("Ok!")
+}
+
 func check(path string) {
+	if f, err := logdb.CheckFormat(path); err == nil && f.Migrate {
+		fmt.Printf("database is in format version %v, run \"migrate\" to upgrade it to version %v\n", f.Version, logdb.FormatVersion())
+		os.Exit(1)
+	}
 	if _, err := logdb.Open(path, 0, false); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -40,6 +143,41 @@ func check(path string) {
 	fmt.Println("Ok!")
 }
 
+func migrate(path string) {
+	from, err := logdb.Migrate(path)
+	if err != nil {
+		fmt.Printf("could not migrate database in %s from version %v: %s\n", path, from, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Migrated from version %v.\n", from)
+}
+
+// repair salvages what it can from a database which cannot be opened. Chunks which cannot be used are moved
+// aside, not deleted.
+func repair(path string) {
+	r, err := logdb.Repair(path)
+	if err != nil {
+		fmt.Printf("could not repair database in %s: %s\n", path, err)
+		os.Exit(1)
+	}
+
+	for _, f := range r.Truncated {
+		fmt.Printf("truncated: %s\n", f)
+	}
+	for _, f := range r.Discarded {
+		fmt.Printf("discarded: %s\n", f)
+	}
+	for _, f := range r.Renamed {
+		fmt.Printf("renamed: %s\n", f)
+	}
+	if r.DiscardDir != "" {
+		fmt.Printf("discarded files moved to %s\n", r.DiscardDir)
+	}
+	fmt.Printf("oldest: %v\n", r.OldestID)
+	fmt.Printf("newest: %v\n", r.NextID-1)
+}
+
 func dump(path string) {
 	db, err := logdb.Open(path, 0, false)
 	if err != nil {
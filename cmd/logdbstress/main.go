@@ -0,0 +1,305 @@
+// Command logdbstress runs a mix of concurrent appends, gets, forgets, rollbacks, and syncs against a database,
+// checking invariants as it goes and reporting throughput. With -kill, the workload runs in a child process
+// which is repeatedly killed with SIGKILL, and the database is checked every time it is reopened.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/barrucadu/logdb"
+)
+
+var (
+	path        = flag.String("path", "", "database directory, created if it does not exist")
+	duration    = flag.Duration("duration", time.Minute, "how long to run for, or 0 to run until interrupted")
+	appenders   = flag.Int("appenders", 4, "number of goroutines appending entries")
+	getters     = flag.Int("getters", 4, "number of goroutines getting and checking entries")
+	forgetters  = flag.Int("forgetters", 1, "number of goroutines forgetting old entries")
+	rollbackers = flag.Int("rollbackers", 1, "number of goroutines rolling back new entries")
+	syncers     = flag.Int("syncers", 1, "number of goroutines syncing")
+	chunkSize   = flag.Uint("chunk-size", 64*1024, "chunk size, if the database is created")
+	syncEvery   = flag.Int("sync-every", 256, "sync period, as for 'SetSync'")
+	maxEntry    = flag.Int("max-entry-size", 256, "largest entry to append, in bytes")
+	keep        = flag.Uint64("keep", 10000, "only forget or roll back while more than this many entries remain")
+	kill        = flag.Duration("kill", 0, "if nonzero, run the workload in a child process, and repeatedly kill it after a random time up to this long and check the database")
+	report      = flag.Duration("report", 10*time.Second, "how often to report throughput")
+	child       = flag.Bool("child", false, "run the workload until killed, as the child process for -kill")
+)
+
+func main() {
+	flag.Parse()
+	if *path == "" || *maxEntry < checksumSize || *kill < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch {
+	case *child:
+		stress(0)
+	case *kill > 0:
+		killLoop()
+	default:
+		stress(*duration)
+		check()
+	}
+}
+
+// Run the workload for the given duration, or forever if 0, exiting if an invariant is violated.
+func stress(d time.Duration) {
+	lfdb, err := logdb.OpenWithOptions(*path, logdb.WithCreate(true), logdb.WithChunkSize(uint32(*chunkSize)), logdb.WithSync(*syncEvery))
+	if err != nil {
+		fail("could not open database in %s: %s", *path, err)
+	}
+	db := logdb.WrapForConcurrency(lfdb)
+
+	s := &stresser{db: db, failed: make(chan error, 1)}
+	for i := 0; i < *appenders; i++ {
+		go s.loop(s.append)
+	}
+	for i := 0; i < *getters; i++ {
+		go s.loop(s.get)
+	}
+	for i := 0; i < *forgetters; i++ {
+		go s.loop(s.forget)
+	}
+	for i := 0; i < *rollbackers; i++ {
+		go s.loop(s.rollback)
+	}
+	for i := 0; i < *syncers; i++ {
+		go s.loop(s.sync)
+	}
+
+	var done <-chan time.Time
+	if d > 0 {
+		done = time.After(d)
+	}
+	ticker := time.NewTicker(*report)
+	defer ticker.Stop()
+	start, last := time.Now(), time.Now()
+	var prev counts
+	for {
+		select {
+		case err := <-s.failed:
+			fail("%s", err)
+		case now := <-ticker.C:
+			cur := s.counts.load()
+			fmt.Printf("%v: %s\n", now.Sub(start).Round(time.Second), cur.sub(prev).rates(now.Sub(last)))
+			prev, last = cur, now
+		case <-done:
+			fmt.Printf("total: %s\n", s.counts.load().rates(time.Since(start)))
+			if err := db.Close(); err != nil {
+				fail("could not close database: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// Run the workload in a child process, kill it, and check the database, until the duration is up.
+func killLoop() {
+	deadline := time.Now().Add(*duration)
+	for cycle := 1; *duration == 0 || time.Now().Before(deadline); cycle++ {
+		cmd := exec.Command(os.Args[0], append(os.Args[1:], "-child")...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			fail("could not start child process: %s", err)
+		}
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		after := *kill/2 + time.Duration(rand.Int63n(int64(*kill/2)+1))
+		select {
+		case err := <-exited:
+			fail("child process exited before being killed: %v", err)
+		case <-time.After(after):
+			_ = cmd.Process.Kill()
+			<-exited
+		}
+
+		fmt.Printf("cycle %v: killed after %v\n", cycle, after.Round(time.Millisecond))
+		check()
+	}
+}
+
+// Open the database and check every entry, exiting if anything is wrong.
+func check() {
+	db, err := logdb.Open(*path, 0, false)
+	if err != nil {
+		fail("could not open database in %s: %s", *path, err)
+	}
+	defer db.Close()
+
+	if db.OldestID() > db.NewestID()+1 {
+		fail("oldest ID %v is after newest ID %v", db.OldestID(), db.NewestID())
+	}
+	if err := db.VerifyIntegrity(); err != nil {
+		fail("could not verify database: %s", err)
+	}
+	for id := db.OldestID(); id <= db.NewestID() && id > 0; id++ {
+		bs, err := db.Get(id)
+		if err != nil {
+			fail("could not get entry %v: %s", id, err)
+		}
+		if err := checkEntry(bs); err != nil {
+			fail("entry %v: %s", id, err)
+		}
+	}
+	fmt.Printf("check: ok, entries [%v, %v]\n", db.OldestID(), db.NewestID())
+}
+
+// Print an error and exit.
+func fail(format string, args ...interface{}) {
+	fmt.Printf("FAIL: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// The state of a running workload.
+type stresser struct {
+	db     *logdb.ChunkDB
+	counts counts
+	failed chan error
+}
+
+// Run an operation repeatedly, until it fails.
+func (s *stresser) loop(op func() error) {
+	for {
+		if err := op(); err != nil {
+			select {
+			case s.failed <- err:
+			default:
+			}
+			return
+		}
+	}
+}
+
+// Append a random entry. Appends never fail.
+func (s *stresser) append() error {
+	if _, err := s.db.Append(makeEntry()); err != nil {
+		return fmt.Errorf("could not append entry: %s", err)
+	}
+	atomic.AddUint64(&s.counts.append, 1)
+	return nil
+}
+
+// Get and check a random entry. The entry can have been forgotten or rolled back since choosing it.
+func (s *stresser) get() error {
+	oldest, newest := s.db.OldestID(), s.db.NewestID()
+	if newest < oldest || newest == 0 {
+		return nil
+	}
+	id := oldest + uint64(rand.Int63n(int64(newest-oldest)+1))
+	bs, err := s.db.Get(id)
+	if errors.Is(err, logdb.ErrIDOutOfRange) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not get entry %v: %s", id, err)
+	}
+	if err := checkEntry(bs); err != nil {
+		return fmt.Errorf("entry %v: %s", id, err)
+	}
+	atomic.AddUint64(&s.counts.get, 1)
+	return nil
+}
+
+// Forget some of the oldest entries, if there are enough.
+func (s *stresser) forget() error {
+	time.Sleep(time.Millisecond)
+	oldest, newest := s.db.OldestID(), s.db.NewestID()
+	if newest < oldest || newest-oldest <= *keep {
+		return nil
+	}
+	newOldest := oldest + uint64(rand.Int63n(int64(newest-oldest-*keep)+1))
+	if err := s.db.Forget(newOldest); err != nil && !errors.Is(err, logdb.ErrIDOutOfRange) {
+		return fmt.Errorf("could not forget up to %v: %s", newOldest, err)
+	}
+	atomic.AddUint64(&s.counts.forget, 1)
+	return nil
+}
+
+// Roll back some of the newest entries, if there are enough.
+func (s *stresser) rollback() error {
+	time.Sleep(10 * time.Millisecond)
+	oldest, newest := s.db.OldestID(), s.db.NewestID()
+	if newest < oldest || newest-oldest <= *keep {
+		return nil
+	}
+	newNewest := newest - uint64(rand.Intn(100))
+	if err := s.db.Rollback(newNewest); err != nil && !errors.Is(err, logdb.ErrIDOutOfRange) {
+		return fmt.Errorf("could not roll back to %v: %s", newNewest, err)
+	}
+	atomic.AddUint64(&s.counts.rollback, 1)
+	return nil
+}
+
+// Sync the database. Syncs never fail.
+func (s *stresser) sync() error {
+	time.Sleep(time.Millisecond)
+	if err := s.db.Sync(); err != nil {
+		return fmt.Errorf("could not sync: %s", err)
+	}
+	atomic.AddUint64(&s.counts.sync, 1)
+	return nil
+}
+
+// Counts of completed operations.
+type counts struct {
+	append, get, forget, rollback, sync uint64
+}
+
+// Read the counts atomically.
+func (c *counts) load() counts {
+	return counts{
+		append:   atomic.LoadUint64(&c.append),
+		get:      atomic.LoadUint64(&c.get),
+		forget:   atomic.LoadUint64(&c.forget),
+		rollback: atomic.LoadUint64(&c.rollback),
+		sync:     atomic.LoadUint64(&c.sync),
+	}
+}
+
+// Subtract earlier counts.
+func (c counts) sub(o counts) counts {
+	return counts{c.append - o.append, c.get - o.get, c.forget - o.forget, c.rollback - o.rollback, c.sync - o.sync}
+}
+
+// Format the counts as rates per second over a period.
+func (c counts) rates(d time.Duration) string {
+	per := func(n uint64) float64 { return float64(n) / d.Seconds() }
+	return fmt.Sprintf("append %.0f/s, get %.0f/s, forget %.0f/s, rollback %.0f/s, sync %.0f/s",
+		per(c.append), per(c.get), per(c.forget), per(c.rollback), per(c.sync))
+}
+
+// Size of the checksum at the end of every entry.
+const checksumSize = 4
+
+// Make a random entry, which ends with a checksum of the rest, so that it can be checked when read back.
+func makeEntry() []byte {
+	bs := make([]byte, rand.Intn(*maxEntry-checksumSize+1)+checksumSize)
+	payload := bs[:len(bs)-checksumSize]
+	rand.Read(payload)
+	binary.LittleEndian.PutUint32(bs[len(payload):], crc32.ChecksumIEEE(payload))
+	return bs
+}
+
+// Check that an entry matches its checksum.
+func checkEntry(bs []byte) error {
+	if len(bs) < checksumSize {
+		return fmt.Errorf("too short (%v bytes)", len(bs))
+	}
+	payload := bs[:len(bs)-checksumSize]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(bs[len(payload):]) {
+		return errors.New("contents do not match checksum")
+	}
+	return nil
+}
@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 )
 
 // ErrNotValueSlice means that AppendValues was called with a non-slice argument.
 var ErrNotValueSlice = errors.New("AppendValues must be called with a slice argument")
 
+// ErrWrongDestination means that a 'CodingDB' could not decode an entry because the value to decode it into is
+// of the wrong type, rather than because the entry is corrupt. It is wrapped in a 'DecodeError'.
+var ErrWrongDestination = errors.New("destination is of the wrong type")
+
 // A CodingDB wraps a 'LogDB' with functions to encode and decode values of some sort, giving a higher-level
 // interface than raw byte slices.
+//
+// 'Decode' should wrap 'ErrWrongDestination' in the errors it returns when the value to decode into is of the
+// wrong type, so that callers can tell that from a corrupt entry. The coders in this package all do so.
 type CodingDB struct {
 	LogDB
 
@@ -38,7 +47,7 @@ func IdentityCoder(logdb LogDB) *CodingDB {
 			outSlice, ok := data.([]byte)
 			if !ok {
 				tystr := reflect.TypeOf(data).String()
-				return fmt.Errorf("identity coder can only decode a '[]byte', got %s", tystr)
+				return fmt.Errorf("%w: identity coder can only decode a '[]byte', got %s", ErrWrongDestination, tystr)
 			}
 			copy(outSlice, bs)
 			return nil
@@ -46,24 +55,66 @@ func IdentityCoder(logdb LogDB) *CodingDB {
 	}
 }
 
-// BinaryCoder creates a 'CodingDB' with the binary encoder/decoder. Values must be valid input for the
-// 'binary.Write' function.
+// BinaryCoder creates a 'CodingDB' with the binary encoder/decoder.
+//
+// Values which are valid input for the 'binary.Write' function are encoded exactly as 'binary.Write' would
+// encode them. Other values are encoded with the following framing, which extends that of 'binary.Write' to
+// variable-length types:
+//
+//   - Fixed-size values (bools, sized integers, floats, and complex numbers) are encoded as by 'binary.Write'.
+//   - 'int' and 'uint' values are encoded as 'int64' and 'uint64' values.
+//   - Strings and slices are encoded as a 'uint32' length followed by the bytes or elements.
+//   - Arrays are encoded as their elements, in order.
+//   - Structs are encoded as their fields, in order. Blank ('_') fields are skipped, and it is an error to have
+//     any other unexported fields.
+//
+// As the length of an entry is known, the outermost value does not have a length prefix if it is a string or a
+// slice: its length is implied by the length of the entry. This also means that a '[]byte' or string is stored
+// unchanged.
+//
+// A value can be decoded into a pointer to a value of the same type. A slice of fixed-size values can also be
+// decoded into a slice of the correct length, rather than into a pointer to a slice. Maps, channels,
+// functions, interfaces, and nested pointers are not supported.
 func BinaryCoder(logdb LogDB, byteOrder binary.ByteOrder) *CodingDB {
 	return &CodingDB{
 		LogDB: logdb,
 		Encode: func(val interface{}) ([]byte, error) {
 			buf := new(bytes.Buffer)
-			err := binary.Write(buf, byteOrder, val)
+			var err error
+			if binary.Size(val) >= 0 {
+				err = binary.Write(buf, byteOrder, val)
+			} else {
+				err = encodeBinary(buf, byteOrder, reflect.ValueOf(val), true)
+			}
 			return buf.Bytes(), err
 		},
 		Decode: func(bs []byte, data interface{}) error {
-			return binary.Read(bytes.NewReader(bs), byteOrder, data)
+			r := bytes.NewReader(bs)
+			v := reflect.ValueOf(data)
+			if !(v.Kind() == reflect.Ptr && !v.IsNil() || v.Kind() == reflect.Slice) {
+				return fmt.Errorf("%w: binary coder can only decode into a pointer or a slice, got %T", ErrWrongDestination, data)
+			}
+			if v.Kind() == reflect.Ptr && !v.IsNil() {
+				if k := v.Elem().Kind(); k == reflect.Slice || k == reflect.String || binary.Size(data) < 0 {
+					return decodeBinary(r, byteOrder, v.Elem(), true)
+				}
+			}
+			if v.Kind() == reflect.Slice && !isFixedSize(v.Type().Elem()) {
+				for i := 0; i < v.Len(); i++ {
+					if err := decodeBinary(r, byteOrder, v.Index(i), false); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			return binary.Read(r, byteOrder, data)
 		},
 	}
 }
 
 // GobCoder creates a 'CodingDB' with the gob encoder/decoder. Values must be valid input for the 'god.Encode'
-// function.
+// function. Only decoding into something other than a pointer is reported as 'ErrWrongDestination': gob does
+// not tell a destination of the wrong type apart from a corrupt entry.
 func GobCoder(logdb LogDB) *CodingDB {
 	return &CodingDB{
 		LogDB: logdb,
@@ -74,18 +125,41 @@ func GobCoder(logdb LogDB) *CodingDB {
 			return buf.Bytes(), err
 		},
 		Decode: func(bs []byte, data interface{}) error {
+			if v := reflect.ValueOf(data); v.Kind() != reflect.Ptr || v.IsNil() {
+				return fmt.Errorf("%w: gob coder can only decode into a pointer, got %T", ErrWrongDestination, data)
+			}
 			dec := gob.NewDecoder(bytes.NewReader(bs))
 			return dec.Decode(data)
 		},
 	}
 }
 
+// JSONCoder creates a 'CodingDB' with the JSON encoder/decoder. Values must be valid input for the
+// 'json.Marshal' function, and are decoded with 'json.Unmarshal', so must be decoded into a pointer.
+func JSONCoder(logdb LogDB) *CodingDB {
+	return &CodingDB{
+		LogDB:  logdb,
+		Encode: json.Marshal,
+		Decode: func(bs []byte, data interface{}) error {
+			err := json.Unmarshal(bs, data)
+			var invalid *json.InvalidUnmarshalError
+			var mismatch *json.UnmarshalTypeError
+			if errors.As(err, &invalid) || errors.As(err, &mismatch) {
+				return fmt.Errorf("%w: %s", ErrWrongDestination, err)
+			}
+			return err
+		},
+	}
+}
+
 // AppendValue encodes a value using the encoder, and stores it in the underlying 'LogDB' is there is no
 // error.
+//
+// Returns an 'EncodeError' value if the value could not be encoded.
 func (db *CodingDB) AppendValue(value interface{}) (uint64, error) {
 	bs, err := db.Encode(value)
 	if err != nil {
-		return 0, err
+		return 0, &EncodeError{Index: 0, Err: err}
 	}
 	return db.Append(bs)
 }
@@ -93,7 +167,8 @@ func (db *CodingDB) AppendValue(value interface{}) (uint64, error) {
 // AppendValues encodes a slice of values (represented as an 'interface{}', to make the casting simpler), and
 // stores them in the underlying 'LogDB' if there is no error.
 //
-// Returns 'ErrNotValueSlice' if called with a non-slice argument.
+// Returns 'ErrNotValueSlice' if called with a non-slice argument, and an 'EncodeError' value if any value could
+// not be encoded.
 func (db *CodingDB) AppendValues(values interface{}) (uint64, error) {
 	v := reflect.ValueOf(values)
 	if v.IsNil() {
@@ -107,7 +182,7 @@ func (db *CodingDB) AppendValues(values interface{}) (uint64, error) {
 	for i := 0; i < v.Len(); i++ {
 		bs, err := db.Encode(v.Index(i).Interface())
 		if err != nil {
-			return 0, err
+			return 0, &EncodeError{Index: i, Err: err}
 		}
 		bss[i] = bs
 	}
@@ -115,10 +190,267 @@ func (db *CodingDB) AppendValues(values interface{}) (uint64, error) {
 }
 
 // GetValue retrieves a value from the underlying 'LogDB' and decodes it.
+//
+// Returns the same errors as 'Get', and a 'DecodeError' value if the entry could not be decoded.
 func (db *CodingDB) GetValue(id uint64, data interface{}) error {
 	bs, err := db.Get(id)
 	if err != nil {
 		return err
 	}
-	return db.Decode(bs, data)
+	if err := db.Decode(bs, data); err != nil {
+		return &DecodeError{ID: id, Err: err}
+	}
+	return nil
+}
+
+// GetRaw retrieves an entry as stored in the underlying 'LogDB', without decoding it, so that it can be read by a
+// streaming decoder of the caller's choice. If the underlying 'LogDB' is a 'RawDB', such as a 'ChunkDB', the
+// entry is read as the reader is read, rather than into memory up front. If the underlying 'LogDB' is a
+// 'CompressingDB', the bytes are the compressed entry, so must be decompressed before decoding.
+//
+// Returns the same errors as 'Get'.
+func (db *CodingDB) GetRaw(id uint64) (io.Reader, error) {
+	return getRaw(db.LogDB, id)
+}
+
+// StoredSize gets the size of an encoded entry as stored in the underlying 'LogDB', which, if that is a
+// 'CompressingDB', is the compressed size rather than the encoded size.
+//
+// Returns the same errors as 'Get'.
+func (db *CodingDB) StoredSize(id uint64) (uint64, error) {
+	return storedSize(db.LogDB, id)
+}
+
+////////// HELPERS //////////
+
+// Get a reader over an entry as stored, using 'GetRaw' if the database is a 'RawDB', and the entry returned by
+// 'Get' if not.
+func getRaw(db LogDB, id uint64) (io.Reader, error) {
+	if rdb, ok := db.(RawDB); ok {
+		return rdb.GetRaw(id)
+	}
+	bs, err := db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(bs), nil
+}
+
+// Get the stored size of an entry, using 'StoredSize' if the database is a 'SizedDB', and the length of the
+// entry otherwise.
+func storedSize(db LogDB, id uint64) (uint64, error) {
+	if sdb, ok := db.(SizedDB); ok {
+		return sdb.StoredSize(id)
+	}
+	bs, err := db.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(bs)), nil
+}
+
+// Encode a value with the 'BinaryCoder' framing. If 'outer' is true, strings and slices do not have a length
+// prefix.
+func encodeBinary(w *bytes.Buffer, order binary.ByteOrder, v reflect.Value, outer bool) error {
+	switch v.Kind() {
+	case reflect.Int:
+		return binary.Write(w, order, v.Int())
+	case reflect.Uint:
+		return binary.Write(w, order, v.Uint())
+	case reflect.String:
+		if !outer {
+			if err := binary.Write(w, order, uint32(v.Len())); err != nil {
+				return err
+			}
+		}
+		_, err := w.WriteString(v.String())
+		return err
+	case reflect.Slice:
+		if !outer {
+			if err := binary.Write(w, order, uint32(v.Len())); err != nil {
+				return err
+			}
+		}
+		if isFixedSize(v.Type().Elem()) {
+			return binary.Write(w, order, v.Interface())
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeBinary(w, order, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeBinary(w, order, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name == "_" {
+				continue
+			}
+			if t.Field(i).PkgPath != "" {
+				return fmt.Errorf("binary coder cannot encode unexported field %s of %s", t.Field(i).Name, t.String())
+			}
+			if err := encodeBinary(w, order, v.Field(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Ptr:
+		if outer && !v.IsNil() {
+			return encodeBinary(w, order, v.Elem(), true)
+		}
+	default:
+		if isFixedSize(v.Type()) {
+			return binary.Write(w, order, v.Interface())
+		}
+	}
+	return fmt.Errorf("binary coder cannot encode a %s", v.Type().String())
+}
+
+// Decode a value with the 'BinaryCoder' framing into a settable 'reflect.Value'. If 'outer' is true, strings and
+// slices do not have a length prefix, and extend to the end of the input.
+func decodeBinary(r *bytes.Reader, order binary.ByteOrder, v reflect.Value, outer bool) error {
+	switch v.Kind() {
+	case reflect.Int:
+		var i int64
+		if err := binary.Read(r, order, &i); err != nil {
+			return err
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint:
+		var u uint64
+		if err := binary.Read(r, order, &u); err != nil {
+			return err
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.String:
+		n := r.Len()
+		if !outer {
+			var err error
+			if n, err = readBinaryLength(r, order, 1); err != nil {
+				return err
+			}
+		}
+		bs := make([]byte, n)
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		v.SetString(string(bs))
+		return nil
+	case reflect.Slice:
+		elem := v.Type().Elem()
+		if isFixedSize(elem) {
+			size := binary.Size(reflect.Zero(elem).Interface())
+			var n int
+			if outer {
+				if size == 0 || r.Len()%size != 0 {
+					return fmt.Errorf("binary coder cannot decode %v bytes into a %s", r.Len(), v.Type().String())
+				}
+				n = r.Len() / size
+			} else {
+				var err error
+				if n, err = readBinaryLength(r, order, size); err != nil {
+					return err
+				}
+			}
+			s := reflect.MakeSlice(v.Type(), n, n)
+			if err := binary.Read(r, order, s.Interface()); err != nil {
+				return err
+			}
+			v.Set(s)
+			return nil
+		}
+		if outer {
+			s := reflect.MakeSlice(v.Type(), 0, 0)
+			for r.Len() > 0 {
+				e := reflect.New(elem).Elem()
+				if err := decodeBinary(r, order, e, false); err != nil {
+					return err
+				}
+				s = reflect.Append(s, e)
+			}
+			v.Set(s)
+			return nil
+		}
+		// A variable-size element takes at least one byte, so the length can be checked against the input.
+		n, err := readBinaryLength(r, order, 1)
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := decodeBinary(r, order, s.Index(i), false); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := decodeBinary(r, order, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name == "_" {
+				continue
+			}
+			if t.Field(i).PkgPath != "" {
+				return fmt.Errorf("%w: binary coder cannot decode unexported field %s of %s", ErrWrongDestination, t.Field(i).Name, t.String())
+			}
+			if err := decodeBinary(r, order, v.Field(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if isFixedSize(v.Type()) {
+			return binary.Read(r, order, v.Addr().Interface())
+		}
+	}
+	return fmt.Errorf("%w: binary coder cannot decode a %s", ErrWrongDestination, v.Type().String())
+}
+
+// Read a 'uint32' length prefix, checking that there are enough bytes left in the input for that many values of
+// the given size.
+func readBinaryLength(r *bytes.Reader, order binary.ByteOrder, size int) (int, error) {
+	var n uint32
+	if err := binary.Read(r, order, &n); err != nil {
+		return 0, err
+	}
+	if uint64(n)*uint64(size) > uint64(r.Len()) {
+		return 0, fmt.Errorf("binary coder length prefix %v too large for remaining %v bytes", n, r.Len())
+	}
+	return int(n), nil
+}
+
+// Check if a type is fixed-size in the sense of 'binary.Size'.
+func isFixedSize(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return isFixedSize(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !isFixedSize(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
@@ -1,17 +1,22 @@
 package logdb
 
 import (
+	"compress/flate"
 	"encoding/binary"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
-	"github.com/stretchr/testify/assert"
+	"github.com/barrucadu/logdb/internal/assert"
 )
 
 var coderTypes = map[string]func() *CodingDB{
 	"id":     func() *CodingDB { return IdentityCoder(&InMemDB{}) },
 	"binary": func() *CodingDB { return BinaryCoder(&InMemDB{}, binary.LittleEndian) },
 	"gob":    func() *CodingDB { return GobCoder(&InMemDB{}) },
+	"json":   func() *CodingDB { return JSONCoder(&InMemDB{}) },
 }
 
 func TestCoding_AppendValue(t *testing.T) {
@@ -30,8 +35,8 @@ func TestCoding_AppendValue(t *testing.T) {
 			assert.Equal(t, uint64(i+1), idx, "expected equal ID")
 
 			v := make([]byte, len(bs))
-			// Gob is slightly special
-			if coderName == "gob" {
+			// Gob and JSON are slightly special
+			if coderName == "gob" || coderName == "json" {
 				err = coder.GetValue(idx, &v)
 			} else {
 				err = coder.GetValue(idx, v)
@@ -58,8 +63,8 @@ func TestCoding_AppendValues(t *testing.T) {
 
 		for i, bs := range bss {
 			v := make([]byte, len(bs))
-			// Gob is slightly special
-			if coderName == "gob" {
+			// Gob and JSON are slightly special
+			if coderName == "gob" || coderName == "json" {
 				err = coder.GetValue(uint64(i+1), &v)
 			} else {
 				err = coder.GetValue(uint64(i+1), v)
@@ -69,3 +74,170 @@ func TestCoding_AppendValues(t *testing.T) {
 		}
 	}
 }
+
+type binaryRecord struct {
+	ID     int
+	Name   string
+	Tags   []string
+	Scores []float64
+	Inner  struct {
+		Flag bool
+		Raw  []byte
+	}
+	_ int32
+}
+
+func TestCoding_BinaryVariableLength(t *testing.T) {
+	coder := BinaryCoder(&InMemDB{}, binary.LittleEndian)
+
+	record := binaryRecord{ID: -12, Name: "hello world", Tags: []string{"a", "", "bc"}, Scores: []float64{1.5, 2}}
+	record.Inner.Flag = true
+	record.Inner.Raw = []byte{1, 2, 3}
+
+	idx, err := coder.AppendValue(record)
+	assert.Nil(t, err, "expected no error in append")
+	var outRecord binaryRecord
+	assert.Nil(t, coder.GetValue(idx, &outRecord), "expected no error in get")
+	assert.Equal(t, record, outRecord, "expected equal struct values")
+
+	idx, err = coder.AppendValue([]string{"one", "two", "three"})
+	assert.Nil(t, err, "expected no error in append")
+	var outStrings []string
+	assert.Nil(t, coder.GetValue(idx, &outStrings), "expected no error in get")
+	assert.Equal(t, []string{"one", "two", "three"}, outStrings, "expected equal '[]string' values")
+
+	idx, err = coder.AppendValue("just a string")
+	assert.Nil(t, err, "expected no error in append")
+	bs, _ := coder.Get(idx)
+	assert.Equal(t, []byte("just a string"), bs, "expected string to be stored unchanged")
+	var outString string
+	assert.Nil(t, coder.GetValue(idx, &outString), "expected no error in get")
+	assert.Equal(t, "just a string", outString, "expected equal string values")
+
+	idx, err = coder.AppendValue([]byte{4, 5, 6})
+	assert.Nil(t, err, "expected no error in append")
+	var outBytes []byte
+	assert.Nil(t, coder.GetValue(idx, &outBytes), "expected no error in get")
+	assert.Equal(t, []byte{4, 5, 6}, outBytes, "expected equal '[]byte' values")
+}
+
+func TestCoding_BinaryUnsupported(t *testing.T) {
+	coder := BinaryCoder(&InMemDB{}, binary.LittleEndian)
+
+	_, err := coder.AppendValue(map[string]int{"a": 1})
+	assert.NotNil(t, err, "expected maps to be unsupported")
+
+	_, err = coder.AppendValue(struct{ hidden string }{"x"})
+	assert.NotNil(t, err, "expected unexported fields to be unsupported")
+}
+
+func TestCoding_BinaryBadLength(t *testing.T) {
+	coder := BinaryCoder(&InMemDB{}, binary.LittleEndian)
+
+	idx, err := coder.Append([]byte{255, 255, 255, 255, 1})
+	assert.Nil(t, err, "expected no error in append")
+
+	var out struct{ Name string }
+	assert.NotNil(t, coder.GetValue(idx, &out), "expected oversized length prefix to be rejected")
+}
+
+func TestCoding_EncodeError(t *testing.T) {
+	coder := IdentityCoder(&InMemDB{})
+
+	_, err := coder.AppendValues([]interface{}{[]byte{1}, "not bytes"})
+	encodeErr, ok := err.(*EncodeError)
+	assert.True(t, ok, "expected encode error, got: %s", err)
+	assert.Equal(t, 1, encodeErr.Index, "expected index of bad value")
+	assert.Equal(t, uint64(0), coder.NewestID(), "expected nothing to be appended")
+}
+
+func TestCoding_DecodeError(t *testing.T) {
+	coder := IdentityCoder(&InMemDB{})
+
+	idx, err := coder.AppendValue([]byte("hello world"))
+	assert.Nil(t, err, "expected no error in append")
+
+	var wrong string
+	err = coder.GetValue(idx, &wrong)
+	decodeErr, ok := err.(*DecodeError)
+	assert.True(t, ok, "expected decode error, got: %s", err)
+	assert.Equal(t, idx, decodeErr.ID, "expected ID of bad entry")
+
+	assert.Equal(t, ErrIDOutOfRange, coder.GetValue(idx+1, make([]byte, 1)), "expected out of range error to be unwrapped")
+}
+
+func TestCoding_DecodeError_WrongDestination(t *testing.T) {
+	for name, coderFactory := range coderTypes {
+		coder := coderFactory()
+		idx, err := coder.AppendValue([]byte("hello world"))
+		assert.Nil(t, err, "expected no error in append (%s)", name)
+
+		var wrong map[string]int
+		err = coder.GetValue(idx, wrong)
+		var decodeErr *DecodeError
+		assert.True(t, errors.As(err, &decodeErr), "expected decode error (%s), got: %s", name, err)
+		assert.True(t, errors.Is(err, ErrWrongDestination), "expected wrong destination (%s), got: %s", name, err)
+	}
+
+	coder := JSONCoder(&InMemDB{})
+	idx, err := coder.AppendValue("a string")
+	assert.Nil(t, err, "expected no error in append")
+	var number int
+	err = coder.GetValue(idx, &number)
+	assert.True(t, errors.Is(err, ErrWrongDestination), "expected wrong destination, got: %s", err)
+}
+
+func TestCoding_DecodeError_Corrupt(t *testing.T) {
+	for name, coderFactory := range coderTypes {
+		if name == "id" {
+			// Every entry is a valid '[]byte'.
+			continue
+		}
+		coder := coderFactory()
+		idx, err := coder.Append([]byte{0xff, 0xff})
+		assert.Nil(t, err, "expected no error in append (%s)", name)
+
+		var out []string
+		err = coder.GetValue(idx, &out)
+		var decodeErr *DecodeError
+		assert.True(t, errors.As(err, &decodeErr), "expected decode error (%s), got: %s", name, err)
+		assert.False(t, errors.Is(err, ErrWrongDestination), "expected a corrupt entry (%s), got: %s", name, err)
+	}
+}
+
+func TestCoding_StoredSize(t *testing.T) {
+	compressed, _ := CompressDEFLATE(&InMemDB{}, flate.BestCompression)
+	coder := IdentityCoder(compressed)
+
+	value := []byte(strings.Repeat("compressible ", 100))
+	idx, err := coder.AppendValue(value)
+	assert.Nil(t, err, "expected no error in append")
+
+	stored, err := compressed.LogDB.Get(idx)
+	assert.Nil(t, err, "expected no error in get")
+	size, err := coder.StoredSize(idx)
+	assert.Nil(t, err, "expected no error in stored size")
+	assert.Equal(t, uint64(len(stored)), size, "expected size of compressed entry")
+	assert.True(t, size < uint64(len(value)), "expected stored size to be smaller than the value")
+
+	_, err = coder.StoredSize(idx + 1)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected out of range error")
+}
+
+func TestCoding_GetRaw(t *testing.T) {
+	compressed, _ := CompressDEFLATE(&InMemDB{}, flate.BestCompression)
+	coder := GobCoder(compressed)
+
+	idx, err := coder.AppendValue([]string{"hello", "world"})
+	assert.Nil(t, err, "expected no error in append")
+
+	// The raw entry is as stored, so still compressed.
+	r, err := coder.GetRaw(idx)
+	assert.Nil(t, err, "expected no error in get")
+	var v []string
+	assert.Nil(t, gob.NewDecoder(flate.NewReader(r)).Decode(&v), "expected no error in streaming decode")
+	assert.Equal(t, []string{"hello", "world"}, v, "expected equal '[]string' values")
+
+	_, err = coder.GetRaw(idx + 1)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected out of range error")
+}
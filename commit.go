@@ -0,0 +1,118 @@
+package logdb
+
+import "time"
+
+// SetCommitPoint is the thread-safe version of 'LockFreeChunkDB.SetCommitPoint'.
+func (db *ChunkDB) SetCommitPoint(id uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.SetCommitPoint(id)
+}
+
+// SetCommitPoint marks an entry as a commit point: typically the newest entry which has been acknowledged to
+// some external system. 'Rollback' and 'Truncate' then refuse to remove it or any older entry, returning
+// 'ErrBelowCommitPoint', unless 'ForceRollback' or 'ForceTruncate' are used instead. This guards against bugs
+// which roll back with a stale ID. 'Forget' is not affected.
+//
+// The commit point is written to disk immediately, and so persists when the database is closed. 0 means there
+// is no commit point.
+//
+// Returns 'ErrIDOutOfRange' if the ID is newer than the newest entry, 'ErrClosed' if the handle is closed, and
+// a 'WriteError' value if the commit point could not be written.
+func (db *LockFreeChunkDB) SetCommitPoint(id uint64) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if id > db.newest {
+		return ErrIDOutOfRange
+	}
+	if err := writeFile(db.path+"/commit_point", id); err != nil {
+		return &WriteError{err}
+	}
+	db.commitPoint = id
+	return nil
+}
+
+// CommitPoint gets the ID of the commit point, or 0 if there is none.
+func (db *LockFreeChunkDB) CommitPoint() uint64 {
+	return db.commitPoint
+}
+
+// ForceRollback is the thread-safe version of 'LockFreeChunkDB.ForceRollback'.
+func (db *ChunkDB) ForceRollback(newNewestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.ForceRollback(newNewestID)
+}
+
+// ForceRollback is like 'Rollback', but ignores the commit point. If entries at or before the commit point are
+// removed, the commit point becomes the new newest entry.
+//
+// Returns the same errors as 'Rollback' and 'SetCommitPoint'.
+func (db *LockFreeChunkDB) ForceRollback(newNewestID uint64) error {
+	defer db.observe(nil, time.Now(), "rollback", newNewestID, db.newest, db.path)
+	defer func() { db.newest = db.next() - 1 }()
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	if err := db.checkInterlock(db.oldest, newNewestID); err != nil {
+		return err
+	}
+	if err := db.softRollback(newNewestID); err != nil {
+		return err
+	}
+	return db.checked("rollback", db.lowerCommitPoint())
+}
+
+// ForceTruncate is the thread-safe version of 'LockFreeChunkDB.ForceTruncate'.
+func (db *ChunkDB) ForceTruncate(newOldestID, newNewestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.ForceTruncate(newOldestID, newNewestID)
+}
+
+// ForceTruncate is like 'Truncate', but ignores the commit point, as with 'ForceRollback'.
+//
+// Returns the same errors as 'Truncate' and 'SetCommitPoint'.
+func (db *LockFreeChunkDB) ForceTruncate(newOldestID, newNewestID uint64) error {
+	defer db.observe(nil, time.Now(), "truncate", newOldestID, newNewestID, db.path)
+	defer func() { db.newest = db.next() - 1 }()
+	if db.closed {
+		return ErrClosed
+	}
+	if newNewestID < newOldestID {
+		return ErrIDOutOfRange
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	if err := db.checkInterlock(newOldestID, newNewestID); err != nil {
+		return err
+	}
+	if err := db.forget(newOldestID); err != nil {
+		return err
+	}
+	if err := db.softRollback(newNewestID); err != nil {
+		return err
+	}
+	return db.checked("truncate", db.lowerCommitPoint())
+}
+
+////////// HELPERS //////////
+
+// Bring the commit point down to the newest entry, if it has been rolled back. Assumes a write lock is held.
+func (db *LockFreeChunkDB) lowerCommitPoint() error {
+	if newest := db.next() - 1; db.commitPoint > newest {
+		if err := writeFile(db.path+"/commit_point", newest); err != nil {
+			return &WriteError{err}
+		}
+		db.commitPoint = newest
+	}
+	return nil
+}
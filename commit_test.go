@@ -0,0 +1,30 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestCommitPoint(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "commit_point", chunkSize).(*ChunkDB)
+	filldb(t, db, numEntries)
+
+	assert.Equal(t, ErrIDOutOfRange, db.SetCommitPoint(numEntries+1), "expected commit point to be an entry")
+	assert.Nil(t, db.SetCommitPoint(200), "expected no error in setting commit point")
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["chunkdb"], false, "commit_point", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(200), db.CommitPoint(), "expected commit point to persist")
+
+	assertRollback(t, db, 200)
+	assert.Equal(t, ErrBelowCommitPoint, db.Rollback(199), "expected rollback below commit point to be refused")
+	assert.Equal(t, ErrBelowCommitPoint, db.Truncate(10, 150), "expected truncate below commit point to be refused")
+	assert.Equal(t, uint64(200), db.NewestID(), "expected nothing to be rolled back")
+	assertForget(t, db, 10)
+
+	assert.Nil(t, db.ForceRollback(150), "expected no error in forced rollback")
+	assert.Equal(t, uint64(150), db.NewestID(), "expected entries to be rolled back")
+	assert.Equal(t, uint64(150), db.CommitPoint(), "expected commit point to be lowered")
+}
@@ -0,0 +1,389 @@
+package logdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Name of the directory chunk files are staged in while being compacted, of the file marking that all the files
+// have been staged, and of the file marking that the chunks they replace have been deleted.
+const (
+	compactDir     = "compact"
+	compactCommit  = "commit"
+	compactDeleted = "deleted"
+)
+
+// Compact is the thread-safe version of 'LockFreeChunkDB.Compact'.
+func (db *ChunkDB) Compact() error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.Compact()
+}
+
+// CompactEvery calls 'Compact' in a background goroutine every 'interval', until the returned function is
+// called or the database is closed. Errors are logged, if a logger is set.
+func (db *ChunkDB) CompactEvery(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				db.rwlock.Lock()
+				err := db.LockFreeChunkDB.Compact()
+				if err != nil && err != ErrClosed && db.logger != nil {
+					db.logger.Printf("logdb: compaction failed: %v", err)
+				}
+				db.rwlock.Unlock()
+				if err == ErrClosed {
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// Compact reclaims disk space. First, chunks awaiting deletion (see 'SetForgetBatch') are deleted, or moved to
+// the trash (see 'SetTrash'), entries which have outlived the retention rule for their label (see
+// 'SetLabelRetention') are dropped, and the records of rollbacks which only concern forgotten entries are merged
+// (see 'RolledBackTo'). Then, if the oldest chunks are not densely packed, which happens when entries have been
+// forgotten from the start of the oldest chunk or when partly-filled chunks have been imported, they are
+// rewritten into fewer chunks. As every chunk file takes up the full chunk size on disk, chunks are only
+// rewritten if this reduces their number: the run of chunks which does so the most is rewritten, and the
+// chunks it replaces are deleted (not moved to the trash, as no entries are lost). The active chunk is never
+// rewritten. Forgotten entries are not copied, so cannot be restored by 'Undelete' afterwards.
+//
+// Entry IDs do not change, so this is invisible to readers, apart from the chunk hooks: the replaced chunks
+// are reported as deleted, and the new chunks as created and then sealed.
+//
+// Compaction is atomic: the new chunks are staged, and only replace the old ones once all have been written.
+// If the process dies part-way through replacing the files, the compaction is completed when the database is
+// next opened.
+//
+// Returns 'ErrClosed' if the handle is closed, a 'SyncError' value if the pending deletions could not be
+// performed, and a 'WriteError' value if the new chunks or the rollback records could not be written.
+func (db *LockFreeChunkDB) Compact() error {
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+
+	if db.pendingDeletes > 0 {
+		if err := db.sync(); err != nil {
+			return err
+		}
+		db.prune()
+	}
+	if err := db.expireLabels(); err != nil {
+		return err
+	}
+	if err := db.compactGenerations(); err != nil {
+		return err
+	}
+
+	n, packed := db.compactPlan()
+	if n == 0 {
+		return nil
+	}
+	old := db.chunks[:n]
+	fresh, err := db.compactChunks(old, packed, nil)
+	if err != nil {
+		return err
+	}
+	db.replaceChunks(old, fresh)
+	if db.logger != nil {
+		db.logger.Printf("logdb: compacted %v chunks into %v", len(old), len(fresh))
+	}
+	return db.checked("compact", nil)
+}
+
+////////// HELPERS //////////
+
+// Work out which chunks to compact: the number of chunks at the start of the database to rewrite, and the
+// number of chunks their live entries fit in. If nothing would be saved, the number to rewrite is 0. Assumes a
+// lock (read or write) is held.
+func (db *LockFreeChunkDB) compactPlan() (int, int) {
+	if len(db.chunks) == 0 {
+		return 0, 0
+	}
+	var best, bestN, bestPacked int
+	for i, packed := range db.packing(len(db.chunks)-1, nil) {
+		if saved := i + 1 - packed; saved > best {
+			best, bestN, bestPacked = saved, i+1, packed
+		}
+	}
+	return bestN, bestPacked
+}
+
+// Work out, for each of the first 'n' chunks, how many chunks its live entries and those of the chunks before it
+// fit in. If 'drop' is not nil, the entries it returns true for are dropped, and so take up no space. Assumes a
+// lock (read or write) is held.
+func (db *LockFreeChunkDB) packing(n int, drop func(id uint64) bool) []int {
+	packing := make([]int, n)
+	var packed int
+	var used int32
+	var entries uint32
+	for i := 0; i < n; i++ {
+		c := db.chunks[i]
+		for id := c.oldest; id < c.next(); id++ {
+			if id < db.oldest {
+				continue
+			}
+			_, start, end := c.find(id)
+			if drop != nil && drop(id) {
+				start = end
+			}
+			if packed == 0 || uint32(used+end-start) > db.chunkSize || db.chunkFull(int(entries)) {
+				packed++
+				used, entries = 0, 0
+			}
+			used += end - start
+			entries++
+		}
+		packing[i] = packed
+	}
+	return packing
+}
+
+// Swap the chunks replaced by 'compactChunks' for the new ones, which are at the start of the database.
+// Assumes a write lock is held.
+func (db *LockFreeChunkDB) replaceChunks(old, fresh []*chunk) {
+	for _, c := range old {
+		_ = syscall.Munmap(c.bytes)
+		_ = c.mmapf.Close()
+		delete(db.syncDirty, c)
+		db.count(MetricChunkDeletions, 1)
+		if db.hooks.Deleted != nil {
+			db.hooks.Deleted(c.info())
+		}
+	}
+	db.chunks = append(fresh, db.chunks[len(old):]...)
+	for _, c := range fresh {
+		if db.hooks.Created != nil {
+			db.hooks.Created(c.info())
+		}
+		if db.hooks.Sealed != nil {
+			db.hooks.Sealed(c.info())
+		}
+	}
+}
+
+// Write the live entries of some chunks into a smaller number of new chunks, replace the old chunks with them,
+// and open them. If 'drop' is not nil, the entries it returns true for are dropped: they are written with no
+// data, so that the IDs of the entries after them do not change. Assumes a write lock is held.
+func (db *LockFreeChunkDB) compactChunks(old []*chunk, packed int, drop func(id uint64) bool) ([]*chunk, error) {
+	// The new chunks are numbered so that they end just before the first chunk which is kept.
+	keep := chunkNumber(db.chunks[len(old)].path)
+	num := keep - uint64(packed)
+
+	stage := db.path + "/" + compactDir
+	if err := os.RemoveAll(stage); err != nil {
+		return nil, &WriteError{err}
+	}
+	if err := os.Mkdir(stage, 0755); err != nil {
+		return nil, &WriteError{err}
+	}
+
+	// Stage the new chunks, filling each in turn. Each new chunk has the modification time of the newest chunk
+	// its entries come from, so that it still says when they were written.
+	var names []string
+	var fill *chunk
+	var written time.Time
+	stageChunk := func() error {
+		if fill == nil {
+			return nil
+		}
+		if err := writeCompactedChunk(fill, written); err != nil {
+			return err
+		}
+		names = append(names, filepath.Base(fill.path))
+		fill = nil
+		written = time.Time{}
+		return nil
+	}
+	for _, c := range old {
+		if c.removed {
+			continue
+		}
+		fi, err := os.Stat(c.path)
+		if err != nil {
+			_ = os.RemoveAll(stage)
+			return nil, &ReadError{err}
+		}
+		for id := c.oldest; id < c.next(); id++ {
+			if id < db.oldest {
+				continue
+			}
+			_, start, end := c.find(id)
+			sum, header := c.sums[id-c.oldest], c.header(id)
+			if drop != nil && drop(id) {
+				start, sum, header = end, droppedSum, nil
+			}
+			var used int32
+			if fill != nil && len(fill.ends) > 0 {
+				used = fill.ends[len(fill.ends)-1]
+			}
+			if fill == nil || uint32(used+end-start) > db.chunkSize || db.chunkFull(len(fill.ends)) {
+				if err := stageChunk(); err != nil {
+					_ = os.RemoveAll(stage)
+					return nil, &WriteError{err}
+				}
+				name := fmt.Sprintf("%s%s%v%s%v", chunkPrefix, sep, num, sep, id)
+				fill = &chunk{path: stage + "/" + name, oldest: id, bytes: make([]byte, db.chunkSize)}
+				num++
+				used = 0
+			}
+			if fi.ModTime().After(written) {
+				written = fi.ModTime()
+			}
+			if err := c.readAt(fill.bytes[used:used+end-start], start); err != nil {
+				_ = os.RemoveAll(stage)
+				return nil, err
+			}
+			fill.ends = append(fill.ends, used+end-start)
+			fill.sums = append(fill.sums, sum)
+			fill.appendLabel(c.label(id))
+			fill.appendHeader(header)
+		}
+	}
+	if err := stageChunk(); err != nil {
+		_ = os.RemoveAll(stage)
+		return nil, &WriteError{err}
+	}
+
+	// Commit, and replace the old chunks.
+	if err := writeFile(stage+"/"+compactCommit, keep); err != nil {
+		_ = os.RemoveAll(stage)
+		return nil, &WriteError{err}
+	}
+	if err := finishCompact(db.path); err != nil {
+		return nil, &WriteError{err}
+	}
+
+	// Finally, open the new chunks.
+	fresh := make([]*chunk, len(names))
+	var prior *chunk
+	for i, name := range names {
+		dir := chunkDir(db.path, name)
+		fi, err := os.Stat(dir + "/" + name)
+		if err != nil {
+			return nil, &ReadError{err}
+		}
+		c, err := openChunkFile(dir, fi, prior, db.chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		fresh[i] = &c
+		prior = &c
+	}
+	return fresh, nil
+}
+
+// Write the files of a compacted chunk, with the given modification time, and seal it.
+func writeCompactedChunk(c *chunk, modTime time.Time) error {
+	if err := createChunkFiles(c.path, uint32(len(c.bytes)), c.oldest); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(c.bytes[:c.ends[len(c.ends)-1]], 0); err != nil {
+		return err
+	}
+	if err := fsync(f); err != nil {
+		return err
+	}
+	if err := os.Chtimes(c.path, modTime, modTime); err != nil {
+		return err
+	}
+	if err := writeFile(c.metaFilePath(), encodeMetadata(nil, c.ends, c.sums, c.labels, c.headers, 0)); err != nil {
+		return err
+	}
+	return c.seal()
+}
+
+// Complete or abandon a compaction. If the staging directory has a commit file, the chunks it replaces are
+// deleted, and the staged files moved into the database directory; otherwise they are deleted. This is safe to
+// call if there is no compaction.
+func finishCompact(path string) error {
+	stage := path + "/" + compactDir
+	if _, err := os.Stat(stage + "/" + compactCommit); err != nil {
+		return os.RemoveAll(stage)
+	}
+	var keep uint64
+	if err := readFile(stage+"/"+compactCommit, &keep); err != nil {
+		return err
+	}
+
+	// Delete the chunks being replaced, which are all those numbered before the first chunk which is kept. This
+	// must not be repeated once any staged file has been moved, as the new chunks are numbered the same way.
+	if _, err := os.Stat(stage + "/" + compactDeleted); err != nil {
+		dirs := []string{path}
+		for i := 0; i < len(dirs); i++ {
+			fis, err := ioutil.ReadDir(dirs[i])
+			if err != nil {
+				return err
+			}
+			for _, fi := range fis {
+				name := fi.Name()
+				if fi.IsDir() && dirs[i] == path && isBasenameShardDir(name) {
+					dirs = append(dirs, path+"/"+name)
+				} else if !fi.IsDir() && isBasenameChunkDataFile(name) && chunkNumber(name) < keep {
+					for _, p := range []string{dirs[i] + "/" + name, metaFilePath(dirs[i] + "/" + name), sealFilePath(dirs[i] + "/" + name)} {
+						if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+							return err
+						}
+					}
+				}
+			}
+		}
+		if err := writeFile(stage+"/"+compactDeleted, keep); err != nil {
+			return err
+		}
+	}
+
+	fis, err := ioutil.ReadDir(stage)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.Name() == compactCommit || fi.Name() == compactDeleted {
+			continue
+		}
+		// Metadata and seal files go in the same directory as their data files.
+		dir := chunkDir(path, fi.Name())
+		if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+			return err
+		}
+		if err := os.Rename(stage+"/"+fi.Name(), dir+"/"+fi.Name()); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(stage)
+}
+
+// Get the number of a chunk from the path of its data file.
+//
+// This does no validation, the path must be to a valid chunk data file.
+func chunkNumber(path string) uint64 {
+	num, _ := strconv.ParseUint(strings.Split(filepath.Base(path), sep)[1], 10, 0)
+	return num
+}
@@ -0,0 +1,115 @@
+package logdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestCompact_PartialChunks(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "compact_partial_chunks", chunkSize).(*LockFreeChunkDB)
+	var violations []*InvariantError
+	db.SetInvariantChecks(func(err *InvariantError) { violations = append(violations, err) })
+
+	// Import some chunks with only a few entries in each.
+	var vs [][]byte
+	for i := 0; i < 4; i++ {
+		_ = os.RemoveAll(fmt.Sprintf("test_db/compact_partial_chunks_src%v", i))
+		w, err := NewChunkWriter(fmt.Sprintf("test_db/compact_partial_chunks_src%v", i), chunkSize, db.next())
+		assert.Nil(t, err, "expected no error in new chunk writer")
+		for j := 0; j < 3; j++ {
+			v := []byte(fmt.Sprintf("entry %v.%v", i, j))
+			_, err := w.Append(v)
+			assert.Nil(t, err, "expected no error in chunk writer append")
+			vs = append(vs, v)
+		}
+		assert.Nil(t, w.Close(), "expected no error in chunk writer close")
+		assert.Nil(t, db.ImportChunks(w.Paths()), "expected no error in import")
+	}
+	for i := 0; i < 50; i++ {
+		v := []byte(fmt.Sprintf("entry-%v", i))
+		assertAppend(t, db, v)
+		vs = append(vs, v)
+	}
+	assertForget(t, db, 2)
+
+	before := len(db.chunks)
+	assert.Nil(t, db.Compact(), "expected no error in compact")
+	assert.True(t, len(db.chunks) < before, "expected fewer chunks, got %v from %v", len(db.chunks), before)
+	assert.Equal(t, uint64(2), db.chunks[0].oldest, "expected forgotten entry to be dropped")
+	assert.Equal(t, 0, len(violations), "expected no invariant violations, got %v", violations)
+	for i := 1; i < len(vs); i++ {
+		assert.Equal(t, vs[i], assertGet(t, db, uint64(i+1)), "expected entry to be unchanged")
+	}
+
+	// Compacting again does nothing, and appends still work.
+	after := len(db.chunks)
+	assert.Nil(t, db.Compact(), "expected no error in compact")
+	assert.Equal(t, after, len(db.chunks), "expected nothing more to compact")
+	assertAppend(t, db, []byte("after compaction"))
+	assertClose(t, db)
+
+	_, err := os.Stat("test_db/compact_partial_chunks/" + compactDir)
+	assert.True(t, os.IsNotExist(err), "expected staging directory to be removed")
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "compact_partial_chunks", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, after, len(db.chunks), "expected compacted chunks to persist")
+	for i := 1; i < len(vs); i++ {
+		assert.Equal(t, vs[i], assertGet(t, db, uint64(i+1)), "expected entry to persist")
+	}
+	assert.Nil(t, db.Verify(), "expected no problems after compaction")
+}
+
+func TestCompact_PendingDeletes(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "compact_pending_deletes", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assert.Nil(t, db.SetForgetBatch(100), "expected no error in set forget batch")
+	filldb(t, db, numEntries)
+	assertForget(t, db, 200)
+
+	before := len(db.chunks)
+	assert.Nil(t, db.Compact(), "expected no error in compact")
+	assert.True(t, len(db.chunks) < before, "expected forgotten chunks to be deleted")
+	assert.Equal(t, 0, db.pendingDeletes, "expected no pending deletes")
+}
+
+func TestCompact_DiscardUncommitted(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "compact_discard_uncommitted", chunkSize)
+	filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	stage := "test_db/compact_discard_uncommitted/" + compactDir
+	if err := os.Mkdir(stage, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "compact_discard_uncommitted", chunkSize)
+	defer assertClose(t, db)
+	assert.Equal(t, []string{"discarded uncommitted compaction"}, db.(*LockFreeChunkDB).OpenReport().Recovery, "expected compaction to be discarded")
+	_, err := os.Stat(stage)
+	assert.True(t, os.IsNotExist(err), "expected staging directory to be removed")
+}
+
+func TestCompact_Every(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "compact_every", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assert.Nil(t, db.SetForgetBatch(100), "expected no error in set forget batch")
+	filldb(t, db, numEntries)
+	assertForget(t, db, 200)
+
+	stop := db.CompactEvery(time.Millisecond)
+	defer stop()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		db.rwlock.RLock()
+		pending := db.pendingDeletes
+		db.rwlock.RUnlock()
+		if pending == 0 {
+			return
+		}
+	}
+	t.Fatal("expected background compaction to delete forgotten chunks")
+}
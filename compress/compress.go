@@ -0,0 +1,130 @@
+// Package compress provides 'CompressingDB' wrappers for compression algorithms which are much faster than
+// DEFLATE and LZW, for when appends need to be cheap more than entries need to be small, and 'AutoCodec'
+// values for them so that 'CompressAuto' can pick between them for every entry.
+package compress
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/barrucadu/logdb"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressSnappy creates a 'CompressingDB' with Snappy compression. This is the fastest, but compresses the
+// least.
+func CompressSnappy(db logdb.LogDB) *logdb.CompressingDB {
+	return &logdb.CompressingDB{
+		LogDB:      db,
+		Compress:   AutoSnappy.Compress,
+		Decompress: AutoSnappy.Decompress,
+	}
+}
+
+// CompressZstd creates a 'CompressingDB' with Zstandard compression at the given level, as understood by the
+// reference zstd implementation, which is mapped to the nearest level supported. Even at high levels this is
+// much faster than DEFLATE, and usually compresses better.
+//
+// Returns an error if the level is < 1 or > 22.
+func CompressZstd(db logdb.LogDB, level int) (*logdb.CompressingDB, error) {
+	codec, err := AutoZstd(level)
+	if err != nil {
+		return nil, err
+	}
+	return &logdb.CompressingDB{
+		LogDB:      db,
+		Compress:   codec.Compress,
+		Decompress: codec.Decompress,
+	}, nil
+}
+
+// CompressAuto creates a 'CompressingDB' which picks the compression algorithm separately for every entry, as
+// 'logdb.CompressAutoWith' does: no compression, Snappy, or Zstandard at the given level. Entries which are
+// already compressed are stored as they are, and the rest get whichever of the two does better on them.
+//
+// Returns an error if the level is < 1 or > 22.
+func CompressAuto(db logdb.LogDB, level int) (*logdb.CompressingDB, error) {
+	zcodec, err := AutoZstd(level)
+	if err != nil {
+		return nil, err
+	}
+	return logdb.CompressAutoWith(db, AutoSnappy, zcodec)
+}
+
+// AutoSnappy is Snappy, for 'logdb.CompressAutoWith'.
+var AutoSnappy = logdb.AutoCodec{
+	Flag:       3,
+	Compress:   func(bs []byte) ([]byte, error) { return snappy.Encode(nil, bs), nil },
+	Decompress: func(bs []byte) ([]byte, error) { return snappy.Decode(nil, bs) },
+}
+
+// AutoZstd is Zstandard at the given level, as for 'CompressZstd', for 'logdb.CompressAutoWith'. The level is
+// not recorded in stored entries, so entries compressed at one level can be read with any other.
+//
+// Returns an error if the level is < 1 or > 22.
+func AutoZstd(level int) (logdb.AutoCodec, error) {
+	if level < 1 || level > 22 {
+		return logdb.AutoCodec{}, errors.New("zstd compression level must be in the range [1,22]")
+	}
+
+	enc, err := zstdEncoder(zstd.EncoderLevelFromZstd(level))
+	if err != nil {
+		return logdb.AutoCodec{}, err
+	}
+	dec, err := zstdDecoder()
+	if err != nil {
+		return logdb.AutoCodec{}, err
+	}
+	return logdb.AutoCodec{
+		Flag:       4,
+		Compress:   func(bs []byte) ([]byte, error) { return enc.EncodeAll(bs, nil), nil },
+		Decompress: func(bs []byte) ([]byte, error) { return dec.DecodeAll(bs, nil) },
+	}, nil
+}
+
+// The largest size an entry may decompress to with Zstandard, so that a corrupt entry cannot make the decoder
+// allocate an arbitrary amount of memory. This is the largest chunk size on 32-bit platforms.
+const maxZstdDecodedSize = 256 * 1024 * 1024
+
+// Zstandard encoders and decoders start goroutines which run until they are closed, so rather than every
+// 'AutoZstd' creating its own, one encoder per level and one decoder are shared by all of them, and never
+// closed. They are safe for concurrent use with 'EncodeAll' and 'DecodeAll'.
+var (
+	zstdLock     sync.Mutex
+	zstdEncoders = make(map[zstd.EncoderLevel]*zstd.Encoder)
+	zstdDec      *zstd.Decoder
+)
+
+// Get the shared encoder for a level, creating it if need be.
+func zstdEncoder(level zstd.EncoderLevel) (*zstd.Encoder, error) {
+	zstdLock.Lock()
+	defer zstdLock.Unlock()
+
+	if enc, ok := zstdEncoders[level]; ok {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[level] = enc
+	return enc, nil
+}
+
+// Get the shared decoder, creating it if need be.
+func zstdDecoder() (*zstd.Decoder, error) {
+	zstdLock.Lock()
+	defer zstdLock.Unlock()
+
+	if zstdDec != nil {
+		return zstdDec, nil
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxZstdDecodedSize))
+	if err != nil {
+		return nil, err
+	}
+	zstdDec = dec
+	return dec, nil
+}
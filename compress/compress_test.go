@@ -0,0 +1,100 @@
+package compress
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/barrucadu/logdb"
+	"github.com/barrucadu/logdb/internal/assert"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var compressTypes = map[string]func() *logdb.CompressingDB{
+	"snappy": func() *logdb.CompressingDB { return CompressSnappy(&logdb.InMemDB{}) },
+	"zstd":   func() *logdb.CompressingDB { db, _ := CompressZstd(&logdb.InMemDB{}, 3); return db },
+	"auto":   func() *logdb.CompressingDB { db, _ := CompressAuto(&logdb.InMemDB{}, 3); return db },
+}
+
+func TestCompress_AppendEntries(t *testing.T) {
+	for compressName, compressFactory := range compressTypes {
+		t.Logf("Compress: %s\n", compressName)
+		compress := compressFactory()
+
+		bss := make([][]byte, 255)
+		for i := 0; i < len(bss); i++ {
+			bss[i] = []byte(fmt.Sprintf("entry %v %s", i, strings.Repeat("x", i)))
+		}
+
+		idx, err := compress.AppendEntries(bss)
+		assert.Nil(t, err, "expected no error in append")
+		assert.Equal(t, uint64(1), idx, "expected first ID")
+
+		for i, bs := range bss {
+			v, err := compress.Get(uint64(i + 1))
+			assert.Nil(t, err, "expected no error in get")
+			assert.Equal(t, bs, v, "expected equal '[]byte' values")
+
+			stored, err := compress.StoredSize(uint64(i + 1))
+			assert.Nil(t, err, "expected no error in stored size")
+			if len(bs) > 128 {
+				assert.True(t, stored < uint64(len(bs)), "expected entry to be compressed")
+			}
+		}
+	}
+}
+
+func TestCompressZstd_Level(t *testing.T) {
+	_, err := CompressZstd(&logdb.InMemDB{}, 0)
+	assert.NotNil(t, err, "expected error for level 0")
+	_, err = CompressZstd(&logdb.InMemDB{}, 23)
+	assert.NotNil(t, err, "expected error for level 23")
+}
+
+func TestCompressZstd_Shared(t *testing.T) {
+	_, err := CompressZstd(&logdb.InMemDB{}, 3)
+	assert.Nil(t, err, "expected no error in create")
+	_, err = CompressZstd(&logdb.InMemDB{}, 3)
+	assert.Nil(t, err, "expected no error in create")
+
+	enc1, _ := zstdEncoder(zstd.EncoderLevelFromZstd(3))
+	enc2, _ := zstdEncoder(zstd.EncoderLevelFromZstd(3))
+	assert.True(t, enc1 == enc2, "expected one encoder per level")
+	dec1, _ := zstdDecoder()
+	dec2, _ := zstdDecoder()
+	assert.True(t, dec1 == dec2, "expected one decoder")
+}
+
+func TestCompressAuto_Mixed(t *testing.T) {
+	inmem := &logdb.InMemDB{}
+	compress, err := CompressAuto(inmem, 3)
+	assert.Nil(t, err, "expected no error in compress")
+
+	random := make([]byte, 8192)
+	rand.Read(random)
+	repetitive := []byte(strings.Repeat(`{"key": "value", "count": 42}`, 256))
+
+	for _, bs := range [][]byte{random, repetitive} {
+		idx, err := compress.Append(bs)
+		assert.Nil(t, err, "expected no error in append")
+
+		v, err := compress.Get(idx)
+		assert.Nil(t, err, "expected no error in get")
+		assert.Equal(t, bs, v, "expected equal '[]byte' values")
+	}
+
+	stored, _ := inmem.Get(1)
+	assert.Equal(t, byte(0), stored[0], "expected incompressible entry to be stored raw")
+	assert.Equal(t, len(random)+1, len(stored), "expected only a flag byte of overhead")
+
+	zcodec, _ := AutoZstd(3)
+	stored, _ = inmem.Get(2)
+	assert.True(t, stored[0] == AutoSnappy.Flag || stored[0] == zcodec.Flag, "expected compressible entry to be compressed")
+	assert.True(t, len(stored) < len(repetitive)/4, "expected a good compression ratio, got %v bytes", len(stored))
+
+	// Entries compressed with the core codecs have different flags, so can be mixed in one log.
+	_, err = logdb.CompressAutoWith(inmem, logdb.AutoDEFLATE, logdb.AutoLZW, AutoSnappy)
+	assert.Nil(t, err, "expected the flags not to clash")
+}
@@ -0,0 +1,11 @@
+module github.com/barrucadu/logdb/compress
+
+go 1.20
+
+require (
+	github.com/barrucadu/logdb v0.0.0
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.17.9
+)
+
+replace github.com/barrucadu/logdb => ../
@@ -5,6 +5,8 @@ import (
 	"compress/flate"
 	"compress/lzw"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 )
 
@@ -58,6 +60,19 @@ func (db *CompressingDB) Get(id uint64) ([]byte, error) {
 	return db.Decompress(bs)
 }
 
+// StoredSize implements the 'SizedDB' interface: it is the size of the compressed entry, as stored in the
+// underlying 'LogDB'. If the underlying 'LogDB' is not also a 'SizedDB', the compressed entry is retrieved to
+// find its size.
+func (db *CompressingDB) StoredSize(id uint64) (uint64, error) {
+	return storedSize(db.LogDB, id)
+}
+
+// GetRaw implements the 'RawDB' interface: it reads the compressed entry, as stored in the underlying 'LogDB'.
+// If the underlying 'LogDB' is not also a 'RawDB', the compressed entry is retrieved in full.
+func (db *CompressingDB) GetRaw(id uint64) (io.Reader, error) {
+	return getRaw(db.LogDB, id)
+}
+
 // CompressIdentity create a 'CompressingDB' with the identity compressor/decompressor.
 func CompressIdentity(logdb LogDB) *CompressingDB {
 	return &CompressingDB{
@@ -75,26 +90,9 @@ func CompressDEFLATE(logdb LogDB, level int) (*CompressingDB, error) {
 		return nil, errors.New("flate compression level must be in the range [-2,9]")
 	}
 	return &CompressingDB{
-		LogDB: logdb,
-		Compress: func(bs []byte) ([]byte, error) {
-			buf := new(bytes.Buffer)
-			w, _ := flate.NewWriter(buf, level)
-			n, err := w.Write(bs)
-			if err != nil {
-				return nil, err
-			}
-			if n < len(bs) {
-				return nil, errors.New("could not compress all bytes")
-			}
-			w.Close()
-			return buf.Bytes(), nil
-		},
-		Decompress: func(bs []byte) ([]byte, error) {
-			r := flate.NewReader(bytes.NewReader(bs))
-			out, err := ioutil.ReadAll(r)
-			r.Close()
-			return out, err
-		},
+		LogDB:      logdb,
+		Compress:   func(bs []byte) ([]byte, error) { return compressDEFLATE(bs, level) },
+		Decompress: decompressDEFLATE,
 	}, nil
 }
 
@@ -106,26 +104,229 @@ func CompressLZW(logdb LogDB, order lzw.Order, litWidth int) (*CompressingDB, er
 		return nil, errors.New("LZW literal width must be in the range [2,8]")
 	}
 
+	return &CompressingDB{
+		LogDB:      logdb,
+		Compress:   func(bs []byte) ([]byte, error) { return compressLZW(bs, order, litWidth) },
+		Decompress: func(bs []byte) ([]byte, error) { return decompressLZW(bs, order, litWidth) },
+	}, nil
+}
+
+// An AutoCodec is a compression algorithm which 'CompressAutoWith' can pick for an entry. The flag byte
+// identifies the algorithm in stored entries, so it must not change once entries have been written with it.
+// Flag 0 marks uncompressed entries. Flags 1 and 2 are those of 'AutoDEFLATE' and 'AutoLZW', and 3 and 4
+// are those of the Snappy and Zstandard codecs in the 'compress' module.
+type AutoCodec struct {
+	Flag       byte
+	Compress   func([]byte) ([]byte, error)
+	Decompress func([]byte) ([]byte, error)
+}
+
+var (
+	// AutoDEFLATE is DEFLATE at its fastest level, for 'CompressAutoWith'.
+	AutoDEFLATE = AutoCodec{
+		Flag:       1,
+		Compress:   func(bs []byte) ([]byte, error) { return compressDEFLATE(bs, flate.BestSpeed) },
+		Decompress: decompressDEFLATE,
+	}
+
+	// AutoLZW is LZW with the least significant bit first order and a literal width of 8, for
+	// 'CompressAutoWith'.
+	AutoLZW = AutoCodec{
+		Flag:       2,
+		Compress:   func(bs []byte) ([]byte, error) { return compressLZW(bs, lzw.LSB, 8) },
+		Decompress: func(bs []byte) ([]byte, error) { return decompressLZW(bs, lzw.LSB, 8) },
+	}
+)
+
+// CompressAutoWith creates a 'CompressingDB' which picks the compression algorithm separately for every
+// entry: no compression, or one of the codecs, which are preferred in the order given when they do equally
+// well. The choice is recorded in a flag byte at the start of the stored entry, so a log can freely mix
+// entries compressed in different ways, such as already-compressed blobs and JSON. 'compress.CompressAuto',
+// in the 'compress' module, picks between the much faster Snappy and Zstandard.
+//
+// To keep appends cheap for large entries, the algorithm is chosen by compressing a sample of at most
+// 'autoSampleSize' bytes from the start of the entry with every codec. If no codec saves at least an eighth
+// of the sample, the entry is stored uncompressed (this is the common case for data which is already
+// compressed or encrypted). Otherwise the whole entry is compressed with the best codec, falling back to no
+// compression if that turns out not to be smaller after all.
+//
+// With 'BoundedDB' the bounded entry size is that of the compressed byte array plus the flag byte.
+//
+// Returns an error if a codec has flag 0, or has the same flag as another.
+func CompressAutoWith(logdb LogDB, codecs ...AutoCodec) (*CompressingDB, error) {
+	seen := make(map[byte]bool)
+	for _, codec := range codecs {
+		if codec.Flag == autoNone {
+			return nil, errors.New("compression flag byte 0 is reserved for uncompressed entries")
+		}
+		if seen[codec.Flag] {
+			return nil, fmt.Errorf("compression flag byte %v used by more than one codec", codec.Flag)
+		}
+		seen[codec.Flag] = true
+	}
+	codecs = append([]AutoCodec(nil), codecs...)
+	return &CompressingDB{
+		LogDB:      logdb,
+		Compress:   func(bs []byte) ([]byte, error) { return compressAuto(codecs, bs) },
+		Decompress: func(bs []byte) ([]byte, error) { return decompressAuto(codecs, bs) },
+	}, nil
+}
+
+// Chain creates a 'CompressingDB' which applies the functions of several others in turn, so that a pipeline of
+// transformations, such as compression and then encryption, is a single wrapper. On append, entries are
+// compressed by the first stage, then the second, and so on; on get, they are decompressed by the stages in
+// reverse. The 'LogDB' each stage wraps is not used, so stages can be created with a nil 'LogDB', and a
+// 'CodingDB' can be put on top of the chain to encode values before the first stage:
+//
+//	zstd, _ := compress.CompressZstd(nil, 3)
+//	aes, _ := EncryptAES(nil, key)
+//	coder := GobCoder(Chain(db, zstd, aes))
+//
+// With 'BoundedDB' the bounded entry size is that of the byte array after the final stage.
+func Chain(logdb LogDB, stages ...*CompressingDB) *CompressingDB {
 	return &CompressingDB{
 		LogDB: logdb,
 		Compress: func(bs []byte) ([]byte, error) {
-			buf := new(bytes.Buffer)
-			w := lzw.NewWriter(buf, order, litWidth)
-			n, err := w.Write(bs)
-			if err != nil {
-				return nil, err
-			}
-			if n < len(bs) {
-				return nil, errors.New("could not compress all bytes")
+			for _, stage := range stages {
+				var err error
+				if bs, err = stage.Compress(bs); err != nil {
+					return nil, err
+				}
 			}
-			w.Close()
-			return buf.Bytes(), nil
+			return bs, nil
 		},
 		Decompress: func(bs []byte) ([]byte, error) {
-			r := lzw.NewReader(bytes.NewReader(bs), order, litWidth)
-			out, err := ioutil.ReadAll(r)
-			r.Close()
-			return out, err
+			for i := len(stages) - 1; i >= 0; i-- {
+				var err error
+				if bs, err = stages[i].Decompress(bs); err != nil {
+					return nil, err
+				}
+			}
+			return bs, nil
 		},
-	}, nil
+	}
+}
+
+////////// HELPERS //////////
+
+// The flag byte of uncompressed entries for 'CompressAutoWith'.
+const autoNone = byte(0)
+
+// Entries smaller than this are never compressed by 'CompressAutoWith', as the framing overhead of the
+// compressed formats outweighs any saving.
+const autoMinSize = 64
+
+// The maximum number of bytes 'CompressAutoWith' compresses to pick an algorithm.
+const autoSampleSize = 4096
+
+// Compress an entry for 'CompressAutoWith'.
+func compressAuto(codecs []AutoCodec, bs []byte) ([]byte, error) {
+	if len(bs) < autoMinSize {
+		return withFlag(autoNone, bs), nil
+	}
+
+	sample := bs
+	if len(sample) > autoSampleSize {
+		sample = sample[:autoSampleSize]
+	}
+
+	// Pick the codec which does best on the sample.
+	var best *AutoCodec
+	var compressed []byte
+	for i := range codecs {
+		out, err := codecs[i].Compress(sample)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) < len(sample)-len(sample)/8 && (best == nil || len(out) < len(compressed)) {
+			best = &codecs[i]
+			compressed = out
+		}
+	}
+	if best == nil {
+		return withFlag(autoNone, bs), nil
+	}
+
+	// If the sample wasn't the whole entry, compress the whole entry.
+	if len(sample) < len(bs) {
+		var err error
+		if compressed, err = best.Compress(bs); err != nil {
+			return nil, err
+		}
+		if len(compressed) >= len(bs) {
+			return withFlag(autoNone, bs), nil
+		}
+	}
+	return withFlag(best.Flag, compressed), nil
+}
+
+// Decompress an entry for 'CompressAutoWith'.
+func decompressAuto(codecs []AutoCodec, bs []byte) ([]byte, error) {
+	if len(bs) == 0 {
+		return nil, errors.New("entry has no compression flag byte")
+	}
+	flag, bs := bs[0], bs[1:]
+	if flag == autoNone {
+		return bs, nil
+	}
+	for _, codec := range codecs {
+		if codec.Flag == flag {
+			return codec.Decompress(bs)
+		}
+	}
+	return nil, fmt.Errorf("unknown compression flag byte %v", flag)
+}
+
+// Prefix a byte slice with a flag byte.
+func withFlag(flag byte, bs []byte) []byte {
+	out := make([]byte, len(bs)+1)
+	out[0] = flag
+	copy(out[1:], bs)
+	return out
+}
+
+// Compress a byte slice with DEFLATE at the given level, which is assumed to be valid.
+func compressDEFLATE(bs []byte, level int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, _ := flate.NewWriter(buf, level)
+	n, err := w.Write(bs)
+	if err != nil {
+		return nil, err
+	}
+	if n < len(bs) {
+		return nil, errors.New("could not compress all bytes")
+	}
+	w.Close()
+	return buf.Bytes(), nil
+}
+
+// Decompress a DEFLATE-compressed byte slice.
+func decompressDEFLATE(bs []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(bs))
+	out, err := ioutil.ReadAll(r)
+	r.Close()
+	return out, err
+}
+
+// Compress a byte slice with LZW with the given order and literal width, which are assumed to be valid.
+func compressLZW(bs []byte, order lzw.Order, litWidth int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := lzw.NewWriter(buf, order, litWidth)
+	n, err := w.Write(bs)
+	if err != nil {
+		return nil, err
+	}
+	if n < len(bs) {
+		return nil, errors.New("could not compress all bytes")
+	}
+	w.Close()
+	return buf.Bytes(), nil
+}
+
+// Decompress an LZW-compressed byte slice.
+func decompressLZW(bs []byte, order lzw.Order, litWidth int) ([]byte, error) {
+	r := lzw.NewReader(bytes.NewReader(bs), order, litWidth)
+	out, err := ioutil.ReadAll(r)
+	r.Close()
+	return out, err
 }
@@ -4,15 +4,23 @@ import (
 	"compress/flate"
 	"compress/lzw"
 	"fmt"
+	"math/rand"
+	"strings"
 	"testing"
 
-	"github.com/stretchr/testify/assert"
+	"github.com/barrucadu/logdb/internal/assert"
 )
 
 var compressTypes = map[string]func() *CompressingDB{
 	"id":      func() *CompressingDB { return CompressIdentity(&InMemDB{}) },
 	"deflate": func() *CompressingDB { db, _ := CompressDEFLATE(&InMemDB{}, flate.BestCompression); return db },
 	"lzw":     func() *CompressingDB { db, _ := CompressLZW(&InMemDB{}, lzw.LSB, 8); return db },
+	"auto":    func() *CompressingDB { db, _ := CompressAutoWith(&InMemDB{}, AutoDEFLATE, AutoLZW); return db },
+	"chain": func() *CompressingDB {
+		deflate, _ := CompressDEFLATE(nil, flate.BestCompression)
+		lzwdb, _ := CompressLZW(nil, lzw.LSB, 8)
+		return Chain(&InMemDB{}, deflate, lzwdb)
+	},
 }
 
 func TestCompress_Append(t *testing.T) {
@@ -58,3 +66,76 @@ func TestCompress_AppendEntries(t *testing.T) {
 		}
 	}
 }
+
+func TestCompress_AutoMixed(t *testing.T) {
+	inmem := &InMemDB{}
+	compress, err := CompressAutoWith(inmem, AutoDEFLATE, AutoLZW)
+	assert.Nil(t, err, "expected no error in compress")
+
+	random := make([]byte, 8192)
+	rand.Read(random)
+	repetitive := []byte(strings.Repeat(`{"key": "value", "count": 42}`, 256))
+	small := []byte("tiny")
+
+	for _, bs := range [][]byte{random, repetitive, small} {
+		idx, err := compress.Append(bs)
+		assert.Nil(t, err, "expected no error in append")
+
+		v, err := compress.Get(idx)
+		assert.Nil(t, err, "expected no error in get")
+		assert.Equal(t, bs, v, "expected equal '[]byte' values")
+	}
+
+	stored, _ := inmem.Get(1)
+	assert.Equal(t, autoNone, stored[0], "expected incompressible entry to be stored raw")
+	assert.Equal(t, len(random)+1, len(stored), "expected only a flag byte of overhead")
+
+	stored, _ = inmem.Get(2)
+	assert.True(t, stored[0] != autoNone, "expected compressible entry to be compressed")
+	assert.True(t, len(stored) < len(repetitive)/4, "expected a good compression ratio, got %v bytes", len(stored))
+
+	stored, _ = inmem.Get(3)
+	assert.Equal(t, autoNone, stored[0], "expected small entry to be stored raw")
+}
+
+func TestCompress_AutoFlags(t *testing.T) {
+	_, err := CompressAutoWith(&InMemDB{}, AutoCodec{Flag: autoNone})
+	assert.NotNil(t, err, "expected error for the uncompressed flag")
+	_, err = CompressAutoWith(&InMemDB{}, AutoDEFLATE, AutoCodec{Flag: AutoDEFLATE.Flag})
+	assert.NotNil(t, err, "expected error for a repeated flag")
+
+	// Entries can only be read with the codecs they were written with.
+	inmem := &InMemDB{}
+	deflate, _ := CompressAutoWith(inmem, AutoDEFLATE)
+	idx, err := deflate.Append([]byte(strings.Repeat("compressible ", 100)))
+	assert.Nil(t, err, "expected no error in append")
+	lzwdb, _ := CompressAutoWith(inmem, AutoLZW)
+	_, err = lzwdb.Get(idx)
+	assert.NotNil(t, err, "expected error for an unknown flag")
+}
+
+func TestCompress_Chain(t *testing.T) {
+	inmem := &InMemDB{}
+	deflate, _ := CompressDEFLATE(nil, flate.BestCompression)
+	aes, err := EncryptAES(nil, make([]byte, 32))
+	assert.Nil(t, err, "expected no error in encrypt")
+	coder := GobCoder(Chain(inmem, deflate, aes))
+
+	value := strings.Repeat("hello world ", 100)
+	idx, err := coder.AppendValue(value)
+	assert.Nil(t, err, "expected no error in append")
+
+	var out string
+	assert.Nil(t, coder.GetValue(idx, &out), "expected no error in get")
+	assert.Equal(t, value, out, "expected equal string values")
+
+	// The stored entry is the encrypted compressed encoding.
+	stored, _ := inmem.Get(idx)
+	assert.True(t, len(stored) < len(value)/4, "expected entry to be compressed, got %v bytes", len(stored))
+	decrypted, err := aes.Decompress(stored)
+	assert.Nil(t, err, "expected no error in decrypt")
+	decompressed, err := deflate.Decompress(decrypted)
+	assert.Nil(t, err, "expected no error in decompress")
+	encoded, _ := GobCoder(nil).Encode(value)
+	assert.Equal(t, encoded, decompressed, "expected stages to be applied in order")
+}
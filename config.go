@@ -0,0 +1,108 @@
+package logdb
+
+import "time"
+
+// Config is the effective configuration of a database, including defaults, as returned by 'Config'. It can be
+// serialised (for example, with 'encoding/json') to record exactly how a database is configured, and given back
+// to 'OpenWithOptions' with 'WithConfig', so it can also be loaded from a configuration file.
+//
+// Functions, such as the logger, the chunk hooks, and the emergency retention callback, cannot be serialised,
+// and so are not included.
+type Config struct {
+	// Fixed when the database is created: see 'WithChunkSize', 'WithChunkEntries', and
+	// 'WithNetworkFilesystem'.
+	ChunkSize         uint32 `json:"chunk_size"`
+	ChunkEntries      uint32 `json:"chunk_entries,omitempty"`
+	NetworkFilesystem bool   `json:"network_filesystem,omitempty"`
+
+	// How the database is opened and written: see 'WithVerify', 'WithRecovery', 'WithWritePath',
+	// 'WithRelaxedSync', and 'WithDurability'.
+	Verify      VerifyLevel    `json:"verify"`
+	Recovery    RecoveryPolicy `json:"recovery,omitempty"`
+	WritePath   WritePath      `json:"write_path"`
+	RelaxedSync bool           `json:"relaxed_sync,omitempty"`
+	Durability  Durability     `json:"durability,omitempty"`
+
+	// Settings of the open database: see the setters of the same names.
+	Sync          int           `json:"sync"`
+	ForgetBatch   int           `json:"forget_batch,omitempty"`
+	SlowThreshold time.Duration `json:"slow_threshold,omitempty"`
+	Trash         time.Duration `json:"trash,omitempty"`
+	SoftRollback  time.Duration `json:"soft_rollback,omitempty"`
+	TruncateLimit uint64        `json:"truncate_limit,omitempty"`
+	MemoryLimit   uint64        `json:"memory_limit,omitempty"`
+
+	BackupRateLimit uint64                  `json:"backup_rate_limit,omitempty"`
+	LabelRetention  map[Label]time.Duration `json:"label_retention,omitempty"`
+
+	// The emergency retention policy, without its callback: see 'SetEmergencyRetention'.
+	EmergencyMinFreePercent float64 `json:"emergency_min_free_percent,omitempty"`
+	EmergencyKeepEntries    uint64  `json:"emergency_keep_entries,omitempty"`
+}
+
+// WithConfig sets everything in the configuration. As with other options, the fixed settings are only used if
+// the database is created, and are otherwise detected automatically. The emergency retention callback is kept,
+// if one has been set.
+func WithConfig(cfg Config) Option {
+	return func(o *options) {
+		o.chunkSize = cfg.ChunkSize
+		o.chunkEntries = cfg.ChunkEntries
+		o.nfs = cfg.NetworkFilesystem
+		o.verify = cfg.Verify
+		o.recovery = cfg.Recovery
+		o.writePath = cfg.WritePath
+		o.relaxedSync = cfg.RelaxedSync
+		o.durability = cfg.Durability
+		WithSync(cfg.Sync)(o)
+		WithForgetBatch(cfg.ForgetBatch)(o)
+		WithSlowThreshold(cfg.SlowThreshold)(o)
+		WithTrash(cfg.Trash)(o)
+		WithSoftRollback(cfg.SoftRollback)(o)
+		WithTruncateLimit(cfg.TruncateLimit)(o)
+		WithMemoryLimit(cfg.MemoryLimit)(o)
+		WithBackupRateLimit(cfg.BackupRateLimit)(o)
+		WithLabelRetention(cfg.LabelRetention)(o)
+		withSetting(func(db *LockFreeChunkDB) error {
+			db.emergency.MinFreePercent = cfg.EmergencyMinFreePercent
+			db.emergency.KeepEntries = cfg.EmergencyKeepEntries
+			return nil
+		})(o)
+	}
+}
+
+// Config is the thread-safe version of 'LockFreeChunkDB.Config'.
+func (db *ChunkDB) Config() Config {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.Config()
+}
+
+// Config gets the effective configuration of the database.
+func (db *LockFreeChunkDB) Config() Config {
+	return Config{
+		ChunkSize:         db.chunkSize,
+		ChunkEntries:      db.chunkEntries,
+		NetworkFilesystem: db.nfs,
+
+		Verify:      db.verify,
+		Recovery:    db.recovery,
+		WritePath:   db.writePath,
+		RelaxedSync: db.relaxedSync,
+		Durability:  db.durability,
+
+		Sync:          db.syncEvery,
+		ForgetBatch:   db.forgetBatch,
+		SlowThreshold: db.slowThreshold,
+		Trash:         db.trashGrace,
+		SoftRollback:  db.redoWindow,
+		TruncateLimit: db.truncateLimit,
+		MemoryLimit:   db.memoryLimit,
+
+		BackupRateLimit: db.backupRate,
+		LabelRetention:  db.labelRetention,
+
+		EmergencyMinFreePercent: db.emergency.MinFreePercent,
+		EmergencyKeepEntries:    db.emergency.KeepEntries,
+	}
+}
@@ -0,0 +1,37 @@
+package logdb
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestConfig_RoundTrip(t *testing.T) {
+	path := "test_db/config_round_trip"
+	_ = os.RemoveAll(path)
+
+	db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(chunkSize), WithChunkEntries(8), WithSync(-1), WithTrash(time.Minute))
+	assert.Nil(t, err, "expected no error in open")
+	cfg := db.Config()
+	assert.Equal(t, uint32(chunkSize), cfg.ChunkSize, "expected the chunk size")
+	assert.Equal(t, uint32(8), cfg.ChunkEntries, "expected the entries per chunk")
+	assert.Equal(t, VerifyFinalChunk, cfg.Verify, "expected the default verify level")
+	assert.Equal(t, -1, cfg.Sync, "expected the sync period")
+	assert.Equal(t, time.Minute, cfg.Trash, "expected the trash grace period")
+	assertClose(t, db)
+
+	bs, err := json.Marshal(cfg)
+	assert.Nil(t, err, "expected no error in marshal")
+	var loaded Config
+	assert.Nil(t, json.Unmarshal(bs, &loaded), "expected no error in unmarshal")
+	assert.Equal(t, cfg, loaded, "expected the config to survive serialisation")
+
+	_ = os.RemoveAll(path)
+	db, err = OpenWithOptions(path, WithCreate(true), WithConfig(loaded))
+	assert.Nil(t, err, "expected no error in open with config")
+	defer assertClose(t, db)
+	assert.Equal(t, cfg, db.Config(), "expected the same config")
+}
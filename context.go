@@ -0,0 +1,77 @@
+package logdb
+
+import (
+	"context"
+	"sync"
+)
+
+// GetContext implements the 'ContextDB' interface.
+func (db *ChunkDB) GetContext(ctx context.Context, id uint64) ([]byte, error) {
+	if err := lockContext(ctx, db.rwlock.RLocker()); err != nil {
+		return nil, err
+	}
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.Get(id)
+}
+
+// GetEntriesContext is like 'GetEntries', but returns the error of the context if it is done first.
+func (db *ChunkDB) GetEntriesContext(ctx context.Context, start, end uint64) ([][]byte, error) {
+	if err := lockContext(ctx, db.rwlock.RLocker()); err != nil {
+		return nil, err
+	}
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.GetEntries(start, end)
+}
+
+// SyncContext implements the 'ContextDB' interface. If the context is done while syncing, the sync carries on
+// in the background, and other operations which need the write lock wait for it to finish as usual.
+func (db *ChunkDB) SyncContext(ctx context.Context) error {
+	if err := lockContext(ctx, db.rwlock.RLocker()); err != nil {
+		return err
+	}
+
+	synced := make(chan error, 1)
+	go func() {
+		defer db.rwlock.RUnlock()
+		synced <- db.LockFreeChunkDB.Sync()
+	}()
+	select {
+	case err := <-synced:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+////////// HELPERS //////////
+
+// Take a lock, unless the context is done first, in which case the error of the context is returned and the
+// lock is not held.
+func lockContext(ctx context.Context, l sync.Locker) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		l.Lock()
+		return nil
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// The lock may still be taken, so release it once it is.
+		go func() {
+			<-locked
+			l.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
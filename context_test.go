@@ -0,0 +1,48 @@
+package logdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestContext_Done(t *testing.T) {
+	var db ContextDB = assertOpen(t, dbTypes["chunkdb"], true, "context_done", chunkSize).(*ChunkDB)
+	defer assertClose(t, db.(*ChunkDB))
+	vs := filldb(t, db, numEntries)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v, err := db.GetContext(ctx, 1)
+	assert.Nil(t, err, "expected no error in get")
+	assert.Equal(t, vs[0], v, "expected the entry")
+	assert.Nil(t, db.SyncContext(ctx), "expected no error in sync")
+
+	cancel()
+	_, err = db.AppendContext(ctx, []byte("cancelled"))
+	assert.Equal(t, context.Canceled, err, "expected appending to be cancelled")
+	assert.Equal(t, uint64(numEntries), db.NewestID(), "expected nothing to be appended")
+	_, err = db.GetContext(ctx, 1)
+	assert.Equal(t, context.Canceled, err, "expected getting to be cancelled")
+	assert.Equal(t, context.Canceled, db.SyncContext(ctx), "expected syncing to be cancelled")
+}
+
+func TestContext_Locked(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "context_locked", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	filldb(t, db, numEntries)
+
+	// Operations waiting for the lock give up when the context is done, and leave the lock usable.
+	db.rwlock.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := db.GetEntriesContext(ctx, 1, 10)
+	assert.Equal(t, context.DeadlineExceeded, err, "expected getting to give up")
+	_, err = db.AppendEntriesContext(ctx, [][]byte{[]byte("a"), []byte("b")})
+	assert.Equal(t, context.DeadlineExceeded, err, "expected appending to give up")
+	db.rwlock.Unlock()
+
+	assertAppend(t, db, []byte("unlocked"))
+	assert.Equal(t, uint64(numEntries+1), db.NewestID(), "expected only the later entry to be appended")
+}
@@ -0,0 +1,60 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// A DeadLetterDB is a database which entries can be appended to with a header, such as a 'ChunkDB', for use as
+// the dead-letter log of an 'Outbox'.
+type DeadLetterDB interface {
+	AppendWithHeader(header, entry []byte) (uint64, error)
+}
+
+// DeadLetter is where a dead-letter entry came from: what 'ParseDeadLetter' gets from its header.
+type DeadLetter struct {
+	// ID of the entry in the source database.
+	ID uint64
+
+	// Number of times the handler failed for the entry.
+	Attempts uint32
+
+	// The error from the handler the last time it failed. This is cut short, at a rune boundary, if it would not
+	// fit in the header.
+	Err string
+}
+
+// ParseDeadLetter gets where a dead-letter entry came from, given its header, as read with 'GetWithMeta'.
+//
+// Returns 'ErrNotDeadLetter' if the header is too short to be the header of a dead-letter entry.
+func ParseDeadLetter(header []byte) (DeadLetter, error) {
+	if len(header) < deadLetterSize {
+		return DeadLetter{}, ErrNotDeadLetter
+	}
+	return DeadLetter{
+		ID:       binary.LittleEndian.Uint64(header),
+		Attempts: binary.LittleEndian.Uint32(header[8:]),
+		Err:      string(header[deadLetterSize:]),
+	}, nil
+}
+
+////////// HELPERS //////////
+
+// The size of the fixed part of a dead-letter header: the ID and the number of attempts.
+const deadLetterSize = 12
+
+// Encode the header of a dead-letter entry: '[id uint64][attempts uint32][error message]'.
+func (d DeadLetter) header() []byte {
+	buf := make([]byte, 0, MaxHeaderSize)
+	buf = binary.LittleEndian.AppendUint64(buf, d.ID)
+	buf = binary.LittleEndian.AppendUint32(buf, d.Attempts)
+	msg := d.Err
+	if len(msg) > MaxHeaderSize-deadLetterSize {
+		end := MaxHeaderSize - deadLetterSize
+		for end > 0 && !utf8.RuneStart(msg[end]) {
+			end--
+		}
+		msg = msg[:end]
+	}
+	return append(buf, msg...)
+}
@@ -0,0 +1,100 @@
+package logdb
+
+// Downsample is the thread-safe version of 'LockFreeChunkDB.Downsample'.
+func (db *ChunkDB) Downsample(before uint64, keep func(id uint64, entry []byte) bool) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.Downsample(before, keep)
+}
+
+// Downsample thins out the entries older than 'before', rather than forgetting them outright, keeping those
+// which 'keep' returns true for, such as every Nth entry (see 'KeepEvery'). This keeps a coarse history of
+// old entries, in less space.
+//
+// The IDs of the entries which are kept do not change. The entries which are dropped still count towards the
+// range of IDs: 'Get' returns 'ErrDownsampled' for them, 'GetEntries' returns nil for them, and 'Scan' skips
+// over them. 'keep' is not called for entries which have already been dropped, so downsampling a range again
+// thins it out further. The entry passed to 'keep' is only valid for the duration of the call.
+//
+// Only whole chunks are downsampled, so entries in the chunk containing 'before', and in the active chunk, are
+// left alone until a later call. The chunks are rewritten as 'Compact' does, packing the entries which are kept
+// into fewer chunks, and this is atomic in the same way.
+//
+// Returns 'ErrClosed' if the handle is closed, a 'SyncError' value if pending deletions could not be performed,
+// and a 'WriteError' value if the new chunks could not be written.
+func (db *LockFreeChunkDB) Downsample(before uint64, keep func(id uint64, entry []byte) bool) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+
+	if db.pendingDeletes > 0 {
+		if err := db.sync(); err != nil {
+			return err
+		}
+		db.prune()
+	}
+
+	// Find the chunks which are entirely before 'before', and which entries in them to drop.
+	var n int
+	for n < len(db.chunks)-1 && db.chunks[n].next() <= before {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	from := db.oldest
+	dropped := make([]bool, db.chunks[n-1].next()-from)
+	var any bool
+	for _, c := range db.chunks[:n] {
+		for id := c.oldest; id < c.next(); id++ {
+			if id < from {
+				continue
+			}
+			_, start, end := c.find(id)
+			if start == end && c.sums[id-c.oldest] == droppedSum {
+				dropped[id-from] = true
+				continue
+			}
+			entry, err := c.slice(start, end)
+			if err != nil {
+				return err
+			}
+			if !keep(id, entry) {
+				dropped[id-from] = true
+				any = true
+			}
+		}
+	}
+	if !any {
+		return nil
+	}
+	return db.checked("downsample", db.dropEntries(n, func(id uint64) bool { return dropped[id-from] }, "downsampled"))
+}
+
+// KeepEvery is a 'Downsample' policy which keeps every Nth entry, by ID: those whose ID is a multiple of 'n'.
+func KeepEvery(n uint64) func(id uint64, entry []byte) bool {
+	return func(id uint64, _ []byte) bool {
+		return id%n == 0
+	}
+}
+
+////////// HELPERS //////////
+
+// Rewrite the first 'n' chunks, which must not include the active chunk, dropping the entries 'drop' returns
+// true for, and logging what was done. Assumes a write lock is held.
+func (db *LockFreeChunkDB) dropEntries(n int, drop func(id uint64) bool, done string) error {
+	old := db.chunks[:n]
+	fresh, err := db.compactChunks(old, db.packing(n, drop)[n-1], drop)
+	if err != nil {
+		return err
+	}
+	db.replaceChunks(old, fresh)
+	if db.logger != nil {
+		db.logger.Printf("logdb: %s %v chunks into %v", done, len(old), len(fresh))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestDownsample(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "downsample", chunkSize).(*ChunkDB)
+	vs := filldb(t, db, numEntries)
+	assertForget(t, db, 5)
+	chunks := len(db.chunks)
+
+	// Only whole chunks before the given ID are downsampled.
+	limit := db.chunks[db.findChunk(200)].oldest
+	assert.Nil(t, db.Downsample(200, KeepEvery(10)), "expected no error in downsample")
+	assert.True(t, len(db.chunks) < chunks, "expected fewer chunks, got %v of %v", len(db.chunks), chunks)
+	assert.Equal(t, uint64(5), db.OldestID(), "expected the oldest entry not to change")
+	assert.Equal(t, uint64(numEntries), db.NewestID(), "expected the newest entry not to change")
+
+	assertDownsampled := func(every uint64) {
+		for id := uint64(5); id <= numEntries; id++ {
+			entry, err := db.Get(id)
+			if id >= limit || id%every == 0 {
+				assert.Nil(t, err, "expected no error in get %v", id)
+				assert.Equal(t, vs[id-1], entry, "expected the entry %v to be kept", id)
+			} else {
+				assert.Equal(t, ErrDownsampled, err, "expected the entry %v to be dropped", id)
+			}
+		}
+
+		entries, err := db.GetEntries(5, numEntries)
+		assert.Nil(t, err, "expected no error in get entries")
+		assert.Equal(t, []byte(nil), entries[0], "expected a dropped entry to be nil")
+		assert.Equal(t, vs[every-1], entries[every-5], "expected a kept entry")
+
+		it, err := db.Scan(5, numEntries)
+		assert.Nil(t, err, "expected no error in scan")
+		var ids []uint64
+		for it.Next() {
+			ids = append(ids, it.ID())
+		}
+		assert.Nil(t, it.Err(), "expected no error in scan")
+		assert.Equal(t, every, ids[0], "expected the scan to skip dropped entries")
+		assert.Equal(t, limit, ids[len(ids)-int(numEntries-limit)-1], "expected the scan to include every later entry")
+	}
+	assertDownsampled(10)
+	assert.Nil(t, db.VerifyIntegrity(), "expected dropped entries not to be corruption")
+
+	// Downsampling again thins the entries out further, and it persists.
+	assert.Nil(t, db.Downsample(200, KeepEvery(20)), "expected no error in second downsample")
+	assertDownsampled(20)
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["chunkdb"], false, "downsample", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assertDownsampled(20)
+}
@@ -0,0 +1,104 @@
+package logdb
+
+// EmergencyRetention is a policy for when the volume the database is on is nearly full: rather than running
+// out of space, the oldest entries are forgotten. This suits unattended systems which would rather lose their
+// oldest data than stop.
+type EmergencyRetention struct {
+	// When less than this percentage of the volume is free, whole chunks are forgotten, oldest first, until it
+	// is not. 0 disables emergency retention.
+	MinFreePercent float64
+
+	// The floor: this many of the newest entries are never forgotten, however little space is free.
+	KeepEntries uint64
+
+	// Called after entries have been forgotten, if not nil. Like the 'ChunkHooks', this is called with any
+	// database locks held, so it must not use the database.
+	Forgotten func(EmergencyEvent)
+}
+
+// EmergencyEvent describes entries forgotten by the 'EmergencyRetention' policy.
+type EmergencyEvent struct {
+	// The percentage of the volume which was free before, and after, forgetting.
+	FreePercentBefore float64
+	FreePercentAfter  float64
+
+	// The oldest entry ID before, and after, forgetting.
+	OldestID    uint64
+	NewOldestID uint64
+}
+
+// SetEmergencyRetention is the thread-safe version of 'LockFreeChunkDB.SetEmergencyRetention'.
+func (db *ChunkDB) SetEmergencyRetention(policy EmergencyRetention) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetEmergencyRetention(policy)
+}
+
+// SetEmergencyRetention configures the database to forget its oldest entries when the volume it is on is
+// nearly full. Free space is checked whenever a new chunk is about to be created, and chunks are forgotten and
+// deleted immediately, ignoring any commit point or truncation limit, and 'SetForgetBatch'. Files moved to the
+// trash (see 'SetTrash') still use space until the grace period is over.
+//
+// Each time entries are forgotten, the policy's callback is called and, if a logger is set, a message logged.
+// If the free space cannot be determined, nothing is forgotten.
+func (db *LockFreeChunkDB) SetEmergencyRetention(policy EmergencyRetention) {
+	db.emergency = policy
+}
+
+////////// HELPERS //////////
+
+// Get the percentage of the volume containing a path which is free. This is a variable so that tests can
+// simulate a full disk.
+var freePercent = volumeFreePercent
+
+// Forget the oldest chunks, down to the floor, until enough space is free. Entries newer than 'newest', such as
+// those being appended by the current 'AppendEntries', are never forgotten. Assumes a write lock is held.
+func (db *LockFreeChunkDB) emergencyForget() error {
+	if db.emergency.MinFreePercent <= 0 || len(db.chunks) == 0 {
+		return nil
+	}
+	before, err := freePercent(db.path)
+	if err != nil || before >= db.emergency.MinFreePercent {
+		return nil
+	}
+
+	oldest := db.oldest
+	free := before
+	for free < db.emergency.MinFreePercent {
+		var next uint64
+		for _, c := range db.chunks[:len(db.chunks)-1] {
+			if !c.delete {
+				next = c.next()
+				break
+			}
+		}
+		if next == 0 || next > db.newest || db.newest-next+1 < db.emergency.KeepEntries {
+			break
+		}
+		if err := db.forget(next); err != nil {
+			return err
+		}
+		if db.pendingDeletes > 0 {
+			if err := db.sync(); err != nil {
+				return err
+			}
+			db.prune()
+		}
+		if free, err = freePercent(db.path); err != nil {
+			break
+		}
+	}
+	if db.oldest == oldest {
+		return nil
+	}
+
+	ev := EmergencyEvent{FreePercentBefore: before, FreePercentAfter: free, OldestID: oldest, NewOldestID: db.oldest}
+	if db.logger != nil {
+		db.logger.Printf("logdb: %.1f%% free, forgot entries [%v:%v]", before, oldest, db.oldest-1)
+	}
+	if db.emergency.Forgotten != nil {
+		db.emergency.Forgotten(ev)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package logdb
+
+import "syscall"
+
+// Get the percentage of the volume containing a path which is free for unprivileged use.
+func volumeFreePercent(path string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	if st.F_blocks == 0 {
+		return 100, nil
+	}
+	return 100 * float64(st.F_bavail) / float64(st.F_blocks), nil
+}
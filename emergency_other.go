@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !openbsd
+// +build !linux,!darwin,!freebsd,!dragonfly,!openbsd
+
+package logdb
+
+import "errors"
+
+// Get the percentage of the volume containing a path which is free for unprivileged use. The free space cannot
+// be read on this platform, so emergency retention is skipped.
+func volumeFreePercent(path string) (float64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
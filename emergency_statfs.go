@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package logdb
+
+import "syscall"
+
+// Get the percentage of the volume containing a path which is free for unprivileged use.
+func volumeFreePercent(path string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	if st.Blocks == 0 {
+		return 100, nil
+	}
+	return 100 * float64(st.Bavail) / float64(st.Blocks), nil
+}
@@ -0,0 +1,48 @@
+package logdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestEmergencyRetention(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "emergency_retention", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	// Pretend that each chunk uses 1% of the volume, which starts out 80% free.
+	freePercent = func(string) (float64, error) {
+		return 80 - float64(len(db.chunks)), nil
+	}
+	defer func() { freePercent = volumeFreePercent }()
+
+	var events []EmergencyEvent
+	db.SetEmergencyRetention(EmergencyRetention{
+		MinFreePercent: 75,
+		KeepEntries:    50,
+		Forgotten:      func(ev EmergencyEvent) { events = append(events, ev) },
+	})
+
+	vs := make([][]byte, numEntries)
+	for i := range vs {
+		vs[i] = []byte(fmt.Sprintf("entry-%v", i))
+		assertAppend(t, db, vs[i])
+	}
+	assert.True(t, len(events) > 0, "expected entries to be forgotten")
+	assert.True(t, len(db.chunks) <= 6, "expected at most 5 full chunks, got %v", len(db.chunks))
+	assert.True(t, db.NewestID()-db.OldestID()+1 >= 50, "expected the floor to be kept")
+	for id := db.OldestID(); id <= db.NewestID(); id++ {
+		assert.Equal(t, vs[id-1], assertGet(t, db, id), "expected equal values")
+	}
+	last := events[len(events)-1]
+	assert.Equal(t, db.OldestID(), last.NewOldestID, "expected the event to give the oldest ID")
+
+	// With a floor of every entry, nothing more can be forgotten.
+	db.SetEmergencyRetention(EmergencyRetention{MinFreePercent: 75, KeepEntries: numEntries * 2})
+	oldest := db.OldestID()
+	for _, v := range vs {
+		assertAppend(t, db, v)
+	}
+	assert.Equal(t, oldest, db.OldestID(), "expected nothing to be forgotten below the floor")
+}
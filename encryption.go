@@ -0,0 +1,140 @@
+package logdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrUnknownKey means that an entry could not be decrypted as it was encrypted with a key which was not given to
+// 'EncryptAES'.
+var ErrUnknownKey = errors.New("entry encrypted with an unknown key")
+
+// EncryptAES creates a 'CompressingDB' which encrypts entries with AES-GCM, so that they are encrypted at rest.
+// The key must be 16, 24, or 32 bytes long, for AES-128, AES-192, or AES-256. It can be combined with a coder,
+// such as 'GobCoder', and with compression, which must come first as encrypted entries do not compress.
+//
+// Every entry is encrypted with a random nonce, which is stored with it, along with an identifier of the key
+// (part of its SHA-256 hash), so that the key an entry was encrypted with can be found. Entries are only ever
+// encrypted with the first key, but can be decrypted with any of the keys given: so to rotate keys, make the new
+// key the first and keep the old ones after it until 'ReencryptRange' has copied every entry encrypted with them.
+// As nonces are random, a key should not be used for more than a few billion entries.
+//
+// Encryption authenticates the contents of an entry, but not its ID, so entries could be reordered by anyone
+// able to write to the database files.
+//
+// With 'BoundedDB' the bounded entry size is that of the encrypted entry, which is 32 bytes longer.
+//
+// Returns an error if any key is not a valid AES key.
+func EncryptAES(logdb LogDB, key []byte, oldKeys ...[]byte) (*CompressingDB, error) {
+	var current aesKey
+	keys := make(map[[aesKeyIDSize]byte]cipher.AEAD)
+	for i, k := range append([][]byte{key}, oldKeys...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(k)
+		var id [aesKeyIDSize]byte
+		copy(id[:], sum[:])
+		if i == 0 {
+			current = aesKey{id: id, gcm: gcm}
+		}
+		if _, ok := keys[id]; !ok {
+			keys[id] = gcm
+		}
+	}
+
+	return &CompressingDB{
+		LogDB:      logdb,
+		Compress:   current.encrypt,
+		Decompress: func(bs []byte) ([]byte, error) { return decryptAES(keys, bs) },
+	}, nil
+}
+
+// ReencryptRange copies the entries from 'start' to 'end', inclusive, from one database to another, returning
+// the ID of the first entry copied. With databases from 'EncryptAES', this re-encrypts the entries with the
+// first key of the destination: as entries cannot be changed once appended, this is how keys are rotated, by
+// copying the log to a new database.
+//
+// Entries are copied in batches, each appended with 'AppendEntries', so if copying fails part way through, the
+// destination holds some initial portion of the entries.
+//
+// Returns 'ErrIDOutOfRange' if 'end' is older than 'start', and the same errors as 'Get' and 'AppendEntries'.
+func ReencryptRange(dst, src LogDB, start, end uint64) (uint64, error) {
+	if end < start {
+		return 0, ErrIDOutOfRange
+	}
+
+	var first uint64
+	batch := make([][]byte, 0, reencryptBatch)
+	for id := start; ; id++ {
+		entry, err := src.Get(id)
+		if err != nil {
+			return 0, err
+		}
+		batch = append(batch, entry)
+		if len(batch) == reencryptBatch || id == end {
+			idx, err := dst.AppendEntries(batch)
+			if err != nil {
+				return 0, err
+			}
+			if first == 0 {
+				first = idx
+			}
+			batch = batch[:0]
+		}
+		if id == end {
+			return first, nil
+		}
+	}
+}
+
+////////// HELPERS //////////
+
+// Length of the identifier of the key an entry was encrypted with, stored at the start of the entry.
+const aesKeyIDSize = 4
+
+// Number of entries 'ReencryptRange' appends at once.
+const reencryptBatch = 256
+
+// A key for 'EncryptAES' and its identifier.
+type aesKey struct {
+	id  [aesKeyIDSize]byte
+	gcm cipher.AEAD
+}
+
+// Encrypt an entry, in the format [key id][nonce][ciphertext and tag].
+func (k aesKey) encrypt(bs []byte) ([]byte, error) {
+	nonceSize := k.gcm.NonceSize()
+	out := make([]byte, aesKeyIDSize+nonceSize, aesKeyIDSize+nonceSize+len(bs)+k.gcm.Overhead())
+	copy(out, k.id[:])
+	if _, err := rand.Read(out[aesKeyIDSize:]); err != nil {
+		return nil, err
+	}
+	return k.gcm.Seal(out, out[aesKeyIDSize:], bs, nil), nil
+}
+
+// Decrypt an entry encrypted with any of the keys.
+func decryptAES(keys map[[aesKeyIDSize]byte]cipher.AEAD, bs []byte) ([]byte, error) {
+	if len(bs) < aesKeyIDSize {
+		return nil, errors.New("entry too short to be encrypted")
+	}
+	var id [aesKeyIDSize]byte
+	copy(id[:], bs)
+	gcm, ok := keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	bs = bs[aesKeyIDSize:]
+	if len(bs) < gcm.NonceSize() {
+		return nil, errors.New("entry too short to be encrypted")
+	}
+	return gcm.Open(nil, bs[:gcm.NonceSize()], bs[gcm.NonceSize():], nil)
+}
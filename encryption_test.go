@@ -0,0 +1,76 @@
+package logdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+var (
+	aesKey1 = bytes.Repeat([]byte{1}, 32)
+	aesKey2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestEncryptAES(t *testing.T) {
+	inmem := &InMemDB{}
+	db, err := EncryptAES(inmem, aesKey1)
+	assert.Nil(t, err, "expected no error in creating encrypting database")
+
+	bss := make([][]byte, 255)
+	for i := 0; i < len(bss); i++ {
+		bss[i] = []byte(fmt.Sprintf("entry %v", i))
+	}
+	_, err = db.AppendEntries(bss)
+	assert.Nil(t, err, "expected no error in append")
+
+	for i, bs := range bss {
+		v, err := db.Get(uint64(i + 1))
+		assert.Nil(t, err, "expected no error in get")
+		assert.Equal(t, bs, v, "expected equal '[]byte' values")
+
+		stored, _ := inmem.Get(uint64(i + 1))
+		assert.Equal(t, len(bs)+32, len(stored), "expected 32 bytes of overhead")
+		assert.False(t, bytes.Contains(stored, bs), "expected entry to be encrypted")
+	}
+
+	// Tampering with an entry is detected.
+	stored, _ := inmem.Get(1)
+	stored[len(stored)-1] ^= 1
+	_, err = db.Get(1)
+	assert.NotNil(t, err, "expected error in get of tampered entry")
+
+	_, err = EncryptAES(inmem, []byte("short"))
+	assert.NotNil(t, err, "expected error in creating encrypting database with an invalid key")
+}
+
+func TestEncryptAES_Rotation(t *testing.T) {
+	old := &InMemDB{}
+	oldDB, _ := EncryptAES(old, aesKey1)
+	vs := filldb(t, oldDB, numEntries)
+
+	// Without the old key, its entries cannot be read.
+	newOnly, _ := EncryptAES(old, aesKey2)
+	_, err := newOnly.Get(1)
+	assert.Equal(t, ErrUnknownKey, err, "expected entry encrypted with an old key to be unreadable")
+
+	// Re-encrypt into a new database with the new key.
+	rotating, err := EncryptAES(old, aesKey2, aesKey1)
+	assert.Nil(t, err, "expected no error in creating encrypting database")
+	fresh := &InMemDB{}
+	freshDB, _ := EncryptAES(fresh, aesKey2)
+	idx, err := ReencryptRange(freshDB, rotating, 1, numEntries)
+	assert.Nil(t, err, "expected no error in re-encryption")
+	assert.Equal(t, uint64(1), idx, "expected first ID")
+	assert.Equal(t, uint64(numEntries), fresh.NewestID(), "expected every entry to be copied")
+
+	for i, v := range vs {
+		got, err := freshDB.Get(uint64(i + 1))
+		assert.Nil(t, err, "expected no error in get")
+		assert.Equal(t, v, got, "expected equal '[]byte' values")
+	}
+
+	_, err = ReencryptRange(freshDB, rotating, 10, 9)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected empty range to be refused")
+}
@@ -12,6 +12,15 @@ var (
 	// ErrUnknownVersion means that the disk format version of an opened database is unknown.
 	ErrUnknownVersion = errors.New("unknown disk format version")
 
+	// ErrNeedsMigration means that the disk format version of a database opened for writing is older than this
+	// version of the library writes, and it must be upgraded with 'Migrate' first. It can still be read with
+	// 'OpenReader' without being upgraded.
+	ErrNeedsMigration = errors.New("database needs migrating to the current disk format version")
+
+	// ErrMigrationIncomplete means that a database cannot be opened as a 'Migrate' was interrupted. Calling
+	// 'Migrate' again completes it.
+	ErrMigrationIncomplete = errors.New("database migration incomplete")
+
 	// ErrNotDirectory means that the path given to 'Open' exists and is not a directory.
 	ErrNotDirectory = errors.New("database path not a directory")
 
@@ -27,42 +36,119 @@ var (
 
 	// ErrEmptyNonfinalChunk means that the metadata for a non-final chunk has zero entries.
 	ErrEmptyNonfinalChunk = errors.New("metadata of non-final chunk contains no entries")
+
+	// ErrChunkMissing means that the files of the active chunk have disappeared from disk while the
+	// database was open. No further changes can be made until the database is reopened.
+	ErrChunkMissing = errors.New("active chunk files missing, database must be reopened")
+
+	// ErrBelowCommitPoint means that a 'Rollback' or 'Truncate' would remove entries at or before the commit
+	// point, and so must be forced.
+	ErrBelowCommitPoint = errors.New("rollback below commit point")
+
+	// ErrNeedsConfirmation means that a 'Forget', 'Rollback', or 'Truncate' would remove more entries than the
+	// truncation limit, and so must be done with 'PrepareTruncate' and 'ConfirmTruncate'.
+	ErrNeedsConfirmation = errors.New("truncation needs confirmation")
+
+	// ErrBadToken means that the token given to 'ConfirmTruncate' is not that of the truncation awaiting
+	// confirmation, or that the database has changed since it was prepared.
+	ErrBadToken = errors.New("truncation token invalid or stale")
+
+	// ErrNotPermitted means that an operation is not permitted through a restricted view of a database.
+	ErrNotPermitted = errors.New("operation not permitted by this view")
+
+	// ErrNoSpace means that a new chunk could not be created as the disk is full. It is wrapped in a
+	// 'WriteError' value.
+	ErrNoSpace = errors.New("no space left on device")
+
+	// ErrChunkSizeTooBig means that the chunk size is larger than this platform supports. On 32-bit platforms,
+	// this is 256MiB, as chunks are mapped into the limited address space.
+	ErrChunkSizeTooBig = errors.New("chunk size too big for this platform")
+
+	// ErrChecksumMismatch means that an entry read from disk does not match the checksum recorded when it was
+	// written, so the chunk data file has been corrupted.
+	ErrChecksumMismatch = errors.New("entry checksum mismatch")
+
+	// ErrDownsampled means that an entry was dropped by 'Downsample'. Its ID is still in range, as the IDs of
+	// the entries around it do not change.
+	ErrDownsampled = errors.New("entry dropped by downsampling")
+
+	// ErrBackupInterrupted means that a 'Backup' failed as the database was rolled back while every attempt was
+	// copying it.
+	ErrBackupInterrupted = errors.New("database rolled back during backup")
+
+	// ErrNotReconfigurable means that 'Reconfigure' was given an option which cannot be changed once the
+	// database has been created.
+	ErrNotReconfigurable = errors.New("option cannot be changed on an open database")
+
+	// ErrFanoutDiverged means that a destination of a 'FanoutDB' does not have the same entries as the
+	// others.
+	ErrFanoutDiverged = errors.New("fanout destination diverged")
+
+	// ErrHeaderTooBig means that an entry header is larger than 'MaxHeaderSize'.
+	ErrHeaderTooBig = errors.New("entry header larger than maximum header size")
+
+	// ErrHeaderCount means that the number of entry headers given does not match the number of entries.
+	ErrHeaderCount = errors.New("number of entry headers does not match number of entries")
+
+	// ErrLockLost means that a lock file was taken over by another owner while held, such as after this
+	// process was paused for longer than the lock takes to go stale.
+	ErrLockLost = errors.New("lock file taken over by another owner")
+
+	// ErrOutboxRolledBack means that entries which had already been delivered by an 'Outbox' were rolled back
+	// in the source.
+	ErrOutboxRolledBack = errors.New("delivered entries rolled back in source")
+
+	// ErrNoCursor means that an 'Outbox' was started without a cursor file path.
+	ErrNoCursor = errors.New("outbox cursor path not given")
+
+	// ErrNotDeadLetter means that an entry header is not the header of a dead-letter entry.
+	ErrNotDeadLetter = errors.New("not a dead-letter header")
+
+	// ErrSQLReadOnly means that a statement other than a query, or a transaction, was given to a table from
+	// 'SQLConnector'.
+	ErrSQLReadOnly = errors.New("SQL table is read-only")
 )
 
 // ReadError means that a read failed. It wraps the actual error.
 type ReadError struct{ Err error }
 
 func (e *ReadError) Error() string          { return e.Err.Error() }
+func (e *ReadError) Unwrap() error          { return e.Err }
 func (e *ReadError) WrappedErrors() []error { return []error{e.Err} }
 
 // WriteError means that a write failed. It wraps the actual error.
 type WriteError struct{ Err error }
 
 func (e *WriteError) Error() string          { return e.Err.Error() }
+func (e *WriteError) Unwrap() error          { return e.Err }
 func (e *WriteError) WrappedErrors() []error { return []error{e.Err} }
 
 // PathError means that a directory could not be created. It wraps the actual error.
 type PathError struct{ Err error }
 
 func (e *PathError) Error() string          { return e.Err.Error() }
+func (e *PathError) Unwrap() error          { return e.Err }
 func (e *PathError) WrappedErrors() []error { return []error{e.Err} }
 
 // SyncError means that a file could not be synced to disk. It wraps the actual error.
 type SyncError struct{ Err error }
 
 func (e *SyncError) Error() string          { return e.Err.Error() }
+func (e *SyncError) Unwrap() error          { return e.Err }
 func (e *SyncError) WrappedErrors() []error { return []error{e.Err} }
 
 // DeleteError means that a file could not be deleted from disk. It wraps the actual error.
 type DeleteError struct{ Err error }
 
 func (e *DeleteError) Error() string          { return e.Err.Error() }
+func (e *DeleteError) Unwrap() error          { return e.Err }
 func (e *DeleteError) WrappedErrors() []error { return []error{e.Err} }
 
 // LockError means that the database files could not be locked. It wraps the actual error.
 type LockError struct{ Err error }
 
 func (e *LockError) Error() string          { return e.Err.Error() }
+func (e *LockError) Unwrap() error          { return e.Err }
 func (e *LockError) WrappedErrors() []error { return []error{e.Err} }
 
 // AtomicityError means that an error occurred while appending an entry in an 'AppendEntries' call, and
@@ -80,6 +166,10 @@ func (e *AtomicityError) WrappedErrors() []error {
 	return []error{e.AppendErr, e.RollbackErr}
 }
 
+func (e *AtomicityError) Unwrap() []error {
+	return e.WrappedErrors()
+}
+
 // FormatError means that there is a problem with the database files. It wraps the actual error.
 type FormatError struct {
 	FilePath string
@@ -94,6 +184,10 @@ func (e *FormatError) WrappedErrors() []error {
 	return []error{e.Err}
 }
 
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
 // ChunkFileNameError means that a filename is not valid for a chunk file.
 type ChunkFileNameError struct {
 	FilePath string
@@ -114,6 +208,17 @@ func (e *ChunkSizeError) Error() string {
 	return fmt.Sprintf("in chunk %s: incorrect chunk file size (expected %v, got %v)", e.ChunkFilePath, e.Expected, e.Actual)
 }
 
+// ChunkEntriesError means that a chunk has more entries than a chunk in the database may hold.
+type ChunkEntriesError struct {
+	ChunkFilePath string
+	Limit         uint32
+	Actual        uint32
+}
+
+func (e *ChunkEntriesError) Error() string {
+	return fmt.Sprintf("in chunk %s: too many entries (limit %v, got %v)", e.ChunkFilePath, e.Limit, e.Actual)
+}
+
 // ChunkContinuityError means that two adjacent chunks do not contain a contiguous sequence of entries.
 type ChunkContinuityError struct {
 	ChunkFilePath string
@@ -139,6 +244,10 @@ func (e *ChunkMetaError) WrappedErrors() []error {
 	return []error{e.Err}
 }
 
+func (e *ChunkMetaError) Unwrap() error {
+	return e.Err
+}
+
 // MetaContinuityError means that the metadata for a chunk does not contain a contiguous sequence of entries.
 type MetaContinuityError struct {
 	Expected int32
@@ -159,3 +268,160 @@ type MetaOffsetError struct {
 func (e *MetaOffsetError) Error() string {
 	return fmt.Sprintf("entry offsets not monotonically increasing (expected >=%v, got %v)", e.Expected, e.Actual)
 }
+
+// EncodeError means that a 'CodingDB' could not encode a value. It wraps the actual error.
+//
+// As the value was never appended it has no entry ID, so instead the error carries the index of the value
+// in the slice given to 'AppendValues' (this is always 0 for 'AppendValue').
+type EncodeError struct {
+	Index int
+	Err   error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("error encoding value %v: %s", e.Index, e.Err.Error())
+}
+
+func (e *EncodeError) WrappedErrors() []error {
+	return []error{e.Err}
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError means that a 'CodingDB' could not decode an entry. It wraps the actual error.
+//
+// Errors from the underlying 'LogDB' (such as 'ErrIDOutOfRange') are returned unchanged, so a 'DecodeError'
+// always means that the entry was read. If it wraps 'ErrWrongDestination', the destination is of the wrong type
+// for the entry; otherwise the entry is corrupt.
+type DecodeError struct {
+	ID  uint64
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding entry %v: %s", e.ID, e.Err.Error())
+}
+
+func (e *DecodeError) WrappedErrors() []error {
+	return []error{e.Err}
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyError means that 'Verify' found problems with the database. It wraps all of the problems found.
+type VerifyError struct {
+	Errs []error
+}
+
+func (e *VerifyError) Error() string {
+	msg := fmt.Sprintf("verification found %v problem(s)", len(e.Errs))
+	for _, err := range e.Errs {
+		msg += "; " + err.Error()
+	}
+	return msg
+}
+
+func (e *VerifyError) WrappedErrors() []error {
+	return e.Errs
+}
+
+func (e *VerifyError) Unwrap() []error {
+	return e.WrappedErrors()
+}
+
+// MetaDivergenceError means that the metadata file for a chunk disagrees with the in-memory offset of a synced
+// entry.
+type MetaDivergenceError struct {
+	ChunkFilePath string
+	Index         int32
+	Expected      int32
+	Actual        int32
+}
+
+func (e *MetaDivergenceError) Error() string {
+	return fmt.Sprintf("in chunk %s: metadata for entry index %v diverges (expected end %v, got %v)", e.ChunkFilePath, e.Index, e.Expected, e.Actual)
+}
+
+// OldestDivergenceError means that the "oldest" file refers to an entry newer than the oldest entry in the
+// database.
+type OldestDivergenceError struct {
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *OldestDivergenceError) Error() string {
+	return fmt.Sprintf("oldest file diverges (expected <=%v, got %v)", e.Expected, e.Actual)
+}
+
+// ChunkModifiedError means that the files of a sealed chunk have been modified since it was sealed.
+type ChunkModifiedError struct {
+	ChunkFilePath string
+}
+
+func (e *ChunkModifiedError) Error() string {
+	return fmt.Sprintf("in chunk %s: files modified after the chunk was sealed", e.ChunkFilePath)
+}
+
+// ChecksumError means that 'VerifyIntegrity' found an entry which does not match its checksum. It wraps
+// 'ErrChecksumMismatch'.
+type ChecksumError struct {
+	ChunkFilePath string
+	ID            uint64
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("in chunk %s: checksum mismatch for entry %v", e.ChunkFilePath, e.ID)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// FanoutError means that a 'FanoutDB' operation did not succeed on enough destinations. It wraps the error of
+// each destination which failed.
+type FanoutError struct {
+	Succeeded int
+	Required  int
+
+	// The error of each destination, in order, which is nil if it succeeded.
+	Errs []error
+}
+
+func (e *FanoutError) Error() string {
+	msg := fmt.Sprintf("succeeded on %v destination(s), needed %v", e.Succeeded, e.Required)
+	for i, err := range e.Errs {
+		if err != nil {
+			msg += fmt.Sprintf("; destination %v: %s", i, err.Error())
+		}
+	}
+	return msg
+}
+
+func (e *FanoutError) WrappedErrors() []error {
+	var errs []error
+	for _, err := range e.Errs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (e *FanoutError) Unwrap() []error {
+	return e.WrappedErrors()
+}
+
+// SQLError means that a query could not be run against a table from 'SQLConnector', as it is not in the SQL
+// the table understands, or an argument is of the wrong type.
+type SQLError struct {
+	Query string
+	Msg   string
+}
+
+func (e *SQLError) Error() string {
+	return fmt.Sprintf("in query %q: %s", e.Query, e.Msg)
+}
@@ -0,0 +1,53 @@
+package logdb
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// ExportCSV writes the entries of any 'LogDB' from 'start' to 'end', inclusive, to 'w' as CSV, one record per
+// entry, for loading into analytics tools. The database does not know what is in its entries, so 'fields'
+// extracts the columns of each one, such as by decoding it. If 'fields' returns a nil record, the entry is
+// left out. If 'header' is not nil, it is written as the first record. The entry passed to 'fields' is only
+// valid for the duration of the call.
+//
+// Entries are read with 'Scan', so entries which have been dropped by 'Downsample' are left out too. Columnar
+// formats such as Parquet need a library this package does not depend on, but 'Scan' and an extractor like
+// 'fields' are all an exporter for one needs.
+//
+// Returns the number of records written, not counting the header, and any error from 'Scan', 'fields', or 'w'.
+func ExportCSV(w io.Writer, db LogDB, start, end uint64, header []string, fields func(id uint64, entry []byte) ([]string, error)) (uint64, error) {
+	cw := csv.NewWriter(w)
+	if header != nil {
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+	}
+
+	it, err := Scan(db, start, end)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+
+	var n uint64
+	for it.Next() {
+		record, err := fields(it.ID(), it.Entry())
+		if err != nil {
+			return n, err
+		}
+		if record == nil {
+			continue
+		}
+		if err := cw.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		return n, err
+	}
+
+	cw.Flush()
+	return n, cw.Error()
+}
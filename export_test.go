@@ -0,0 +1,36 @@
+package logdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestExportCSV(t *testing.T) {
+	for dbName, dbType := range dbTypes {
+		t.Logf("Database: %s\n", dbName)
+		func() {
+			db := assertOpen(t, dbType, true, "export_csv", chunkSize)
+			defer assertClose(t, db)
+
+			filldb(t, db, numEntries)
+			var buf bytes.Buffer
+			n, err := ExportCSV(&buf, db, 8, 12, []string{"id", "entry"}, func(id uint64, entry []byte) ([]string, error) {
+				if id == 10 {
+					return nil, nil
+				}
+				return []string{fmt.Sprint(id), "\"" + string(entry) + "\""}, nil
+			})
+			assert.Nil(t, err, "expected no error in export")
+			assert.Equal(t, uint64(4), n, "expected a record per entry not left out")
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			assert.Equal(t, []string{"id,entry", `8,"""entry-7"""`, `9,"""entry-8"""`, `11,"""entry-10"""`, `12,"""entry-11"""`}, lines, "expected quoted CSV records")
+
+			_, err = ExportCSV(&buf, db, 0, 12, nil, nil)
+			assert.Equal(t, ErrIDOutOfRange, err, "expected the range to be checked")
+		}()
+	}
+}
@@ -0,0 +1,28 @@
+package logdb
+
+// Failpoints are places in the write path, in locking, and in mapping chunks, where a failure can be injected
+// with 'EnableFailpoint', to test how the database behaves if the process is interrupted there. They are only
+// compiled in with the "failpoints" build tag: otherwise they cost nothing, and only their names are available.
+const (
+	// FailpointBeforeDataSync is reached when a chunk is synced, before its data file is flushed.
+	FailpointBeforeDataSync = "before data sync"
+
+	// FailpointBeforeMetaWrite is reached when a chunk is synced, after its data file has been flushed, but
+	// before its metadata file is written.
+	FailpointBeforeMetaWrite = "before meta write"
+
+	// FailpointAfterMetaWrite is reached when a chunk is synced, after its metadata file has been written.
+	FailpointAfterMetaWrite = "after meta write"
+
+	// FailpointChunkRollover is reached when a new chunk is started, after the previous chunk has been synced
+	// and sealed, but before the files of the new chunk are created.
+	FailpointChunkRollover = "chunk rollover"
+
+	// FailpointMmap is reached when a chunk data file is about to be memory-mapped. An action returning
+	// 'syscall.ENOMEM' simulates running out of address space, so that the chunk is read without mmap.
+	FailpointMmap = "mmap"
+
+	// FailpointLockTakeover is reached when a stale lock file is taken over in network filesystem mode, after
+	// it has been found stale, but before the takeover lock is taken.
+	FailpointLockTakeover = "lock takeover"
+)
@@ -0,0 +1,80 @@
+//go:build failpoints
+// +build failpoints
+
+package logdb
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrFailpoint is the error injected by the helpers 'FailAlways', 'FailOnce', and 'FailOnNth'.
+var ErrFailpoint = errors.New("failpoint triggered")
+
+// EnableFailpoint sets the action to run when a failpoint is reached, replacing any already set. If the action
+// returns an error, the operation fails there with that error, as if the filesystem had failed.
+func EnableFailpoint(name string, action func() error) {
+	failpointsMutex.Lock()
+	defer failpointsMutex.Unlock()
+
+	failpoints[name] = action
+}
+
+// DisableFailpoint removes the action of a failpoint.
+func DisableFailpoint(name string) {
+	failpointsMutex.Lock()
+	defer failpointsMutex.Unlock()
+
+	delete(failpoints, name)
+}
+
+// DisableAllFailpoints removes the actions of every failpoint.
+func DisableAllFailpoints() {
+	failpointsMutex.Lock()
+	defer failpointsMutex.Unlock()
+
+	failpoints = make(map[string]func() error)
+}
+
+// FailAlways is an action which fails every time the failpoint is reached.
+func FailAlways() func() error {
+	return func() error { return ErrFailpoint }
+}
+
+// FailOnce is an action which fails the first time the failpoint is reached, and then does nothing.
+func FailOnce() func() error {
+	return FailOnNth(1)
+}
+
+// FailOnNth is an action which fails the nth time the failpoint is reached (counting from 1), and does nothing
+// the other times.
+func FailOnNth(n int) func() error {
+	var reached int
+	return func() error {
+		reached++
+		if reached == n {
+			return ErrFailpoint
+		}
+		return nil
+	}
+}
+
+////////// HELPERS //////////
+
+// The actions of the enabled failpoints.
+var (
+	failpointsMutex sync.Mutex
+	failpoints      = make(map[string]func() error)
+)
+
+// Run the action of a failpoint, if it is enabled.
+func failpoint(name string) error {
+	failpointsMutex.Lock()
+	action := failpoints[name]
+	failpointsMutex.Unlock()
+
+	if action == nil {
+		return nil
+	}
+	return action()
+}
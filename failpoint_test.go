@@ -0,0 +1,150 @@
+//go:build failpoints
+// +build failpoints
+
+package logdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestFailpoint_BeforeMetaWrite(t *testing.T) {
+	defer DisableAllFailpoints()
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "failpoint_before_meta_write", chunkSize).(*LockFreeChunkDB)
+	vs := filldb(t, db, 20)
+	assertSync(t, db)
+
+	// Interrupt every sync between the data and metadata writes, as a crash there would.
+	EnableFailpoint(FailpointBeforeMetaWrite, FailAlways())
+	assertAppendEntries(t, db, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	assert.True(t, errors.Is(db.Sync(), ErrFailpoint), "expected sync to fail at the failpoint")
+	assert.True(t, errors.Is(db.Close(), ErrFailpoint), "expected close to fail at the failpoint")
+	DisableFailpoint(FailpointBeforeMetaWrite)
+
+	// The unsynced entries are invisible, and the rest are intact.
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "failpoint_before_meta_write", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(len(vs)), db.NewestID(), "expected unsynced entries to be lost")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected synced entries to be intact")
+	}
+}
+
+func TestFailpoint_AfterMetaWrite(t *testing.T) {
+	defer DisableAllFailpoints()
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "failpoint_after_meta_write", chunkSize).(*LockFreeChunkDB)
+	vs := filldb(t, db, 20)
+
+	EnableFailpoint(FailpointAfterMetaWrite, FailOnce())
+	assert.True(t, errors.Is(db.Sync(), ErrFailpoint), "expected sync to fail at the failpoint")
+	assertClose(t, db)
+
+	// The metadata was written, so the entries are all there.
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "failpoint_after_meta_write", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(len(vs)), db.NewestID(), "expected synced entries to persist")
+}
+
+func TestFailpoint_ChunkRollover(t *testing.T) {
+	defer DisableAllFailpoints()
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "failpoint_chunk_rollover", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+
+	// The first chunk is created without a rollover, so the second is the first to fail.
+	EnableFailpoint(FailpointChunkRollover, FailOnNth(2))
+	var err error
+	for err == nil {
+		_, err = db.Append([]byte("entry"))
+	}
+	assert.True(t, errors.Is(err, ErrFailpoint), "expected append to fail at the failpoint")
+	newest := db.NewestID()
+	assert.Equal(t, 1, len(db.chunks), "expected no new chunk")
+
+	// Once the failure has passed, appends roll over as normal.
+	assertAppend(t, db, []byte("entry"))
+	assert.Equal(t, newest+1, db.NewestID(), "expected entry to be appended")
+	assert.Equal(t, 2, len(db.chunks), "expected a new chunk")
+}
+
+func TestFailpoint_Mmap(t *testing.T) {
+	defer DisableAllFailpoints()
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "failpoint_mmap", chunkSize).(*LockFreeChunkDB)
+	vs := filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	// Without the address space to map them, chunks are read and written without mmap.
+	EnableFailpoint(FailpointMmap, func() error { return syscall.ENOMEM })
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "failpoint_mmap", chunkSize).(*LockFreeChunkDB)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected entry to be read without mmap")
+	}
+	entries, err := db.GetEntries(1, uint64(len(vs)))
+	assert.Nil(t, err, "expected no error in get entries")
+	assert.Equal(t, vs, entries, "expected entries to be read without mmap")
+
+	more := make([][]byte, numEntries)
+	for i := range more {
+		more[i] = []byte(fmt.Sprintf("more-%v", i))
+	}
+	assertAppendEntries(t, db, more)
+	vs = append(vs, more...)
+	for _, c := range db.chunks {
+		assert.True(t, c.bytes == nil, "expected chunk not to be mapped")
+	}
+	assert.Nil(t, db.VerifyIntegrity(), "expected entries to be intact")
+	assertRollback(t, db, uint64(numEntries/2))
+	vs = vs[:numEntries/2]
+	assertClose(t, db)
+	DisableFailpoint(FailpointMmap)
+
+	// The entries are all there once the chunks can be mapped again.
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "failpoint_mmap", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(len(vs)), db.NewestID(), "expected rollback to persist")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected entry to be written without mmap")
+	}
+}
+
+func TestFailpoint_LockTakeover(t *testing.T) {
+	defer DisableAllFailpoints()
+
+	_ = os.RemoveAll("test_db/failpoint_lock_takeover")
+	if err := os.MkdirAll("test_db/failpoint_lock_takeover", 0755); err != nil {
+		t.Fatal(err)
+	}
+	lockPath := "test_db/failpoint_lock_takeover/" + heartbeatLockFile
+	if err := writeFile(lockPath, []byte("dead 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lockPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both contenders find the lock stale, but the second takes it over while the first is paused.
+	var second *heartbeatLock
+	var secondErr error
+	EnableFailpoint(FailpointLockTakeover, func() error {
+		DisableFailpoint(FailpointLockTakeover)
+		second, secondErr = acquireHeartbeatLock(lockPath, time.Hour, time.Minute)
+		return nil
+	})
+	first, err := acquireHeartbeatLock(lockPath, time.Hour, time.Minute)
+
+	assert.Nil(t, secondErr, "expected the second contender to take over the lock")
+	assert.NotNil(t, err, "expected the first contender to find the lock held")
+	assert.True(t, first == nil, "expected the first contender not to get the lock")
+	assert.Nil(t, second.check(), "expected the second contender to still hold the lock")
+	assert.Nil(t, second.release(), "expected no error releasing lock")
+}
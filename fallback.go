@@ -0,0 +1,122 @@
+package logdb
+
+import "sync"
+
+// A FallbackDB wraps a 'LogDB' with a chain of databases to read entries from if it does not have them, such as
+// archives of older entries, presenting them as one log. Entries are appended to the wrapped database, and
+// 'Get' reads from the first database in the chain which has the entry, starting with the wrapped database.
+//
+// The databases are expected to hold different parts of the same log, with the same IDs, such as a copy made
+// with 'Backup' before the entries were forgotten. Overlapping ranges are fine, as the first database with an
+// entry is used.
+//
+// The range of IDs each fallback holds is remembered, so that a 'Get' only reads from a fallback which has the
+// entry, rather than trying each in turn, which matters if they are slow, like archives in object storage. If
+// the fallbacks change, call 'Refresh'.
+type FallbackDB struct {
+	LogDB
+
+	// The databases to read from, in order, if the wrapped database does not have an entry. These are only
+	// ever read.
+	Fallbacks []LogDB
+
+	// The range of IDs in each fallback, when last refreshed. Fallbacks added since are always tried.
+	rwlock sync.RWMutex
+	ranges []idRange
+}
+
+// Fallback creates a 'FallbackDB', and gets the range of IDs in each fallback.
+func Fallback(logdb LogDB, fallbacks ...LogDB) *FallbackDB {
+	db := &FallbackDB{LogDB: logdb, Fallbacks: fallbacks}
+	db.Refresh()
+	return db
+}
+
+// Refresh gets the range of IDs in each fallback again. Call this if entries are added to or removed from a
+// fallback, or if 'Fallbacks' is changed.
+func (db *FallbackDB) Refresh() {
+	ranges := make([]idRange, len(db.Fallbacks))
+	for i, fallback := range db.Fallbacks {
+		if newest := fallback.NewestID(); newest > 0 {
+			ranges[i] = idRange{oldest: fallback.OldestID(), newest: newest}
+		}
+	}
+
+	db.rwlock.Lock()
+	db.ranges = ranges
+	db.rwlock.Unlock()
+}
+
+// Get implements the 'LogDB' interface. If the wrapped database does not have the entry, the fallbacks which
+// have it are tried in order. Any error other than 'ErrIDOutOfRange' is returned straight away.
+func (db *FallbackDB) Get(id uint64) ([]byte, error) {
+	entry, err := db.LogDB.Get(id)
+	if err != ErrIDOutOfRange {
+		return entry, err
+	}
+
+	db.rwlock.RLock()
+	ranges := db.ranges
+	db.rwlock.RUnlock()
+	for i, fallback := range db.Fallbacks {
+		if i < len(ranges) && !ranges[i].has(id) {
+			continue
+		}
+		if entry, err = fallback.Get(id); err != ErrIDOutOfRange {
+			return entry, err
+		}
+	}
+	return nil, ErrIDOutOfRange
+}
+
+// OldestID implements the 'LogDB' interface. This is the oldest entry of the first database in the chain which
+// continues on to the entries of the databases before it, so that every entry from here to the newest can be
+// read.
+//
+// 'Forget', 'Rollback', and 'Truncate' only change the wrapped database, so forgotten entries can still be read
+// if a fallback has them. The ranges of the fallbacks are as of the last 'Refresh'.
+func (db *FallbackDB) OldestID() uint64 {
+	var oldest uint64
+	if db.LogDB.NewestID() > 0 {
+		oldest = db.LogDB.OldestID()
+	}
+
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+	for _, r := range db.ranges {
+		if r.newest == 0 {
+			continue
+		}
+		if oldest == 0 || r.oldest < oldest && r.newest+1 >= oldest {
+			oldest = r.oldest
+		}
+	}
+	return oldest
+}
+
+// NewestID implements the 'LogDB' interface. This is the newest entry of any of the databases, which is
+// usually that of the wrapped database. The ranges of the fallbacks are as of the last 'Refresh'.
+func (db *FallbackDB) NewestID() uint64 {
+	newest := db.LogDB.NewestID()
+
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+	for _, r := range db.ranges {
+		if r.newest > newest {
+			newest = r.newest
+		}
+	}
+	return newest
+}
+
+////////// HELPERS //////////
+
+// An inclusive range of entry IDs. The zero value is empty.
+type idRange struct {
+	oldest, newest uint64
+}
+
+// Check if an ID is in the range.
+func (r idRange) has(id uint64) bool {
+	return r.newest > 0 && id >= r.oldest && id <= r.newest
+}
@@ -0,0 +1,80 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestFallback(t *testing.T) {
+	hot := assertOpen(t, dbTypes["lock free chunkdb"], true, "fallback", chunkSize)
+	defer assertClose(t, hot)
+	vs := filldb(t, hot, numEntries)
+
+	// The archive holds the older entries, overlapping with the hot database, which then forgets them.
+	archive := &InMemDB{}
+	filldb(t, archive, 120)
+	assertForget(t, hot, 100)
+
+	db := Fallback(hot, archive)
+	assert.Equal(t, uint64(1), db.OldestID(), "expected the oldest entry of the archive")
+	assert.Equal(t, uint64(numEntries), db.NewestID(), "expected the newest entry of the hot database")
+	for id := uint64(100); id <= numEntries; id++ {
+		assert.Equal(t, vs[id-1], assertGet(t, db, id), "expected the entry from the hot database")
+	}
+
+	it, err := Scan(db, 1, numEntries)
+	assert.Nil(t, err, "expected no error in scan")
+	var n int
+	for it.Next() {
+		n++
+	}
+	assert.Nil(t, it.Err(), "expected no error in scan")
+	assert.Equal(t, numEntries, n, "expected to scan every entry")
+
+	_, err = db.Get(numEntries + 1)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected an entry nowhere to be out of range")
+
+	// A gap between the databases is not bridged.
+	old := &InMemDB{}
+	filldb(t, old, 50)
+	gapped := Fallback(hot, old)
+	assert.Equal(t, uint64(100), gapped.OldestID(), "expected the oldest entry of the hot database")
+}
+
+func TestFallback_Routing(t *testing.T) {
+	var archives []LogDB
+	var gets []int
+	for i := 0; i < 3; i++ {
+		archive := &InMemDB{}
+		filldb(t, archive, 100*(i+1))
+		assertForget(t, archive, uint64(100*i+1))
+		i := i
+		gets = append(gets, 0)
+		archives = append(archives, countingGets{archive, func() { gets[i]++ }})
+	}
+	db := Fallback(&InMemDB{}, archives...)
+	assert.Equal(t, uint64(1), db.OldestID(), "expected the oldest entry of the oldest archive")
+	assert.Equal(t, uint64(300), db.NewestID(), "expected the newest entry of the newest archive")
+
+	assertGet(t, db, 250)
+	assert.Equal(t, []int{0, 0, 1}, gets, "expected only the archive with the entry to be read")
+
+	// Entries added to an archive are found once refreshed.
+	assertAppend(t, archives[2], []byte("late"))
+	_, err := db.Get(301)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected the entry not to be found before refreshing")
+	db.Refresh()
+	assert.Equal(t, []byte("late"), assertGet(t, db, 301), "expected the entry after refreshing")
+}
+
+// A database which calls a function on every 'Get'.
+type countingGets struct {
+	LogDB
+	got func()
+}
+
+func (db countingGets) Get(id uint64) ([]byte, error) {
+	db.got()
+	return db.LogDB.Get(id)
+}
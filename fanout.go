@@ -0,0 +1,342 @@
+package logdb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FanoutPolicy is how many destinations of a 'FanoutDB' an operation must succeed on for it to succeed.
+type FanoutPolicy int
+
+const (
+	// FanoutAll requires every destination to succeed. This is the default.
+	FanoutAll FanoutPolicy = iota
+
+	// FanoutQuorum requires a majority of the destinations to succeed.
+	FanoutQuorum
+
+	// FanoutAny requires one destination to succeed.
+	FanoutAny
+)
+
+// FanoutOptions configure 'Fanout'. The zero value requires every destination to succeed.
+type FanoutOptions struct {
+	// How many destinations must succeed.
+	Policy FanoutPolicy
+
+	// Failed, if not nil, is called for every destination an operation fails on, even if the operation as a
+	// whole succeeds, so that a destination which falls behind can be noticed and repaired. The destination is
+	// given by its index.
+	Failed func(dest int, err error)
+}
+
+// A FanoutDB writes every entry to several databases, such as a local 'ChunkDB' and a remote copy, as simple
+// synchronous replication. The destinations are written to in parallel, and each operation succeeds if enough
+// of them succeed (see 'FanoutPolicy').
+//
+// All of the destinations must give the same IDs to the same entries. A destination which gives an appended
+// entry a different ID to the one expected (because it has been written to by something else), which misses an
+// append, or which is left with a different newest entry by a failed rollback, has diverged. A diverged
+// destination is neither written to nor read from until it has been repaired and passed to 'Rejoin': every
+// operation counts it as a failure, with 'ErrFanoutDiverged'. If an append does not succeed on enough
+// destinations, it is rolled back on those it did succeed on.
+//
+// Entries are read from the first destination which has them and has not diverged, in order, so the first
+// destination should be the fastest. Appends, forgets, and rollbacks are serialised, so this is safe for concurrent use if the
+// destinations are.
+type FanoutDB struct {
+	dsts []LogDB
+	opts FanoutOptions
+
+	// Held while changing the destinations.
+	lock sync.Mutex
+
+	// The ID the next entry appended should get.
+	next uint64
+
+	// Which destinations have diverged, by index. Held while reading or changing this, so that reads do not
+	// wait for appends.
+	divergedLock sync.RWMutex
+	diverged     []bool
+}
+
+// Fanout makes a 'FanoutDB' writing to the given destinations, which must all have the same newest entry.
+//
+// Returns 'ErrFanoutDiverged' if they do not, and an error if there are no destinations.
+func Fanout(dsts []LogDB, opts FanoutOptions) (*FanoutDB, error) {
+	if len(dsts) == 0 {
+		return nil, fmt.Errorf("fanout needs at least one destination")
+	}
+	for _, dst := range dsts[1:] {
+		if dst.NewestID() != dsts[0].NewestID() {
+			return nil, ErrFanoutDiverged
+		}
+	}
+	return &FanoutDB{
+		dsts:     append([]LogDB(nil), dsts...),
+		opts:     opts,
+		next:     dsts[0].NewestID() + 1,
+		diverged: make([]bool, len(dsts)),
+	}, nil
+}
+
+// Rejoin starts writing to and reading from a destination which has diverged again, once it has been repaired
+// so that it has the same entries as the others. The destination is given by its index.
+//
+// Returns 'ErrFanoutDiverged' if the destination's newest entry is not the newest entry of the 'FanoutDB'.
+func (db *FanoutDB) Rejoin(dest int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.dsts[dest].NewestID() != db.next-1 {
+		return ErrFanoutDiverged
+	}
+	db.divergedLock.Lock()
+	db.diverged[dest] = false
+	db.divergedLock.Unlock()
+	return nil
+}
+
+// Diverged checks if a destination has diverged, and so is not being written to or read from. The destination
+// is given by its index.
+func (db *FanoutDB) Diverged(dest int) bool {
+	db.divergedLock.RLock()
+	defer db.divergedLock.RUnlock()
+
+	return db.diverged[dest]
+}
+
+// Append implements the 'LogDB' interface.
+func (db *FanoutDB) Append(entry []byte) (uint64, error) {
+	return db.AppendEntries([][]byte{entry})
+}
+
+// AppendEntries implements the 'LogDB' interface. If the entries are not appended to enough destinations, this
+// returns a 'FanoutError' value.
+func (db *FanoutDB) AppendEntries(entries [][]byte) (uint64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if len(entries) == 0 {
+		return db.next, nil
+	}
+
+	next := db.next
+	errs := db.eachHealthy(func(i int) error {
+		id, err := db.dsts[i].AppendEntries(entries)
+		if err == nil && id != next {
+			db.diverge(i)
+			err = ErrFanoutDiverged
+		}
+		return err
+	})
+	err := db.result(errs)
+	if err != nil {
+		db.eachHealthy(func(i int) error {
+			if errs[i] == nil {
+				return db.dsts[i].Rollback(next - 1)
+			}
+			return nil
+		})
+	} else {
+		db.next += uint64(len(entries))
+	}
+
+	// Destinations which failed, or could not be rolled back, may not have the entries they should.
+	db.checkDiverged()
+	if err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// Get implements the 'LogDB' interface. The entry is read from the first destination which has it and has not
+// diverged.
+//
+// Returns 'ErrFanoutDiverged' if every destination has diverged.
+func (db *FanoutDB) Get(id uint64) ([]byte, error) {
+	err := ErrFanoutDiverged
+	for i, dst := range db.dsts {
+		if db.Diverged(i) {
+			continue
+		}
+		var entry []byte
+		if entry, err = dst.Get(id); err == nil {
+			return entry, nil
+		}
+	}
+	return nil, err
+}
+
+// Forget implements the 'LogDB' interface. If the entries are not forgotten from enough destinations, this
+// returns a 'FanoutError' value.
+func (db *FanoutDB) Forget(newOldestID uint64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.result(db.eachHealthy(func(i int) error { return db.dsts[i].Forget(newOldestID) }))
+}
+
+// Rollback implements the 'LogDB' interface. If the entries are not rolled back on enough destinations, this
+// returns a 'FanoutError' value.
+func (db *FanoutDB) Rollback(newNewestID uint64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	err := db.result(db.eachHealthy(func(i int) error { return db.dsts[i].Rollback(newNewestID) }))
+	if err == nil && newNewestID < db.next {
+		db.next = newNewestID + 1
+	}
+	db.checkDiverged()
+	return err
+}
+
+// Truncate implements the 'LogDB' interface. If the entries are not truncated on enough destinations, this
+// returns a 'FanoutError' value.
+func (db *FanoutDB) Truncate(newOldestID, newNewestID uint64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	err := db.result(db.eachHealthy(func(i int) error { return db.dsts[i].Truncate(newOldestID, newNewestID) }))
+	if err == nil && newNewestID < db.next {
+		db.next = newNewestID + 1
+	}
+	db.checkDiverged()
+	return err
+}
+
+// SetSync implements the 'PersistDB' interface. Destinations which are not a 'PersistDB' are skipped.
+func (db *FanoutDB) SetSync(every int) error {
+	return db.result(db.eachHealthy(func(i int) error {
+		if pdb, ok := db.dsts[i].(PersistDB); ok {
+			return pdb.SetSync(every)
+		}
+		return nil
+	}))
+}
+
+// Sync implements the 'PersistDB' interface. Destinations which are not a 'PersistDB' are skipped. If enough
+// destinations are not synced, this returns a 'FanoutError' value.
+func (db *FanoutDB) Sync() error {
+	return db.result(db.eachHealthy(func(i int) error {
+		if pdb, ok := db.dsts[i].(PersistDB); ok {
+			return pdb.Sync()
+		}
+		return nil
+	}))
+}
+
+// Close implements the 'CloseDB' interface. Destinations which are not a 'CloseDB' are skipped. Every
+// destination is closed, and the error is a 'FanoutError' value if any could not be.
+func (db *FanoutDB) Close() error {
+	errs := db.each(func(i int) error {
+		if cdb, ok := db.dsts[i].(CloseDB); ok {
+			return cdb.Close()
+		}
+		return nil
+	})
+	var succeeded int
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded < len(db.dsts) {
+		return &FanoutError{Succeeded: succeeded, Required: len(db.dsts), Errs: errs}
+	}
+	return nil
+}
+
+// OldestID implements the 'LogDB' interface. This is the oldest ID of the first destination which has not
+// diverged, or 0 if they all have.
+func (db *FanoutDB) OldestID() uint64 {
+	for i, dst := range db.dsts {
+		if !db.Diverged(i) {
+			return dst.OldestID()
+		}
+	}
+	return 0
+}
+
+// NewestID implements the 'LogDB' interface. This is the ID of the newest entry appended to enough
+// destinations.
+func (db *FanoutDB) NewestID() uint64 {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.next - 1
+}
+
+////////// HELPERS //////////
+
+// Run an operation on every destination in parallel, given its index, and get its error for each.
+func (db *FanoutDB) each(op func(int) error) []error {
+	errs := make([]error, len(db.dsts))
+	var wg sync.WaitGroup
+	for i := range db.dsts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = op(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Run an operation on every destination which has not diverged in parallel, as 'each'. The error for a diverged
+// destination is 'ErrFanoutDiverged'.
+func (db *FanoutDB) eachHealthy(op func(int) error) []error {
+	return db.each(func(i int) error {
+		if db.Diverged(i) {
+			return ErrFanoutDiverged
+		}
+		return op(i)
+	})
+}
+
+// Mark a destination as diverged.
+func (db *FanoutDB) diverge(i int) {
+	db.divergedLock.Lock()
+	defer db.divergedLock.Unlock()
+
+	db.diverged[i] = true
+}
+
+// Mark every destination whose newest entry is not the newest entry of the 'FanoutDB' as diverged, after an
+// operation which may have failed part-way on some of them.
+func (db *FanoutDB) checkDiverged() {
+	for i, dst := range db.dsts {
+		if !db.Diverged(i) && dst.NewestID() != db.next-1 {
+			db.diverge(i)
+		}
+	}
+}
+
+// Report the failed destinations, and return a 'FanoutError' value if there were too many.
+func (db *FanoutDB) result(errs []error) error {
+	var succeeded int
+	for i, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if db.opts.Failed != nil {
+			db.opts.Failed(i, err)
+		}
+	}
+	if required := db.required(); succeeded < required {
+		return &FanoutError{Succeeded: succeeded, Required: required, Errs: errs}
+	}
+	return nil
+}
+
+// Get the number of destinations which must succeed.
+func (db *FanoutDB) required() int {
+	switch db.opts.Policy {
+	case FanoutQuorum:
+		return len(db.dsts)/2 + 1
+	case FanoutAny:
+		return 1
+	default:
+		return len(db.dsts)
+	}
+}
@@ -0,0 +1,106 @@
+package logdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestFanout_All(t *testing.T) {
+	local := WrapForConcurrency(assertOpen(t, dbTypes["lock free chunkdb"], true, "fanout_all", chunkSize).(*LockFreeChunkDB))
+	remote := &InMemDB{}
+	db, err := Fanout([]LogDB{local, remote}, FanoutOptions{})
+	assert.Nil(t, err, "expected no error in fanout")
+	defer assertClose(t, db)
+
+	vs := filldb(t, db, numEntries)
+	assertForget(t, db, 20)
+	assertRollback(t, db, 200)
+	assertSync(t, db)
+	for _, dst := range []LogDB{local, remote} {
+		assert.Equal(t, uint64(20), dst.OldestID(), "expected the destination to be forgotten")
+		assert.Equal(t, uint64(200), dst.NewestID(), "expected the destination to be rolled back")
+		for id := uint64(20); id <= 200; id++ {
+			assert.Equal(t, vs[id-1], assertGet(t, dst, id), "expected the entry in the destination")
+		}
+	}
+
+	// An append which fails on one destination is rolled back on the other.
+	failing, err := Fanout([]LogDB{remote, AdminView(local)}, FanoutOptions{})
+	assert.Nil(t, err, "expected no error in fanout")
+	_, err = failing.Append([]byte("fails"))
+	assert.True(t, errors.Is(err, ErrNotPermitted), "expected the destination's error, got %v", err)
+	assert.Equal(t, uint64(200), remote.NewestID(), "expected the append to be rolled back")
+}
+
+func TestFanout_Quorum(t *testing.T) {
+	dsts := []LogDB{&InMemDB{}, &InMemDB{}, &InMemDB{}}
+	var failed []int
+	db, err := Fanout(dsts, FanoutOptions{
+		Policy: FanoutQuorum,
+		Failed: func(dest int, _ error) { failed = append(failed, dest) },
+	})
+	assert.Nil(t, err, "expected no error in fanout")
+
+	// Something else writes to one destination, so it diverges.
+	assertAppend(t, dsts[2], []byte("elsewhere"))
+	id := assertAppend(t, db, []byte("quorum"))
+	assert.Equal(t, uint64(1), id, "expected the first ID")
+	assert.Equal(t, []int{2}, failed, "expected the diverged destination to be reported")
+
+	// Once another destination fails, there is no majority.
+	dsts[1] = AdminView(dsts[1])
+	db.dsts[1] = dsts[1]
+	_, err = db.Append([]byte("minority"))
+	var ferr *FanoutError
+	assert.True(t, errors.As(err, &ferr), "expected a 'FanoutError' value, got %v", err)
+	assert.Equal(t, 1, ferr.Succeeded, "expected one destination to succeed")
+	assert.True(t, errors.Is(err, ErrFanoutDiverged), "expected the divergence to be wrapped")
+	assert.Equal(t, uint64(1), db.NewestID(), "expected nothing to be appended")
+	assert.Equal(t, uint64(1), dsts[0].NewestID(), "expected the append to be rolled back")
+}
+
+func TestFanout_Diverged(t *testing.T) {
+	dst := &InMemDB{}
+	assertAppend(t, dst, []byte("entry"))
+	_, err := Fanout([]LogDB{&InMemDB{}, dst}, FanoutOptions{})
+	assert.Equal(t, ErrFanoutDiverged, err, "expected destinations with different entries to be rejected")
+}
+
+func TestFanout_FailedNotRead(t *testing.T) {
+	fail := true
+	dsts := []LogDB{failingAppends{&InMemDB{}, &fail}, &InMemDB{}}
+	db, err := Fanout(dsts, FanoutOptions{Policy: FanoutAny})
+	assert.Nil(t, err, "expected no error in fanout")
+
+	// The first destination misses an append, so has diverged, and is not read from or written to even once it
+	// could be.
+	assertAppend(t, db, []byte("A"))
+	assert.True(t, db.Diverged(0), "expected the destination which missed an append to have diverged")
+	fail = false
+	assertAppend(t, db, []byte("B"))
+	assert.Equal(t, []byte("A"), assertGet(t, db, 1), "expected the entry from the destination which has it")
+	assert.Equal(t, uint64(0), dsts[0].NewestID(), "expected the diverged destination not to be written to")
+
+	// Once repaired, it can rejoin.
+	assert.Equal(t, ErrFanoutDiverged, db.Rejoin(0), "expected an unrepaired destination not to rejoin")
+	_, err = dsts[0].AppendEntries([][]byte{[]byte("A"), []byte("B")})
+	assert.Nil(t, err, "expected no error in repair")
+	assert.Nil(t, db.Rejoin(0), "expected a repaired destination to rejoin")
+	assertAppend(t, db, []byte("C"))
+	assert.Equal(t, []byte("C"), assertGet(t, dsts[0], 3), "expected the rejoined destination to be written to")
+}
+
+// A database whose appends fail while a flag is set.
+type failingAppends struct {
+	LogDB
+	fail *bool
+}
+
+func (db failingAppends) AppendEntries(entries [][]byte) (uint64, error) {
+	if *db.fail {
+		return 0, ErrNotPermitted
+	}
+	return db.LogDB.AppendEntries(entries)
+}
@@ -0,0 +1,110 @@
+package logdb
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrStaleFencingToken means that a write was refused because a newer fencing token has been set on the
+// database, so another writer has taken over.
+var ErrStaleFencingToken = errors.New("fencing token is stale")
+
+// Name of the file holding the highest fencing token set on the database.
+const fenceFile = "fence"
+
+// WithFencingToken sets the fencing token, as 'SetFencingToken' does. Opening fails with 'ErrStaleFencingToken'
+// if a newer token has already been set.
+func WithFencingToken(token uint64) Option {
+	return withSetting(func(db *LockFreeChunkDB) error { return db.SetFencingToken(token) })
+}
+
+// SetFencingToken is the thread-safe version of 'LockFreeChunkDB.SetFencingToken'.
+func (db *ChunkDB) SetFencingToken(token uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.SetFencingToken(token)
+}
+
+// SetFencingToken protects the database from a writer which no longer has the right to write to it, such as a
+// process on another host which has been paused and has lost its lease (see 'Lease') while it still has the
+// database open on shared storage. The token comes from an external coordinator, and must increase every time
+// the right to write changes hands.
+//
+// The token is written to disk, and every write ('Append', 'Forget', 'Rollback', 'Truncate', and their variants,
+// 'ImportChunks', 'Downsample', 'Compact', and 'Undelete') first checks that no newer token has been written
+// since, failing with 'ErrStaleFencingToken' if one has. This costs a small read for every write. Setting a token
+// equal to the current one does nothing, and 0 means there is no fencing, which is the default.
+//
+// Returns 'ErrStaleFencingToken' if a newer token has been set, 'ErrClosed' if the handle is closed, and a
+// 'ReadError' or 'WriteError' value if the token could not be read or written.
+func (db *LockFreeChunkDB) SetFencingToken(token uint64) error {
+	if db.closed {
+		return ErrClosed
+	}
+	current, err := readFence(db.path)
+	if err != nil {
+		return &ReadError{err}
+	}
+	if token < current {
+		return ErrStaleFencingToken
+	}
+	if token > current {
+		if err := writeFile(db.path+"/"+fenceFile, token); err != nil {
+			return &WriteError{err}
+		}
+	}
+	db.fence = token
+	return nil
+}
+
+// FencingToken gets the fencing token set with 'SetFencingToken', or 0 if there is none.
+func (db *LockFreeChunkDB) FencingToken() uint64 {
+	return db.fence
+}
+
+// AppendFenced is the thread-safe version of 'LockFreeChunkDB.AppendFenced'.
+func (db *ChunkDB) AppendFenced(token uint64, entries [][]byte) (uint64, error) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.AppendFenced(token, entries)
+}
+
+// AppendFenced sets the fencing token, as 'SetFencingToken' does, and then appends entries, as 'AppendEntries'
+// does. This suits a writer which is given a token with each request.
+//
+// Returns the same errors as 'SetFencingToken' and 'AppendEntries'.
+func (db *LockFreeChunkDB) AppendFenced(token uint64, entries [][]byte) (uint64, error) {
+	if err := db.SetFencingToken(token); err != nil {
+		return 0, err
+	}
+	return db.AppendEntries(entries)
+}
+
+////////// HELPERS //////////
+
+// Check that no newer fencing token than this handle's has been set. Assumes a read lock is held.
+func (db *LockFreeChunkDB) checkFence() error {
+	if db.fence == 0 {
+		return nil
+	}
+	current, err := readFence(db.path)
+	if err != nil {
+		return &ReadError{err}
+	}
+	if current > db.fence {
+		return ErrStaleFencingToken
+	}
+	return nil
+}
+
+// Read the "fence" file, which only exists if a fencing token has been set.
+func readFence(path string) (uint64, error) {
+	var token uint64
+	if _, err := os.Stat(path + "/" + fenceFile); os.IsNotExist(err) {
+		return 0, nil
+	}
+	err := readFile(path+"/"+fenceFile, &token)
+	return token, err
+}
@@ -0,0 +1,77 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestFencingToken(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "fence", chunkSize).(*ChunkDB)
+	filldb(t, db, numEntries)
+
+	assert.Nil(t, db.SetFencingToken(5), "expected no error in setting fencing token")
+	assertAppend(t, db, []byte("fenced"))
+	assert.Equal(t, ErrStaleFencingToken, db.SetFencingToken(4), "expected older fencing token to be refused")
+
+	_, err := db.AppendFenced(6, [][]byte{[]byte("newer")})
+	assert.Nil(t, err, "expected no error in append with newer fencing token")
+	assert.Equal(t, uint64(6), db.FencingToken(), "expected fencing token to be raised")
+
+	// Another writer takes over, as if on another host.
+	assert.Nil(t, writeFile(db.path+"/"+fenceFile, uint64(7)), "expected no error in writing fence file")
+	_, err = db.Append([]byte("stale"))
+	assert.Equal(t, ErrStaleFencingToken, err, "expected append with stale fencing token to be refused")
+	assert.Equal(t, ErrStaleFencingToken, db.Rollback(10), "expected rollback with stale fencing token to be refused")
+	assert.Equal(t, ErrStaleFencingToken, db.Forget(10), "expected forget with stale fencing token to be refused")
+	assert.Equal(t, uint64(numEntries+2), db.NewestID(), "expected nothing to be written")
+	assertClose(t, db)
+
+	_, err = OpenWithOptions("test_db/fence", WithFencingToken(6))
+	assert.Equal(t, ErrStaleFencingToken, err, "expected open with stale fencing token to fail")
+
+	lfdb, err := OpenWithOptions("test_db/fence", WithFencingToken(7))
+	assert.Nil(t, err, "expected no error in open with current fencing token")
+	defer assertClose(t, lfdb)
+	assertAppend(t, lfdb, []byte("current"))
+}
+
+func TestFencingToken_Import(t *testing.T) {
+	_, paths := buildImportChunks(t, "fence_import_src")
+
+	db := assertStaleFence(t, "fence_import")
+	defer assertClose(t, db)
+	assert.Equal(t, ErrStaleFencingToken, db.ImportChunks(paths), "expected import with stale fencing token to be refused")
+	assert.Equal(t, uint64(numEntries), db.NewestID(), "expected nothing to be imported")
+}
+
+func TestFencingToken_Downsample(t *testing.T) {
+	db := assertStaleFence(t, "fence_downsample")
+	defer assertClose(t, db)
+	chunks := len(db.chunks)
+	assert.Equal(t, ErrStaleFencingToken, db.Downsample(200, KeepEvery(10)), "expected downsample with stale fencing token to be refused")
+	assert.Equal(t, chunks, len(db.chunks), "expected nothing to be downsampled")
+}
+
+func TestFencingToken_Compact(t *testing.T) {
+	db := assertStaleFence(t, "fence_compact")
+	defer assertClose(t, db)
+	assert.Equal(t, ErrStaleFencingToken, db.Compact(), "expected compact with stale fencing token to be refused")
+}
+
+func TestFencingToken_Undelete(t *testing.T) {
+	db := assertStaleFence(t, "fence_undelete")
+	defer assertClose(t, db)
+	assert.Equal(t, ErrStaleFencingToken, db.Undelete(), "expected undelete with stale fencing token to be refused")
+	assert.Equal(t, uint64(200), db.OldestID(), "expected nothing to be restored")
+}
+
+// Open a database, fill it, forget some entries, and set a fencing token which another writer then replaces.
+func assertStaleFence(t *testing.T, name string) *ChunkDB {
+	db := assertOpen(t, dbTypes["chunkdb"], true, name, chunkSize).(*ChunkDB)
+	filldb(t, db, numEntries)
+	assertForget(t, db, 200)
+	assert.Nil(t, db.SetFencingToken(5), "expected no error in setting fencing token")
+	assert.Nil(t, writeFile(db.path+"/"+fenceFile, uint64(6)), "expected no error in writing fence file")
+	return db
+}
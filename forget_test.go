@@ -0,0 +1,37 @@
+package logdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestForget_Batch(t *testing.T) {
+	syncs := make(map[int]uint64)
+	for _, batch := range []int{0, 4} {
+		func() {
+			db := assertOpen(t, dbTypes["lock free chunkdb"], true, fmt.Sprintf("forget_batch_%v", batch), chunkSize).(*LockFreeChunkDB)
+			defer assertClose(t, db)
+
+			filldb(t, db, numEntries)
+			assertSetSync(t, db, -1)
+			assertSync(t, db)
+			assert.Nil(t, db.SetForgetBatch(batch))
+			before := db.Latencies().Sync.Count
+
+			for id := uint64(2); id < numEntries; id++ {
+				assertForget(t, db, id)
+				_, err := db.Get(id - 1)
+				assert.Equal(t, ErrIDOutOfRange, err, "expected forgotten entry to be gone")
+			}
+			syncs[batch] = db.Latencies().Sync.Count - before
+
+			assertSync(t, db)
+			db.prune()
+			assert.Equal(t, 1, len(db.chunks), "expected forgotten chunks to be deleted after a sync")
+			assert.Nil(t, db.Verify(), "expected no problems")
+		}()
+	}
+	assert.True(t, syncs[4] < syncs[0], "expected fewer syncs with batching: %v", syncs)
+}
@@ -0,0 +1,85 @@
+package logdb
+
+import "os"
+
+// A Format is a disk format version, and what this version of the library can do with a database in it.
+//
+// Disk format versions are a single number which is incremented whenever the files written change in a way
+// that an older version of the library could not read. A database can only be opened for writing in the
+// version this library writes ('FormatVersion'), but a database in an older version can still be read with
+// 'OpenReader', and upgraded with 'Migrate' whenever it suits: nothing is upgraded without asking. Newer
+// versions cannot be used at all.
+type Format struct {
+	Version uint16
+
+	// A database in this format can be opened to read entries with 'OpenReader', and to write them with 'Open'.
+	Read  bool
+	Write bool
+
+	// A database in this format can be upgraded to the current format with 'Migrate'.
+	Migrate bool
+}
+
+// FormatVersion gets the disk format version written by this version of the library.
+func FormatVersion() uint16 {
+	return latestVersion
+}
+
+// SupportedFormats gets every disk format version known to this version of the library, oldest first, and what
+// it can do with databases in each.
+func SupportedFormats() []Format {
+	formats := make([]Format, 0, latestVersion+1)
+	for v := uint16(0); v <= latestVersion; v++ {
+		formats = append(formats, formatOf(v))
+	}
+	return formats
+}
+
+// CheckFormat gets the disk format version of the database in the given directory, and what this version of the
+// library can do with it, without opening the database. A version newer than this library knows about is
+// returned with none of the capabilities.
+//
+// Returns 'ErrPathDoesntExist' if the path does not exist, 'ErrNotDirectory' if it is not a directory, and a
+// 'ReadError' value if the version could not be read.
+func CheckFormat(path string) (Format, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return Format{}, ErrPathDoesntExist
+	} else if err != nil {
+		return Format{}, &ReadError{err}
+	}
+	if !fi.IsDir() {
+		return Format{}, ErrNotDirectory
+	}
+
+	var version uint16
+	if err := readFile(path+"/version", &version); err != nil {
+		return Format{}, &ReadError{err}
+	}
+	return formatOf(version), nil
+}
+
+////////// HELPERS //////////
+
+// Get what this version of the library can do with a database in the given format version.
+func formatOf(version uint16) Format {
+	return Format{
+		Version: version,
+		Read:    version <= latestVersion,
+		Write:   version == latestVersion,
+		Migrate: version < latestVersion && canMigrate(version, latestVersion, migrations),
+	}
+}
+
+// Check the error to return when opening a database in the given format version for writing.
+func checkFormat(version uint16) error {
+	f := formatOf(version)
+	switch {
+	case f.Write:
+		return nil
+	case f.Migrate:
+		return ErrNeedsMigration
+	default:
+		return ErrUnknownVersion
+	}
+}
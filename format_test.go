@@ -0,0 +1,72 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestFormat_Supported(t *testing.T) {
+	formats := SupportedFormats()
+	assert.Equal(t, int(FormatVersion())+1, len(formats), "expected every version up to the current one")
+	for i, f := range formats {
+		assert.Equal(t, uint16(i), f.Version, "expected versions in order")
+		current := f.Version == FormatVersion()
+		assert.True(t, f.Read, "expected every version to be readable")
+		assert.Equal(t, current, f.Write, "expected only the current version to be writable")
+		assert.Equal(t, !current, f.Migrate, "expected every older version to be migratable")
+	}
+}
+
+func TestFormat_Check(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "format_check", chunkSize)
+	assertClose(t, db)
+	path := "test_db/format_check"
+
+	f, err := CheckFormat(path)
+	assert.Nil(t, err, "expected no error checking format")
+	assert.Equal(t, Format{Version: FormatVersion(), Read: true, Write: true}, f, "expected the current format")
+
+	downgradeToVersion0(t, path)
+	f, err = CheckFormat(path)
+	assert.Nil(t, err, "expected no error checking format")
+	assert.Equal(t, Format{Version: 0, Read: true, Migrate: true}, f, "expected an old format")
+
+	if err := writeFile(path+"/version", FormatVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	f, err = CheckFormat(path)
+	assert.Nil(t, err, "expected no error checking format")
+	assert.Equal(t, Format{Version: FormatVersion() + 1}, f, "expected an unknown format")
+	_, err = Open(path, 0, false)
+	assert.Equal(t, ErrUnknownVersion, err, "expected an unknown format to not open")
+
+	_, err = CheckFormat("test_db/format_check_missing")
+	assert.Equal(t, ErrPathDoesntExist, err, "expected missing path")
+}
+
+func TestFormat_ReadOld(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "format_read_old", chunkSize)
+	vs := filldb(t, db, numEntries)
+	assertClose(t, db)
+	path := "test_db/format_read_old"
+	downgradeToVersion0(t, path)
+
+	// An old database cannot be written to without migrating, but can be read.
+	_, err := Open(path, 0, false)
+	assert.Equal(t, ErrNeedsMigration, err, "expected an old database to need migrating to write")
+
+	r, err := OpenReader(path)
+	assert.Nil(t, err, "expected no error opening an old database to read, got: %s", err)
+	assert.Equal(t, uint64(numEntries), r.NewestID(), "expected every entry")
+	for i, v := range vs {
+		entry, err := r.Get(uint64(i + 1))
+		assert.Nil(t, err, "expected no error in get")
+		assert.Equal(t, v, entry, "expected equal values")
+	}
+	assert.Nil(t, r.Close(), "expected no error closing reader")
+
+	var version uint16
+	assert.Nil(t, readFile(path+"/version", &version), "expected no error reading version")
+	assert.Equal(t, uint16(0), version, "expected reading not to migrate the database")
+}
@@ -0,0 +1,124 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Name of the file recording the rollbacks of a database, which only exists once there has been one.
+const generationFile = "generation"
+
+// A record of one rollback: the generation it started, and the ID of the newest entry it left.
+type generationRecord struct {
+	Generation uint64
+	Newest     uint64
+}
+
+// Generation is the thread-safe version of 'LockFreeChunkDB.Generation'.
+func (db *ChunkDB) Generation() uint64 {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.Generation()
+}
+
+// Generation implements the 'GenerationDB' interface. The generation persists when the database is closed.
+func (db *LockFreeChunkDB) Generation() uint64 {
+	if len(db.generations) == 0 {
+		return 0
+	}
+	return db.generations[len(db.generations)-1].Generation
+}
+
+// RolledBackTo is the thread-safe version of 'LockFreeChunkDB.RolledBackTo'.
+func (db *ChunkDB) RolledBackTo(generation uint64) uint64 {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.RolledBackTo(generation)
+}
+
+// RolledBackTo implements the 'GenerationDB' interface. Every rollback is recorded on disk, so this works for
+// any generation, including those from before the database was last opened. Once the oldest entry is past what a
+// rollback left, 'Compact' may merge its record with older ones: the result is still older than the oldest entry,
+// but may be lower than it was.
+func (db *LockFreeChunkDB) RolledBackTo(generation uint64) uint64 {
+	to := db.newest
+	for i := len(db.generations) - 1; i >= 0 && db.generations[i].Generation > generation; i-- {
+		if db.generations[i].Newest < to {
+			to = db.generations[i].Newest
+		}
+	}
+	return to
+}
+
+////////// HELPERS //////////
+
+// Start a new generation for a rollback to the given newest ID. This is recorded before the rollback is made,
+// so that a crash in between can only cause a spurious new generation, not a missed one. Assumes a write lock is
+// held.
+func (db *LockFreeChunkDB) newGeneration(newest uint64) error {
+	rec := generationRecord{Generation: db.Generation() + 1, Newest: newest}
+	if err := appendFile(db.path+"/"+generationFile, rec); err != nil {
+		return &WriteError{err}
+	}
+	db.generations = append(db.generations, rec)
+	return nil
+}
+
+// Merge the rollback records up to the last which left a newest ID older than the oldest entry into one, and
+// rewrite the file if any were merged. For any generation before the merged record, the lowest ID rolled back to
+// was already older than the oldest entry, which is all that a reader can still act on, so keeping the lowest
+// of them is enough. Assumes a write lock is held.
+func (db *LockFreeChunkDB) compactGenerations() error {
+	last := -1
+	for i, rec := range db.generations {
+		if rec.Newest < db.oldest {
+			last = i
+		}
+	}
+	if last < 1 {
+		return nil
+	}
+
+	merged := db.generations[last]
+	for _, rec := range db.generations[:last] {
+		if rec.Newest < merged.Newest {
+			merged.Newest = rec.Newest
+		}
+	}
+	recs := append([]generationRecord{merged}, db.generations[last+1:]...)
+	path := db.path + "/" + generationFile
+	if err := writeFile(path+tmpSuffix, recs); err != nil {
+		return &WriteError{err}
+	}
+	if err := os.Rename(path+tmpSuffix, path); err != nil {
+		return &WriteError{err}
+	}
+	db.generations = recs
+	return nil
+}
+
+// Read the rollback records of a database. A partial final record, left by a crash while it was written, is
+// ignored: the rollback was not made.
+func readGenerations(path string) ([]generationRecord, error) {
+	file, err := os.Open(path + "/" + generationFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var recs []generationRecord
+	for {
+		var rec generationRecord
+		if err := binary.Read(file, binary.LittleEndian, &rec); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return recs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+}
@@ -0,0 +1,66 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestGeneration(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "generation", chunkSize).(*LockFreeChunkDB)
+	filldb(t, db, numEntries)
+	assert.Equal(t, uint64(0), db.Generation(), "expected no rollbacks")
+	assert.Equal(t, uint64(numEntries), db.RolledBackTo(0), "expected nothing rolled back")
+
+	assertForget(t, db, 10)
+	assertRollback(t, db, uint64(numEntries))
+	assert.Equal(t, uint64(0), db.Generation(), "expected forgetting and no-op rollbacks to not change the generation")
+
+	assertRollback(t, db, 200)
+	assert.Equal(t, uint64(1), db.Generation(), "expected a rollback to start a new generation")
+	assertRollback(t, db, 150)
+	if err := db.Truncate(20, 180); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(2), db.Generation(), "expected a truncate which only forgets to not start a new generation")
+	if err := db.Truncate(20, 120); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(3), db.Generation(), "expected a truncate which rolls back to start a new generation")
+	assertAppend(t, db, []byte("replacement"))
+
+	assert.Equal(t, uint64(120), db.RolledBackTo(0), "expected the lowest rollback since generation 0")
+	assert.Equal(t, uint64(120), db.RolledBackTo(2), "expected the lowest rollback since generation 2")
+	assert.Equal(t, db.NewestID(), db.RolledBackTo(3), "expected nothing rolled back since generation 3")
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "generation", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(3), db.Generation(), "expected the generation to persist")
+	assert.Equal(t, uint64(120), db.RolledBackTo(1), "expected the rollbacks to persist")
+}
+
+func TestGeneration_Compact(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "generation_compact", chunkSize).(*LockFreeChunkDB)
+	filldb(t, db, numEntries)
+	assertRollback(t, db, 200)
+	assertRollback(t, db, 100)
+	for id := db.NewestID() + 1; id <= 200; id++ {
+		assertAppend(t, db, []byte("replacement"))
+	}
+	assertRollback(t, db, 180)
+	assertForget(t, db, 160)
+	assert.Nil(t, db.Compact(), "expected no error in compact")
+
+	assert.Equal(t, 2, len(db.generations), "expected the rollbacks to before the oldest entry to be merged")
+	assert.Equal(t, uint64(3), db.Generation(), "expected the generation to be kept")
+	assert.Equal(t, uint64(100), db.RolledBackTo(0), "expected the lowest rollback to be kept")
+	assert.Equal(t, uint64(100), db.RolledBackTo(1), "expected a rollback to before the oldest entry")
+	assert.Equal(t, uint64(180), db.RolledBackTo(2), "expected later rollbacks to be unchanged")
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "generation_compact", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, 2, len(db.generations), "expected the merged records to persist")
+	assert.Equal(t, uint64(3), db.Generation(), "expected the generation to persist")
+}
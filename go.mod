@@ -0,0 +1,3 @@
+module github.com/barrucadu/logdb
+
+go 1.20
@@ -0,0 +1,45 @@
+package logdb
+
+// SetGroupCommit configures whether appends which need a sync share it with other appends. By default, an
+// append which makes a sync due (see 'SetSync') syncs while holding the write lock, so with a sync period of 0,
+// every append pays for a sync of its own, one after another.
+//
+// With group commit, the sync happens after the write lock is released, so other goroutines can append while
+// it waits its turn. Only one goroutine syncs at a time, and a sync covers every entry appended before it, so
+// an append which waited while another goroutine synced has nothing left to do. Under many concurrent writers,
+// this syncs once for each batch of appends, rather than once for each append. Either way, an append does not
+// return until its entries have been synced, if a sync was due.
+//
+// This only applies to a 'ChunkDB', as a 'LockFreeChunkDB' has only one writer.
+func (db *ChunkDB) SetGroupCommit(enabled bool) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.groupCommit = enabled
+}
+
+////////// HELPERS //////////
+
+// Sync the entries appended before the given number of syncs had finished, unless a sync has finished since.
+// Assumes no lock is held.
+func (db *ChunkDB) commit(syncs uint64) error {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	// Syncing only needs a read lock, so the sync lock must be held to read the count.
+	db.slock.Lock()
+	synced := db.syncs != syncs
+	db.slock.Unlock()
+	if synced {
+		return nil
+	}
+	if db.closed {
+		return ErrClosed
+	}
+	err := db.sync()
+	db.countError(err)
+	return db.checked("append", err)
+}
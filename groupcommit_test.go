@@ -0,0 +1,47 @@
+package logdb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestGroupCommit(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "group_commit", chunkSize).(*ChunkDB)
+	assert.Nil(t, db.SetSync(0), "expected no error in set sync")
+	db.SetGroupCommit(true)
+
+	// Hold up the first sync until every append has been made, so that one sync covers them all.
+	const writers = 8
+	syncs := db.syncs
+	db.commitLock.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Append([]byte("grouped"))
+			assert.Nil(t, err, "expected no error in append")
+		}()
+	}
+	appended := func() bool {
+		db.rwlock.RLock()
+		defer db.rwlock.RUnlock()
+		return db.newest == writers
+	}
+	for !appended() {
+		time.Sleep(time.Millisecond)
+	}
+	db.commitLock.Unlock()
+	wg.Wait()
+
+	assert.Equal(t, syncs+1, db.syncs, "expected one sync for every append")
+	assert.Equal(t, uint64(0), db.sinceLastSync, "expected every append to be synced")
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["chunkdb"], false, "group_commit", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(writers), db.NewestID(), "expected every entry to persist")
+}
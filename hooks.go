@@ -0,0 +1,61 @@
+package logdb
+
+// ChunkHooks are functions called as the chunk files of a database change state, so that other systems (such
+// as archiving or monitoring) can react without polling the database directory. Any hook may be nil.
+//
+// Hooks are called synchronously, with any database locks held, so they must not use the database, and should
+// return quickly.
+type ChunkHooks struct {
+	// Created is called after the files for a new chunk have been created, just before the first entry is
+	// written to it.
+	Created func(ChunkInfo)
+
+	// Sealed is called when a chunk stops being the chunk which entries are appended to, after it has been
+	// synced for the last time. A sealed chunk is never written to again unless a 'Rollback' or 'Truncate'
+	// removes all of the entries in the chunks after it.
+	Sealed func(ChunkInfo)
+
+	// Opened is called when an existing chunk is opened along with its database. This is only possible if the
+	// hooks are given to 'OpenWithOptions'.
+	Opened func(ChunkInfo)
+
+	// Deleted is called after the files for a chunk have been deleted, as a result of 'Forget', 'Rollback',
+	// or 'Truncate'.
+	Deleted func(ChunkInfo)
+}
+
+// ChunkInfo describes a chunk, for the 'ChunkHooks'.
+type ChunkInfo struct {
+	// Paths to the data and metadata files.
+	DataFilePath string
+	MetaFilePath string
+
+	// ID of the oldest entry in the chunk, and one past the newest. If the chunk is empty, these are equal.
+	OldestID uint64
+	NextID   uint64
+}
+
+// SetChunkHooks is the thread-safe version of 'LockFreeChunkDB.SetChunkHooks'.
+func (db *ChunkDB) SetChunkHooks(hooks ChunkHooks) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetChunkHooks(hooks)
+}
+
+// SetChunkHooks replaces the hooks called as chunk files change state.
+func (db *LockFreeChunkDB) SetChunkHooks(hooks ChunkHooks) {
+	db.hooks = hooks
+}
+
+////////// HELPERS //////////
+
+// Describe a chunk for the hooks.
+func (c *chunk) info() ChunkInfo {
+	return ChunkInfo{
+		DataFilePath: c.path,
+		MetaFilePath: c.metaFilePath(),
+		OldestID:     c.oldest,
+		NextID:       c.next(),
+	}
+}
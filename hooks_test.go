@@ -0,0 +1,54 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestHooks_Lifecycle(t *testing.T) {
+	_ = os.RemoveAll("test_db/hooks_lifecycle")
+
+	var created, sealed, opened, deleted []ChunkInfo
+	hooks := ChunkHooks{
+		Created: func(ci ChunkInfo) { created = append(created, ci) },
+		Sealed:  func(ci ChunkInfo) { sealed = append(sealed, ci) },
+		Opened:  func(ci ChunkInfo) { opened = append(opened, ci) },
+		Deleted: func(ci ChunkInfo) { deleted = append(deleted, ci) },
+	}
+
+	db, err := OpenWithOptions("test_db/hooks_lifecycle", WithChunkSize(chunkSize), WithCreate(true), WithChunkHooks(hooks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filldb(t, db, numEntries)
+
+	numChunks := len(db.chunks)
+	assert.Equal(t, numChunks, len(created), "expected every chunk to be created")
+	assert.Equal(t, numChunks-1, len(sealed), "expected every chunk but the last to be sealed")
+	assert.Equal(t, "test_db/hooks_lifecycle/"+initialChunkFile, created[0].DataFilePath, "expected first chunk path")
+	assert.Equal(t, uint64(1), sealed[0].OldestID, "expected first chunk oldest ID")
+	assert.Equal(t, created[1].OldestID, sealed[0].NextID, "expected sealed chunk to end where the next begins")
+	assert.Equal(t, 0, len(opened), "expected no chunks to be opened")
+
+	assertForget(t, db, 100)
+	assert.True(t, len(deleted) > 0, "expected chunks to be deleted")
+	// Chunks are deleted newest-first.
+	assert.Equal(t, created[0].DataFilePath, deleted[len(deleted)-1].DataFilePath, "expected first chunk to be deleted")
+	assert.Equal(t, sealed[0], deleted[len(deleted)-1], "expected deleted chunk to be as it was sealed")
+	numChunks = len(db.chunks)
+	assertClose(t, db)
+
+	db, err = OpenWithOptions("test_db/hooks_lifecycle", WithChunkHooks(hooks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, db)
+	assert.Equal(t, numChunks, len(opened), "expected every chunk to be opened")
+}
+
+func TestHooks_OpenWithOptionsMissing(t *testing.T) {
+	_, err := OpenWithOptions("test_db/hooks_open_missing")
+	assert.Equal(t, ErrPathDoesntExist, err, "expected database to not be created by default")
+}
@@ -0,0 +1,241 @@
+package logdb
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Name of the directory chunk files are staged in while being imported, and of the file marking that all the
+// files have been staged.
+const (
+	importDir    = "import"
+	importCommit = "commit"
+)
+
+// ImportChunks adopts externally-built chunk files into the database. See 'LockFreeChunkDB.ImportChunks' for
+// details.
+func (db *ChunkDB) ImportChunks(paths []string) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.ImportChunks(paths)
+}
+
+// ImportChunks adopts externally-built chunk files into the database, appending their entries after the newest
+// entry. This is much faster than appending the entries one at a time.
+//
+// Each path is to a chunk data file, which must be accompanied by its metadata file, as written by a
+// 'ChunkWriter' or by a database with the same chunk size. The oldest entry IDs in the file names must continue
+// on from the newest entry in the database, and from each other. The files are copied, and the originals left
+// untouched.
+//
+// The import is atomic: the files are copied into a staging directory, and only moved into place once all have
+// been copied. If the process dies part-way through moving the files, the import is completed when the
+// database is next opened.
+//
+// Returns a 'ChunkFileNameError', 'ChunkSizeError', 'ChunkEntriesError', 'ChunkContinuityError', 'ChunkMetaError',
+// or 'FormatError' value if a chunk is malformed, in which case nothing is imported; and a 'WriteError' value if
+// the files could not be copied.
+func (db *LockFreeChunkDB) ImportChunks(paths []string) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// Check the chunks, and work out the names they will have in the database.
+	names, err := db.importNames(paths)
+	if err != nil {
+		return err
+	}
+
+	// The current final chunk will not be written to again, so sync and seal it.
+	if err := db.sync(); err != nil {
+		return err
+	}
+	if len(db.chunks) > 0 {
+		if err := db.chunks[len(db.chunks)-1].seal(); err != nil {
+			return &SyncError{err}
+		}
+	}
+
+	// Stage the files, and then commit.
+	stage := db.path + "/" + importDir
+	if err := os.RemoveAll(stage); err != nil {
+		return &WriteError{err}
+	}
+	if err := os.Mkdir(stage, 0755); err != nil {
+		return &WriteError{err}
+	}
+	for i, path := range paths {
+		if err := copyFile(path, stage+"/"+names[i]); err != nil {
+			_ = os.RemoveAll(stage)
+			return &WriteError{err}
+		}
+		if err := copyFile(metaFilePath(path), metaFilePath(stage+"/"+names[i])); err != nil {
+			_ = os.RemoveAll(stage)
+			return &WriteError{err}
+		}
+	}
+	if err := writeFile(stage+"/"+importCommit, uint32(len(paths))); err != nil {
+		_ = os.RemoveAll(stage)
+		return &WriteError{err}
+	}
+	if err := finishImport(db.path); err != nil {
+		return &WriteError{err}
+	}
+
+	// Finally, open the new chunks.
+	for i, name := range names {
+		dir := chunkDir(db.path, name)
+		fi, err := os.Stat(dir + "/" + name)
+		if err != nil {
+			return &ReadError{err}
+		}
+		var prior *chunk
+		if len(db.chunks) > 0 {
+			prior = db.chunks[len(db.chunks)-1]
+		}
+		c, err := openChunkFile(dir, fi, prior, db.chunkSize)
+		if err != nil {
+			return err
+		}
+		if i < len(names)-1 {
+			if err := c.seal(); err != nil {
+				return &SyncError{err}
+			}
+		}
+		db.chunks = append(db.chunks, &c)
+		if db.hooks.Created != nil {
+			db.hooks.Created(c.info())
+		}
+	}
+	if db.newest == 0 {
+		db.oldest = db.chunks[0].oldest
+	}
+	db.newest = db.next() - 1
+
+	return nil
+}
+
+////////// HELPERS //////////
+
+// Check chunk files to import, returning the names they will have in the database.
+func (db *LockFreeChunkDB) importNames(paths []string) ([]string, error) {
+	var num uint64
+	if len(db.chunks) > 0 {
+		base := filepath.Base(db.chunks[len(db.chunks)-1].path)
+		num, _ = strconv.ParseUint(strings.Split(base, sep)[1], 10, 0)
+		num++
+	}
+
+	names := make([]string, len(paths))
+	next := db.next()
+	for i, path := range paths {
+		base := filepath.Base(path)
+		if !isBasenameChunkDataFile(base) {
+			return nil, &ChunkFileNameError{path}
+		}
+		oldest, _ := strconv.ParseUint(strings.Split(base, sep)[2], 10, 0)
+		if oldest != next {
+			return nil, &ChunkContinuityError{ChunkFilePath: path, Expected: next, Actual: oldest}
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, &ReadError{err}
+		}
+		if fi.Size() != int64(db.chunkSize) {
+			return nil, &ChunkSizeError{ChunkFilePath: path, Expected: db.chunkSize, Actual: uint32(fi.Size())}
+		}
+
+		mfile, err := os.Open(metaFilePath(path))
+		if err != nil {
+			return nil, &ReadError{err}
+		}
+		ends, _, err := readMetadata(mfile)
+		_ = mfile.Close()
+		if err != nil {
+			return nil, &ChunkMetaError{ChunkFilePath: path, Err: err}
+		}
+		if len(ends) == 0 {
+			return nil, &FormatError{FilePath: metaFilePath(path), Err: ErrEmptyNonfinalChunk}
+		}
+		if db.chunkFull(len(ends) - 1) {
+			limit := uint32(maxChunkEntries)
+			if db.chunkEntries > 0 && db.chunkEntries < limit {
+				limit = db.chunkEntries
+			}
+			return nil, &ChunkEntriesError{ChunkFilePath: path, Limit: limit, Actual: uint32(len(ends))}
+		}
+		if last := ends[len(ends)-1]; last > int32(db.chunkSize) {
+			return nil, &ChunkMetaError{ChunkFilePath: path, Err: &MetaOffsetError{Expected: int32(db.chunkSize), Actual: last}}
+		}
+
+		names[i] = fmt.Sprintf("%s%s%v%s%v", chunkPrefix, sep, num, sep, oldest)
+		num++
+		next += uint64(len(ends))
+	}
+
+	return names, nil
+}
+
+// Complete or abandon an import. If the staging directory has a commit file, the staged files are moved into
+// the database directory; otherwise they are deleted. This is safe to call if there is no import.
+func finishImport(path string) error {
+	stage := path + "/" + importDir
+	if _, err := os.Stat(stage + "/" + importCommit); err != nil {
+		return os.RemoveAll(stage)
+	}
+
+	fis, err := ioutil.ReadDir(stage)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.Name() == importCommit {
+			continue
+		}
+		// Metadata files go in the same directory as their data files.
+		dir := chunkDir(path, dataFilePath(fi.Name()))
+		if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+			return err
+		}
+		if err := os.Rename(stage+"/"+fi.Name(), dir+"/"+fi.Name()); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(stage + "/" + importCommit); err != nil {
+		return err
+	}
+	return os.Remove(stage)
+}
+
+// Copy a file, syncing the copy to disk.
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return fsync(out)
+}
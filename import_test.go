@@ -0,0 +1,102 @@
+package logdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestImport_Chunks(t *testing.T) {
+	vs, paths := buildImportChunks(t, "import_chunks_src")
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "import_chunks", chunkSize).(*LockFreeChunkDB)
+	assert.Nil(t, db.ImportChunks(paths), "expected no error in import")
+	assert.Equal(t, uint64(1), db.OldestID(), "expected oldest entry to be imported")
+	assert.Equal(t, uint64(len(vs)), db.NewestID(), "expected newest entry to be imported")
+
+	id := assertAppend(t, db, []byte("after import"))
+	assert.Equal(t, uint64(len(vs)+1), id, "expected appends to follow the imported entries")
+	assertClose(t, db)
+
+	_, err := os.Stat("test_db/import_chunks/" + importDir)
+	assert.True(t, os.IsNotExist(err), "expected staging directory to be removed")
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "import_chunks", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected imported entry to persist")
+	}
+	assert.Nil(t, db.Verify(), "expected no problems after import")
+}
+
+func TestImport_NoDiscontinuity(t *testing.T) {
+	_, paths := buildImportChunks(t, "import_no_discontinuity_src")
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "import_no_discontinuity", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assertAppend(t, db, []byte("existing"))
+
+	err := db.ImportChunks(paths)
+	assert.True(t, errors.As(err, new(*ChunkContinuityError)), "expected continuity error, got: %s", err)
+	assert.Equal(t, uint64(1), db.NewestID(), "expected nothing to be imported")
+}
+
+func TestImport_RecoverCommitted(t *testing.T) {
+	vs, paths := buildImportChunks(t, "import_recover_committed_src")
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "import_recover_committed", chunkSize)
+	assertClose(t, db)
+
+	// Simulate dying after staging and committing, but before moving the files into place.
+	stage := "test_db/import_recover_committed/" + importDir
+	if err := os.Mkdir(stage, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if err := copyFile(path, stage+"/"+filepath.Base(path)); err != nil {
+			t.Fatal(err)
+		}
+		if err := copyFile(metaFilePath(path), metaFilePath(stage+"/"+filepath.Base(path))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeFile(stage+"/"+importCommit, uint32(len(paths))); err != nil {
+		t.Fatal(err)
+	}
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "import_recover_committed", chunkSize)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(len(vs)), db.NewestID(), "expected import to be completed")
+}
+
+////////// HELPERS //////////
+
+// Build a database to import the chunk files of, returning the entries and chunk data file paths.
+func buildImportChunks(t *testing.T, testName string) ([][]byte, []string) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, testName, chunkSize)
+	vs := filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	fis, err := ioutil.ReadDir("test_db/" + testName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunkFiles []os.FileInfo
+	for _, fi := range fis {
+		if isBasenameChunkDataFile(fi.Name()) {
+			chunkFiles = append(chunkFiles, fi)
+		}
+	}
+	sort.Sort(fileInfoSlice(chunkFiles))
+
+	paths := make([]string, len(chunkFiles))
+	for i, fi := range chunkFiles {
+		paths[i] = "test_db/" + testName + "/" + fi.Name()
+	}
+	return vs, paths
+}
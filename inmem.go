@@ -52,6 +52,29 @@ func (db *InMemDB) Get(id uint64) ([]byte, error) {
 	return db.entries[id], nil
 }
 
+// GetEntries gets the entries from 'start' to 'end', inclusive, as 'LockFreeChunkDB.GetEntries' does.
+func (db *InMemDB) GetEntries(start, end uint64) ([][]byte, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	if db.oldest == 0 || start < db.oldest || end > db.newest || end < start {
+		return nil, ErrIDOutOfRange
+	}
+
+	out := make([][]byte, 0, end-start+1)
+	for id := start; id <= end; id++ {
+		out = append(out, db.entries[id])
+	}
+	return out, nil
+}
+
+// StoredSize implements the 'SizedDB' interface. Entries are stored unchanged, so this is the length of the
+// entry.
+func (db *InMemDB) StoredSize(id uint64) (uint64, error) {
+	bs, err := db.Get(id)
+	return uint64(len(bs)), err
+}
+
 // Forget implements the 'LogDB' interface.
 func (db *InMemDB) Forget(newOldestID uint64) error {
 	db.rwlock.Lock()
@@ -84,11 +107,17 @@ func (db *InMemDB) Truncate(newOldestID, newNewestID uint64) error {
 
 // OldestID implements the 'LogDB' interface.
 func (db *InMemDB) OldestID() uint64 {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
 	return db.oldest
 }
 
 // NewestID implements the 'LogDB' interface.
 func (db *InMemDB) NewestID() uint64 {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
 	return db.newest
 }
 
@@ -0,0 +1,127 @@
+package logdb
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
+// A TruncateToken identifies a truncation prepared by 'PrepareTruncate'.
+type TruncateToken uint64
+
+// SetTruncateLimit is the thread-safe version of 'LockFreeChunkDB.SetTruncateLimit'.
+func (db *ChunkDB) SetTruncateLimit(entries uint64) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetTruncateLimit(entries)
+}
+
+// SetTruncateLimit configures a safety interlock against destroying large parts of the log by mistake. Any
+// 'Forget', 'Rollback', or 'Truncate' (or their variants) which would remove more than this many entries is
+// refused with 'ErrNeedsConfirmation', and must instead be done in two steps: 'PrepareTruncate' followed by
+// 'ConfirmTruncate'. 0, the default, disables the interlock.
+//
+// This does not persist when the database is closed.
+func (db *LockFreeChunkDB) SetTruncateLimit(entries uint64) {
+	db.truncateLimit = entries
+}
+
+// PrepareTruncate is the thread-safe version of 'LockFreeChunkDB.PrepareTruncate'.
+func (db *ChunkDB) PrepareTruncate(newOldestID, newNewestID uint64) (TruncateToken, error) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.PrepareTruncate(newOldestID, newNewestID)
+}
+
+// PrepareTruncate is the first step of a 'Truncate' which exceeds the truncation limit. Nothing is removed:
+// instead a token is returned, which must be given to 'ConfirmTruncate' to perform the truncation. Only the most
+// recently prepared truncation can be confirmed, and only if the database has not been changed in the meantime.
+//
+// Returns 'ErrIDOutOfRange' if the new newest ID is older than the new oldest ID, and 'ErrClosed' if the handle
+// is closed.
+func (db *LockFreeChunkDB) PrepareTruncate(newOldestID, newNewestID uint64) (TruncateToken, error) {
+	if db.closed {
+		return 0, ErrClosed
+	}
+	if newNewestID < newOldestID {
+		return 0, ErrIDOutOfRange
+	}
+
+	var bs [8]byte
+	if _, err := rand.Read(bs[:]); err != nil {
+		return 0, err
+	}
+	db.pendingTruncate = &pendingTruncate{
+		token:       TruncateToken(binary.LittleEndian.Uint64(bs[:])),
+		newOldestID: newOldestID,
+		newNewestID: newNewestID,
+		oldest:      db.oldest,
+		newest:      db.newest,
+	}
+	return db.pendingTruncate.token, nil
+}
+
+// ConfirmTruncate is the thread-safe version of 'LockFreeChunkDB.ConfirmTruncate'.
+func (db *ChunkDB) ConfirmTruncate(token TruncateToken) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.ConfirmTruncate(token)
+}
+
+// ConfirmTruncate performs a truncation prepared by 'PrepareTruncate', regardless of the truncation limit. The
+// commit point still applies.
+//
+// Returns 'ErrBadToken' if the token is not that of the most recently prepared truncation, or if the database
+// has changed since it was prepared, and the same errors as 'Truncate'.
+func (db *LockFreeChunkDB) ConfirmTruncate(token TruncateToken) error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	p := db.pendingTruncate
+	if p == nil || p.token != token || p.oldest != db.oldest || p.newest != db.newest {
+		return ErrBadToken
+	}
+	db.pendingTruncate = nil
+
+	defer db.observe(nil, time.Now(), "truncate", p.newOldestID, p.newNewestID, db.path)
+	defer func() { db.newest = db.next() - 1 }()
+	return db.checked("truncate", db.truncate(p.newOldestID, p.newNewestID))
+}
+
+////////// HELPERS //////////
+
+// A truncation awaiting confirmation, and the oldest and newest IDs when it was prepared.
+type pendingTruncate struct {
+	token       TruncateToken
+	newOldestID uint64
+	newNewestID uint64
+	oldest      uint64
+	newest      uint64
+}
+
+// Check whether removing entries so that 'newOldestID' and 'newNewestID' are the oldest and newest needs
+// confirmation. Assumes a read lock is held.
+func (db *LockFreeChunkDB) checkInterlock(newOldestID, newNewestID uint64) error {
+	if db.truncateLimit == 0 {
+		return nil
+	}
+
+	var removed uint64
+	if newOldestID > db.oldest {
+		removed += newOldestID - db.oldest
+	}
+	if newNewestID < db.newest {
+		removed += db.newest - newNewestID
+	}
+	if removed > db.truncateLimit {
+		return ErrNeedsConfirmation
+	}
+	return nil
+}
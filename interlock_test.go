@@ -0,0 +1,35 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestTruncateInterlock(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "truncate_interlock", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	db.SetTruncateLimit(50)
+
+	assertForget(t, db, 40)
+	assert.Equal(t, ErrNeedsConfirmation, db.Forget(100), "expected large forget to need confirmation")
+	assert.Equal(t, ErrNeedsConfirmation, db.Rollback(100), "expected large rollback to need confirmation")
+	assert.Equal(t, ErrNeedsConfirmation, db.Truncate(70, 230), "expected large truncate to need confirmation")
+
+	// A stale token cannot be confirmed.
+	token, err := db.PrepareTruncate(100, 200)
+	assert.Nil(t, err, "expected no error in prepare")
+	assertForget(t, db, 41)
+	assert.Equal(t, ErrBadToken, db.ConfirmTruncate(token), "expected stale token to be rejected")
+	assert.Equal(t, uint64(41), db.OldestID(), "expected nothing to be truncated")
+
+	token, err = db.PrepareTruncate(100, 200)
+	assert.Nil(t, err, "expected no error in prepare")
+	assert.Equal(t, ErrBadToken, db.ConfirmTruncate(token+1), "expected wrong token to be rejected")
+	assert.Nil(t, db.ConfirmTruncate(token), "expected no error in confirm")
+	assert.Equal(t, uint64(100), db.OldestID(), "expected oldest ID to be truncated")
+	assert.Equal(t, uint64(200), db.NewestID(), "expected newest ID to be truncated")
+	assert.Equal(t, ErrBadToken, db.ConfirmTruncate(token), "expected token to be used only once")
+}
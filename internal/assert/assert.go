@@ -0,0 +1,119 @@
+// Package assert provides the handful of test assertions used by the logdb tests, so that the core package
+// does not need any external dependencies, not even for testing.
+//
+// The functions follow the conventions of github.com/stretchr/testify/assert: they report a failure with
+// 'Errorf', return whether the assertion held, and take an optional message and format arguments.
+package assert
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// TestingT is the subset of 'testing.TB' used by the assertions.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+	Helper()
+}
+
+// Equal asserts that two values are equal. Byte slices are compared by contents, so nil and empty are equal.
+func Equal(t TestingT, expected, actual interface{}, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	if objectsAreEqual(expected, actual) {
+		return true
+	}
+	return fail(t, fmt.Sprintf("not equal:\nexpected: %#v\nactual:   %#v", expected, actual), msgAndArgs)
+}
+
+// True asserts that a value is true.
+func True(t TestingT, value bool, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	if value {
+		return true
+	}
+	return fail(t, "should be true", msgAndArgs)
+}
+
+// False asserts that a value is false.
+func False(t TestingT, value bool, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	if !value {
+		return true
+	}
+	return fail(t, "should be false", msgAndArgs)
+}
+
+// Nil asserts that a value is nil, including a typed nil inside an interface.
+func Nil(t TestingT, value interface{}, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	if isNil(value) {
+		return true
+	}
+	return fail(t, fmt.Sprintf("expected nil, got: %#v", value), msgAndArgs)
+}
+
+// NotNil asserts that a value is not nil, including a typed nil inside an interface.
+func NotNil(t TestingT, value interface{}, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	if !isNil(value) {
+		return true
+	}
+	return fail(t, "expected value not to be nil", msgAndArgs)
+}
+
+////////// HELPERS //////////
+
+// Report a failure, with the optional message.
+func fail(t TestingT, failure string, msgAndArgs []interface{}) bool {
+	t.Helper()
+	if msg := message(msgAndArgs); msg != "" {
+		failure += "\nmessage:  " + msg
+	}
+	t.Errorf("%s", failure)
+	return false
+}
+
+// Format the optional message: either a single value, or a format string and arguments.
+func message(msgAndArgs []interface{}) string {
+	switch len(msgAndArgs) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%v", msgAndArgs[0])
+	default:
+		if format, ok := msgAndArgs[0].(string); ok {
+			return fmt.Sprintf(format, msgAndArgs[1:]...)
+		}
+		return fmt.Sprint(msgAndArgs...)
+	}
+}
+
+// Check if two values are equal.
+func objectsAreEqual(expected, actual interface{}) bool {
+	if expected == nil || actual == nil {
+		return expected == actual
+	}
+	exp, ok := expected.([]byte)
+	if !ok {
+		return reflect.DeepEqual(expected, actual)
+	}
+	act, ok := actual.([]byte)
+	if !ok {
+		return false
+	}
+	return bytes.Equal(exp, act)
+}
+
+// Check if a value is nil, or a nil pointer, slice, map, channel, function, or interface.
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package logdb
+
+import "fmt"
+
+// An InvariantError is reported when a check enabled with 'SetInvariantChecks' finds that the database is in a
+// state it should never be in: this means there is a bug in logdb, or the database has been changed from
+// outside.
+type InvariantError struct {
+	// The operation after which the problem was found.
+	Op string
+
+	// What is wrong.
+	Invariant string
+}
+
+func (e *InvariantError) Error() string {
+	return fmt.Sprintf("invariant violated after %s: %s", e.Op, e.Invariant)
+}
+
+// PanicOnViolation is a function for 'SetInvariantChecks' which panics with the 'InvariantError' value.
+func PanicOnViolation(err *InvariantError) {
+	panic(err)
+}
+
+// WithInvariantChecks enables invariant checks, as 'SetInvariantChecks' does.
+func WithInvariantChecks(report func(*InvariantError)) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetInvariantChecks(report)
+		return nil
+	})
+}
+
+// SetInvariantChecks is the thread-safe version of 'LockFreeChunkDB.SetInvariantChecks'.
+func (db *ChunkDB) SetInvariantChecks(report func(*InvariantError)) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetInvariantChecks(report)
+}
+
+// SetInvariantChecks enables a debug mode, in which the in-memory state of the database is checked after every
+// successful change ('Append', 'Forget', 'Rollback', 'Truncate', 'Sync', and their variants), and every
+// violation is reported to the given function, which can log it, or panic with 'PanicOnViolation'. This is
+// slow, as every chunk is checked every time: it is meant for tests and for investigating bugs. A nil function
+// disables the checks, which is the default.
+//
+// The invariants are:
+//
+//   - Once there are entries, the oldest ID is at least 1, and at most one past the newest ID.
+//   - Chunks are contiguous, and hold no more entries than fit in a chunk, each with a checksum.
+//   - Only the final chunk is empty, unless it is awaiting deletion.
+//   - Only the oldest chunks are awaiting deletion or deleted, and only if every entry in them is forgotten.
+//   - A chunk goes from active to sealed to deleted: once deleted it stays deleted; while sealed its entries do
+//     not change; and it is only unsealed if a rollback makes it the final chunk again.
+func (db *LockFreeChunkDB) SetInvariantChecks(report func(*InvariantError)) {
+	db.invariants = report
+	db.chunkStates = nil
+}
+
+////////// HELPERS //////////
+
+// The state of a chunk at the last invariant check, to check the transitions since.
+type chunkState struct {
+	sealed  bool
+	removed bool
+	entries int
+}
+
+// Check the invariants (if enabled) and the soft limits after an operation, if it was successful, and return
+// its error. Assumes a lock (read or write) is held.
+func (db *LockFreeChunkDB) checked(op string, err error) error {
+	if err != nil || (db.invariants == nil && !db.softLimits.enabled()) {
+		return err
+	}
+
+	// A sync can run with only a read lock held, so hold the sync lock to stop chunks changing.
+	db.slock.Lock()
+	var violations []string
+	if db.invariants != nil {
+		violations = db.checkInvariants()
+	}
+	db.checkSoftLimits()
+	db.slock.Unlock()
+	for _, violation := range violations {
+		db.invariants(&InvariantError{Op: op, Invariant: violation})
+	}
+	return nil
+}
+
+// Check the invariants, returning a description of each violation. Assumes a lock (read or write) is held.
+func (db *LockFreeChunkDB) checkInvariants() []string {
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	// The cached newest ID is only updated once an operation has finished, so use the chunks.
+	next := db.next()
+	if len(db.chunks) > 0 && (db.oldest < 1 || db.oldest > next) {
+		violate("oldest ID %v not in range [1,%v]", db.oldest, next)
+	}
+
+	states := make(map[*chunk]chunkState, len(db.chunks))
+	deleting := true
+	for i, c := range db.chunks {
+		final := i == len(db.chunks)-1
+		if i > 0 && c.oldest != db.chunks[i-1].next() {
+			violate("chunk %s starts at %v, but the chunk before ends at %v", c.path, c.oldest, db.chunks[i-1].next())
+		}
+		if len(c.sums) != len(c.ends) {
+			violate("chunk %s has %v entries, but %v checksums", c.path, len(c.ends), len(c.sums))
+		}
+		for j := 1; j < len(c.ends); j++ {
+			if c.ends[j] < c.ends[j-1] {
+				violate("chunk %s entry %v ends before the entry before it", c.path, c.oldest+uint64(j))
+				break
+			}
+		}
+		if len(c.ends) > 0 && uint32(c.ends[len(c.ends)-1]) > db.chunkSize {
+			violate("chunk %s entries end at %v, past the chunk size %v", c.path, c.ends[len(c.ends)-1], db.chunkSize)
+		}
+		if db.chunkEntries > 0 && uint32(len(c.ends)) > db.chunkEntries {
+			violate("chunk %s has %v entries, more than the limit of %v", c.path, len(c.ends), db.chunkEntries)
+		}
+		if !final && !c.delete && len(c.ends) == 0 {
+			violate("non-final chunk %s is empty", c.path)
+		}
+
+		if c.removed && !c.delete {
+			violate("chunk %s is deleted without being marked for deletion", c.path)
+		}
+		if c.delete && !deleting {
+			violate("chunk %s is marked for deletion after a chunk which is not", c.path)
+		}
+		if c.delete && c.next() > db.oldest {
+			violate("chunk %s is marked for deletion, but entry %v is not forgotten", c.path, c.next()-1)
+		}
+		deleting = deleting && c.delete
+
+		state := chunkState{sealed: c.sealed, removed: c.removed, entries: len(c.ends)}
+		if prev, ok := db.chunkStates[c]; ok {
+			if prev.removed && !state.removed {
+				violate("chunk %s was deleted, but is not now", c.path)
+			}
+			if prev.sealed && state.sealed && prev.entries != state.entries {
+				violate("sealed chunk %s had %v entries, but now has %v", c.path, prev.entries, state.entries)
+			}
+			if prev.sealed && !state.sealed && !final {
+				violate("chunk %s was unsealed, but is not the final chunk", c.path)
+			}
+		}
+		states[c] = state
+	}
+	db.chunkStates = states
+
+	return violations
+}
@@ -0,0 +1,54 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestInvariants_Hold(t *testing.T) {
+	var violations []*InvariantError
+	_ = os.RemoveAll("test_db/invariants_hold")
+	lfdb, err := OpenWithOptions("test_db/invariants_hold", WithCreate(true), WithChunkSize(chunkSize),
+		WithInvariantChecks(func(err *InvariantError) { violations = append(violations, err) }))
+	assert.Nil(t, err, "expected no error in open")
+	db := WrapForConcurrency(lfdb)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	assertForget(t, db, 50)
+	assertRollback(t, db, 200)
+	assertSync(t, db)
+	assert.Nil(t, db.Truncate(100, 150), "expected no error in truncate")
+	assertAppend(t, db, []byte("after truncate"))
+	assertSync(t, db)
+
+	assert.Equal(t, 0, len(violations), "expected no invariant violations, got %v", violations)
+}
+
+func TestInvariants_Violated(t *testing.T) {
+	var violations []*InvariantError
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "invariants_violated", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	db.SetInvariantChecks(func(err *InvariantError) { violations = append(violations, err) })
+
+	filldb(t, db, numEntries)
+	assert.Equal(t, 0, len(violations), "expected no invariant violations, got %v", violations)
+
+	// A sealed chunk which changes.
+	c := db.chunks[1]
+	c.ends = c.ends[:len(c.ends)-1]
+	c.sums = c.sums[:len(c.ends)]
+	assertSync(t, db)
+	assert.True(t, len(violations) > 0, "expected invariant violations")
+	assert.Equal(t, "sync", violations[0].Op, "expected violation after sync")
+
+	// With 'PanicOnViolation', the violation panics.
+	db.SetInvariantChecks(PanicOnViolation)
+	defer func() {
+		_, ok := recover().(*InvariantError)
+		assert.True(t, ok, "expected panic with an 'InvariantError'")
+	}()
+	_ = db.Sync()
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"syscall"
+	"unsafe"
 )
 
 // Create a new file with 0644 permissions and the given size, truncating it if it already exists.
@@ -15,35 +16,75 @@ func createFile(path string, size uint32) error {
 	}
 	defer file.Close()
 
-	return syscall.Ftruncate(int(file.Fd()), int64(size))
+	if err := syscall.Ftruncate(int(file.Fd()), int64(size)); err != nil {
+		return err
+	}
+	// Reserve the space now, so that running out of disk is an error here rather than a SIGBUS when the
+	// mapping is written to.
+	if size == 0 {
+		return nil
+	}
+	if err := allocate(file, int64(size)); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
 }
 
+// Reserve space for a file. This is a variable so that tests can simulate a full disk.
+var allocate = preallocate
+
 // Write the given value to the file using little-endian byte order. If the file doesn't exist, it is created.
 // If the file does exist, it is truncated. The contents of the file are synced to disk after the write.
 func writeFile(path string, data interface{}) error {
-	return openAndWriteFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, data)
+	return writeFileWith(path, data, fsync)
+}
+
+// Write the given value to the file as 'writeFile' does, but flush it with the given function.
+func writeFileWith(path string, data interface{}, flush func(*os.File) error) error {
+	return openAndWriteFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, data, flush)
 }
 
 // Append the given value to the file using little-endian byte order. If the file doesn't exist, it is created.
 // The contents of the file are synced to disk after the write.
 func appendFile(path string, data interface{}) error {
-	return openAndWriteFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, data)
+	return appendFileWith(path, data, fsync)
+}
+
+// Append the given value to the file as 'appendFile' does, but flush it with the given function.
+func appendFileWith(path string, data interface{}, flush func(*os.File) error) error {
+	return openAndWriteFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, data, flush)
 }
 
 // Open a file with the given flags and write the given data to it in little-endian byte order. The contents of
-// the file are synced to disk after the write.
-func openAndWriteFile(path string, flags int, data interface{}) error {
+// the file are flushed with the given function after the write.
+func openAndWriteFile(path string, flags int, data interface{}, flush func(*os.File) error) error {
 	file, err := os.OpenFile(path, flags, 0644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	if err := binary.Write(file, binary.LittleEndian, data); err != nil {
+	// Byte slices are written directly, as 'binary.Write' would copy them.
+	if bs, ok := data.([]byte); ok {
+		if _, err := file.Write(bs); err != nil {
+			return err
+		}
+	} else if err := binary.Write(file, binary.LittleEndian, data); err != nil {
 		return err
 	}
 
-	return fsync(file)
+	return flush(file)
+}
+
+// Flush a directory, so that files created, renamed, or deleted in it stay that way.
+func fsyncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
 }
 
 // Read data into the given pointer from the file using little-endian byte order.
@@ -57,8 +98,9 @@ func readFile(path string, data interface{}) error {
 	return binary.Read(file, binary.LittleEndian, data)
 }
 
-// Memory-map the given file.
-func mmap(path string) (*os.File, []byte, error) {
+// Memory-map the given file. If 'writable' is false, the file is opened and mapped read-only. The file is
+// returned even if mapping it fails, unless it could not be opened.
+func mmap(path string, writable bool) (*os.File, []byte, error) {
 	fi, err := os.Stat(path)
 	if err != nil {
 		return nil, nil, err
@@ -68,15 +110,35 @@ func mmap(path string) (*os.File, []byte, error) {
 		return nil, nil, errors.New("tried to mmap a directory")
 	}
 
-	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	flags, prot := os.O_RDWR, syscall.PROT_READ|syscall.PROT_WRITE
+	if !writable {
+		flags, prot = os.O_RDONLY, syscall.PROT_READ
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := failpoint(FailpointMmap); err != nil {
+		return f, nil, err
+	}
 
-	bytes, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	bytes, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), prot, syscall.MAP_SHARED)
 	return f, bytes, err
 }
 
+// Synchronously flush a memory-mapped region to disk.
+func msyncBytes(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(sysMsync, uintptr(unsafe.Pointer(&bytes[0])), uintptr(len(bytes)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
 // Close and delete a file.
 func closeAndRemove(file *os.File) error {
 	if err := file.Close(); err != nil {
@@ -94,6 +156,19 @@ func flock(path string) (*os.File, error) {
 	return f, syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
 }
 
+// Take a shared lock on a file, creating it if it does not exist.
+func flockShared(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
 // Unlock and close a file.
 func funlock(file *os.File) error {
 	// No need to do a flock(LOCK_UN) call, as closing the fd also releases the lock.
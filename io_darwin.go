@@ -0,0 +1,36 @@
+package logdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// Synchronise writes to a file descriptor, and flush the drive cache. Plain fsync on macOS only hands the data
+// to the drive, so 'F_FULLFSYNC' is needed for it to survive power loss. Some filesystems do not support it, in
+// which case this falls back to fsync.
+func fsync(file *os.File) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), syscall.F_FULLFSYNC, 0); errno == 0 {
+		return nil
+	}
+	return file.Sync()
+}
+
+// Synchronise writes to a file descriptor, and flush the drive cache. 'fsync' already does this.
+func fullFsync(file *os.File) error {
+	return fsync(file)
+}
+
+// The flag to open a file with for each write to be synchronised. Unlike fsync, this does not flush the drive
+// cache.
+const dsyncFlag = syscall.O_DSYNC
+
+// Synchronise writes to a file descriptor, without flushing the drive cache.
+func relaxedFsync(file *os.File) error {
+	return file.Sync()
+}
+
+// Allocate disk space for the first 'size' bytes of a file. This is only supported on Linux; elsewhere, running
+// out of disk space may not be detected until the file is written to.
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !netbsd
+// +build !netbsd
+
+package logdb
+
+import "syscall"
+
+// The msync system call number.
+const sysMsync = syscall.SYS_MSYNC
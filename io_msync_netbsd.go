@@ -0,0 +1,4 @@
+package logdb
+
+// The msync system call number. NetBSD calls it __msync13, which the syscall package does not name.
+const sysMsync = 277
@@ -1,3 +1,4 @@
+//go:build linux
 // +build linux
 
 package logdb
@@ -8,7 +9,35 @@ import (
 )
 
 // Synchronise writes to a file descriptor.
+//
+// This uses fdatasync, which skips metadata such as the modification time, but not metadata which is needed to
+// read the data back, such as the file length. Chunk data files have their full length from creation, so their
+// length never needs flushing after the first sync, and files which are appended to have their new length
+// flushed as part of the same call. There is therefore no need to track length changes and fall back to fsync.
 func fsync(file *os.File) error {
 	fd := int(file.Fd())
 	return syscall.Fdatasync(fd)
 }
+
+// Synchronise writes to a file descriptor, including all metadata, such as the modification time.
+func fullFsync(file *os.File) error {
+	return file.Sync()
+}
+
+// The flag to open a file with for each write to be synchronised, as with fdatasync.
+const dsyncFlag = syscall.O_DSYNC
+
+// Allocate disk space for the first 'size' bytes of a file.
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
+
+// Synchronise writes to a file descriptor, without necessarily flushing the drive cache. On Linux, 'fsync'
+// already does no more than this.
+func relaxedFsync(file *os.File) error {
+	return fsync(file)
+}
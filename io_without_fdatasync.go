@@ -1,4 +1,5 @@
-// +build !linux
+//go:build !linux && !darwin
+// +build !linux,!darwin
 
 package logdb
 
@@ -8,3 +9,23 @@ import "os"
 func fsync(file *os.File) error {
 	return file.Sync()
 }
+
+// Synchronise writes to a file descriptor, including all metadata. 'fsync' already does this.
+func fullFsync(file *os.File) error {
+	return fsync(file)
+}
+
+// The flag to open a file with for each write to be synchronised. O_DSYNC is not available everywhere, so this
+// uses the stronger O_SYNC.
+const dsyncFlag = os.O_SYNC
+
+// Allocate disk space for the first 'size' bytes of a file. This is only supported on Linux; elsewhere, running
+// out of disk space may not be detected until the file is written to.
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
+
+// Synchronise writes to a file descriptor, without necessarily flushing the drive cache.
+func relaxedFsync(file *os.File) error {
+	return file.Sync()
+}
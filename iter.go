@@ -0,0 +1,270 @@
+package logdb
+
+import (
+	"sync"
+	"time"
+)
+
+// Scan gets an iterator over the entries of any 'LogDB' from 'start' to 'end', inclusive. If the database is an
+// 'IterDB', its 'Scan' is used, otherwise each entry is fetched with 'Get'.
+//
+// Returns 'ErrIDOutOfRange' if either ID is out of range, or if 'end' is less than 'start'.
+func Scan(db LogDB, start, end uint64) (Iterator, error) {
+	if idb, ok := db.(IterDB); ok {
+		return idb.Scan(start, end)
+	}
+	if start > end || start < db.OldestID() || end > db.NewestID() || start == 0 {
+		return nil, ErrIDOutOfRange
+	}
+	return &getIterator{db: db, next: start, end: end}, nil
+}
+
+// Between gets an iterator over the entries of any 'LogDB' from time 't1' up to, but not including, time 't2'.
+// The database does not record when entries were appended, so 'timeOf' gives the time of an entry, such as by
+// decoding a timestamp kept in it. Times must not decrease from one entry to the next, as the first and last
+// entries are found by binary search, which only reads a few entries. If there are no entries in the range,
+// the iterator is empty.
+//
+// Returns any error from 'Get' or 'timeOf' while searching.
+func Between(db LogDB, t1, t2 time.Time, timeOf func(id uint64, entry []byte) (time.Time, error)) (Iterator, error) {
+	oldest, newest := db.OldestID(), db.NewestID()
+	start, err := searchTime(db, oldest, newest, t1, timeOf)
+	if err != nil {
+		return nil, err
+	}
+	end, err := searchTime(db, start, newest, t2, timeOf)
+	if err != nil {
+		return nil, err
+	}
+	return scanOrEmpty(db, start, end-1)
+}
+
+// Since gets an iterator over the entries of any 'LogDB' from the given duration ago up to the newest, such
+// as to replay the last 15 minutes. Entries are found as 'Between' does.
+func Since(db LogDB, d time.Duration, timeOf func(id uint64, entry []byte) (time.Time, error)) (Iterator, error) {
+	oldest, newest := db.OldestID(), db.NewestID()
+	start, err := searchTime(db, oldest, newest, time.Now().Add(-d), timeOf)
+	if err != nil {
+		return nil, err
+	}
+	return scanOrEmpty(db, start, newest)
+}
+
+// Scan implements the 'IterDB' interface. The read lock is only held during each call to 'Next', not for the
+// whole of the iteration, so writers are not blocked.
+func (db *ChunkDB) Scan(start, end uint64) (Iterator, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	it, err := db.LockFreeChunkDB.scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+	it.lock = db.rwlock.RLocker()
+	return it, nil
+}
+
+// Scan implements the 'IterDB' interface. Chunk files are read sequentially, without looking up the chunk for
+// each entry.
+func (db *LockFreeChunkDB) Scan(start, end uint64) (Iterator, error) {
+	return db.scan(start, end)
+}
+
+////////// HELPERS //////////
+
+// Find the first entry from 'lo' to 'hi' with a time not before 't', or 'hi+1' if there is none.
+func searchTime(db LogDB, lo, hi uint64, t time.Time, timeOf func(uint64, []byte) (time.Time, error)) (uint64, error) {
+	if lo == 0 || hi < lo {
+		return hi + 1, nil
+	}
+	end := hi + 1
+	for lo < end {
+		// Entries dropped by 'Downsample' have no time, so use the first entry from the middle which has not
+		// been dropped. If they have all been dropped, the upper half can be skipped.
+		mid := lo + (end-lo)/2
+		id := mid
+		entry, err := db.Get(id)
+		for err == ErrDownsampled && id+1 < end {
+			id++
+			entry, err = db.Get(id)
+		}
+		if err == ErrDownsampled {
+			end = mid
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		et, err := timeOf(id, entry)
+		if err != nil {
+			return 0, err
+		}
+		if et.Before(t) {
+			lo = id + 1
+		} else {
+			end = mid
+		}
+	}
+	return lo, nil
+}
+
+// Get an iterator from 'start' to 'end', which is empty if 'end' is before 'start'.
+func scanOrEmpty(db LogDB, start, end uint64) (Iterator, error) {
+	if start == 0 || end < start {
+		return &getIterator{db: db, next: 1, end: 0}, nil
+	}
+	return Scan(db, start, end)
+}
+
+// Create an iterator over a 'LockFreeChunkDB'. Assumes a lock (read or write) is held.
+func (db *LockFreeChunkDB) scan(start, end uint64) (*chunkIterator, error) {
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if start > end || start < db.oldest || end >= db.next() || len(db.chunks) == 0 {
+		return nil, ErrIDOutOfRange
+	}
+	idx := db.findChunk(start)
+	return &chunkIterator{db: db, next: start, end: end, idx: idx, chunk: db.chunks[idx]}, nil
+}
+
+// An iterator over a 'LockFreeChunkDB', which remembers the chunk it is in.
+type chunkIterator struct {
+	db *LockFreeChunkDB
+
+	// If not nil, held during each call to 'Next'.
+	lock sync.Locker
+
+	// The next ID to read, and the last.
+	next, end uint64
+
+	// The chunk containing 'next', if it has not been removed from the database, and its index.
+	idx   int
+	chunk *chunk
+
+	id    uint64
+	entry []byte
+	err   error
+}
+
+func (it *chunkIterator) Next() bool {
+	it.entry = nil
+	if it.err != nil || it.next > it.end {
+		return false
+	}
+	if it.lock != nil {
+		it.lock.Lock()
+		defer it.lock.Unlock()
+	}
+
+	db := it.db
+	if db.closed {
+		it.err = ErrClosed
+		return false
+	}
+
+	// Entries dropped by 'Downsample' are skipped.
+	for ; it.next <= it.end; it.next++ {
+		if it.next < db.oldest || it.next >= db.next() {
+			it.err = ErrIDOutOfRange
+			return false
+		}
+
+		// Move on to the next chunk if this one is finished. If the chunks have changed since the last call, look
+		// the chunk up again.
+		if it.idx >= len(db.chunks) || db.chunks[it.idx] != it.chunk {
+			it.idx = db.findChunk(it.next)
+		} else if it.next >= it.chunk.next() {
+			it.idx++
+			if it.idx >= len(db.chunks) || db.chunks[it.idx].oldest != it.next {
+				it.idx = db.findChunk(it.next)
+			}
+		}
+		it.chunk = db.chunks[it.idx]
+
+		_, start, end := it.chunk.find(it.next)
+		entry := make([]byte, end-start)
+		if err := it.chunk.readAt(entry, start); err != nil {
+			it.err = err
+			return false
+		}
+		if err := it.chunk.check(it.next, entry); err == ErrDownsampled {
+			continue
+		} else if err != nil {
+			it.err = err
+			return false
+		}
+		it.entry = entry
+		it.id = it.next
+		it.next++
+		return true
+	}
+	return false
+}
+
+func (it *chunkIterator) ID() uint64 {
+	return it.id
+}
+
+func (it *chunkIterator) Entry() []byte {
+	return it.entry
+}
+
+func (it *chunkIterator) Err() error {
+	return it.err
+}
+
+func (it *chunkIterator) Close() error {
+	it.next = it.end + 1
+	it.entry = nil
+	return nil
+}
+
+// An iterator over any 'LogDB', using 'Get'.
+type getIterator struct {
+	db LogDB
+
+	next, end uint64
+
+	id    uint64
+	entry []byte
+	err   error
+}
+
+func (it *getIterator) Next() bool {
+	it.entry = nil
+	if it.err != nil || it.next > it.end {
+		return false
+	}
+	// Entries dropped by 'Downsample' are skipped.
+	for it.entry, it.err = it.db.Get(it.next); it.err == ErrDownsampled; it.entry, it.err = it.db.Get(it.next) {
+		if it.next++; it.next > it.end {
+			it.err = nil
+			return false
+		}
+	}
+	if it.err != nil {
+		return false
+	}
+	it.id = it.next
+	it.next++
+	return true
+}
+
+func (it *getIterator) ID() uint64 {
+	return it.id
+}
+
+func (it *getIterator) Entry() []byte {
+	return it.entry
+}
+
+func (it *getIterator) Err() error {
+	return it.err
+}
+
+func (it *getIterator) Close() error {
+	it.next = it.end + 1
+	it.entry = nil
+	return nil
+}
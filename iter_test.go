@@ -0,0 +1,150 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestScan(t *testing.T) {
+	for dbName, dbType := range dbTypes {
+		t.Logf("Database: %s\n", dbName)
+		func() {
+			db := assertOpen(t, dbType, true, "scan", chunkSize)
+			defer assertClose(t, db)
+
+			vs := filldb(t, db, numEntries)
+			it, err := Scan(db, 10, 200)
+			assert.Nil(t, err, "expected no error in scan")
+			id := uint64(10)
+			for it.Next() {
+				assert.Equal(t, id, it.ID(), "expected IDs in order")
+				assert.Equal(t, vs[id-1], it.Entry(), "expected equal values")
+				id++
+			}
+			assert.Nil(t, it.Err(), "expected no error in iteration")
+			assert.Equal(t, uint64(201), id, "expected every entry in the range")
+
+			_, err = Scan(db, 0, 10)
+			assert.Equal(t, ErrIDOutOfRange, err, "expected start to be checked")
+			_, err = Scan(db, 10, numEntries+1)
+			assert.Equal(t, ErrIDOutOfRange, err, "expected end to be checked")
+			_, err = Scan(db, 20, 10)
+			assert.Equal(t, ErrIDOutOfRange, err, "expected the range to be checked")
+		}()
+	}
+}
+
+func TestScan_Changes(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "scan_changes", chunkSize)
+	defer assertClose(t, db)
+
+	vs := filldb(t, db, numEntries)
+	it, err := Scan(db, 1, numEntries)
+	assert.Nil(t, err, "expected no error in scan")
+	for i := 0; i < 60; i++ {
+		assert.True(t, it.Next(), "expected an entry")
+	}
+
+	// Forgetting entries the iterator has already passed does not affect it.
+	assertForget(t, db, 50)
+	for it.Next() && it.ID() < 90 {
+		assert.Equal(t, vs[it.ID()-1], it.Entry(), "expected equal values after forgetting")
+	}
+	assert.Nil(t, it.Err(), "expected no error after forgetting")
+
+	// Rolling back entries it has not reached stops it.
+	assertRollback(t, db, 100)
+	for it.Next() {
+	}
+	assert.Equal(t, ErrIDOutOfRange, it.Err(), "expected rolled back entries to stop iteration")
+}
+
+func benchScan(b *testing.B, scan bool) {
+	path := "test_db/bench_scan"
+	_ = os.RemoveAll(path)
+	defer os.RemoveAll(path)
+
+	db, err := Open(path, DefaultChunkSize, true)
+	if err != nil {
+		b.Fatal("could not open database:", err)
+	}
+	defer db.Close()
+	entries := make([][]byte, 10000)
+	for i := range entries {
+		entries[i] = make([]byte, 256)
+	}
+	if _, err := db.AppendEntries(entries); err != nil {
+		b.Fatal("could not append:", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !scan {
+			for id := db.OldestID(); id <= db.NewestID(); id++ {
+				if _, err := db.Get(id); err != nil {
+					b.Fatal("could not get:", err)
+				}
+			}
+			continue
+		}
+		it, err := db.Scan(db.OldestID(), db.NewestID())
+		if err != nil {
+			b.Fatal("could not scan:", err)
+		}
+		for it.Next() {
+		}
+		if err := it.Err(); err != nil {
+			b.Fatal("could not scan:", err)
+		}
+	}
+}
+
+func BenchmarkScan_Get(b *testing.B) {
+	benchScan(b, false)
+}
+
+func BenchmarkScan_Iterator(b *testing.B) {
+	benchScan(b, true)
+}
+
+func TestBetween(t *testing.T) {
+	db := &InMemDB{}
+	timeOf := func(_ uint64, entry []byte) (time.Time, error) {
+		return time.Unix(int64(binary.LittleEndian.Uint64(entry)), 0), nil
+	}
+
+	// Entry 'i' is at second 10*i, and one second later.
+	for i := uint64(1); i <= 100; i++ {
+		for _, s := range []uint64{10 * i, 10*i + 1} {
+			entry := make([]byte, 8)
+			binary.LittleEndian.PutUint64(entry, s)
+			assertAppend(t, db, entry)
+		}
+	}
+	ids := func(it Iterator, err error) []uint64 {
+		assert.Nil(t, err, "expected no error in search")
+		var ids []uint64
+		for it.Next() {
+			ids = append(ids, it.ID())
+		}
+		assert.Nil(t, it.Err(), "expected no error in iteration")
+		return ids
+	}
+
+	assert.Equal(t, []uint64{9, 10, 11, 12}, ids(Between(db, time.Unix(50, 0), time.Unix(70, 0), timeOf)), "expected entries from 50s to before 70s")
+	assert.Equal(t, []uint64{10, 11}, ids(Between(db, time.Unix(51, 0), time.Unix(61, 0), timeOf)), "expected entries from 51s to before 61s")
+	assert.Equal(t, 0, len(ids(Between(db, time.Unix(52, 0), time.Unix(60, 0), timeOf))), "expected no entries in a gap")
+	assert.Equal(t, 0, len(ids(Between(db, time.Unix(2000, 0), time.Unix(3000, 0), timeOf))), "expected no entries after the newest")
+	assert.Equal(t, 200, len(ids(Between(db, time.Unix(0, 0), time.Unix(3000, 0), timeOf))), "expected every entry")
+	assert.Equal(t, 0, len(ids(Since(db, time.Minute, timeOf))), "expected no recent entries")
+	assert.Equal(t, 0, len(ids(Since(&InMemDB{}, time.Minute, timeOf))), "expected no entries in an empty database")
+
+	entry := make([]byte, 8)
+	binary.LittleEndian.PutUint64(entry, uint64(time.Now().Unix()))
+	assertAppend(t, db, entry)
+	assert.Equal(t, []uint64{201}, ids(Since(db, time.Minute, timeOf)), "expected the recent entry")
+}
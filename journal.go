@@ -0,0 +1,209 @@
+package logdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A JournalDB wraps a 'LogDB' to record every call made through it (the operation, its arguments, its result,
+// and how long it took) to a separate journal log. 'ReplayJournal' re-executes a journal against another
+// database, such as a fresh one, to reproduce bugs.
+//
+// Calls are serialised, so that the order of the journal is exactly the order in which the calls were made to
+// the underlying 'LogDB'. This makes a 'JournalDB' slower under concurrent use: it is meant for debugging.
+//
+// If the underlying 'LogDB' is also a 'PersistDB' then that interface is also implemented, and recorded.
+type JournalDB struct {
+	LogDB
+
+	// The journal, which each 'JournalRecord' is appended to, gob-encoded.
+	Journal LogDB
+
+	mutex sync.Mutex
+}
+
+// A JournalRecord is one call recorded by a 'JournalDB'.
+type JournalRecord struct {
+	// The method called, such as "Append".
+	Op string
+
+	// The arguments: IDs in 'IDs' and entries in 'Entries', in the order they were given.
+	IDs     []uint64
+	Entries [][]byte
+
+	// The result: an ID, an entry, and the message of the error, if there was one.
+	ID    uint64
+	Entry []byte
+	Err   string
+
+	// When the call began, and how long it took.
+	Began time.Time
+	Took  time.Duration
+}
+
+// JournalMismatchError means that replaying a journal gave a different result to the one recorded.
+type JournalMismatchError struct {
+	// ID of the record in the journal.
+	JournalID uint64
+	Expected  JournalRecord
+	Actual    JournalRecord
+}
+
+func (e *JournalMismatchError) Error() string {
+	return fmt.Sprintf("journal entry %v: %s gave (%v, %q, %q), expected (%v, %q, %q)", e.JournalID, e.Expected.Op, e.Actual.ID, e.Actual.Entry, e.Actual.Err, e.Expected.ID, e.Expected.Entry, e.Expected.Err)
+}
+
+// Journal creates a 'JournalDB'.
+func Journal(logdb, journal LogDB) *JournalDB {
+	return &JournalDB{LogDB: logdb, Journal: journal}
+}
+
+// Append implements the 'LogDB' interface.
+func (db *JournalDB) Append(entry []byte) (uint64, error) {
+	r := db.record(JournalRecord{Op: "Append", Entries: [][]byte{entry}}, db.LogDB)
+	return r.ID, r.err
+}
+
+// AppendEntries implements the 'LogDB' interface.
+func (db *JournalDB) AppendEntries(entries [][]byte) (uint64, error) {
+	r := db.record(JournalRecord{Op: "AppendEntries", Entries: entries}, db.LogDB)
+	return r.ID, r.err
+}
+
+// Get implements the 'LogDB' interface.
+func (db *JournalDB) Get(id uint64) ([]byte, error) {
+	r := db.record(JournalRecord{Op: "Get", IDs: []uint64{id}}, db.LogDB)
+	return r.Entry, r.err
+}
+
+// Forget implements the 'LogDB' interface.
+func (db *JournalDB) Forget(newOldestID uint64) error {
+	return db.record(JournalRecord{Op: "Forget", IDs: []uint64{newOldestID}}, db.LogDB).err
+}
+
+// Rollback implements the 'LogDB' interface.
+func (db *JournalDB) Rollback(newNewestID uint64) error {
+	return db.record(JournalRecord{Op: "Rollback", IDs: []uint64{newNewestID}}, db.LogDB).err
+}
+
+// Truncate implements the 'LogDB' interface.
+func (db *JournalDB) Truncate(newOldestID, newNewestID uint64) error {
+	return db.record(JournalRecord{Op: "Truncate", IDs: []uint64{newOldestID, newNewestID}}, db.LogDB).err
+}
+
+// OldestID implements the 'LogDB' interface.
+func (db *JournalDB) OldestID() uint64 {
+	return db.record(JournalRecord{Op: "OldestID"}, db.LogDB).ID
+}
+
+// NewestID implements the 'LogDB' interface.
+func (db *JournalDB) NewestID() uint64 {
+	return db.record(JournalRecord{Op: "NewestID"}, db.LogDB).ID
+}
+
+// SetSync implements the 'PersistDB' interface. If the underlying 'LogDB' is not a 'PersistDB', this does
+// nothing.
+func (db *JournalDB) SetSync(every int) error {
+	return db.record(JournalRecord{Op: "SetSync", IDs: []uint64{uint64(every)}}, db.LogDB).err
+}
+
+// Sync implements the 'PersistDB' interface. If the underlying 'LogDB' is not a 'PersistDB', this does nothing.
+func (db *JournalDB) Sync() error {
+	return db.record(JournalRecord{Op: "Sync"}, db.LogDB).err
+}
+
+// ReplayJournal re-executes the calls recorded in a journal against a database, in order, checking that each
+// gives the same result as it did originally. Only the results are compared, not the timings.
+//
+// Returns a 'JournalMismatchError' value for the first call which gives a different result, a 'DecodeError'
+// value if a record cannot be decoded, and the same errors as 'Get' if the journal cannot be read.
+func ReplayJournal(journal, db LogDB) error {
+	oldest := journal.OldestID()
+	if oldest == 0 {
+		return nil
+	}
+	for id := oldest; id <= journal.NewestID(); id++ {
+		bs, err := journal.Get(id)
+		if err != nil {
+			return err
+		}
+		var expected JournalRecord
+		if err := gob.NewDecoder(bytes.NewReader(bs)).Decode(&expected); err != nil {
+			return &DecodeError{ID: id, Err: err}
+		}
+
+		actual := execute(JournalRecord{Op: expected.Op, IDs: expected.IDs, Entries: expected.Entries}, db)
+		if actual.ID != expected.ID || !bytes.Equal(actual.Entry, expected.Entry) || actual.Err != expected.Err {
+			return &JournalMismatchError{JournalID: id, Expected: expected, Actual: actual.JournalRecord}
+		}
+	}
+	return nil
+}
+
+////////// HELPERS //////////
+
+// A record along with the actual error, which is not itself recorded.
+type journalResult struct {
+	JournalRecord
+	err error
+}
+
+// Perform a call and append it to the journal. If it cannot be journalled, the error returned by the call is
+// replaced.
+func (db *JournalDB) record(r JournalRecord, logdb LogDB) journalResult {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	res := execute(r, logdb)
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(res.JournalRecord); err != nil {
+		res.err = &EncodeError{Err: err}
+		return res
+	}
+	if _, err := db.Journal.Append(buf.Bytes()); err != nil {
+		res.err = err
+	}
+	return res
+}
+
+// Perform a call, filling in the result.
+func execute(r JournalRecord, db LogDB) journalResult {
+	res := journalResult{JournalRecord: r}
+	res.Began = time.Now()
+	switch r.Op {
+	case "Append":
+		res.ID, res.err = db.Append(r.Entries[0])
+	case "AppendEntries":
+		res.ID, res.err = db.AppendEntries(r.Entries)
+	case "Get":
+		res.Entry, res.err = db.Get(r.IDs[0])
+	case "Forget":
+		res.err = db.Forget(r.IDs[0])
+	case "Rollback":
+		res.err = db.Rollback(r.IDs[0])
+	case "Truncate":
+		res.err = db.Truncate(r.IDs[0], r.IDs[1])
+	case "OldestID":
+		res.ID = db.OldestID()
+	case "NewestID":
+		res.ID = db.NewestID()
+	case "SetSync":
+		if pdb, ok := db.(PersistDB); ok {
+			res.err = pdb.SetSync(int(r.IDs[0]))
+		}
+	case "Sync":
+		if pdb, ok := db.(PersistDB); ok {
+			res.err = pdb.Sync()
+		}
+	default:
+		res.err = fmt.Errorf("unknown journal operation %q", r.Op)
+	}
+	res.Took = time.Since(res.Began)
+	if res.err != nil {
+		res.Err = res.err.Error()
+	}
+	return res
+}
@@ -0,0 +1,29 @@
+package logdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestJournal_Replay(t *testing.T) {
+	journal := &InMemDB{}
+	db := Journal(&InMemDB{}, journal)
+
+	_, err := db.AppendEntries([][]byte{[]byte("one"), []byte("two"), []byte("three")})
+	assert.Nil(t, err, "expected no error in append")
+	assert.Nil(t, db.Forget(2), "expected no error in forget")
+	_, err = db.Get(1)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected out of range error")
+	assert.Equal(t, uint64(3), db.NewestID(), "expected newest ID")
+	assert.Equal(t, uint64(4), journal.NewestID(), "expected every call to be journalled")
+
+	assert.Nil(t, ReplayJournal(journal, &InMemDB{}), "expected replay against a fresh database to match")
+
+	// Replaying against a database in a different state does not match.
+	other := &InMemDB{}
+	_, _ = other.Append([]byte("zero"))
+	err = ReplayJournal(journal, other)
+	assert.True(t, errors.As(err, new(*JournalMismatchError)), "expected mismatch error, got: %s", err)
+}
@@ -0,0 +1,136 @@
+package logdb
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// A Label is a small tag attached to an entry when it is appended, recorded in the chunk metadata alongside the
+// entry rather than in the entry itself, so that it can be read without reading the entry. Labels let one log
+// hold entries with different lifecycles, such as audit entries which are kept forever alongside debug
+// entries which are only kept for a day (see 'SetLabelRetention').
+//
+// Applications define their own labels, as constants of this type. Label 0 is the label of entries appended
+// without one.
+type Label uint8
+
+// AppendLabelled is the thread-safe version of 'LockFreeChunkDB.AppendLabelled'. If 'SetBlockOnNoSpace' has
+// been used, this blocks while the disk is full.
+func (db *ChunkDB) AppendLabelled(label Label, entry []byte) (uint64, error) {
+	return db.appendContext(context.Background(), [][]byte{entry}, label, nil)
+}
+
+// AppendLabelled appends an entry with a label. It is otherwise the same as 'Append'.
+func (db *LockFreeChunkDB) AppendLabelled(label Label, entry []byte) (uint64, error) {
+	return db.appendEntries([][]byte{entry}, label, nil)
+}
+
+// AppendEntriesLabelled is the thread-safe version of 'LockFreeChunkDB.AppendEntriesLabelled'. If
+// 'SetBlockOnNoSpace' has been used, this blocks while the disk is full.
+func (db *ChunkDB) AppendEntriesLabelled(label Label, entries [][]byte) (uint64, error) {
+	return db.appendContext(context.Background(), entries, label, nil)
+}
+
+// AppendEntriesLabelled appends entries which all have the same label. It is otherwise the same as
+// 'AppendEntries'.
+func (db *LockFreeChunkDB) AppendEntriesLabelled(label Label, entries [][]byte) (uint64, error) {
+	return db.appendEntries(entries, label, nil)
+}
+
+// SetLabelRetention is the thread-safe version of 'LockFreeChunkDB.SetLabelRetention'.
+func (db *ChunkDB) SetLabelRetention(rules map[Label]time.Duration) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetLabelRetention(rules)
+}
+
+// SetLabelRetention sets how long entries with each label are kept. When 'Compact' runs, entries which have
+// been kept for longer than the duration for their label are dropped, as 'Downsample' drops entries: their IDs
+// remain, but they can no longer be read. Entries with labels which have no rule are kept until they are
+// forgotten. nil, the default, keeps every entry.
+//
+// How long an entry has been kept is judged by when the chunk it is in was last written to, so entries may be
+// kept for up to as long as it takes to fill a chunk beyond their duration. The active chunk is never changed,
+// so run 'Compact' periodically (see 'ChunkDB.CompactEvery') to apply the rules.
+func (db *LockFreeChunkDB) SetLabelRetention(rules map[Label]time.Duration) {
+	db.labelRetention = make(map[Label]time.Duration, len(rules))
+	for label, d := range rules {
+		db.labelRetention[label] = d
+	}
+	if len(rules) == 0 {
+		db.labelRetention = nil
+	}
+}
+
+////////// HELPERS //////////
+
+// Get the label of an entry in a chunk, which must contain it.
+func (c *chunk) label(id uint64) Label {
+	if c.labels == nil {
+		return 0
+	}
+	return c.labels[id-c.oldest]
+}
+
+// Record the label of the entry which has just been added to the chunk. The labels are only stored once an
+// entry has a label.
+func (c *chunk) appendLabel(label Label) {
+	if label != 0 && c.labels == nil {
+		c.labels = make([]Label, len(c.ends)-1, cap(c.ends))
+	}
+	if c.labels != nil {
+		c.labels = append(c.labels, label)
+	}
+}
+
+// Check if a chunk is full, with this many entries.
+func (db *LockFreeChunkDB) chunkFull(entries int) bool {
+	return entries >= maxChunkEntries || db.chunkEntries > 0 && uint32(entries) >= db.chunkEntries
+}
+
+// Drop the entries which have been kept for longer than the rule for their label, up to the active chunk.
+// Assumes a write lock is held.
+func (db *LockFreeChunkDB) expireLabels() error {
+	if len(db.labelRetention) == 0 || len(db.chunks) < 2 {
+		return nil
+	}
+
+	// Find the chunks which have entries to drop, by how long ago each was last written to.
+	now := time.Now()
+	ages := make(map[*chunk]time.Duration)
+	var n int
+	for i, c := range db.chunks[:len(db.chunks)-1] {
+		if c.removed {
+			continue
+		}
+		fi, err := os.Stat(c.path)
+		if err != nil {
+			return &ReadError{err}
+		}
+		ages[c] = now.Sub(fi.ModTime())
+		for id := c.oldest; id < c.next(); id++ {
+			if id < db.oldest {
+				continue
+			}
+			if _, start, end := c.find(id); start == end && c.sums[id-c.oldest] == droppedSum {
+				continue
+			}
+			if d, ok := db.labelRetention[c.label(id)]; ok && ages[c] > d {
+				n = i + 1
+				break
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+
+	drop := func(id uint64) bool {
+		c := db.chunks[db.findChunk(id)]
+		d, ok := db.labelRetention[c.label(id)]
+		return ok && ages[c] > d
+	}
+	return db.dropEntries(n, drop, "expired labelled entries from")
+}
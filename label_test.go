@@ -0,0 +1,79 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestLabel_Persist(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "label_persist", chunkSize).(*LockFreeChunkDB)
+	for i := 1; i <= numEntries; i++ {
+		_, err := db.AppendLabelled(Label(i%3), []byte{byte(i)})
+		assert.Nil(t, err, "expected no error in append labelled")
+	}
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "label_persist", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	var labels []Label
+	assert.Nil(t, db.ForgetWhile(func(id uint64, meta EntryMeta) bool {
+		labels = append(labels, meta.Label)
+		return false
+	}), "expected no error in forget while")
+	assert.Equal(t, []Label{1}, labels, "expected the label of the oldest entry")
+	for id := uint64(1); id <= numEntries; id++ {
+		c := db.chunks[db.findChunk(id)]
+		assert.Equal(t, Label(id%3), c.label(id), "expected the label of entry %v to persist", id)
+	}
+}
+
+func TestLabel_Retention(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "label_retention", chunkSize).(*ChunkDB)
+	var vs [][]byte
+	for i := 1; i <= numEntries; i++ {
+		v := []byte{byte(i)}
+		_, err := db.AppendLabelled(Label(i%2), v)
+		assert.Nil(t, err, "expected no error in append labelled")
+		vs = append(vs, v)
+	}
+	assertSync(t, db)
+
+	// Age every chunk but the active one beyond the retention of label 1.
+	old := time.Now().Add(-2 * time.Hour)
+	for _, c := range db.chunks[:len(db.chunks)-1] {
+		if err := os.Chtimes(c.path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	active := db.chunks[len(db.chunks)-1].oldest
+
+	db.SetLabelRetention(map[Label]time.Duration{1: time.Hour})
+	assert.Equal(t, time.Hour, db.Config().LabelRetention[1], "expected the rule in the config")
+	assert.Nil(t, db.Compact(), "expected no error in compact")
+
+	assertExpired := func() {
+		for id := uint64(1); id <= numEntries; id++ {
+			entry, err := db.Get(id)
+			if id >= active || id%2 == 0 {
+				assert.Nil(t, err, "expected no error in get %v", id)
+				assert.Equal(t, vs[id-1], entry, "expected the entry %v to be kept", id)
+			} else {
+				assert.Equal(t, ErrDownsampled, err, "expected the entry %v to be dropped", id)
+			}
+		}
+	}
+	assertExpired()
+	for _, c := range db.chunks[:len(db.chunks)-1] {
+		fi, err := os.Stat(c.path)
+		assert.Nil(t, err, "expected no error in stat")
+		assert.True(t, fi.ModTime().Before(time.Now().Add(-time.Hour)), "expected compaction to keep the modification time")
+	}
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["chunkdb"], false, "label_retention", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assertExpired()
+}
@@ -0,0 +1,135 @@
+package logdb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// The number of buckets in a 'LatencyHistogram', not including the overflow bucket. Bucket bounds double
+// each time, starting from 'latencyBase', so the largest bound is about 16 seconds.
+const (
+	latencyBuckets = 25
+	latencyBase    = time.Microsecond
+)
+
+// A LatencyHistogram is a snapshot of the latencies of one sort of operation.
+//
+// Buckets are exponentially sized: 'Counts[i]' is the number of operations which took less than 'Bounds[i]'
+// (and at least 'Bounds[i-1]'), with the final count being the number of operations which took longer than
+// any bound.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+
+	// The total number of operations, and their total and maximum durations.
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Quantile gives an upper bound on the given quantile (in the range [0,1]) of the latency, using the bucket
+// bounds. For the overflow bucket, the maximum latency is returned. If there have been no operations, 0 is
+// returned.
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	target := uint64(q * float64(h.Count))
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if seen > target || seen == h.Count {
+			if i < len(h.Bounds) {
+				return h.Bounds[i]
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// Mean gives the mean latency. If there have been no operations, 0 is returned.
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Total / time.Duration(h.Count)
+}
+
+// Latencies are snapshots of the latency histograms of a 'LockFreeChunkDB'.
+type Latencies struct {
+	// 'Append' covers both 'Append' and 'AppendEntries' calls, including any sync they trigger.
+	Append LatencyHistogram
+	Get    LatencyHistogram
+
+	// 'Sync' covers all syncs, whether explicit or periodic.
+	Sync LatencyHistogram
+
+	// 'Rollover' covers creating a new chunk, including syncing the prior chunk.
+	Rollover LatencyHistogram
+}
+
+// Latencies gets a snapshot of the latency histograms.
+func (db *LockFreeChunkDB) Latencies() Latencies {
+	return Latencies{
+		Append:   db.latencies.append.snapshot(),
+		Get:      db.latencies.get.snapshot(),
+		Sync:     db.latencies.sync.snapshot(),
+		Rollover: db.latencies.rollover.snapshot(),
+	}
+}
+
+////////// HELPERS //////////
+
+// The latency histograms of a database. This is allocated separately so that the 64-bit fields used with
+// 'sync/atomic' are correctly aligned on 32-bit platforms.
+type latencies struct {
+	append, get, sync, rollover latencyHistogram
+}
+
+// A histogram which can be updated concurrently. All fields must only be accessed atomically.
+type latencyHistogram struct {
+	counts [latencyBuckets + 1]uint64
+	count  uint64
+	total  uint64
+	max    uint64
+}
+
+// Record the latency of an operation.
+func (h *latencyHistogram) record(d time.Duration) {
+	bucket := 0
+	for bound := latencyBase; bucket < latencyBuckets && d >= bound; bound *= 2 {
+		bucket++
+	}
+
+	atomic.AddUint64(&h.counts[bucket], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.total, uint64(d))
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if uint64(d) <= max || atomic.CompareAndSwapUint64(&h.max, max, uint64(d)) {
+			break
+		}
+	}
+}
+
+// Take a snapshot of the histogram. The snapshot is not atomic as a whole, so if operations are happening
+// concurrently the total count may not quite match the bucket counts.
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	out := LatencyHistogram{
+		Bounds: make([]time.Duration, latencyBuckets),
+		Counts: make([]uint64, latencyBuckets+1),
+		Count:  atomic.LoadUint64(&h.count),
+		Total:  time.Duration(atomic.LoadUint64(&h.total)),
+		Max:    time.Duration(atomic.LoadUint64(&h.max)),
+	}
+	bound := latencyBase
+	for i := range out.Bounds {
+		out.Bounds[i] = bound
+		bound *= 2
+	}
+	for i := range out.Counts {
+		out.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package logdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestLatency_Buckets(t *testing.T) {
+	h := new(latencyHistogram)
+	h.record(0)
+	h.record(time.Microsecond)
+	h.record(3 * time.Microsecond)
+	h.record(time.Hour)
+
+	snap := h.snapshot()
+	assert.Equal(t, uint64(4), snap.Count, "expected four operations")
+	assert.Equal(t, uint64(1), snap.Counts[0], "expected one operation under 1us")
+	assert.Equal(t, uint64(1), snap.Counts[1], "expected one operation in [1us,2us)")
+	assert.Equal(t, uint64(1), snap.Counts[2], "expected one operation in [2us,4us)")
+	assert.Equal(t, uint64(1), snap.Counts[latencyBuckets], "expected one operation in the overflow bucket")
+	assert.Equal(t, time.Hour, snap.Max, "expected maximum latency")
+
+	assert.Equal(t, time.Microsecond, snap.Quantile(0), "expected minimum bucket bound")
+	assert.Equal(t, 4*time.Microsecond, snap.Quantile(0.5), "expected median bucket bound")
+	assert.Equal(t, time.Hour, snap.Quantile(1), "expected overflow to give the maximum")
+}
+
+func TestLatency_Recorded(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "latency_recorded", chunkSize)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	assertGet(t, db, 1)
+	assertGet(t, db, 2)
+	assertSync(t, db.(PersistDB))
+
+	latencies := db.(*LockFreeChunkDB).Latencies()
+	assert.Equal(t, uint64(1), latencies.Append.Count, "expected one append")
+	assert.Equal(t, uint64(2), latencies.Get.Count, "expected two gets")
+	assert.True(t, latencies.Sync.Count > 0, "expected syncs")
+	assert.True(t, latencies.Rollover.Count > 1, "expected chunk rollovers")
+}
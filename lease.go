@@ -0,0 +1,268 @@
+package logdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLeaseHeld means that a 'Lease' could not be acquired as another owner holds it.
+	ErrLeaseHeld = errors.New("lease held by another owner")
+
+	// ErrNotLeader means that a 'Lease' is not held, or has expired, so a write through a 'LeaderView' was
+	// refused, or the lease could not be renewed.
+	ErrNotLeader = errors.New("lease not held")
+)
+
+// A Lease is leadership among processes, possibly on different machines, which share a filesystem: at most one
+// owner holds it at a time, for a limited duration, and must renew it to keep it. This gives "exactly one
+// writer" for simple setups without a consensus system. A database can then be opened only by the leader, and
+// written to through a 'LeaderView'.
+//
+// The lease is a file, recording the owner, when the lease expires, and a fencing token, which increases every
+// time the lease changes hands. A leader can pass its token to other systems, which can reject requests with a
+// token lower than the highest they have seen, so a stale leader cannot do damage. The holder judges expiry by
+// its own clock from when it acquired or renewed the lease, so a process which is paused for longer than the
+// lease duration finds it has lost the lease when it resumes. Other owners judge expiry by the time in the file,
+// so the clocks of the machines must agree to within much less than the lease duration.
+//
+// A 'Lease' is safe for concurrent use.
+type Lease struct {
+	path     string
+	owner    string
+	duration time.Duration
+
+	mutex   sync.Mutex
+	token   uint64
+	expires time.Time
+}
+
+// NewLease creates a handle on the lease in the given file, which is created when the lease is first acquired.
+// The owner identifies this process in the file, and must be unique among the processes sharing the lease.
+func NewLease(path, owner string, duration time.Duration) *Lease {
+	return &Lease{path: path, owner: owner, duration: duration}
+}
+
+// Acquire takes the lease, if it is not held, has expired, or is already held by this owner (in which case it
+// is renewed), and returns the fencing token. The token is increased unless this owner already held the lease.
+//
+// Returns 'ErrLeaseHeld' if another owner holds the lease, a 'LockError' value if the lease file is being
+// changed by another owner (wrapping 'ErrLockLost' if another owner took over the lock file while this one
+// held it, in which case the lease file may or may not have been changed), and a 'ReadError' or 'WriteError'
+// value if the lease file could not be read or written.
+func (l *Lease) Acquire() (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var token uint64
+	err := l.update(func(rec leaseRecord, expires int64) (leaseRecord, error) {
+		if rec.Owner != l.owner && time.Now().Before(time.Unix(0, rec.Expires)) {
+			return rec, ErrLeaseHeld
+		}
+		token = rec.Token
+		if rec.Owner != l.owner || token == 0 {
+			token++
+		}
+		return leaseRecord{Token: token, Expires: expires, Owner: l.owner}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	l.token = token
+	return token, nil
+}
+
+// Renew extends the lease, which must be held.
+//
+// Returns 'ErrNotLeader' if the lease is not held by this owner or has expired, and the same errors as
+// 'Acquire' otherwise.
+func (l *Lease) Renew() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.held() {
+		return ErrNotLeader
+	}
+	return l.update(func(rec leaseRecord, expires int64) (leaseRecord, error) {
+		if rec.Owner != l.owner || rec.Token != l.token {
+			return rec, ErrNotLeader
+		}
+		rec.Expires = expires
+		return rec, nil
+	})
+}
+
+// Release gives up the lease, if it is held, so that another owner can acquire it straight away.
+//
+// Returns the same errors as 'Acquire'.
+func (l *Lease) Release() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.token == 0 {
+		return nil
+	}
+	err := l.update(func(rec leaseRecord, _ int64) (leaseRecord, error) {
+		if rec.Owner != l.owner || rec.Token != l.token {
+			return rec, ErrNotLeader
+		}
+		rec.Expires = 0
+		return rec, nil
+	})
+	l.token = 0
+	l.expires = time.Time{}
+	if err == ErrNotLeader {
+		return nil
+	}
+	return err
+}
+
+// Leader gets the fencing token of the lease, and whether it is held by this owner and has not expired.
+func (l *Lease) Leader() (uint64, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.token, l.held()
+}
+
+// LeaderView wraps a 'LogDB' so that entries can only be appended, forgotten, rolled back, or truncated while
+// the lease is held, failing with 'ErrNotLeader' otherwise. Entries can always be read. As with 'AppendOnly', the
+// underlying database cannot be recovered from the result.
+//
+// The lease is checked before each write, so a write can still happen just after the lease expires if the
+// process is paused in between: use the fencing token where that matters.
+func LeaderView(db LogDB, lease *Lease) LogDB {
+	return leaderView{readOnlyView{db}, lease}
+}
+
+////////// HELPERS //////////
+
+// The contents of a lease file: the fencing token, when the lease expires (in Unix nanoseconds), and the owner.
+type leaseRecord struct {
+	Token   uint64
+	Expires int64
+	Owner   string
+}
+
+// Check if the lease is held and has not expired. Assumes the mutex is held.
+func (l *Lease) held() bool {
+	return l.token != 0 && time.Now().Before(l.expires)
+}
+
+// Read, change, and write the lease file, holding a lock file so that no other owner can change it in the
+// meantime. 'change' is given the expiry for a lease acquired or renewed now, and if it keeps that expiry, the
+// lease is held until then. Assumes the mutex is held.
+func (l *Lease) update(change func(rec leaseRecord, expires int64) (leaseRecord, error)) error {
+	// The lock file is only held for as long as it takes to update the lease file, so it is considered stale
+	// after the lease duration, and is never refreshed.
+	lock, err := acquireHeartbeatLock(l.path+".lock", l.duration, l.duration)
+	if err != nil {
+		return &LockError{err}
+	}
+
+	rec, err := readLease(l.path)
+	if err != nil {
+		_ = lock.release()
+		return &ReadError{err}
+	}
+	expires := time.Now().Add(l.duration)
+	rec, err = change(rec, expires.UnixNano())
+	if err != nil {
+		_ = lock.release()
+		return err
+	}
+
+	// If this process was paused for longer than the lease duration, the lock file may have gone stale and been
+	// taken over by another owner, who may have changed the lease file since it was read.
+	if err := lock.check(); err != nil {
+		_ = lock.release()
+		return &LockError{err}
+	}
+	if err := writeLease(l.path, rec); err != nil {
+		_ = lock.release()
+		return &WriteError{err}
+	}
+	if err := lock.release(); err != nil {
+		return &LockError{err}
+	}
+	if rec.Expires == expires.UnixNano() {
+		l.expires = expires
+	}
+	return nil
+}
+
+// Read a lease file. A lease file which does not exist is a lease which has never been acquired.
+func readLease(path string) (leaseRecord, error) {
+	var rec leaseRecord
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return rec, nil
+	} else if err != nil {
+		return rec, err
+	}
+	r := bytes.NewReader(bs)
+	if err := binary.Read(r, binary.LittleEndian, &rec.Token); err != nil {
+		return rec, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &rec.Expires); err != nil {
+		return rec, err
+	}
+	rec.Owner = string(bs[16:])
+	return rec, nil
+}
+
+// Write a lease file, atomically replacing the old one.
+func writeLease(path string, rec leaseRecord) error {
+	buf := binary.LittleEndian.AppendUint64(nil, rec.Token)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(rec.Expires))
+	buf = append(buf, rec.Owner...)
+	if err := writeFile(path+tmpSuffix, buf); err != nil {
+		return err
+	}
+	return os.Rename(path+tmpSuffix, path)
+}
+
+type leaderView struct {
+	readOnlyView
+	lease *Lease
+}
+
+func (v leaderView) Append(entry []byte) (uint64, error) {
+	if _, ok := v.lease.Leader(); !ok {
+		return 0, ErrNotLeader
+	}
+	return v.db.Append(entry)
+}
+
+func (v leaderView) AppendEntries(entries [][]byte) (uint64, error) {
+	if _, ok := v.lease.Leader(); !ok {
+		return 0, ErrNotLeader
+	}
+	return v.db.AppendEntries(entries)
+}
+
+func (v leaderView) Forget(newOldestID uint64) error {
+	if _, ok := v.lease.Leader(); !ok {
+		return ErrNotLeader
+	}
+	return v.db.Forget(newOldestID)
+}
+
+func (v leaderView) Rollback(newNewestID uint64) error {
+	if _, ok := v.lease.Leader(); !ok {
+		return ErrNotLeader
+	}
+	return v.db.Rollback(newNewestID)
+}
+
+func (v leaderView) Truncate(newOldestID, newNewestID uint64) error {
+	if _, ok := v.lease.Leader(); !ok {
+		return ErrNotLeader
+	}
+	return v.db.Truncate(newOldestID, newNewestID)
+}
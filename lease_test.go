@@ -0,0 +1,132 @@
+package logdb
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestLease_AcquireRenewRelease(t *testing.T) {
+	path := leasePath(t, "acquire_renew_release")
+	a := NewLease(path, "a", time.Minute)
+	b := NewLease(path, "b", time.Minute)
+
+	tok, err := a.Acquire()
+	assert.Nil(t, err, "expected no error in acquire")
+	assert.Equal(t, uint64(1), tok, "expected first fencing token")
+	_, ok := a.Leader()
+	assert.True(t, ok, "expected lease to be held")
+
+	_, err = b.Acquire()
+	assert.Equal(t, ErrLeaseHeld, err, "expected lease to be held by another owner")
+	assert.Equal(t, ErrNotLeader, b.Renew(), "expected renew of an unheld lease to fail")
+
+	assert.Nil(t, a.Renew(), "expected no error in renew")
+	tok, err = a.Acquire()
+	assert.Nil(t, err, "expected no error in re-acquire")
+	assert.Equal(t, uint64(1), tok, "expected re-acquire not to change the fencing token")
+
+	assert.Nil(t, a.Release(), "expected no error in release")
+	_, ok = a.Leader()
+	assert.False(t, ok, "expected lease not to be held after release")
+
+	tok, err = b.Acquire()
+	assert.Nil(t, err, "expected no error in acquire after release")
+	assert.Equal(t, uint64(2), tok, "expected fencing token to increase")
+}
+
+func TestLease_Expiry(t *testing.T) {
+	path := leasePath(t, "expiry")
+	a := NewLease(path, "a", 50*time.Millisecond)
+	b := NewLease(path, "b", time.Minute)
+
+	_, err := a.Acquire()
+	assert.Nil(t, err, "expected no error in acquire")
+	time.Sleep(100 * time.Millisecond)
+
+	_, ok := a.Leader()
+	assert.False(t, ok, "expected lease to have expired")
+	assert.Equal(t, ErrNotLeader, a.Renew(), "expected renew of an expired lease to fail")
+
+	tok, err := b.Acquire()
+	assert.Nil(t, err, "expected no error in takeover of an expired lease")
+	assert.Equal(t, uint64(2), tok, "expected fencing token to increase")
+
+	// The old owner cannot take the lease back while it is held.
+	_, err = a.Acquire()
+	assert.Equal(t, ErrLeaseHeld, err, "expected lease to be held by another owner")
+}
+
+func TestLease_StaleLockContenders(t *testing.T) {
+	path := leasePath(t, "stale_lock_contenders")
+
+	for i := 0; i < 50; i++ {
+		// Simulate an owner which died while changing the lease file.
+		if err := writeFile(path+".lock", []byte("dead 1\n")); err != nil {
+			t.Fatal(err)
+		}
+		past := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(path+".lock", past, past); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(path)
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		leaders := make(chan string, 2)
+		for _, owner := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(owner string) {
+				defer wg.Done()
+				<-start
+				if _, err := NewLease(path, owner, time.Minute).Acquire(); err == nil {
+					leaders <- owner
+				}
+			}(owner)
+		}
+		close(start)
+		wg.Wait()
+		close(leaders)
+
+		assert.Equal(t, 1, len(leaders), "expected exactly one contender to acquire the lease")
+		rec, err := readLease(path)
+		assert.Nil(t, err, "expected no error reading lease")
+		assert.Equal(t, <-leaders, rec.Owner, "expected the lease file to name the leader")
+	}
+}
+
+func TestLeaderView(t *testing.T) {
+	path := leasePath(t, "leader_view")
+	lease := NewLease(path, "a", 50*time.Millisecond)
+	inmem := &InMemDB{}
+	db := LeaderView(inmem, lease)
+
+	_, err := db.Append([]byte("one"))
+	assert.Equal(t, ErrNotLeader, err, "expected append without the lease to fail")
+
+	_, err = lease.Acquire()
+	assert.Nil(t, err, "expected no error in acquire")
+	_, err = db.AppendEntries([][]byte{[]byte("one"), []byte("two")})
+	assert.Nil(t, err, "expected no error in append")
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Append([]byte("three"))
+	assert.Equal(t, ErrNotLeader, err, "expected append after the lease expired to fail")
+	assert.Equal(t, ErrNotLeader, db.Rollback(1), "expected rollback after the lease expired to fail")
+	assert.Equal(t, ErrNotLeader, db.Forget(2), "expected forget after the lease expired to fail")
+
+	v, err := db.Get(2)
+	assert.Nil(t, err, "expected no error in get")
+	assert.Equal(t, []byte("two"), v, "expected equal '[]byte' values")
+	assert.Equal(t, uint64(2), inmem.NewestID(), "expected newest ID of the underlying database")
+}
+
+func leasePath(t *testing.T, name string) string {
+	dir := "test_db/lease_" + name
+	_ = os.RemoveAll(dir)
+	assert.Nil(t, os.MkdirAll(dir, os.ModeDir|0755), "expected no error creating directory")
+	return dir + "/lease"
+}
@@ -0,0 +1,62 @@
+package logdb
+
+import "time"
+
+// A Logger is used to report events which are not errors, but which may be of interest to an operator. The
+// standard library '*log.Logger' implements this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// SetLogger is the thread-safe version of 'LockFreeChunkDB.SetLogger'.
+func (db *ChunkDB) SetLogger(logger Logger) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetLogger(logger)
+}
+
+// SetLogger configures the database to report events to the given logger. A nil logger disables logging,
+// which is the default.
+func (db *LockFreeChunkDB) SetLogger(logger Logger) {
+	db.logger = logger
+}
+
+// SetSlowThreshold is the thread-safe version of 'LockFreeChunkDB.SetSlowThreshold'.
+func (db *ChunkDB) SetSlowThreshold(threshold time.Duration) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetSlowThreshold(threshold)
+}
+
+// SetSlowThreshold configures the database to log every operation which takes at least this long, with the
+// operation type, the range of entry IDs, and the chunk file (or database directory, if the operation is not
+// confined to one chunk) involved. Nothing is logged unless a logger has been set with 'SetLogger'.
+//
+// A threshold <=0 disables slow-operation logging, which is the default.
+func (db *LockFreeChunkDB) SetSlowThreshold(threshold time.Duration) {
+	db.slowThreshold = threshold
+}
+
+////////// HELPERS //////////
+
+// Record the latency of an operation which started at the given time in the histogram (if not nil), and log it
+// if it was slow.
+func (db *LockFreeChunkDB) observe(h *latencyHistogram, start time.Time, op string, first, last uint64, path string) {
+	d := time.Since(start)
+	if h != nil {
+		h.record(d)
+	}
+	if db.logger != nil && db.slowThreshold > 0 && d >= db.slowThreshold {
+		db.logger.Printf("logdb: slow %s of entries [%v:%v] in %s took %v", op, first, last, path, d)
+	}
+}
+
+// Get the path of the chunk file which appends go to, or the database directory if there are no chunks.
+func (db *LockFreeChunkDB) activeChunkPath() string {
+	if len(db.chunks) == 0 {
+		return db.path
+	}
+	return db.chunks[len(db.chunks)-1].path
+}
@@ -0,0 +1,41 @@
+package logdb
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestLog_SlowOperations(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "log_slow_operations", chunkSize)
+	defer assertClose(t, db)
+
+	buf := new(bytes.Buffer)
+	db.(*ChunkDB).SetLogger(log.New(buf, "", 0))
+	db.(*ChunkDB).SetSlowThreshold(time.Nanosecond)
+
+	assertAppend(t, db, []byte("hello world"))
+	assertGet(t, db, 1)
+
+	logged := buf.String()
+	assert.True(t, strings.Contains(logged, "slow append of entries [1:1] in test_db/log_slow_operations/"+initialChunkFile), "expected slow append, got: %s", logged)
+	assert.True(t, strings.Contains(logged, "slow get of entries [1:1] in test_db/log_slow_operations/"+initialChunkFile), "expected slow get, got: %s", logged)
+}
+
+func TestLog_FastOperations(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "log_fast_operations", chunkSize)
+	defer assertClose(t, db)
+
+	buf := new(bytes.Buffer)
+	db.(*ChunkDB).SetLogger(log.New(buf, "", 0))
+	db.(*ChunkDB).SetSlowThreshold(time.Hour)
+
+	filldb(t, db, numEntries)
+	assertGet(t, db, 1)
+
+	assert.Equal(t, "", buf.String(), "expected nothing to be logged")
+}
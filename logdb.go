@@ -3,10 +3,15 @@
 //
 // This provides a number of interfaces and types, and a lot of errors.
 //
-//  - 'LogDB' is the main interface for a log-structured database.
-//  - 'PersistDB' is an interface for databases which can be persisted in some way.
-//  - 'BoundedDB' is an interface for databases with a fixed maximum entry size.
-//  - 'CloseDB' is an interface for databases which can be closed.
+//   - 'LogDB' is the main interface for a log-structured database.
+//   - 'PersistDB' is an interface for databases which can be persisted in some way.
+//   - 'BoundedDB' is an interface for databases with a fixed maximum entry size.
+//   - 'CloseDB' is an interface for databases which can be closed.
+//   - 'SizedDB' is an interface for databases which can report how much storage an entry takes up.
+//   - 'RawDB' is an interface for databases which can stream an entry as stored, without reading it into memory.
+//   - 'IterDB' is an interface for databases which can stream a range of entries with an 'Iterator'.
+//   - 'GenerationDB' is an interface for databases which count rollbacks, so that copies can detect them.
+//   - 'ReadOnlyDB', 'AppendOnlyDB', and 'WriterDB' are subsets of 'LogDB' for least-privilege handles.
 //
 // The 'LockFreeChunkDB' and 'ChunkDB' types implement all of these interfaces, and are created with 'Open'
 // and 'WrapForConcurrency' respectively. As the names suggest, the difference is the thread-safety. A
@@ -15,6 +20,11 @@
 // in-memory store.
 package logdb
 
+import (
+	"context"
+	"io"
+)
+
 // A LogDB is a log-structured database.
 type LogDB interface {
 	// Append writes a new entry to the log and returns its ID.
@@ -68,13 +78,49 @@ type LogDB interface {
 	NewestID() uint64
 }
 
+// A ReadOnlyDB is the subset of a 'LogDB' which can only read entries. Every 'LogDB' is a 'ReadOnlyDB', and
+// 'ReadOnlyView' narrows a 'LogDB' to one which cannot be converted back.
+type ReadOnlyDB interface {
+	// Get is as in 'LogDB'.
+	Get(id uint64) ([]byte, error)
+
+	// OldestID is as in 'LogDB'.
+	OldestID() uint64
+
+	// NewestID is as in 'LogDB'.
+	NewestID() uint64
+}
+
+// An AppendOnlyDB is the subset of a 'LogDB' which can only add and read entries, never remove them. Every
+// 'LogDB' is an 'AppendOnlyDB', and 'AppendOnly' narrows a 'LogDB' to one which cannot be converted back.
+type AppendOnlyDB interface {
+	// 'AppendOnlyDB' is an extension of 'ReadOnlyDB'.
+	ReadOnlyDB
+
+	// Append is as in 'LogDB'.
+	Append(entry []byte) (uint64, error)
+
+	// AppendEntries is as in 'LogDB'.
+	AppendEntries(entries [][]byte) (uint64, error)
+}
+
+// A WriterDB is an 'AppendOnlyDB' which can also persist what it has written. 'WriterView' narrows a 'LogDB'
+// to one of these.
+type WriterDB interface {
+	// 'WriterDB' is an extension of 'AppendOnlyDB'.
+	AppendOnlyDB
+
+	// Sync is as in 'PersistDB'.
+	Sync() error
+}
+
 // A PersistDB is a database which can be persisted in some fashion. In addition to defining methods, a
 // 'PersistDB' changes some existing behaviours:
 //
-//  - 'Append', 'AppendEntries', 'Forget', 'Rollback', and 'Truncate' can now cause a 'Sync', if
-//    'SetSync' has been called.
+//   - 'Append', 'AppendEntries', 'Forget', 'Rollback', and 'Truncate' can now cause a 'Sync', if
+//     'SetSync' has been called.
 //
-//  - The above may return a 'SyncError' value if a periodic synchronisation failed.
+//   - The above may return a 'SyncError' value if a periodic synchronisation failed.
 type PersistDB interface {
 	// 'PersistDB' is an extension of 'LogDB'.
 	LogDB
@@ -106,6 +152,72 @@ type BoundedDB interface {
 	MaxEntrySize() uint64
 }
 
+// A SizedDB can report the size of an entry as stored, which may differ from the size of the entry returned by
+// 'Get' if the database transforms entries in some way, such as by compressing them.
+type SizedDB interface {
+	// 'SizedDB' is an extension of 'LogDB'.
+	LogDB
+
+	// StoredSize gets the size in bytes of an entry as stored by the underlying storage. This does not
+	// include any per-entry metadata.
+	//
+	// Returns the same errors as 'Get'.
+	StoredSize(id uint64) (uint64, error)
+}
+
+// A RawDB can stream an entry as stored by the underlying storage, which may differ from the entry returned by
+// 'Get' if the database transforms entries in some way, such as by compressing them.
+type RawDB interface {
+	// 'RawDB' is an extension of 'LogDB'.
+	LogDB
+
+	// GetRaw gets a reader over an entry as stored by the underlying storage. The entry need not be read into
+	// memory up front.
+	//
+	// Returns the same errors as 'Get'.
+	GetRaw(id uint64) (io.Reader, error)
+}
+
+// An IterDB can stream a range of entries, which is faster than calling 'Get' for each one. The package-level
+// 'Scan' function works with any 'LogDB', using an 'IterDB' if possible.
+type IterDB interface {
+	// 'IterDB' is an extension of 'LogDB'.
+	LogDB
+
+	// Scan gets an iterator over the entries from 'start' to 'end', inclusive.
+	//
+	// Returns 'ErrIDOutOfRange' if either ID is out of range, or if 'end' is less than 'start'.
+	Scan(start, end uint64) (Iterator, error)
+}
+
+// An Iterator streams entries in order of ID. The entries are read as 'Next' is called, not when the iterator
+// is created, so if they are removed from the database in the meantime, 'Next' fails with 'ErrIDOutOfRange'.
+//
+// An iterator is used like so:
+//
+//	for it.Next() {
+//		process(it.ID(), it.Entry())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator interface {
+	// Next moves on to the next entry, returning false if there are none left or if there was an error.
+	Next() bool
+
+	// ID gets the ID of the current entry.
+	ID() uint64
+
+	// Entry gets the current entry.
+	Entry() []byte
+
+	// Err gets the error which stopped iteration, if there was one.
+	Err() error
+
+	// Close stops iteration. It is not necessary to call this if 'Next' has returned false.
+	Close() error
+}
+
 // A CloseDB can be closed, which may perform some clean-up.
 //
 // If a 'CloseDB' is also a 'PersistDB', then 'Sync' should be called during 'Close'. In addition, all
@@ -120,3 +232,43 @@ type CloseDB interface {
 	// Returns 'ErrClosed' if the database is already closed.
 	Close() error
 }
+
+// A GenerationDB counts the rollbacks of its history in a generation number, so that something which copies
+// entries out of it, like 'Mirror', can detect that entries it has copied no longer exist, even if they have
+// been replaced by the time it checks.
+type GenerationDB interface {
+	// 'GenerationDB' is an extension of 'LogDB'.
+	LogDB
+
+	// Generation gets the current generation, which is increased by every 'Rollback' or 'Truncate' which
+	// removes entries from the end of the log. It is 0 if there has never been one.
+	Generation() uint64
+
+	// RolledBackTo gets the lowest ID which any rollback since the given generation has left as the newest
+	// entry: entries up to and including it are unchanged since then, but later ones may have been replaced.
+	// If there have been no rollbacks since, this is the ID of the newest entry.
+	RolledBackTo(generation uint64) uint64
+}
+
+// A ContextDB has variants of its operations which stop waiting when a context is done, so that the caller can
+// bound how long they block, for example for a large batch append or a slow sync.
+type ContextDB interface {
+	// 'ContextDB' is an extension of 'PersistDB'.
+	PersistDB
+
+	// AppendContext is like 'Append', but if the context is done before the entry is written, nothing is
+	// written, and the error of the context is returned.
+	AppendContext(ctx context.Context, entry []byte) (uint64, error)
+
+	// AppendEntriesContext is like 'AppendEntries', but if the context is done before the entries are written,
+	// nothing is written, and the error of the context is returned. The entries are still appended atomically,
+	// so once writing has started, it is not stopped.
+	AppendEntriesContext(ctx context.Context, entries [][]byte) (uint64, error)
+
+	// GetContext is like 'Get', but returns the error of the context if it is done first.
+	GetContext(ctx context.Context, id uint64) ([]byte, error)
+
+	// SyncContext is like 'Sync', but returns the error of the context if it is done first. The sync is not
+	// interrupted, so it is not known whether it succeeded.
+	SyncContext(ctx context.Context) error
+}
@@ -2,10 +2,11 @@ package logdb
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 
-	"github.com/stretchr/testify/assert"
+	"github.com/barrucadu/logdb/internal/assert"
 )
 
 const (
@@ -130,6 +131,89 @@ func TestLogDB_NoGetOutOfRange(t *testing.T) {
 	}
 }
 
+/* ***** GetEntries */
+
+func TestLogDB_GetEntries(t *testing.T) {
+	for dbName, dbType := range dbTypes {
+		t.Logf("Database: %s\n", dbName)
+		func() {
+			db := assertOpen(t, dbType, true, "get_entries", chunkSize)
+			defer assertClose(t, db)
+
+			vs := filldb(t, db, numEntries)
+			assertForget(t, db, 10)
+
+			getEntries := db.(interface {
+				GetEntries(start, end uint64) ([][]byte, error)
+			}).GetEntries
+
+			// The range spans several chunks.
+			entries, err := getEntries(10, 200)
+			assert.Nil(t, err)
+			assert.Equal(t, vs[9:200], entries)
+			entries, err = getEntries(numEntries, numEntries)
+			assert.Nil(t, err)
+			assert.Equal(t, vs[numEntries-1:], entries)
+
+			_, err = getEntries(9, 20)
+			assert.Equal(t, ErrIDOutOfRange, err)
+			_, err = getEntries(20, numEntries+1)
+			assert.Equal(t, ErrIDOutOfRange, err)
+			_, err = getEntries(20, 19)
+			assert.Equal(t, ErrIDOutOfRange, err)
+		}()
+	}
+}
+
+/* ***** StoredSize */
+
+func TestLogDB_StoredSize(t *testing.T) {
+	for dbName, dbType := range dbTypes {
+		t.Logf("Database: %s\n", dbName)
+		func() {
+			db := assertOpen(t, dbType, true, "stored_size", chunkSize)
+			defer assertClose(t, db)
+
+			vs := filldb(t, db, numEntries)
+			for i, v := range vs {
+				size, err := db.(SizedDB).StoredSize(uint64(i + 1))
+				assert.Nil(t, err)
+				assert.Equal(t, uint64(len(v)), size)
+			}
+
+			_, err := db.(SizedDB).StoredSize(uint64(len(vs) + 1))
+			assert.Equal(t, ErrIDOutOfRange, err)
+		}()
+	}
+}
+
+/* ***** GetRaw */
+
+func TestLogDB_GetRaw(t *testing.T) {
+	for dbName, dbType := range dbTypes {
+		if _, ok := dbType.(RawDB); !ok {
+			continue
+		}
+		t.Logf("Database: %s\n", dbName)
+		func() {
+			db := assertOpen(t, dbType, true, "get_raw", chunkSize)
+			defer assertClose(t, db)
+
+			vs := filldb(t, db, numEntries)
+			for i, v := range vs {
+				r, err := db.(RawDB).GetRaw(uint64(i + 1))
+				assert.Nil(t, err)
+				bs, err := ioutil.ReadAll(r)
+				assert.Nil(t, err)
+				assert.Equal(t, v, bs)
+			}
+
+			_, err := db.(RawDB).GetRaw(uint64(len(vs) + 1))
+			assert.Equal(t, ErrIDOutOfRange, err)
+		}()
+	}
+}
+
 /* ***** Forget */
 
 func TestLogDB_Forget_Zero(t *testing.T) {
@@ -502,7 +586,7 @@ func TestLogDB_Persist_DisablePerioid(t *testing.T) {
 			// Allocate a huge chunk file, because a sync is forced when a
 			// chunk is filled, regardless of the periodic syncing
 			// behaviour.
-			db := assertOpen(t, dbType, true, "disable_periodic_sync", 1024*1024*1024)
+			db := assertOpen(t, dbType, true, "disable_periodic_sync", bigChunkSize)
 			defer assertClose(t, db)
 
 			persistdb, _ := db.(PersistDB)
@@ -529,7 +613,7 @@ func TestLogDB_Persist_Explicit(t *testing.T) {
 
 		t.Logf("Database: %s\n", dbName)
 		func() {
-			db := assertOpen(t, dbType, true, "explicit_sync", 1024*1024*1024)
+			db := assertOpen(t, dbType, true, "explicit_sync", bigChunkSize)
 			defer assertClose(t, db)
 
 			persistdb, _ := db.(PersistDB)
@@ -556,7 +640,7 @@ func TestLogDB_Persist_SetPeriodic(t *testing.T) {
 
 		t.Logf("Database: %s\n", dbName)
 		func() {
-			db := assertOpen(t, dbType, true, "setsync_syncs", 1024*1024*1024)
+			db := assertOpen(t, dbType, true, "setsync_syncs", bigChunkSize)
 			defer assertClose(t, db)
 
 			persistdb, _ := db.(PersistDB)
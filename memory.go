@@ -0,0 +1,91 @@
+package logdb
+
+import "unsafe"
+
+// MemoryUsage is an estimate of the memory used by a database handle, in bytes.
+type MemoryUsage struct {
+	// The in-memory index: the state of each chunk, including the end offset and checksum of every entry, and the set of
+	// chunks awaiting a sync.
+	Index uint64
+
+	// Copies of rolled back entries, kept for 'UnRollback' (see 'SetSoftRollback').
+	Redo uint64
+
+	// Chunk files mapped into memory. This is address space, rather than heap: the operating system pages it
+	// in and out as needed.
+	Mapped uint64
+}
+
+// Heap is the memory used on the heap, which is everything but the mapped chunk files.
+func (u MemoryUsage) Heap() uint64 {
+	return u.Index + u.Redo
+}
+
+// MemoryUsage is the thread-safe version of 'LockFreeChunkDB.MemoryUsage'.
+func (db *ChunkDB) MemoryUsage() MemoryUsage {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.MemoryUsage()
+}
+
+// MemoryUsage estimates the memory used by the database.
+func (db *LockFreeChunkDB) MemoryUsage() MemoryUsage {
+	var u MemoryUsage
+	for _, c := range db.chunks {
+		u.Index += uint64(unsafe.Sizeof(*c)) + uint64(len(c.path)) + uint64(cap(c.ends))*4 + uint64(cap(c.sums))*4
+		u.Index += uint64(cap(c.labels)) + uint64(cap(c.headers))*uint64(unsafe.Sizeof([]byte(nil)))
+		for _, header := range c.headers {
+			u.Index += uint64(cap(header))
+		}
+		u.Mapped += uint64(len(c.bytes))
+	}
+	u.Index += uint64(len(db.syncDirty)) * uint64(unsafe.Sizeof((*chunk)(nil)))
+	for _, r := range db.redo {
+		u.Redo += uint64(unsafe.Sizeof(r)) + uint64(cap(r.entry)) + uint64(cap(r.header))
+	}
+	return u
+}
+
+// SetMemoryLimit is the thread-safe version of 'LockFreeChunkDB.SetMemoryLimit'.
+func (db *ChunkDB) SetMemoryLimit(limit uint64) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetMemoryLimit(limit)
+}
+
+// SetMemoryLimit caps the heap memory used by the database, as given by 'MemoryUsage'. When it is exceeded, the
+// spare capacity of the index of sealed chunks is released, and then rolled back entries kept for 'UnRollback'
+// are discarded. The index itself cannot be shrunk, so the limit may still be exceeded: 'Forget' old entries to
+// reduce it. 0, the default, is no limit.
+func (db *LockFreeChunkDB) SetMemoryLimit(limit uint64) {
+	db.memoryLimit = limit
+	db.enforceMemoryLimit()
+}
+
+////////// HELPERS //////////
+
+// Shrink the in-memory state until it fits in the memory limit, if possible.
+func (db *LockFreeChunkDB) enforceMemoryLimit() {
+	if db.memoryLimit == 0 || db.MemoryUsage().Heap() <= db.memoryLimit {
+		return
+	}
+	for _, c := range db.chunks {
+		if c.sealed && cap(c.ends) > len(c.ends) {
+			ends := make([]int32, len(c.ends))
+			copy(ends, c.ends)
+			c.ends = ends
+			c.sums = append([]uint32(nil), c.sums...)
+			if c.labels != nil {
+				c.labels = append([]Label(nil), c.labels...)
+			}
+			if c.headers != nil {
+				c.headers = append([][]byte(nil), c.headers...)
+			}
+		}
+	}
+	if db.MemoryUsage().Heap() > db.memoryLimit {
+		db.redo = nil
+	}
+}
@@ -0,0 +1,28 @@
+package logdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "memory_usage", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	empty := db.MemoryUsage()
+	filldb(t, db, numEntries)
+	full := db.MemoryUsage()
+	assert.True(t, full.Index > empty.Index, "expected the index to grow")
+	assert.Equal(t, uint64(len(db.chunks))*uint64(chunkSize), full.Mapped, "expected every chunk to be mapped")
+	assert.Equal(t, uint64(0), full.Redo, "expected no rolled back entries")
+
+	db.SetSoftRollback(time.Hour)
+	assertRollback(t, db, 100)
+	assert.True(t, db.MemoryUsage().Redo > 0, "expected rolled back entries to be counted")
+
+	db.SetMemoryLimit(1)
+	assert.Equal(t, uint64(0), db.MemoryUsage().Redo, "expected rolled back entries to be discarded")
+	assert.Equal(t, ErrNoRedo, db.UnRollback(), "expected nothing to restore")
+}
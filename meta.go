@@ -0,0 +1,257 @@
+package logdb
+
+import "context"
+
+// MaxHeaderSize is the largest entry header, in bytes.
+const MaxHeaderSize = 255
+
+// EntryMeta is the per-entry metadata which can be read without reading an entry itself: what the chunk
+// metadata files record.
+type EntryMeta struct {
+	// Size of the entry as stored, in bytes.
+	Size uint64
+
+	// Label the entry was appended with, see 'AppendLabelled'.
+	Label Label
+
+	// Header the entry was appended with, see 'AppendWithHeader', or nil if it has none. A header passed to a
+	// predicate must not be modified.
+	Header []byte
+}
+
+// AppendWithHeader is the thread-safe version of 'LockFreeChunkDB.AppendWithHeader'. If 'SetBlockOnNoSpace'
+// has been used, this blocks while the disk is full.
+func (db *ChunkDB) AppendWithHeader(header, entry []byte) (uint64, error) {
+	return db.appendContext(context.Background(), [][]byte{entry}, 0, [][]byte{header})
+}
+
+// AppendWithHeader appends an entry with a header: a small amount of metadata, such as a term number, type, or
+// timestamp, which is kept in the chunk metadata file rather than in the entry, so that it can be read without
+// reading the entry (see 'GetWithMeta', and the predicates of 'Count', 'ScanWhere', and 'ForgetWhile'). The
+// database does not interpret headers. An empty header is the same as none. It is otherwise the same as
+// 'Append'.
+//
+// Returns 'ErrHeaderTooBig' if the header is larger than 'MaxHeaderSize', and the same errors as 'Append'.
+func (db *LockFreeChunkDB) AppendWithHeader(header, entry []byte) (uint64, error) {
+	return db.appendEntries([][]byte{entry}, 0, [][]byte{header})
+}
+
+// AppendEntriesWithHeaders is the thread-safe version of 'LockFreeChunkDB.AppendEntriesWithHeaders'. If
+// 'SetBlockOnNoSpace' has been used, this blocks while the disk is full.
+func (db *ChunkDB) AppendEntriesWithHeaders(headers, entries [][]byte) (uint64, error) {
+	return db.appendContext(context.Background(), entries, 0, headers)
+}
+
+// AppendEntriesWithHeaders appends entries with a header each, as 'AppendWithHeader' does. It is otherwise the
+// same as 'AppendEntries'.
+//
+// Returns 'ErrHeaderCount' if there is not one header for each entry, 'ErrHeaderTooBig' if any header is larger
+// than 'MaxHeaderSize', and the same errors as 'AppendEntries'.
+func (db *LockFreeChunkDB) AppendEntriesWithHeaders(headers, entries [][]byte) (uint64, error) {
+	return db.appendEntries(entries, 0, headers)
+}
+
+// GetWithMeta is the thread-safe version of 'LockFreeChunkDB.GetWithMeta'.
+func (db *ChunkDB) GetWithMeta(id uint64) ([]byte, EntryMeta, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.GetWithMeta(id)
+}
+
+// GetWithMeta gets an entry and its metadata, including its header. Both are copies.
+//
+// Returns the same errors as 'Get'.
+func (db *LockFreeChunkDB) GetWithMeta(id uint64) ([]byte, EntryMeta, error) {
+	entry, err := db.Get(id)
+	if err != nil {
+		return nil, EntryMeta{}, err
+	}
+	c, start, end := db.find(id)
+	meta := c.entryMeta(id, start, end)
+	if meta.Header != nil {
+		meta.Header = append([]byte(nil), meta.Header...)
+	}
+	return entry, meta, nil
+}
+
+// ForgetWhile forgets entries from the oldest onwards while a predicate holds. See
+// 'LockFreeChunkDB.ForgetWhile' for details.
+func (db *ChunkDB) ForgetWhile(fn func(id uint64, meta EntryMeta) bool) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.ForgetWhile(fn)
+}
+
+// ForgetWhile forgets entries from the oldest onwards while the predicate holds, stopping at the first entry it
+// does not hold for. The predicate is given the metadata of each entry, which is read from memory, so entries
+// themselves are never read. The newest entry is never forgotten, as there is nothing to advance the oldest ID
+// to past it.
+//
+// This has the same behaviour as a 'Forget' up to the first entry the predicate does not hold for, including
+// syncing, and returns the same errors.
+func (db *LockFreeChunkDB) ForgetWhile(fn func(id uint64, meta EntryMeta) bool) error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	newOldestID := db.oldest
+	for newOldestID < db.newest {
+		c, start, end := db.find(newOldestID)
+		if !fn(newOldestID, c.entryMeta(newOldestID, start, end)) {
+			break
+		}
+		newOldestID++
+	}
+
+	if newOldestID == db.oldest {
+		return nil
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	if err := db.checkInterlock(newOldestID, db.newest); err != nil {
+		return err
+	}
+	return db.checked("forget", db.forget(newOldestID))
+}
+
+// Count is the thread-safe version of 'LockFreeChunkDB.Count'.
+func (db *ChunkDB) Count(from, to uint64, pred func(meta EntryMeta) bool) (uint64, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.Count(from, to, pred)
+}
+
+// Count counts the entries from 'from' to 'to', inclusive, which the predicate holds for, or all of them if it
+// is nil. As with 'ForgetWhile', the predicate is given the metadata of each entry, so entries themselves are
+// never read. Entries which have been dropped by 'Downsample' are not counted.
+//
+// Returns 'ErrIDOutOfRange' if any of the entries do not exist (including if 'to' is older than 'from'), and
+// 'ErrClosed' if the handle is closed.
+func (db *LockFreeChunkDB) Count(from, to uint64, pred func(meta EntryMeta) bool) (uint64, error) {
+	var n uint64
+	err := db.eachMatching(from, to, pred, func(*chunk, uint64, int32, int32) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// ScanWhere is the thread-safe version of 'LockFreeChunkDB.ScanWhere'. The read lock is held for the whole of
+// the scan, so 'fn' must not write to the database.
+func (db *ChunkDB) ScanWhere(from, to uint64, pred func(meta EntryMeta) bool, fn func(id uint64, entry []byte) error) error {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.ScanWhere(from, to, pred, fn)
+}
+
+// ScanWhere calls 'fn' with each entry from 'from' to 'to', inclusive, which the predicate holds for, or with
+// all of them if it is nil. The predicate is given the metadata of each entry, as with 'Count', and only the
+// entries it holds for are read, so a selective predicate saves reading most of the log. Entries which have
+// been dropped by 'Downsample' are skipped. The entry passed to 'fn' is only valid for the duration of the
+// call.
+//
+// The scan stops at the first error 'fn' returns, which is returned. Otherwise, returns 'ErrIDOutOfRange' if any
+// of the entries do not exist (including if 'to' is older than 'from'), 'ErrChecksumMismatch' if an entry is
+// corrupt, and 'ErrClosed' if the handle is closed.
+func (db *LockFreeChunkDB) ScanWhere(from, to uint64, pred func(meta EntryMeta) bool, fn func(id uint64, entry []byte) error) error {
+	return db.eachMatching(from, to, pred, func(c *chunk, id uint64, start, end int32) error {
+		entry, err := c.slice(start, end)
+		if err != nil {
+			return err
+		}
+		if err := c.check(id, entry); err != nil {
+			return err
+		}
+		return fn(id, entry)
+	})
+}
+
+// ScanMeta is the thread-safe version of 'LockFreeChunkDB.ScanMeta'. The read lock is held for the whole of the
+// scan, so 'fn' must not write to the database.
+func (db *ChunkDB) ScanMeta(from, to uint64, fn func(id uint64, meta EntryMeta) error) error {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.ScanMeta(from, to, fn)
+}
+
+// ScanMeta calls 'fn' with the metadata of each entry from 'from' to 'to', inclusive, without reading the entries
+// themselves. Entries which have been dropped by 'Downsample' are skipped. The header passed to 'fn' is only
+// valid for the duration of the call.
+//
+// The scan stops at the first error 'fn' returns, which is returned. Otherwise, returns 'ErrIDOutOfRange' if any
+// of the entries do not exist (including if 'to' is older than 'from'), and 'ErrClosed' if the handle is closed.
+func (db *LockFreeChunkDB) ScanMeta(from, to uint64, fn func(id uint64, meta EntryMeta) error) error {
+	return db.eachMatching(from, to, nil, func(c *chunk, id uint64, start, end int32) error {
+		return fn(id, c.entryMeta(id, start, end))
+	})
+}
+
+////////// HELPERS //////////
+
+// Call 'fn' with each entry from 'from' to 'to', inclusive, which has not been dropped and which the predicate
+// holds for (if it is not nil). Assumes a lock (read or write) is held.
+func (db *LockFreeChunkDB) eachMatching(from, to uint64, pred func(EntryMeta) bool, fn func(c *chunk, id uint64, start, end int32) error) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if from < db.oldest || to >= db.next() || to < from || len(db.chunks) == 0 {
+		return ErrIDOutOfRange
+	}
+
+	for i := db.findChunk(from); from <= to; i++ {
+		c := db.chunks[i]
+		last := c.next() - 1
+		if last > to {
+			last = to
+		}
+		for id := from; id <= last; id++ {
+			_, start, end := c.find(id)
+			if start == end && c.sums[id-c.oldest] == droppedSum {
+				continue
+			}
+			if pred != nil && !pred(c.entryMeta(id, start, end)) {
+				continue
+			}
+			if err := fn(c, id, start, end); err != nil {
+				return err
+			}
+		}
+		from = last + 1
+	}
+	return nil
+}
+
+// Get the metadata of an entry, given its ID and its start and end offsets.
+func (c *chunk) entryMeta(id uint64, start, end int32) EntryMeta {
+	return EntryMeta{Size: uint64(end - start), Label: c.label(id), Header: c.header(id)}
+}
+
+// Get the header of an entry in a chunk, which must contain it.
+func (c *chunk) header(id uint64) []byte {
+	if c.headers == nil {
+		return nil
+	}
+	return c.headers[id-c.oldest]
+}
+
+// Record the header of the entry which has just been added to the chunk, as 'appendLabel' does. Empty headers
+// are recorded as nil.
+func (c *chunk) appendHeader(header []byte) {
+	if len(header) == 0 {
+		header = nil
+	} else {
+		header = append([]byte(nil), header...)
+	}
+	if header != nil && c.headers == nil {
+		c.headers = make([][]byte, len(c.ends)-1, cap(c.ends))
+	}
+	if c.headers != nil {
+		c.headers = append(c.headers, header)
+	}
+}
@@ -0,0 +1,135 @@
+package logdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestMeta_ForgetWhile(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "meta_forget_while", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	vs := filldb(t, db, numEntries)
+
+	var seen uint64
+	err := db.ForgetWhile(func(id uint64, meta EntryMeta) bool {
+		assert.Equal(t, uint64(len(vs[id-1])), meta.Size, "expected entry size")
+		seen++
+		return id < 100
+	})
+	assert.Nil(t, err, "expected no error in forget")
+	assert.Equal(t, uint64(100), seen, "expected predicate to stop being called once false")
+	assert.Equal(t, uint64(100), db.OldestID(), "expected entries to be forgotten while the predicate held")
+
+	err = db.ForgetWhile(func(uint64, EntryMeta) bool { return true })
+	assert.Nil(t, err, "expected no error in forget")
+	assert.Equal(t, db.NewestID(), db.OldestID(), "expected newest entry to be kept")
+	assert.Equal(t, vs[len(vs)-1], assertGet(t, db, db.NewestID()), "expected newest entry to be intact")
+}
+
+func TestMeta_ForgetWhileEmpty(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "meta_forget_while_empty", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+
+	err := db.ForgetWhile(func(uint64, EntryMeta) bool { return true })
+	assert.Nil(t, err, "expected no error in forget")
+}
+
+func TestMeta_Count(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "meta_count", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+
+	n, err := db.Count(1, numEntries, func(meta EntryMeta) bool { return meta.Size == uint64(len("entry-10")) })
+	assert.Nil(t, err, "expected no error in count")
+	assert.Equal(t, uint64(90), n, "expected the entries with two-digit numbers to be counted")
+
+	n, err = db.Count(5, 10, nil)
+	assert.Nil(t, err, "expected no error in count")
+	assert.Equal(t, uint64(6), n, "expected every entry to be counted")
+
+	_, err = db.Count(0, numEntries, nil)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected the range to be checked")
+}
+
+func TestMeta_ScanWhere(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "meta_scan_where", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	vs := filldb(t, db, numEntries)
+
+	var ids []uint64
+	err := db.ScanWhere(1, numEntries, func(meta EntryMeta) bool { return meta.Size == uint64(len("entry-0")) }, func(id uint64, entry []byte) error {
+		assert.Equal(t, vs[id-1], entry, "expected the entry")
+		ids = append(ids, id)
+		return nil
+	})
+	assert.Nil(t, err, "expected no error in scan")
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, ids, "expected the entries with one-digit numbers")
+
+	stop := errors.New("stop")
+	var seen int
+	err = db.ScanWhere(1, numEntries, nil, func(uint64, []byte) error {
+		seen++
+		return stop
+	})
+	assert.Equal(t, stop, err, "expected the error from the callback")
+	assert.Equal(t, 1, seen, "expected the scan to stop at the first error")
+}
+
+func TestMeta_Headers(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "meta_headers", chunkSize).(*ChunkDB)
+
+	// Every third entry has no header.
+	header := func(id uint64) []byte {
+		if id%3 == 0 {
+			return nil
+		}
+		return []byte{byte(id), 'h'}
+	}
+	vs := make([][]byte, numEntries)
+	for i := range vs {
+		vs[i] = []byte(fmt.Sprintf("entry-%v", i))
+		id, err := db.AppendWithHeader(header(uint64(i+1)), vs[i])
+		assert.Nil(t, err, "expected no error in append with header")
+		assert.Equal(t, uint64(i+1), id, "expected the next ID")
+	}
+
+	// Rolling back and appending again replaces the headers.
+	assertRollback(t, db, numEntries-2)
+	_, err := db.AppendEntriesWithHeaders([][]byte{header(numEntries - 1), header(numEntries)}, vs[numEntries-2:])
+	assert.Nil(t, err, "expected no error in append entries with headers")
+
+	_, err = db.AppendWithHeader(make([]byte, MaxHeaderSize+1), []byte("big"))
+	assert.Equal(t, ErrHeaderTooBig, err, "expected the header size to be checked")
+	_, err = db.AppendEntriesWithHeaders([][]byte{nil}, [][]byte{[]byte("a"), []byte("b")})
+	assert.Equal(t, ErrHeaderCount, err, "expected the header count to be checked")
+
+	assertHeaders := func() {
+		for id := db.OldestID(); id <= db.NewestID(); id++ {
+			entry, meta, err := db.GetWithMeta(id)
+			assert.Nil(t, err, "expected no error in get with meta")
+			assert.Equal(t, vs[id-1], entry, "expected the entry")
+			assert.Equal(t, header(id), meta.Header, "expected the header of entry %v", id)
+			assert.Equal(t, uint64(len(vs[id-1])), meta.Size, "expected the size of entry %v", id)
+		}
+
+		n, err := db.Count(db.OldestID(), db.NewestID(), func(meta EntryMeta) bool { return meta.Header == nil })
+		assert.Nil(t, err, "expected no error in count")
+		assert.Equal(t, db.NewestID()/3-(db.OldestID()-1)/3, n, "expected the entries without headers")
+	}
+	assertHeaders()
+	assertClose(t, db)
+
+	// Headers persist, and are kept by compaction.
+	db = assertOpen(t, dbTypes["chunkdb"], false, "meta_headers", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assertHeaders()
+	assertForget(t, db, 100)
+	assert.Nil(t, db.Compact(), "expected no error in compact")
+	assertHeaders()
+}
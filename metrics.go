@@ -0,0 +1,108 @@
+package logdb
+
+import "expvar"
+
+// A Metric is a count of something a database does, reported to a 'MetricsCollector'.
+type Metric int
+
+// The metrics reported to a 'MetricsCollector'.
+const (
+	// Entries appended.
+	MetricAppends Metric = iota
+
+	// Entries read by 'Get' or 'GetEntries'.
+	MetricGets
+
+	// Syncs, whether by 'Sync' or periodic.
+	MetricSyncs
+
+	// Chunks created.
+	MetricChunkRollovers
+
+	// Chunks deleted, or moved to the trash.
+	MetricChunkDeletions
+
+	// Appends, gets, forgets, rollbacks, truncates, and syncs which failed.
+	MetricErrors
+)
+
+func (m Metric) String() string {
+	switch m {
+	case MetricAppends:
+		return "appends"
+	case MetricGets:
+		return "gets"
+	case MetricSyncs:
+		return "syncs"
+	case MetricChunkRollovers:
+		return "chunk_rollovers"
+	case MetricChunkDeletions:
+		return "chunk_deletions"
+	case MetricErrors:
+		return "errors"
+	}
+	return "unknown"
+}
+
+// A MetricsCollector receives counts of what a database does, to export them to a monitoring system, such as
+// Prometheus or expvar. Every metric is a counter, which only goes up.
+//
+// 'Add' is called synchronously, with any database locks held, and (as gets can be concurrent) from many
+// goroutines at once. So it must be safe for concurrent use, must not use the database, and should return
+// quickly.
+type MetricsCollector interface {
+	// Add is called when something has happened 'n' times.
+	Add(metric Metric, n uint64)
+}
+
+// ExpvarMetrics is a 'MetricsCollector' which adds to the entries of an 'expvar.Map', with the names given by
+// 'Metric.String'.
+func ExpvarMetrics(m *expvar.Map) MetricsCollector {
+	return expvarMetrics{m}
+}
+
+// WithMetricsCollector sets the metrics collector, as 'SetMetricsCollector' does.
+func WithMetricsCollector(collector MetricsCollector) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetMetricsCollector(collector)
+		return nil
+	})
+}
+
+// SetMetricsCollector is the thread-safe version of 'LockFreeChunkDB.SetMetricsCollector'.
+func (db *ChunkDB) SetMetricsCollector(collector MetricsCollector) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetMetricsCollector(collector)
+}
+
+// SetMetricsCollector sets the collector which metrics are reported to. nil, the default, disables metrics.
+func (db *LockFreeChunkDB) SetMetricsCollector(collector MetricsCollector) {
+	db.metrics = collector
+}
+
+////////// HELPERS //////////
+
+// A 'MetricsCollector' for an 'expvar.Map'.
+type expvarMetrics struct {
+	m *expvar.Map
+}
+
+func (e expvarMetrics) Add(metric Metric, n uint64) {
+	e.m.Add(metric.String(), int64(n))
+}
+
+// Report a metric, if there is a collector.
+func (db *LockFreeChunkDB) count(metric Metric, n uint64) {
+	if db.metrics != nil && n > 0 {
+		db.metrics.Add(metric, n)
+	}
+}
+
+// Report an error returned by an operation, if there is one.
+func (db *LockFreeChunkDB) countError(err error) {
+	if err != nil {
+		db.count(MetricErrors, 1)
+	}
+}
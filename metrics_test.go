@@ -0,0 +1,62 @@
+package logdb
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	collector := &countingCollector{counts: make(map[Metric]uint64)}
+	db := assertOpen(t, dbTypes["chunkdb"], true, "metrics", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	db.SetMetricsCollector(collector)
+
+	filldb(t, db, numEntries)
+	assertGet(t, db, 1)
+	_, err := db.GetEntries(1, 10)
+	assert.Nil(t, err, "expected no error in get entries")
+	_, err = db.Get(numEntries + 1)
+	assert.NotNil(t, err, "expected error getting missing entry")
+	assertForget(t, db, 200)
+	assertSync(t, db)
+
+	assert.Equal(t, uint64(numEntries), collector.get(MetricAppends), "expected appends")
+	assert.Equal(t, uint64(11), collector.get(MetricGets), "expected gets")
+	assert.Equal(t, uint64(1), collector.get(MetricErrors), "expected one error")
+	assert.True(t, collector.get(MetricSyncs) >= 1, "expected syncs")
+	assert.Equal(t, uint64(len(db.chunks)), collector.get(MetricChunkRollovers)-collector.get(MetricChunkDeletions), "expected rollovers and deletions to account for the chunks")
+	assert.True(t, collector.get(MetricChunkDeletions) > 0, "expected chunk deletions")
+}
+
+func TestMetrics_Expvar(t *testing.T) {
+	m := new(expvar.Map).Init()
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "metrics_expvar", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	db.SetMetricsCollector(ExpvarMetrics(m))
+
+	filldb(t, db, numEntries)
+	assert.Equal(t, int64(numEntries), m.Get("appends").(*expvar.Int).Value(), "expected appends")
+}
+
+////////// HELPERS //////////
+
+// A 'MetricsCollector' which counts in a map.
+type countingCollector struct {
+	mutex  sync.Mutex
+	counts map[Metric]uint64
+}
+
+func (c *countingCollector) Add(metric Metric, n uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[metric] += n
+}
+
+func (c *countingCollector) get(metric Metric) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.counts[metric]
+}
@@ -0,0 +1,334 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the directory the files of a database are backed up to while it is being migrated.
+const migrateDir = ".migrate"
+
+// Migrate upgrades a database in place from an older disk format version to the newest, one version at a time,
+// and returns the version it was upgraded from. A database which is already the newest version is left alone.
+//
+// Before upgrading, the database is backed up to the ".migrate" subdirectory (chunk data files are hard linked,
+// everything else is copied), and if any step fails, the backup is restored, so the database is either fully
+// upgraded or unchanged. If the process dies while migrating, calling 'Migrate' again restores the backup
+// before trying again. The backup is deleted once the upgrade is complete.
+//
+// Returns 'ErrUnknownVersion' if the database is newer than this version of the library, or if there is no
+// upgrade path from its version; a 'LockError' value if it is open, including by a 'Reader'; a 'ReadError'
+// value if it could not be read; and a 'WriteError' value if it could not be backed up, upgraded, or restored.
+//
+// The disk format versions are:
+//
+//   - 0: the original format.
+//   - 1: chunk metadata records have a checksum of the entry. Upgrading computes the checksums from the chunk
+//     data files, so any corruption which is already there will go undetected.
+//   - 2: the top 8 bits of the index in each chunk metadata record are the label of the entry (see
+//     'AppendLabelled'), so a chunk holds at most 2^24 entries. Upgrading changes nothing but the version,
+//     unless a chunk has more entries, in which case it fails.
+//   - 3: a chunk metadata record may be followed by a header record, giving the header of the entry (see
+//     'AppendWithHeader'), which is marked by the largest index, so a chunk holds at most 2^24-1 entries.
+//     Upgrading changes nothing but the version, unless a chunk has 2^24 entries, in which case it fails.
+//   - 4: the "chunk_entries" file always exists, giving the most entries a chunk may hold, or 0 for no limit
+//     (see 'WithChunkEntries'), so a database which has lost it cannot be opened. Upgrading writes the file
+//     with no limit, unless the database already has one.
+func Migrate(path string) (uint16, error) {
+	return migrate(path, latestVersion, migrations)
+}
+
+////////// HELPERS //////////
+
+// A migration upgrades the files of a database from one disk format version to the next. A migration must not
+// modify chunk data files, as they are hard linked into the backup: it must write new files and rename them
+// into place instead. The version file is written by 'migrate', not by the migration.
+type migration func(path string) error
+
+// The migrations, by the version they upgrade from.
+var migrations = map[uint16]migration{
+	0: addChecksums,
+	1: addLabels,
+	2: addHeaders,
+	3: addChunkEntries,
+}
+
+// Upgrade a database to the given version with the given migrations.
+func migrate(path string, to uint16, steps map[uint16]migration) (uint16, error) {
+	lockfile, heartbeat, err := lockdb(path, false)
+	if err != nil {
+		return 0, &LockError{err}
+	}
+	defer func() { _ = unlockdb(lockfile, heartbeat) }()
+
+	// Readers would see the files change under them, so there must not be any.
+	readers, err := lockOutReaders(path)
+	if err != nil {
+		return 0, &LockError{err}
+	}
+	defer func() { _ = funlock(readers) }()
+
+	// Finish off an interrupted migration by restoring the backup.
+	if _, err := os.Stat(path + "/" + migrateDir); err == nil {
+		if err := restoreBackup(path); err != nil {
+			return 0, &WriteError{err}
+		}
+	}
+
+	var from uint16
+	if err := readFile(path+"/version", &from); err != nil {
+		return 0, &ReadError{err}
+	}
+	if from == to {
+		return from, nil
+	}
+	if !canMigrate(from, to, steps) {
+		return from, ErrUnknownVersion
+	}
+
+	if err := backup(path); err != nil {
+		_ = os.RemoveAll(path + "/" + migrateDir)
+		return from, &WriteError{err}
+	}
+	for v := from; v < to; v++ {
+		err := steps[v](path)
+		if err == nil {
+			err = writeFile(path+"/version", v+1)
+		}
+		if err != nil {
+			if rerr := restoreBackup(path); rerr != nil {
+				return from, &WriteError{rerr}
+			}
+			return from, &WriteError{err}
+		}
+	}
+	if err := os.RemoveAll(path + "/" + migrateDir); err != nil {
+		return from, &WriteError{err}
+	}
+	return from, nil
+}
+
+// Check that there is a migration for every version from 'from' up to 'to'.
+func canMigrate(from, to uint16, steps map[uint16]migration) bool {
+	if from > to {
+		return false
+	}
+	for v := from; v < to; v++ {
+		if steps[v] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Back up every file of a database into the migration directory, preserving the directory structure and
+// modification times (which sealed chunks are checked against). Chunk data files are hard linked, as they can
+// be large.
+func backup(path string) error {
+	return filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(file, path)
+		if rel == "/"+migrateDir {
+			return filepath.SkipDir
+		}
+		to := path + "/" + migrateDir + rel
+		if fi.IsDir() {
+			return os.MkdirAll(to, os.ModeDir|0755)
+		}
+		if isBasenameChunkDataFile(fi.Name()) {
+			return os.Link(file, to)
+		}
+		if err := copyFile(file, to); err != nil {
+			return err
+		}
+		return os.Chtimes(to, fi.ModTime(), fi.ModTime())
+	})
+}
+
+// Restore a database from the migration directory, deleting anything which is not in the backup, and then
+// delete the backup.
+func restoreBackup(path string) error {
+	backed := make(map[string]bool)
+	err := filepath.Walk(path+"/"+migrateDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		backed[strings.TrimPrefix(file, path+"/"+migrateDir)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Delete files created by the migration, then move the backup into place.
+	err = filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(file, path)
+		if rel == "/"+migrateDir {
+			return filepath.SkipDir
+		}
+		if rel != "" && !backed[rel] {
+			if err := os.RemoveAll(file); err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(path+"/"+migrateDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		to := path + strings.TrimPrefix(file, path+"/"+migrateDir)
+		if fi.IsDir() {
+			return os.MkdirAll(to, os.ModeDir|0755)
+		}
+		return os.Rename(file, to)
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path + "/" + migrateDir)
+}
+
+// Version 0 to 1: add a checksum of each entry to the chunk metadata records, wherever there are chunk files
+// (including the trash and any staged import).
+func addChecksums(path string) error {
+	if err := checkChunkIndices(path, 8, 0xFFFFFFFF, 1<<24); err != nil {
+		return err
+	}
+	return filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && (fi.Name() == migrateDir || fi.Name() == backupLinks) {
+			return filepath.SkipDir
+		}
+		if fi.IsDir() || !isBasenameChunkDataFile(fi.Name()) {
+			return nil
+		}
+		return addChunkChecksums(file)
+	})
+}
+
+// Rewrite the metadata file of one chunk with checksums. If the chunk is sealed, the metadata file changes, so
+// the seal file is rewritten too.
+func addChunkChecksums(path string) error {
+	c := &chunk{path: path}
+	mfi, err := os.Stat(c.metaFilePath())
+	if err != nil {
+		return err
+	}
+	mfile, err := os.Open(c.metaFilePath())
+	if err != nil {
+		return err
+	}
+	m, err := readMetadataRecords(mfile, 0)
+	_ = mfile.Close()
+	c.ends = m.ends
+	if err != nil {
+		return &ChunkMetaError{ChunkFilePath: path, Err: err}
+	}
+	if c.bytes, err = ioutil.ReadFile(path); err != nil {
+		return err
+	}
+
+	var start int32
+	for _, end := range c.ends {
+		if int(end) > len(c.bytes) {
+			return &ChunkMetaError{ChunkFilePath: path, Err: &MetaOffsetError{Expected: int32(len(c.bytes)), Actual: end}}
+		}
+		c.sums = append(c.sums, checksum(c.bytes[start:end]))
+		start = end
+	}
+
+	tmpPath := c.metaFilePath() + tmpSuffix
+	if err := writeFile(tmpPath, encodeMetadata(nil, c.ends, c.sums, nil, nil, 0)); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, mfi.Mode()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, c.metaFilePath()); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(c.sealFilePath()); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rec, err := c.sealRecord()
+	if err != nil {
+		return err
+	}
+	return writeFile(c.sealFilePath(), rec)
+}
+
+// A chunk has too many entries to be upgraded.
+var errTooManyEntries = errors.New("chunk has too many entries for the newer disk format version")
+
+// Version 1 to 2: nothing, as long as no chunk has 2^24 or more entries, as before version 2 the index of a
+// chunk metadata record is a full 32 bits, and the top 8 bits of a larger index would be read as a label.
+func addLabels(path string) error {
+	return checkChunkIndices(path, 12, 0xFFFFFFFF, 1<<24)
+}
+
+// Version 2 to 3: nothing, as long as no chunk has so many entries that the index of the last is the header
+// record marker.
+func addHeaders(path string) error {
+	return checkChunkIndices(path, 12, indexMask, headerMarker)
+}
+
+// Migrate from version 3 to version 4: write the chunk entries file with no limit, if it does not exist.
+func addChunkEntries(path string) error {
+	if _, err := os.Stat(path + "/chunk_entries"); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return writeFile(path+"/chunk_entries", uint32(0))
+}
+
+// Check that no chunk metadata record has an index of 'limit' or more, after masking off the bits which are not
+// part of the index, refusing to upgrade a database with chunks which the newer format cannot represent. As
+// every record before version 3 is the same size, the records can be checked without being parsed, which must
+// be done before parsing them with 'readMetadataRecords', as it would mask off the top bits of the index too.
+//
+// Returns a 'ChunkMetaError' value wrapping 'errTooManyEntries' if a chunk has too many entries.
+func checkChunkIndices(path string, recordSize int, mask, limit uint32) error {
+	return filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && (fi.Name() == migrateDir || fi.Name() == backupLinks) {
+			return filepath.SkipDir
+		}
+		if fi.IsDir() || !isBasenameChunkMetaFile(fi.Name()) {
+			return nil
+		}
+		meta, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for i := 0; i+recordSize <= len(meta); i += recordSize {
+			if binary.LittleEndian.Uint32(meta[i:])&mask >= limit {
+				return &ChunkMetaError{ChunkFilePath: file, Err: errTooManyEntries}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,103 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestMigrate_Latest(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "migrate_latest", chunkSize)
+	vs := filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	from, err := Migrate("test_db/migrate_latest")
+	assert.Nil(t, err, "expected no error in migrate")
+	assert.Equal(t, latestVersion, from, "expected nothing to upgrade")
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "migrate_latest", chunkSize)
+	defer assertClose(t, db)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after migrating")
+	}
+}
+
+func TestMigrate_Rollback(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "migrate_rollback", chunkSize)
+	vs := filldb(t, db, numEntries)
+	assertClose(t, db)
+	path := "test_db/migrate_rollback"
+
+	failing := map[uint16]migration{
+		latestVersion: func(path string) error {
+			return ioutil.WriteFile(path+"/new_file", []byte("new"), 0644)
+		},
+		latestVersion + 1: func(path string) error {
+			if err := os.Remove(path + "/" + initialMetaFile); err != nil {
+				return err
+			}
+			return errors.New("migration failed")
+		},
+	}
+	_, err := migrate(path, latestVersion+2, failing)
+	assert.NotNil(t, err, "expected the migration to fail")
+	_, err = os.Stat(path + "/new_file")
+	assert.True(t, os.IsNotExist(err), "expected files created by the migration to be removed")
+	_, err = os.Stat(path + "/" + migrateDir)
+	assert.True(t, os.IsNotExist(err), "expected the backup to be removed")
+
+	_, err = migrate(path, latestVersion+1, nil)
+	assert.Equal(t, ErrUnknownVersion, err, "expected no upgrade path")
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "migrate_rollback", chunkSize)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after rolling back")
+	}
+	assertClose(t, db)
+
+	// A migration interrupted after the backup was made blocks opening, until it is completed.
+	assert.Nil(t, backup(path), "expected no error in backup")
+	_, err = Open(path, 0, false)
+	assert.Equal(t, ErrMigrationIncomplete, err, "expected opening to fail")
+	_, err = Migrate(path)
+	assert.Nil(t, err, "expected no error completing the migration")
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "migrate_rollback", chunkSize)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(numEntries), db.NewestID(), "expected the entries to remain")
+}
+
+func TestMigrate_TooManyEntries(t *testing.T) {
+	for _, version := range []uint16{0, 1} {
+		name := fmt.Sprintf("migrate_too_many_entries_%v", version)
+		db := assertOpen(t, dbTypes["lock free chunkdb"], true, name, chunkSize)
+		filldb(t, db, numEntries)
+		assertClose(t, db)
+		path := "test_db/" + name
+
+		// Before version 2, the index of a metadata record is a full 32 bits, so a chunk can have an entry
+		// with an index of 2^24, the top bits of which would be read as a label.
+		record := binary.LittleEndian.AppendUint32(nil, 1<<24)
+		record = binary.LittleEndian.AppendUint32(record, 0)
+		if version == 0 {
+			downgradeToVersion0(t, path)
+		} else {
+			record = binary.LittleEndian.AppendUint32(record, 0)
+			assert.Nil(t, writeFile(path+"/version", version), "expected no error writing version")
+		}
+		metaPath := path + "/" + initialMetaFile
+		assert.Nil(t, os.Chmod(metaPath, 0644), "expected no error making metadata writable")
+		assert.Nil(t, appendFile(metaPath, record), "expected no error writing metadata")
+
+		_, err := Migrate(path)
+		assert.True(t, errors.Is(err, errTooManyEntries), "expected too many entries from version %v, got: %s", version, err)
+
+		var actual uint16
+		assert.Nil(t, readFile(path+"/version", &actual), "expected no error reading version")
+		assert.Equal(t, version, actual, "expected the database to be left at version %v", version)
+	}
+}
@@ -0,0 +1,373 @@
+package logdb
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMirrorDiverged means that entries which had already been mirrored were rolled back in the source, and the
+// destination could not be rolled back to match because the mirror filters entries, or because the source was
+// rolled back past the entry the mirror started from.
+var ErrMirrorDiverged = errors.New("mirrored entries rolled back in source")
+
+// MirrorOptions configure 'Mirror'. The zero value copies every entry unchanged.
+type MirrorOptions struct {
+	// Filter, if not nil, is called for every entry of the source. Entries it returns false for are not
+	// copied, which means that IDs in the destination do not correspond to IDs in the source.
+	Filter func(id uint64, entry []byte) bool
+
+	// Transform, if not nil, re-encodes entries before they are appended to the destination. If it returns an
+	// error, mirroring stops.
+	Transform func(id uint64, entry []byte) ([]byte, error)
+
+	// From is the ID of the first source entry to copy. If 0, mirroring resumes after the newest entry of the
+	// destination if there is no filter, and otherwise starts from the oldest entry of the source.
+	From uint64
+
+	// How often to check the source for new entries. If 0, this is 100ms. Calling 'Wake' checks immediately.
+	PollInterval time.Duration
+
+	// Maximum number of entries to append to the destination at once. If 0, this is 256.
+	BatchSize int
+
+	// Maximum number of bytes of entries to append to the destination per second, so that mirroring does not
+	// starve other users of the databases. If 0, there is no limit.
+	BytesPerSecond uint64
+}
+
+// MirrorStatus describes the progress of a 'Mirror'.
+type MirrorStatus struct {
+	// ID of the newest source entry which has been dealt with (copied or filtered out), and the newest entry of
+	// the source when last checked.
+	Mirrored uint64
+	Newest   uint64
+
+	// Number of entries copied, filtered out, and lost because the source forgot them (or 'Downsample' dropped
+	// them) before they could be copied.
+	Copied   uint64
+	Filtered uint64
+	Lost     uint64
+
+	// When entries were last copied.
+	LastCopy time.Time
+
+	// The error which stopped mirroring, if any.
+	Err error
+}
+
+// Lag is the number of source entries which have not yet been dealt with.
+func (s MirrorStatus) Lag() uint64 {
+	if s.Newest < s.Mirrored {
+		return 0
+	}
+	return s.Newest - s.Mirrored
+}
+
+// A Mirroring is a running 'Mirror'.
+type Mirroring struct {
+	src, dst LogDB
+	opts     MirrorOptions
+	limit    *rateLimiter
+
+	// The next source ID to deal with, and the generation of the source when last checked.
+	next uint64
+	gen  uint64
+
+	// The source ID the mirror started from, and, without filtering, where source IDs are copied to in the
+	// destination, in increasing order.
+	from     uint64
+	segments []mirrorSegment
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	lock   sync.Mutex
+	status MirrorStatus
+}
+
+// Mirror starts a goroutine which keeps 'dst' a copy of 'src', possibly filtered and re-encoded. Both databases
+// must be safe for concurrent use, and nothing else should append to 'dst'.
+//
+// New entries are found by polling the source; a writer can call 'Wake' after appending to have them copied
+// straight away. Entries which the source forgets before they are copied are lost. If the source rolls back
+// entries which have been copied, the destination is rolled back too; unless entries are filtered, in which
+// case mirroring stops with 'ErrMirrorDiverged'. If the source is a 'GenerationDB', every rollback is noticed
+// through its generation. Otherwise, a rollback is only noticed if the source has fewer entries than have been
+// copied when it is checked, so entries which are rolled back and replaced between checks are not detected.
+// Either way, rollbacks made while the mirror is not running are not detected.
+//
+// Entries which 'Downsample' has dropped from the source are lost. Unless entries are filtered, the hole is
+// forwarded to the destination as an empty entry, so that IDs in the destination still correspond to IDs in the
+// source.
+func Mirror(src, dst LogDB, opts MirrorOptions) *Mirroring {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 100 * time.Millisecond
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 256
+	}
+
+	next := opts.From
+	if next == 0 {
+		if opts.Filter == nil && dst.NewestID() > 0 {
+			next = dst.NewestID() + 1
+		} else {
+			next = src.OldestID()
+		}
+	}
+	if next == 0 {
+		next = 1
+	}
+
+	m := &Mirroring{
+		src:   src,
+		dst:   dst,
+		opts:  opts,
+		limit: newRateLimiter(opts.BytesPerSecond),
+		next:  next,
+		from:  next,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if gsrc, ok := src.(GenerationDB); ok {
+		m.gen = gsrc.Generation()
+	}
+	m.segments = []mirrorSegment{{src: next, dst: dst.NewestID() + 1}}
+	m.status.Mirrored = next - 1
+	go m.run()
+	return m
+}
+
+// Wake makes the mirror check the source for new entries now, rather than at the next poll.
+func (m *Mirroring) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status gets the progress of the mirror.
+func (m *Mirroring) Status() MirrorStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.status
+}
+
+// Stop stops mirroring, waiting for any copy in progress to finish, and returns the error which stopped it
+// early, if any. It is safe to call more than once.
+func (m *Mirroring) Stop() error {
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	<-m.done
+	return m.Status().Err
+}
+
+////////// HELPERS //////////
+
+// Copy entries until stopped or an error occurs.
+func (m *Mirroring) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.catchUp(); err != nil {
+			m.lock.Lock()
+			m.status.Err = err
+			m.lock.Unlock()
+			return
+		}
+		select {
+		case <-m.stop:
+			return
+		case <-m.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Copy everything the source has which has not yet been copied.
+func (m *Mirroring) catchUp() error {
+	for {
+		select {
+		case <-m.stop:
+			return nil
+		default:
+		}
+
+		// Check the generation first, so that a rollback after the entries are looked at is seen next time.
+		rolledBackTo := m.rolledBackTo()
+		oldest, newest := m.src.OldestID(), m.src.NewestID()
+		m.lock.Lock()
+		m.status.Newest = newest
+		m.lock.Unlock()
+
+		// Only entries from the one the mirror started from have been dealt with, so a rollback before that, or
+		// a source which has not yet reached it, does not matter.
+		if rolledBackTo+1 < m.next && m.next > m.from {
+			if err := m.rolledBack(rolledBackTo); err != nil {
+				return err
+			}
+			continue
+		}
+		if newest+1 < m.next && m.next > m.from {
+			if err := m.rolledBack(newest); err != nil {
+				return err
+			}
+			continue
+		}
+		if m.next < oldest {
+			if m.opts.Filter == nil {
+				m.segments = append(m.segments, mirrorSegment{src: oldest, dst: m.dstID(m.next)})
+			}
+			m.lock.Lock()
+			m.status.Lost += oldest - m.next
+			m.lock.Unlock()
+			m.next = oldest
+		}
+		if m.next > newest {
+			return nil
+		}
+
+		if err := m.copyBatch(newest); err != nil {
+			return err
+		}
+	}
+}
+
+// Copy a batch of entries, up to at most 'newest'.
+func (m *Mirroring) copyBatch(newest uint64) error {
+	var entries [][]byte
+	var filtered, lost, holes uint64
+	id := m.next
+	for ; id <= newest && len(entries) < m.opts.BatchSize; id++ {
+		entry, err := m.src.Get(id)
+		if err == ErrIDOutOfRange {
+			// Forgotten or rolled back while copying: stop here and check again.
+			break
+		}
+		if err == ErrDownsampled {
+			lost++
+			if m.opts.Filter == nil {
+				entries = append(entries, []byte{})
+				holes++
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if m.opts.Filter != nil && !m.opts.Filter(id, entry) {
+			filtered++
+			continue
+		}
+		if m.opts.Transform != nil {
+			if entry, err = m.opts.Transform(id, entry); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) > 0 {
+		var size int
+		for _, entry := range entries {
+			size += len(entry)
+		}
+		if !m.limit.wait(size, m.stop) {
+			// Stopped while waiting: nothing has been copied.
+			return nil
+		}
+		if _, err := m.dst.AppendEntries(entries); err != nil {
+			return err
+		}
+	}
+
+	m.lock.Lock()
+	m.status.Mirrored = id - 1
+	m.status.Copied += uint64(len(entries)) - holes
+	m.status.Filtered += filtered
+	m.status.Lost += lost
+	if uint64(len(entries)) > holes {
+		m.status.LastCopy = time.Now()
+	}
+	m.lock.Unlock()
+	m.next = id
+	return nil
+}
+
+// Get the lowest ID the source has been rolled back to since it was last checked, if it is a 'GenerationDB'.
+// Otherwise, or if it has not been rolled back, this is the newest ID already dealt with.
+func (m *Mirroring) rolledBackTo() uint64 {
+	gsrc, ok := m.src.(GenerationDB)
+	if !ok {
+		return m.next - 1
+	}
+	gen := gsrc.Generation()
+	if gen == m.gen {
+		return m.next - 1
+	}
+	to := gsrc.RolledBackTo(m.gen)
+	m.gen = gen
+	return to
+}
+
+// Where a source ID is, or would be, copied to in the destination, without filtering. Source entries from 'src'
+// are copied to destination IDs from 'dst', until the next segment: the source entries between are lost.
+type mirrorSegment struct {
+	src, dst uint64
+}
+
+// Get the destination ID a source entry was copied to, or, if it was lost, the ID of the next entry copied.
+func (m *Mirroring) dstID(src uint64) uint64 {
+	i := len(m.segments) - 1
+	for i > 0 && m.segments[i].src > src {
+		i--
+	}
+	seg := m.segments[i]
+	id := seg.dst + (src - seg.src)
+	if i+1 < len(m.segments) && id > m.segments[i+1].dst {
+		id = m.segments[i+1].dst
+	}
+	return id
+}
+
+// Deal with the source having rolled back entries which have been copied.
+func (m *Mirroring) rolledBack(newest uint64) error {
+	if m.opts.Filter != nil {
+		return ErrMirrorDiverged
+	}
+
+	// Entries before the one the mirror started from have no counterpart in the destination, which may have
+	// other entries there, so a rollback before that removes every mirrored entry, and the mirror waits for the
+	// source to reach it again.
+	if newest+1 < m.from {
+		newest = m.from - 1
+	}
+	target := m.dstID(newest+1) - 1
+	if target > m.dst.NewestID() || target+1 < m.dst.OldestID() {
+		return ErrMirrorDiverged
+	}
+	if err := m.dst.Rollback(target); err != nil {
+		return err
+	}
+	m.next = newest + 1
+
+	// The next entry copied follows on from the destination entry rolled back to.
+	for len(m.segments) > 0 && m.segments[len(m.segments)-1].src >= m.next {
+		m.segments = m.segments[:len(m.segments)-1]
+	}
+	m.segments = append(m.segments, mirrorSegment{src: m.next, dst: target + 1})
+
+	m.lock.Lock()
+	m.status.Mirrored = newest
+	m.lock.Unlock()
+	return nil
+}
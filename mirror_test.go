@@ -0,0 +1,186 @@
+package logdb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestMirror_Copy(t *testing.T) {
+	src := &InMemDB{}
+	dst := WrapForConcurrency(assertOpen(t, dbTypes["lock free chunkdb"], true, "mirror_copy", chunkSize).(*LockFreeChunkDB))
+	defer assertClose(t, dst)
+
+	m := Mirror(src, dst, MirrorOptions{PollInterval: time.Hour})
+	vs := filldb(t, src, numEntries)
+	m.Wake()
+	waitForMirror(t, m, uint64(numEntries))
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+
+	status := m.Status()
+	assert.Equal(t, uint64(numEntries), status.Copied, "expected every entry to be copied")
+	assert.Equal(t, uint64(0), status.Lag(), "expected no lag")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, dst, uint64(i+1)), "expected mirrored entry")
+	}
+}
+
+func TestMirror_FilterTransform(t *testing.T) {
+	src := &InMemDB{}
+	dst := &InMemDB{}
+
+	m := Mirror(src, dst, MirrorOptions{
+		PollInterval: time.Millisecond,
+		Filter:       func(id uint64, _ []byte) bool { return id%2 == 0 },
+		Transform:    func(_ uint64, entry []byte) ([]byte, error) { return bytes.ToUpper(entry), nil },
+	})
+	vs := filldb(t, src, numEntries)
+	waitForMirror(t, m, uint64(numEntries))
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+
+	status := m.Status()
+	assert.Equal(t, uint64(numEntries/2), status.Copied, "expected even entries to be copied")
+	assert.Equal(t, uint64(numEntries-numEntries/2), status.Filtered, "expected odd entries to be filtered")
+	assert.Equal(t, bytes.ToUpper(vs[1]), assertGet(t, dst, 1), "expected transformed entry")
+}
+
+func TestMirror_Rollback(t *testing.T) {
+	src := &InMemDB{}
+	dst := &InMemDB{}
+
+	m := Mirror(src, dst, MirrorOptions{PollInterval: time.Millisecond})
+	filldb(t, src, numEntries)
+	waitForMirror(t, m, uint64(numEntries))
+
+	assertRollback(t, src, 100)
+	waitForMirror(t, m, 100)
+	assertAppend(t, src, []byte("replacement"))
+	waitForMirror(t, m, 101)
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+
+	assert.Equal(t, uint64(101), dst.NewestID(), "expected destination to be rolled back")
+	assert.Equal(t, []byte("replacement"), assertGet(t, dst, 101), "expected replacement entry")
+}
+
+func TestMirror_RollbackReplaced(t *testing.T) {
+	src := WrapForConcurrency(assertOpen(t, dbTypes["lock free chunkdb"], true, "mirror_rollback_replaced", chunkSize).(*LockFreeChunkDB))
+	defer assertClose(t, src)
+	dst := &InMemDB{}
+
+	m := Mirror(src, dst, MirrorOptions{PollInterval: time.Hour})
+	vs := filldb(t, src, numEntries)
+	m.Wake()
+	waitForMirror(t, m, uint64(numEntries))
+
+	// Replace the rolled back entries with more than there were, so that only the generation shows it.
+	assertRollback(t, src, 100)
+	assertAppendEntries(t, src, vs)
+	m.Wake()
+	waitForMirror(t, m, uint64(100+numEntries))
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, dst, uint64(101+i)), "expected replacement entry")
+	}
+}
+
+func TestMirror_RollbackFrom(t *testing.T) {
+	src := &InMemDB{}
+	dst := &InMemDB{}
+	assertAppend(t, dst, []byte("existing"))
+	vs := filldb(t, src, numEntries)
+
+	// Source entries from 50 are copied to destination entries from 2.
+	m := Mirror(src, dst, MirrorOptions{PollInterval: time.Millisecond, From: 50})
+	waitForMirror(t, m, uint64(numEntries))
+	assert.Equal(t, uint64(numEntries-48), dst.NewestID(), "expected entries from 50 to be copied")
+
+	assertRollback(t, src, 100)
+	waitForMirror(t, m, 100)
+	assertAppend(t, src, []byte("replacement"))
+	waitForMirror(t, m, 101)
+	assert.Equal(t, uint64(53), dst.NewestID(), "expected the destination to be rolled back to match")
+	assert.Equal(t, vs[99], assertGet(t, dst, 52), "expected the entry rolled back to")
+	assert.Equal(t, []byte("replacement"), assertGet(t, dst, 53), "expected the replacement entry")
+
+	// A rollback before the entry the mirror started from removes every mirrored entry, but nothing else.
+	assertRollback(t, src, 40)
+	for dst.NewestID() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 20; i++ {
+		assertAppend(t, src, []byte("again"))
+	}
+	waitForMirror(t, m, 60)
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+	assert.Equal(t, []byte("existing"), assertGet(t, dst, 1), "expected the existing entry to be kept")
+	assert.Equal(t, uint64(12), dst.NewestID(), "expected copying to start from 50 again")
+}
+
+func TestMirror_RollbackLost(t *testing.T) {
+	src := &InMemDB{}
+	dst := &InMemDB{}
+
+	// Entries 11 to 20 are forgotten before they can be copied, so source entries from 21 are copied to
+	// destination entries from 11.
+	m := Mirror(src, dst, MirrorOptions{PollInterval: time.Hour})
+	filldb(t, src, 10)
+	m.Wake()
+	waitForMirror(t, m, 10)
+	for i := 0; i < 20; i++ {
+		assertAppend(t, src, []byte("later"))
+	}
+	assertForget(t, src, 21)
+	m.Wake()
+	waitForMirror(t, m, 30)
+	assert.Equal(t, uint64(20), dst.NewestID(), "expected the forgotten entries to be lost")
+
+	assertRollback(t, src, 25)
+	m.Wake()
+	waitForMirror(t, m, 25)
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+	assert.Equal(t, uint64(15), dst.NewestID(), "expected the destination to be rolled back to match")
+}
+
+////////// HELPERS //////////
+
+// Wait for a mirror to deal with entries up to the given ID.
+func waitForMirror(t *testing.T, m *Mirroring, id uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := m.Status()
+		if status.Err != nil {
+			t.Fatal(status.Err)
+		}
+		if status.Mirrored == id && status.Newest == id {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("mirror did not reach %v: %+v", id, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMirror_Downsampled(t *testing.T) {
+	src := assertOpen(t, dbTypes["chunkdb"], true, "mirror_downsampled", chunkSize).(*ChunkDB)
+	defer assertClose(t, src)
+	dst := &InMemDB{}
+
+	vs := filldb(t, src, numEntries)
+	assert.Nil(t, src.Downsample(100, KeepEvery(10)), "expected no error in downsample")
+	m := Mirror(src, dst, MirrorOptions{PollInterval: time.Millisecond})
+	waitForMirror(t, m, uint64(numEntries))
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+
+	// Dropped entries are forwarded as empty entries, so the IDs still correspond.
+	status := m.Status()
+	assert.True(t, status.Lost > 0, "expected dropped entries to be lost")
+	assert.Equal(t, uint64(numEntries), status.Copied+status.Lost, "expected every other entry to be copied")
+	assert.Equal(t, uint64(numEntries), dst.NewestID(), "expected the IDs to correspond")
+	assert.Equal(t, []byte{}, assertGet(t, dst, 1), "expected an empty entry for a dropped entry")
+	assert.Equal(t, vs[9], assertGet(t, dst, 10), "expected a kept entry")
+	assert.Equal(t, vs[numEntries-1], assertGet(t, dst, numEntries), "expected a later entry")
+}
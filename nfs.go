@@ -0,0 +1,213 @@
+package logdb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Name of the lock file used in network filesystem mode.
+	heartbeatLockFile = "lock"
+
+	// Suffix of the lock file held while taking over a stale lock file.
+	takeoverSuffix = ".takeover"
+
+	// How often the lock file modification time is refreshed, and how old it must be to be considered stale.
+	heartbeatInterval = 5 * time.Second
+	heartbeatStale    = 30 * time.Second
+)
+
+// A lock held by exclusively creating a file, and kept alive by periodically refreshing its modification time.
+// The file holds an owner token unique to this acquisition, so the holder can tell if the lock has been taken
+// over.
+type heartbeatLock struct {
+	path  string
+	owner []byte
+	stop  chan struct{}
+	done  chan struct{}
+
+	// Set by the heartbeat if it finds the lock has been lost.
+	mutex sync.Mutex
+	lost  bool
+}
+
+// Lock a database, returning the locked file (normal mode) or the heartbeat lock (network filesystem mode).
+func lockdb(path string, nfs bool) (*os.File, *heartbeatLock, error) {
+	if !nfs {
+		lockfile, err := flock(path + "/version")
+		return lockfile, nil, err
+	}
+	heartbeat, err := acquireHeartbeatLock(path+"/"+heartbeatLockFile, heartbeatInterval, heartbeatStale)
+	return nil, heartbeat, err
+}
+
+// Unlock a database locked with 'lockdb'.
+func unlockdb(lockfile *os.File, heartbeat *heartbeatLock) error {
+	if heartbeat != nil {
+		return heartbeat.release()
+	}
+	return funlock(lockfile)
+}
+
+// Acquire a heartbeat lock. If the lock file exists but has not been refreshed for 'stale', it is taken over.
+//
+// Creating and taking over the lock only use link and rename, which are atomic on network filesystems too. The
+// lock file is written in full under a unique temporary name, and linked into place, which fails if it already
+// exists. A stale lock file is taken over while holding a second, takeover, lock file, so contenders take over
+// one at a time: the holder checks the lock file is still stale, renames its own lock file over it in one step,
+// and then re-reads it to confirm it is the owner. A contender which found the lock stale before another took
+// it over sees the fresh lock file once it gets the takeover lock, and gives up.
+func acquireHeartbeatLock(path string, interval, stale time.Duration) (*heartbeatLock, error) {
+	// The owner token has random bytes as well as the host and process ID, so that two acquisitions by the same
+	// process are told apart.
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	owner := []byte(fmt.Sprintf("%s %d %s\n", host, os.Getpid(), hex.EncodeToString(nonce)))
+
+	tmpPath := path + "." + hex.EncodeToString(nonce) + tmpSuffix
+	if err := writeFile(tmpPath, owner); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	err := os.Link(tmpPath, path)
+	if os.IsExist(err) {
+		err = takeOverStaleLock(path, tmpPath, stale)
+	}
+	if os.IsExist(err) {
+		holder, _ := ioutil.ReadFile(path)
+		return nil, fmt.Errorf("lock file %s held by %q", path, string(holder))
+	} else if err != nil {
+		return nil, err
+	}
+
+	l := &heartbeatLock{
+		path:  path,
+		owner: owner,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := l.check(); err != nil {
+		return nil, err
+	}
+	go l.beat(interval)
+	return l, nil
+}
+
+// Refresh the lock file modification time every 'interval', until stopped or the lock is found to be lost.
+func (l *heartbeatLock) beat(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			if err := l.check(); err != nil {
+				l.mutex.Lock()
+				l.lost = true
+				l.mutex.Unlock()
+				return
+			}
+			_ = os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+// Check if the heartbeat has found the lock lost. This does not read the lock file, so is cheap enough to call
+// before every write.
+func (l *heartbeatLock) isLost() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.lost
+}
+
+// Check that the lock is still held: that the heartbeat has not found it lost, and that the lock file still has
+// this owner.
+//
+// Returns 'ErrLockLost' if not.
+func (l *heartbeatLock) check() error {
+	if l.isLost() {
+		return ErrLockLost
+	}
+	holder, err := ioutil.ReadFile(l.path)
+	if err != nil || !bytes.Equal(holder, l.owner) {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Stop refreshing the lock file, and delete it if it is still held.
+//
+// Returns 'ErrLockLost' if the lock has been lost, in which case the lock file belongs to another owner, and is
+// left alone.
+func (l *heartbeatLock) release() error {
+	close(l.stop)
+	<-l.done
+	if err := l.check(); err != nil {
+		return err
+	}
+	return os.Remove(l.path)
+}
+
+////////// HELPERS //////////
+
+// Take over a stale lock file by renaming 'tmpPath', this caller's lock file, over it. Returns 'os.ErrExist' if
+// the lock file is not stale, or another contender is taking it over.
+func takeOverStaleLock(path, tmpPath string, stale time.Duration) error {
+	if fresh, err := isFreshLock(path, stale); err != nil || fresh {
+		return err
+	}
+	if err := failpoint(FailpointLockTakeover); err != nil {
+		return err
+	}
+
+	takeoverPath := path + takeoverSuffix
+	if err := os.Link(tmpPath, takeoverPath); os.IsExist(err) {
+		// If the holder of the takeover lock died part way through, clear it so a later attempt can go ahead.
+		if fresh, err := isFreshLock(takeoverPath, stale); err == nil && !fresh {
+			_ = os.Remove(takeoverPath)
+		}
+		return os.ErrExist
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(takeoverPath) }()
+
+	// Another contender may have taken over the lock between checking it and getting the takeover lock, in which
+	// case the lock file is fresh again. If it has been released instead, linking into place still fails if a
+	// contender which does not need the takeover lock has got there first.
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return os.Link(tmpPath, path)
+	} else if err != nil {
+		return err
+	} else if time.Since(fi.ModTime()) < stale {
+		return os.ErrExist
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Check if a lock file exists and has been refreshed within 'stale'.
+func isFreshLock(path string, stale time.Duration) (bool, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return time.Since(fi.ModTime()) < stale, nil
+}
@@ -0,0 +1,179 @@
+package logdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestNFS_Persist(t *testing.T) {
+	_ = os.RemoveAll("test_db/nfs_persist")
+
+	db, err := OpenWithOptions("test_db/nfs_persist", WithChunkSize(chunkSize), WithCreate(true), WithNetworkFilesystem(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := filldb(t, db, numEntries)
+	assertRollback(t, db, uint64(numEntries-3))
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The final chunk metadata was rewritten rather than appended to, so has no rolled-back records.
+	final := db.chunks[len(db.chunks)-1]
+	fi, err := os.Stat(final.metaFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(12*len(final.ends)), fi.Size(), "expected metadata file to be rewritten in full")
+	assertClose(t, db)
+
+	_, err = os.Stat("test_db/nfs_persist/" + heartbeatLockFile)
+	assert.True(t, os.IsNotExist(err), "expected lock file to be removed on close")
+
+	db, err = OpenWithOptions("test_db/nfs_persist", WithNetworkFilesystem(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(numEntries-3), db.NewestID(), "expected rollback to persist")
+	for i := uint64(1); i <= db.NewestID(); i++ {
+		assert.Equal(t, vs[i-1], assertGet(t, db, i), "expected entry to persist")
+	}
+}
+
+func TestNFS_NoConcurrentOpen(t *testing.T) {
+	_ = os.RemoveAll("test_db/nfs_no_concurrent_open")
+
+	db, err := OpenWithOptions("test_db/nfs_no_concurrent_open", WithCreate(true), WithNetworkFilesystem(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, db)
+
+	_, err = OpenWithOptions("test_db/nfs_no_concurrent_open", WithNetworkFilesystem(true))
+	assert.True(t, errors.As(err, new(*LockError)), "expected lock error, got: %s", err)
+}
+
+func TestNFS_StaleLock(t *testing.T) {
+	_ = os.RemoveAll("test_db/nfs_stale_lock")
+
+	db, err := OpenWithOptions("test_db/nfs_stale_lock", WithCreate(true), WithNetworkFilesystem(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertClose(t, db)
+
+	// Simulate a process which died holding the lock.
+	lockPath := "test_db/nfs_stale_lock/" + heartbeatLockFile
+	if err := writeFile(lockPath, []byte("dead 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-2 * heartbeatStale)
+	if err := os.Chtimes(lockPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenWithOptions("test_db/nfs_stale_lock", WithNetworkFilesystem(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertClose(t, db)
+}
+
+func TestNFS_Heartbeat(t *testing.T) {
+	_ = os.RemoveAll("test_db/nfs_heartbeat")
+	if err := os.MkdirAll("test_db/nfs_heartbeat", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	lockPath := "test_db/nfs_heartbeat/" + heartbeatLockFile
+	l, err := acquireHeartbeatLock(lockPath, time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lockPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	fi, err := os.Stat(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, fi.ModTime().After(past), "expected lock file to be refreshed")
+	if err := l.release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNFS_StaleLockContenders(t *testing.T) {
+	_ = os.RemoveAll("test_db/nfs_stale_lock_contenders")
+	if err := os.MkdirAll("test_db/nfs_stale_lock_contenders", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	lockPath := "test_db/nfs_stale_lock_contenders/" + heartbeatLockFile
+	for i := 0; i < 100; i++ {
+		if err := writeFile(lockPath, []byte("dead 1\n")); err != nil {
+			t.Fatal(err)
+		}
+		past := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(lockPath, past, past); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		locks := make(chan *heartbeatLock, 8)
+		for j := 0; j < cap(locks); j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if l, err := acquireHeartbeatLock(lockPath, time.Hour, time.Minute); err == nil {
+					locks <- l
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		close(locks)
+
+		assert.Equal(t, 1, len(locks), "expected exactly one contender to take over the lock")
+		for l := range locks {
+			assert.Nil(t, l.release(), "expected no error releasing lock")
+		}
+	}
+}
+
+func TestNFS_LockLost(t *testing.T) {
+	_ = os.RemoveAll("test_db/nfs_lock_lost")
+	if err := os.MkdirAll("test_db/nfs_lock_lost", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	lockPath := "test_db/nfs_lock_lost/" + heartbeatLockFile
+	l, err := acquireHeartbeatLock(lockPath, time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate another process taking over the lock.
+	if err := writeFile(lockPath, []byte("other 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	assert.True(t, l.isLost(), "expected heartbeat to find the lock lost")
+	assert.Equal(t, ErrLockLost, l.release(), "expected release to fail")
+	holder, err := ioutil.ReadFile(lockPath)
+	assert.Nil(t, err, "expected lock file of the other process to be left alone")
+	assert.Equal(t, []byte("other 1\n"), holder, "expected lock file of the other process to be left alone")
+}
@@ -0,0 +1,91 @@
+package logdb
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// SetBlockOnNoSpace configures what appends do when the disk is full. By default they fail with 'ErrNoSpace'.
+// If 'retry' is nonzero, they instead block until space has been freed (by 'Forget', 'Truncate', retention, or
+// something outside the database), trying again this often. Before each attempt the hook, if not nil, is
+// called without the lock held, so that the application can trim the database or free space elsewhere.
+//
+// This only applies to a 'ChunkDB', as a 'LockFreeChunkDB' cannot be changed by another goroutine while an
+// append is blocked. Use 'AppendEntriesContext' to give up waiting.
+func (db *ChunkDB) SetBlockOnNoSpace(retry time.Duration, hook func()) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.noSpaceRetry = retry
+	db.noSpaceHook = hook
+}
+
+// AppendContext implements the 'ContextDB' interface. It also gives up blocking for disk space when the context
+// is done.
+func (db *ChunkDB) AppendContext(ctx context.Context, entry []byte) (uint64, error) {
+	return db.AppendEntriesContext(ctx, [][]byte{entry})
+}
+
+// AppendEntriesContext implements the 'ContextDB' interface. It also gives up blocking for disk space when the
+// context is done.
+func (db *ChunkDB) AppendEntriesContext(ctx context.Context, entries [][]byte) (uint64, error) {
+	return db.appendContext(ctx, entries, 0, nil)
+}
+
+////////// HELPERS //////////
+
+// Append entries with a label and headers, blocking for disk space if 'SetBlockOnNoSpace' has been used, until
+// the context is done.
+func (db *ChunkDB) appendContext(ctx context.Context, entries [][]byte, label Label, headers [][]byte) (uint64, error) {
+	id, _, err := db.appendContextThen(ctx, entries, label, headers, nil)
+	return id, err
+}
+
+// Append entries as 'appendContext' does. If 'queue' is not nil, the entries are never synced here, even if a
+// sync is due: instead, the number of syncs finished before the append is returned, to pass to 'commit' later,
+// and 'queue' is called with the result before the write lock is released, so in the order of the appends.
+// It is not called if the context is done first.
+func (db *ChunkDB) appendContextThen(ctx context.Context, entries [][]byte, label Label, headers [][]byte, queue func(id, syncs uint64, err error)) (uint64, uint64, error) {
+	for {
+		if err := lockContext(ctx, &db.rwlock); err != nil {
+			return 0, 0, err
+		}
+		sync := db.LockFreeChunkDB.periodicSync
+		if db.groupCommit || queue != nil {
+			sync = nil
+		}
+		id, err := db.LockFreeChunkDB.appendEntriesThen(entries, label, headers, sync)
+		commit, syncs := db.groupCommit && queue == nil && err == nil && db.syncDue(), db.syncs
+		retry, hook := db.noSpaceRetry, db.noSpaceHook
+		if queue != nil && (retry == 0 || !errors.Is(err, ErrNoSpace)) {
+			queue(id, syncs, err)
+		}
+		db.rwlock.Unlock()
+
+		if commit {
+			err = db.commit(syncs)
+		}
+
+		if retry == 0 || !errors.Is(err, ErrNoSpace) {
+			return id, syncs, err
+		}
+		if hook != nil {
+			hook()
+		}
+		select {
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// Replace an out of space error with 'ErrNoSpace'.
+func noSpace(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return ErrNoSpace
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package logdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestNoSpace_Block(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "nospace_block", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	full := true
+	allocate = func(file *os.File, size int64) error {
+		if full {
+			return syscall.ENOSPC
+		}
+		return preallocate(file, size)
+	}
+	defer func() { allocate = preallocate }()
+
+	entry := make([]byte, chunkSize)
+	_, err := db.Append(entry)
+	assert.True(t, errors.Is(err, ErrNoSpace), "expected ErrNoSpace, got %v", err)
+	assert.Equal(t, uint64(0), db.NewestID(), "expected nothing to be appended")
+
+	var calls int
+	db.SetBlockOnNoSpace(time.Millisecond, func() {
+		calls++
+		full = calls < 3
+	})
+	assert.Equal(t, uint64(1), assertAppend(t, db, entry), "expected the entry to be appended")
+	assert.Equal(t, 3, calls, "expected the hook to be called until there was space")
+
+	full = true
+	db.SetBlockOnNoSpace(time.Millisecond, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = db.AppendContext(ctx, entry)
+	assert.Equal(t, context.DeadlineExceeded, err, "expected appending to give up")
+	assert.Equal(t, uint64(1), db.NewestID(), "expected nothing more to be appended")
+}
@@ -0,0 +1,204 @@
+package logdb
+
+// DefaultChunkSize is the chunk size used when creating a database with 'OpenWithOptions' if the 'WithChunkSize'
+// option is not given.
+const DefaultChunkSize = 1024 * 1024
+
+// An Option configures how a database is opened by 'OpenWithOptions'.
+type Option func(*options)
+
+// WithChunkSize sets the size of chunk files to use if the database is created. If the database already exists,
+// this is ignored, and the chunk size is detected automatically. See 'Open' for how to choose a chunk size.
+func WithChunkSize(chunkSize uint32) Option {
+	return func(o *options) { o.chunkSize = chunkSize }
+}
+
+// WithChunkEntries sets the most entries a chunk may hold if the database is created, so that a new chunk is
+// started when either the chunk size or this is reached. This suits workloads which reason about retention in
+// entries rather than bytes. If the database already exists, this is ignored, and the limit is detected
+// automatically. The default, 0, is no limit.
+func WithChunkEntries(entries uint32) Option {
+	return func(o *options) { o.chunkEntries = entries }
+}
+
+// WithCreate sets whether the database should be created if it does not already exist. The default is false.
+func WithCreate(create bool) Option {
+	return func(o *options) { o.create = create }
+}
+
+// WithChunkHooks sets the hooks called as chunk files change state. Unlike 'SetChunkHooks', this also calls the
+// 'Opened' hook for every chunk opened along with the database.
+func WithChunkHooks(hooks ChunkHooks) Option {
+	return func(o *options) { o.hooks = hooks }
+}
+
+// WithNetworkFilesystem sets whether the database is on a network filesystem, such as NFS. The default is false.
+//
+// In network filesystem mode, flock is not used, as it is unreliable or unsupported on many network
+// filesystems. Instead, a "lock" file is created exclusively and its modification time is refreshed
+// periodically by a background goroutine; a lock file which has not been refreshed for a while is considered
+// stale, and is taken over. Metadata files are also rewritten in full, through a temporary file and a rename,
+// rather than appended to, as appends are not atomic on many network filesystems.
+//
+// There are caveats:
+//
+//   - Stale lock detection compares modification times against the local clock, so clocks must be roughly
+//     synchronised between hosts.
+//   - If a process is paused for longer than the staleness period, another process may take over the lock.
+//     The first finds out at its next heartbeat, after which syncs fail with a 'LockError' wrapping
+//     'ErrLockLost', but writes made in between may clobber those of the new holder.
+//   - Only close-to-open consistency is assumed, so a database must not be read from another host while it
+//     is open for writing; open it after the writer has closed it.
+func WithNetworkFilesystem(nfs bool) Option {
+	return func(o *options) { o.nfs = nfs }
+}
+
+// A VerifyLevel controls how much verification is performed when a database is opened, trading startup time
+// against assurance. Problems which make a database impossible to load are always detected.
+type VerifyLevel int
+
+const (
+	// VerifyNone performs no verification beyond what is needed to load the database.
+	VerifyNone VerifyLevel = iota
+
+	// VerifyFinalChunk checks that sealed chunks have not been modified (by comparing file modification times
+	// and sizes), and checks the final chunk, which is the one most likely to have been left inconsistent by a
+	// crash, as 'Verify' does. This is the default.
+	VerifyFinalChunk
+
+	// VerifyAll checks every chunk as 'Verify' does, including the data checksums of sealed chunks, and then
+	// checks every entry against its checksum, as 'VerifyIntegrity' does. This reads the whole database.
+	VerifyAll
+)
+
+// WithVerify sets how much verification is performed when the database is opened. The default is
+// 'VerifyFinalChunk'. If verification finds a problem, opening fails with a 'VerifyError' or a
+// 'ChunkModifiedError' value.
+func WithVerify(level VerifyLevel) Option {
+	return func(o *options) { o.verify = level }
+}
+
+// A RecoveryPolicy controls what happens when a database is opened and a chunk cannot be loaded, because its
+// files are corrupt, it does not continue on from the chunk before it, or the chunk before it is empty but not
+// final. Chunks cut off by the policy are not deleted, but moved to a timestamped subdirectory of ".repair",
+// as 'Repair' does, and the recovery step is recorded in the 'OpenReport'.
+//
+// Gaps in the chunk numbers, which are left when the process dies while deleting chunks, are always recovered
+// from by keeping the newest chunks, whatever the policy.
+type RecoveryPolicy int
+
+const (
+	// RecoverFail fails to open the database, with a 'FormatError' or 'ReadError' value. Nothing is lost, but
+	// the database cannot be used until it is repaired. This is the default.
+	RecoverFail RecoveryPolicy = iota
+
+	// RecoverTruncate keeps the entries before the problem, cutting off the problem chunk and every chunk after
+	// it. The oldest entries survive, and the newest are lost.
+	RecoverTruncate
+
+	// RecoverForget keeps the entries after the problem, cutting off the problem chunk (if the chunk itself is
+	// corrupt) and every chunk before it. The newest entries survive, and the oldest are lost.
+	RecoverForget
+)
+
+// WithRecovery sets what to do if a chunk cannot be loaded when the database is opened. The default is
+// 'RecoverFail'.
+func WithRecovery(policy RecoveryPolicy) Option {
+	return func(o *options) { o.recovery = policy }
+}
+
+// A WritePath controls how entries are written to the active chunk, and how they are flushed to disk when
+// syncing. Which is fastest depends on the platform and the size of entries: see the 'BenchmarkWritePath'
+// benchmarks.
+type WritePath int
+
+const (
+	// WritePathMmap copies entries into the memory-mapped chunk file, and flushes it with fdatasync where
+	// available, and fsync otherwise. This is the default.
+	WritePathMmap WritePath = iota
+
+	// WritePathMsync copies entries into the memory-mapped chunk file, as 'WritePathMmap' does, but flushes it
+	// with msync. This avoids a file descriptor based flush, which can be cheaper for small entries.
+	WritePathMsync
+
+	// WritePathWrite writes entries to the chunk file with a pwrite syscall each, rather than through the
+	// memory map, and flushes as 'WritePathMmap' does.
+	WritePathWrite
+)
+
+// WithWritePath sets how entries are written and flushed. The default is 'WritePathMmap'. This only affects the
+// process, not the files on disk, so a database can be opened with a different write path each time.
+func WithWritePath(path WritePath) Option {
+	return func(o *options) { o.writePath = path }
+}
+
+// WithRelaxedSync sets whether chunk data files are flushed with a cheaper, weaker sync. By default a sync
+// waits until the data is on stable storage: on macOS this needs 'F_FULLFSYNC', as fsync there only hands the
+// data to the drive, which may lose its cache on power failure. In relaxed mode, plain fsync is used, which is
+// much faster on macOS but may lose recently synced entries if power is lost. On Linux there is no difference.
+//
+// Metadata files are always flushed fully, so a relaxed sync cannot leave the database inconsistent, only
+// missing entries.
+func WithRelaxedSync(relaxed bool) Option {
+	return func(o *options) { o.relaxedSync = relaxed }
+}
+
+// A Durability controls how much a sync guarantees, and so how much it costs. 'SetSync' controls how often
+// syncs happen; this controls what they do.
+type Durability int
+
+const (
+	// DurabilityDataSync flushes chunk data and metadata files with fdatasync where available, and fsync
+	// otherwise, which is enough for synced entries to survive a crash of the system. This is the default.
+	DurabilityDataSync Durability = iota
+
+	// DurabilityFull flushes chunk data and metadata files with a full fsync, including metadata of the files
+	// such as their modification times, and then flushes the database directory, so that chunk files which
+	// have been created, renamed, or deleted since the last sync stay that way too.
+	DurabilityFull
+
+	// DurabilityDsync writes each entry to the chunk data file with O_DSYNC, so that it is on disk when the
+	// append returns, whatever the write path. Syncing then only flushes the metadata files, which is what
+	// makes the entries visible when the database is next opened.
+	DurabilityDsync
+
+	// DurabilityNone never flushes anything. Entries survive the process crashing, as they are in the
+	// operating system's cache, but not the system crashing or losing power. A sync still writes the metadata
+	// files, which is what makes entries visible to a 'Reader' and to the next 'Open'.
+	DurabilityNone
+)
+
+// WithDurability sets what a sync does. The default is 'DurabilityDataSync'. 'WithRelaxedSync' only affects
+// 'DurabilityDataSync'. This only affects the process, not the files on disk, so a database can be opened with
+// a different durability each time.
+func WithDurability(durability Durability) Option {
+	return func(o *options) { o.durability = durability }
+}
+
+////////// HELPERS //////////
+
+// The configuration built up by applying 'Option' values.
+type options struct {
+	chunkSize uint32
+	create    bool
+	hooks     ChunkHooks
+	nfs       bool
+	verify    VerifyLevel
+	recovery  RecoveryPolicy
+	writePath WritePath
+
+	relaxedSync  bool
+	durability   Durability
+	chunkEntries uint32
+
+	// Settings of the open database, such as the sync period, applied in order once it is open.
+	settings []func(*LockFreeChunkDB) error
+}
+
+// The options used if none are given.
+func defaultOptions() options {
+	return options{
+		chunkSize: DefaultChunkSize,
+		verify:    VerifyFinalChunk,
+	}
+}
@@ -0,0 +1,309 @@
+package logdb
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// OutboxOptions configure 'Outbox'.
+type OutboxOptions struct {
+	// Cursor is the path of the file which records how far delivery has got, so that it resumes from there
+	// when the outbox is next started. It is written after every entry, so must be on a local filesystem.
+	Cursor string
+
+	// From is the ID of the first entry to deliver if there is no cursor file yet. If 0, delivery starts from
+	// the oldest entry.
+	From uint64
+
+	// How often to check the source for new entries. If 0, this is 100ms. Calling 'Wake' checks immediately.
+	PollInterval time.Duration
+
+	// How long to wait before trying an entry again after the handler fails. If 0, this is one second.
+	RetryDelay time.Duration
+
+	// MaxAttempts is how many times the handler may fail for an entry before the entry is parked: skipped,
+	// after calling 'Park' if it is not nil. If 0, an entry is tried until the handler succeeds.
+	MaxAttempts int
+	Park        func(id uint64, entry []byte, err error)
+
+	// DeadLetter, if not nil, is the dead-letter log: parked entries are appended to it, with a header saying
+	// where they came from (see 'ParseDeadLetter'), before 'Park' is called.
+	DeadLetter DeadLetterDB
+}
+
+// OutboxStatus describes the progress of an 'Outbox'.
+type OutboxStatus struct {
+	// ID of the newest entry which has been dealt with (delivered or parked), and the newest entry of the
+	// source when last checked.
+	Delivered uint64
+	Newest    uint64
+
+	// Number of entries delivered, parked, and lost because the source forgot them (or 'Downsample' dropped
+	// them) before they could be delivered; and number of times the handler has failed.
+	Deliveries uint64
+	Parked     uint64
+	Lost       uint64
+	Failures   uint64
+
+	// The error from the handler the last time it failed, and when an entry was last delivered.
+	LastFailure  error
+	LastDelivery time.Time
+
+	// The error which stopped delivery, if any.
+	Err error
+}
+
+// Lag is the number of entries which have not yet been dealt with.
+func (s OutboxStatus) Lag() uint64 {
+	if s.Newest < s.Delivered {
+		return 0
+	}
+	return s.Newest - s.Delivered
+}
+
+// An OutboxConsumer is a running 'Outbox'.
+type OutboxConsumer struct {
+	src     LogDB
+	handler func(uint64, []byte) error
+	opts    OutboxOptions
+
+	// The next ID to deliver, how many times the handler has failed for it, and the generation of the source
+	// when last checked.
+	next     uint64
+	attempts int
+	gen      uint64
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	lock   sync.Mutex
+	status OutboxStatus
+}
+
+// Outbox starts a goroutine which delivers the entries of 'src' to 'handler' in order, one at a time, as in the
+// transactional outbox pattern: the application appends messages to the log as part of its own writes, and the
+// outbox passes them on to another system. The source must be safe for concurrent use.
+//
+// Progress is recorded in the cursor file after each entry is dealt with, so delivery picks up where it left
+// off when the outbox is next started. An entry is only dealt with once the handler returns nil for it, or it
+// has been parked. If the handler fails, the entry is tried again after the retry delay, and if it keeps failing
+// (see 'OutboxOptions.MaxAttempts'), it is parked so that one poison entry does not hold up the rest. If the
+// process dies after the handler succeeds but before the cursor is written, the entry is delivered again, so
+// the handler should use the ID to ignore entries it has already seen: together, this is exactly-once.
+//
+// New entries are found by polling the source; a writer can call 'Wake' after appending to have them delivered
+// straight away. Entries which the source forgets before they are delivered are lost. If the source rolls back
+// entries which have been delivered, delivery stops with 'ErrOutboxRolledBack', as they cannot be taken back.
+// Rollbacks are noticed as they are by 'Mirror'.
+//
+// Parked entries can be kept in a dead-letter log (see 'OutboxOptions.DeadLetter'), to be looked at and
+// replayed later. If appending to the dead-letter log fails, delivery stops with its error, so that no entry
+// is parked without being kept. As with delivery, an entry parked just before the process died may be appended
+// to the dead-letter log again when the outbox is next started, with the same source ID.
+//
+// Returns 'ErrNoCursor' if 'OutboxOptions.Cursor' is empty, and a 'ReadError' value if the cursor file could not
+// be read.
+func Outbox(src LogDB, handler func(id uint64, entry []byte) error, opts OutboxOptions) (*OutboxConsumer, error) {
+	if opts.Cursor == "" {
+		return nil, ErrNoCursor
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 100 * time.Millisecond
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+
+	var next uint64
+	if err := readFile(opts.Cursor, &next); err != nil && !os.IsNotExist(err) {
+		return nil, &ReadError{err}
+	}
+	if next == 0 {
+		next = opts.From
+	}
+	if next == 0 {
+		next = src.OldestID()
+	}
+	if next == 0 {
+		next = 1
+	}
+
+	o := &OutboxConsumer{
+		src:     src,
+		handler: handler,
+		opts:    opts,
+		next:    next,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if gsrc, ok := src.(GenerationDB); ok {
+		o.gen = gsrc.Generation()
+	}
+	o.status.Delivered = next - 1
+	go o.run()
+	return o, nil
+}
+
+// Wake makes the outbox check the source for new entries now, rather than at the next poll.
+func (o *OutboxConsumer) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status gets the progress of the outbox.
+func (o *OutboxConsumer) Status() OutboxStatus {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.status
+}
+
+// Stop stops delivery, waiting for any call to the handler in progress to finish, and returns the error which
+// stopped it early, if any. It is safe to call more than once.
+func (o *OutboxConsumer) Stop() error {
+	select {
+	case <-o.stop:
+	default:
+		close(o.stop)
+	}
+	<-o.done
+	return o.Status().Err
+}
+
+////////// HELPERS //////////
+
+// Deliver entries until stopped or an error occurs.
+func (o *OutboxConsumer) run() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(o.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		retry, err := o.catchUp()
+		if err != nil {
+			o.lock.Lock()
+			o.status.Err = err
+			o.lock.Unlock()
+			return
+		}
+		wait := ticker.C
+		if retry {
+			wait = time.After(o.opts.RetryDelay)
+		}
+		select {
+		case <-o.stop:
+			return
+		case <-o.wake:
+		case <-wait:
+		}
+	}
+}
+
+// Deliver everything the source has which has not yet been delivered, returning true if the handler failed and
+// the entry should be tried again later.
+func (o *OutboxConsumer) catchUp() (bool, error) {
+	for {
+		select {
+		case <-o.stop:
+			return false, nil
+		default:
+		}
+
+		rolledBackTo := o.rolledBackTo()
+		oldest, newest := o.src.OldestID(), o.src.NewestID()
+		o.lock.Lock()
+		o.status.Newest = newest
+		o.lock.Unlock()
+
+		if rolledBackTo+1 < o.next || newest+1 < o.next {
+			return false, ErrOutboxRolledBack
+		}
+		if o.next < oldest {
+			if err := o.advance(oldest, func(s *OutboxStatus) { s.Lost += oldest - o.next }); err != nil {
+				return false, err
+			}
+		}
+		if o.next > newest {
+			return false, nil
+		}
+
+		entry, err := o.src.Get(o.next)
+		switch {
+		case err == ErrIDOutOfRange:
+			// Forgotten or rolled back since checking: check again.
+			continue
+		case err == ErrDownsampled:
+			if err := o.advance(o.next+1, func(s *OutboxStatus) { s.Lost++ }); err != nil {
+				return false, err
+			}
+			continue
+		case err != nil:
+			return false, err
+		}
+
+		if herr := o.handler(o.next, entry); herr != nil {
+			o.attempts++
+			o.lock.Lock()
+			o.status.Failures++
+			o.status.LastFailure = herr
+			o.lock.Unlock()
+			if o.opts.MaxAttempts == 0 || o.attempts < o.opts.MaxAttempts {
+				return true, nil
+			}
+			if o.opts.DeadLetter != nil {
+				dl := DeadLetter{ID: o.next, Attempts: uint32(o.attempts), Err: herr.Error()}
+				if _, err := o.opts.DeadLetter.AppendWithHeader(dl.header(), entry); err != nil {
+					return false, err
+				}
+			}
+			if o.opts.Park != nil {
+				o.opts.Park(o.next, entry, herr)
+			}
+			if err := o.advance(o.next+1, func(s *OutboxStatus) { s.Parked++ }); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if err := o.advance(o.next+1, func(s *OutboxStatus) {
+			s.Deliveries++
+			s.LastDelivery = time.Now()
+		}); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Record that every entry before 'next' has been dealt with, updating the status.
+func (o *OutboxConsumer) advance(next uint64, update func(*OutboxStatus)) error {
+	if err := writeFile(o.opts.Cursor, next); err != nil {
+		return &WriteError{err}
+	}
+	o.lock.Lock()
+	update(&o.status)
+	o.status.Delivered = next - 1
+	o.lock.Unlock()
+	o.next = next
+	o.attempts = 0
+	return nil
+}
+
+// Get the lowest ID the source has been rolled back to since it was last checked, as 'Mirroring.rolledBackTo'
+// does.
+func (o *OutboxConsumer) rolledBackTo() uint64 {
+	gsrc, ok := o.src.(GenerationDB)
+	if !ok {
+		return o.next - 1
+	}
+	gen := gsrc.Generation()
+	if gen == o.gen {
+		return o.next - 1
+	}
+	to := gsrc.RolledBackTo(o.gen)
+	o.gen = gen
+	return to
+}
@@ -0,0 +1,182 @@
+package logdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestOutbox_Deliver(t *testing.T) {
+	cursor := "test_db/outbox_deliver_cursor"
+	_ = os.MkdirAll("test_db", os.ModePerm)
+	_ = os.Remove(cursor)
+
+	src := &InMemDB{}
+	var lock sync.Mutex
+	var delivered [][]byte
+	handler := func(id uint64, entry []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, uint64(len(delivered)+1), id, "expected entries in order")
+		delivered = append(delivered, entry)
+		return nil
+	}
+
+	o, err := Outbox(src, handler, OutboxOptions{Cursor: cursor, PollInterval: time.Millisecond})
+	assert.Nil(t, err, "expected no error starting outbox")
+	vs := filldb(t, src, numEntries)
+	waitForOutbox(t, o, uint64(numEntries))
+	assert.Nil(t, o.Stop(), "expected no error in outbox")
+	assert.Equal(t, uint64(numEntries), o.Status().Deliveries, "expected every entry to be delivered")
+
+	// Restarting resumes from the cursor.
+	assertAppend(t, src, []byte("after restart"))
+	o, err = Outbox(src, handler, OutboxOptions{Cursor: cursor, PollInterval: time.Millisecond})
+	assert.Nil(t, err, "expected no error restarting outbox")
+	waitForOutbox(t, o, uint64(numEntries+1))
+	assert.Nil(t, o.Stop(), "expected no error in outbox")
+	assert.Equal(t, uint64(1), o.Status().Deliveries, "expected only the new entry to be delivered")
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, numEntries+1, len(delivered), "expected each entry to be delivered once")
+	for i, v := range vs {
+		assert.Equal(t, v, delivered[i], "expected delivered entry")
+	}
+}
+
+func TestOutbox_Park(t *testing.T) {
+	cursor := "test_db/outbox_park_cursor"
+	_ = os.MkdirAll("test_db", os.ModePerm)
+	_ = os.Remove(cursor)
+
+	src := &InMemDB{}
+	filldb(t, src, 10)
+
+	poison := errors.New("poison")
+	var parked []uint64
+	o, err := Outbox(src, func(id uint64, _ []byte) error {
+		if id == 5 {
+			return poison
+		}
+		return nil
+	}, OutboxOptions{
+		Cursor:       cursor,
+		PollInterval: time.Millisecond,
+		RetryDelay:   time.Millisecond,
+		MaxAttempts:  3,
+		Park:         func(id uint64, _ []byte, err error) { parked = append(parked, id) },
+	})
+	assert.Nil(t, err, "expected no error starting outbox")
+	waitForOutbox(t, o, 10)
+	assert.Nil(t, o.Stop(), "expected no error in outbox")
+
+	status := o.Status()
+	assert.Equal(t, []uint64{5}, parked, "expected the poison entry to be parked")
+	assert.Equal(t, uint64(9), status.Deliveries, "expected the other entries to be delivered")
+	assert.Equal(t, uint64(1), status.Parked, "expected one parked entry")
+	assert.Equal(t, uint64(3), status.Failures, "expected the poison entry to be tried MaxAttempts times")
+	assert.Equal(t, poison, status.LastFailure, "expected the handler error")
+}
+
+func TestOutbox_DeadLetter(t *testing.T) {
+	cursor := "test_db/outbox_dead_letter_cursor"
+	_ = os.MkdirAll("test_db", os.ModePerm)
+	_ = os.Remove(cursor)
+
+	src := &InMemDB{}
+	vs := filldb(t, src, 10)
+	dl := assertOpen(t, dbTypes["chunkdb"], true, "outbox_dead_letter", chunkSize).(*ChunkDB)
+	defer assertClose(t, dl)
+
+	o, err := Outbox(src, func(id uint64, _ []byte) error {
+		if id%4 == 0 {
+			return fmt.Errorf("cannot handle %v", id)
+		}
+		return nil
+	}, OutboxOptions{
+		Cursor:       cursor,
+		PollInterval: time.Millisecond,
+		RetryDelay:   time.Millisecond,
+		MaxAttempts:  2,
+		DeadLetter:   dl,
+	})
+	assert.Nil(t, err, "expected no error starting outbox")
+	waitForOutbox(t, o, 10)
+	assert.Nil(t, o.Stop(), "expected no error in outbox")
+	assert.Equal(t, uint64(2), o.Status().Parked, "expected two parked entries")
+
+	assert.Equal(t, uint64(2), dl.NewestID(), "expected two dead-letter entries")
+	for i, id := range []uint64{4, 8} {
+		entry, meta, err := dl.GetWithMeta(uint64(i + 1))
+		assert.Nil(t, err, "expected no error getting dead-letter entry")
+		assert.Equal(t, vs[id-1], entry, "expected the parked entry")
+
+		letter, err := ParseDeadLetter(meta.Header)
+		assert.Nil(t, err, "expected no error parsing dead-letter header")
+		assert.Equal(t, DeadLetter{ID: id, Attempts: 2, Err: fmt.Sprintf("cannot handle %v", id)}, letter, "expected provenance")
+	}
+
+	// Long errors are cut short at a rune boundary.
+	long := DeadLetter{ID: 1, Attempts: 1, Err: strings.Repeat("é", MaxHeaderSize)}
+	letter, err := ParseDeadLetter(long.header())
+	assert.Nil(t, err, "expected no error parsing long dead-letter header")
+	assert.True(t, len(long.header()) <= MaxHeaderSize, "expected header to fit")
+	assert.True(t, utf8.ValidString(letter.Err), "expected error to be valid UTF-8")
+
+	_, err = ParseDeadLetter([]byte("short"))
+	assert.Equal(t, ErrNotDeadLetter, err, "expected short header to be rejected")
+}
+
+func TestOutbox_Rollback(t *testing.T) {
+	cursor := "test_db/outbox_rollback_cursor"
+	_ = os.MkdirAll("test_db", os.ModePerm)
+	_ = os.Remove(cursor)
+
+	src := &InMemDB{}
+	filldb(t, src, 10)
+
+	o, err := Outbox(src, func(uint64, []byte) error { return nil }, OutboxOptions{Cursor: cursor, PollInterval: time.Millisecond})
+	assert.Nil(t, err, "expected no error starting outbox")
+	waitForOutbox(t, o, 10)
+
+	assertRollback(t, src, 5)
+	o.Wake()
+	deadline := time.Now().Add(5 * time.Second)
+	for o.Status().Err == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, ErrOutboxRolledBack, o.Stop(), "expected delivery to stop")
+}
+
+func TestOutbox_NoCursor(t *testing.T) {
+	_, err := Outbox(&InMemDB{}, func(uint64, []byte) error { return nil }, OutboxOptions{})
+	assert.Equal(t, ErrNoCursor, err, "expected an error without a cursor")
+}
+
+////////// HELPERS //////////
+
+// Wait until the outbox has dealt with every entry up to 'id'.
+func waitForOutbox(t *testing.T, o *OutboxConsumer, id uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := o.Status()
+		if status.Err != nil {
+			t.Fatal(status.Err)
+		}
+		if status.Delivered == id && status.Newest == id {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox did not reach %v: %+v", id, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,232 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// ErrProducerSeq means that a producer sequence number given to 'AppendProduced' is older than the newest
+// sequence number of that producer, but does not belong to any entry still in the log.
+var ErrProducerSeq = errors.New("producer sequence number out of order")
+
+// A ProducerDB wraps a 'HeaderDB' to make appends idempotent. Each producer picks a unique nonzero producer ID,
+// and numbers its entries with increasing sequence numbers. If an entry is sent again (for example because a
+// network append was retried), the ID of the original entry is returned rather than the entry being appended
+// twice.
+//
+// The producer ID and sequence number are stored as the 16-byte header of the entry in the underlying
+// 'HeaderDB', so entries are stored unchanged. The sequence numbers seen are kept in memory, and are rebuilt by
+// 'Producers' when the database is opened, from the chunk metadata alone. Entries must not be appended to the
+// underlying 'HeaderDB' with other headers.
+//
+// Entries appended with 'Append' or 'AppendEntries' have the producer ID 0, are stored without a header, and
+// are never deduplicated.
+type ProducerDB struct {
+	HeaderDB
+
+	rwlock sync.RWMutex
+
+	// Entry IDs of produced entries, and the newest sequence number of each producer.
+	produced map[producerSeq]uint64
+	newest   map[uint64]uint64
+
+	// Produced entries in increasing order of entry ID. These are used to update the maps when entries are
+	// forgotten or rolled back.
+	entries []producedEntry
+}
+
+// Producers creates a 'ProducerDB', reading the header of every entry of the underlying 'HeaderDB' to find the
+// sequence numbers seen. The entries themselves are not read, and entries dropped by 'Downsample' are skipped.
+//
+// Returns 'ErrNoSeq' if an entry has a header which is not a producer ID and sequence number, and the same
+// errors as 'ScanMeta'.
+func Producers(logdb HeaderDB) (*ProducerDB, error) {
+	db := &ProducerDB{HeaderDB: logdb, produced: make(map[producerSeq]uint64), newest: make(map[uint64]uint64)}
+
+	oldest := logdb.OldestID()
+	if oldest == 0 {
+		return db, nil
+	}
+	err := logdb.ScanMeta(oldest, logdb.NewestID(), func(id uint64, meta EntryMeta) error {
+		key, err := decodeProducer(meta.Header)
+		if err != nil {
+			return err
+		}
+		db.insert(id, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Append implements the 'LogDB' interface, with the producer ID 0.
+func (db *ProducerDB) Append(entry []byte) (uint64, error) {
+	return db.AppendEntries([][]byte{entry})
+}
+
+// AppendEntries implements the 'LogDB' interface, with the producer ID 0 for every entry.
+func (db *ProducerDB) AppendEntries(entries [][]byte) (uint64, error) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.HeaderDB.AppendEntries(entries)
+}
+
+// AppendProduced appends an entry from a producer, unless the producer has already appended an entry with
+// this sequence number, in which case the ID of that entry is returned instead and 'dup' is true.
+//
+// Returns 'ErrProducerSeq' if the sequence number is older than the newest from this producer and it does not
+// belong to an entry still in the log, and the same errors as 'Append'.
+func (db *ProducerDB) AppendProduced(producerID, seq uint64, entry []byte) (id uint64, dup bool, err error) {
+	if producerID == 0 {
+		id, err = db.Append(entry)
+		return id, false, err
+	}
+
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	key := producerSeq{producerID, seq}
+	if id, ok := db.produced[key]; ok {
+		return id, true, nil
+	}
+	if newest, ok := db.newest[producerID]; ok && seq <= newest {
+		return 0, false, ErrProducerSeq
+	}
+
+	id, err = db.HeaderDB.AppendEntriesWithHeaders([][]byte{key.header()}, [][]byte{entry})
+	if err != nil {
+		return 0, false, err
+	}
+	db.insert(id, key)
+	return id, false, nil
+}
+
+// Producer gets the producer ID and sequence number of an entry. The producer ID is 0 if the entry was
+// appended without one.
+//
+// Returns 'ErrNoSeq' if the entry has a header which is not a producer ID and sequence number, and the same
+// errors as 'Get'.
+func (db *ProducerDB) Producer(id uint64) (producerID, seq uint64, err error) {
+	_, meta, err := db.HeaderDB.GetWithMeta(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	key, err := decodeProducer(meta.Header)
+	return key.producerID, key.seq, err
+}
+
+// Forget implements the 'LogDB' interface.
+//
+// The newest sequence number of a producer is remembered even if all of its entries are forgotten, so that a
+// retry of a forgotten entry gives 'ErrProducerSeq' rather than appending a duplicate. This is not persisted,
+// so 'Producers' only knows about the producers of entries still in the log.
+func (db *ProducerDB) Forget(newOldestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	defer db.prune()
+	return db.HeaderDB.Forget(newOldestID)
+}
+
+// Rollback implements the 'LogDB' interface. Sequence numbers of entries rolled back may be used again.
+func (db *ProducerDB) Rollback(newNewestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	defer db.prune()
+	return db.HeaderDB.Rollback(newNewestID)
+}
+
+// Truncate implements the 'LogDB' interface.
+func (db *ProducerDB) Truncate(newOldestID, newNewestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	defer db.prune()
+	return db.HeaderDB.Truncate(newOldestID, newNewestID)
+}
+
+// StoredSize implements the 'SizedDB' interface. The producer ID and sequence number are kept in the chunk
+// metadata, so are not included.
+func (db *ProducerDB) StoredSize(id uint64) (uint64, error) {
+	return storedSize(db.HeaderDB, id)
+}
+
+////////// HELPERS //////////
+
+// A producer ID and sequence number.
+type producerSeq struct {
+	producerID uint64
+	seq        uint64
+}
+
+// An entry appended by a producer.
+type producedEntry struct {
+	id  uint64
+	key producerSeq
+}
+
+// Encode a producer ID and sequence number as an entry header.
+func (key producerSeq) header() []byte {
+	bs := make([]byte, 16)
+	binary.LittleEndian.PutUint64(bs, key.producerID)
+	binary.LittleEndian.PutUint64(bs[8:], key.seq)
+	return bs
+}
+
+// Decode the producer ID and sequence number in an entry header, which are 0 if there is no header.
+func decodeProducer(header []byte) (producerSeq, error) {
+	switch len(header) {
+	case 0:
+		return producerSeq{}, nil
+	case 16:
+		return producerSeq{binary.LittleEndian.Uint64(header), binary.LittleEndian.Uint64(header[8:])}, nil
+	default:
+		return producerSeq{}, ErrNoSeq
+	}
+}
+
+// Record an entry, if it has a producer. Assumes the write lock is held.
+func (db *ProducerDB) insert(id uint64, key producerSeq) {
+	if key.producerID == 0 {
+		return
+	}
+	db.produced[key] = id
+	db.newest[key.producerID] = key.seq
+	db.entries = append(db.entries, producedEntry{id, key})
+}
+
+// Forget entries which are no longer in the log. Assumes the write lock is held.
+func (db *ProducerDB) prune() {
+	oldest, newest := db.HeaderDB.OldestID(), db.HeaderDB.NewestID()
+
+	from := 0
+	for from < len(db.entries) && db.entries[from].id < oldest {
+		delete(db.produced, db.entries[from].key)
+		from++
+	}
+
+	// Entries which are rolled back never happened, so the newest sequence number of their producer goes back
+	// to that of its newest remaining entry.
+	to := len(db.entries)
+	rolledBack := make(map[uint64]bool)
+	for to > from && db.entries[to-1].id > newest {
+		delete(db.produced, db.entries[to-1].key)
+		rolledBack[db.entries[to-1].key.producerID] = true
+		to--
+	}
+	for producerID := range rolledBack {
+		delete(db.newest, producerID)
+	}
+	for _, e := range db.entries[:to] {
+		if rolledBack[e.key.producerID] {
+			db.newest[e.key.producerID] = e.key.seq
+		}
+	}
+
+	db.entries = db.entries[from:to]
+}
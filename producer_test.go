@@ -0,0 +1,73 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestProducers(t *testing.T) {
+	chunkdb := assertOpen(t, dbTypes["chunkdb"], true, "producers", chunkSize).(*ChunkDB)
+	defer assertClose(t, chunkdb)
+	db, err := Producers(chunkdb)
+	assert.Nil(t, err, "expected no error in wrapping")
+
+	first, dup, err := db.AppendProduced(7, 1, []byte("a"))
+	assert.Nil(t, err, "expected no error in append")
+	assert.False(t, dup, "expected first append not to be a duplicate")
+	second, _, err := db.AppendProduced(7, 2, []byte("b"))
+	assert.Nil(t, err, "expected no error in append")
+
+	// A retry returns the original entry.
+	id, dup, err := db.AppendProduced(7, 1, []byte("a"))
+	assert.Nil(t, err, "expected no error in retried append")
+	assert.True(t, dup, "expected retry to be a duplicate")
+	assert.Equal(t, first, id, "expected ID of original entry")
+	assert.Equal(t, second, db.NewestID(), "expected nothing to be appended")
+
+	v, err := db.Get(second)
+	assert.Nil(t, err, "expected no error in get")
+	assert.Equal(t, []byte("b"), v, "expected the entry to be stored unchanged")
+	producerID, seq, err := db.Producer(second)
+	assert.Nil(t, err, "expected no error in getting producer")
+	assert.Equal(t, uint64(7), producerID, "expected producer ID")
+	assert.Equal(t, uint64(2), seq, "expected sequence number")
+
+	// Forgotten entries cannot be appended again, but rolled back ones can.
+	_, _, err = db.AppendProduced(7, 3, []byte("c"))
+	assert.Nil(t, err, "expected no error in append")
+	assert.Nil(t, db.Forget(second), "expected no error in forget")
+	_, _, err = db.AppendProduced(7, 1, []byte("a"))
+	assert.Equal(t, ErrProducerSeq, err, "expected forgotten entry to be rejected")
+	assert.Nil(t, db.Rollback(second), "expected no error in rollback")
+	db, err = Producers(chunkdb)
+	assert.Nil(t, err, "expected no error in rewrapping")
+	_, dup, err = db.AppendProduced(7, 3, []byte("c"))
+	assert.Nil(t, err, "expected no error in append")
+	assert.False(t, dup, "expected rolled back entry not to be a duplicate")
+
+	_, err = chunkdb.AppendWithHeader([]byte("other"), []byte("f"))
+	assert.Nil(t, err, "expected no error in append")
+	_, err = Producers(chunkdb)
+	assert.Equal(t, ErrNoSeq, err, "expected a header which is not a producer to be rejected")
+}
+
+func TestProducers_Downsampled(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "producers_downsampled", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	pdb, err := Producers(db)
+	assert.Nil(t, err, "expected no error in wrapping")
+
+	for seq := uint64(1); seq <= 100; seq++ {
+		_, _, err := pdb.AppendProduced(7, seq, []byte("entry"))
+		assert.Nil(t, err, "expected no error in append")
+	}
+	assert.Nil(t, db.Downsample(50, KeepEvery(2)), "expected no error in downsample")
+
+	pdb, err = Producers(db)
+	assert.Nil(t, err, "expected dropped entries to be skipped")
+	id, dup, err := pdb.AppendProduced(7, 100, []byte("entry"))
+	assert.Nil(t, err, "expected no error in retried append")
+	assert.True(t, dup, "expected the retry to be a duplicate")
+	assert.Equal(t, uint64(100), id, "expected the ID of the original entry")
+}
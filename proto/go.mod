@@ -0,0 +1,10 @@
+module github.com/barrucadu/logdb/proto
+
+go 1.20
+
+require (
+	github.com/barrucadu/logdb v0.0.0
+	google.golang.org/protobuf v1.34.2
+)
+
+replace github.com/barrucadu/logdb => ../
@@ -0,0 +1,44 @@
+// Package proto provides a 'CodingDB' for Protocol Buffers messages.
+package proto
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/barrucadu/logdb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtoCoder creates a 'CodingDB' with the protobuf encoder/decoder. Values must be 'proto.Message' values, and
+// are decoded into a 'proto.Message' of the same type, which is reset first. Values and destinations which are
+// not a 'proto.Message' are reported as 'logdb.ErrWrongDestination'.
+func ProtoCoder(db logdb.LogDB) *logdb.CodingDB {
+	return &logdb.CodingDB{
+		LogDB: db,
+		Encode: func(val interface{}) ([]byte, error) {
+			msg, ok := val.(proto.Message)
+			if !ok {
+				return nil, fmt.Errorf("%w: proto coder can only encode a 'proto.Message', got %s", logdb.ErrWrongDestination, typeString(val))
+			}
+			return proto.Marshal(msg)
+		},
+		Decode: func(bs []byte, data interface{}) error {
+			msg, ok := data.(proto.Message)
+			if !ok {
+				return fmt.Errorf("%w: proto coder can only decode a 'proto.Message', got %s", logdb.ErrWrongDestination, typeString(data))
+			}
+			return proto.Unmarshal(bs, msg)
+		},
+	}
+}
+
+////////// HELPERS //////////
+
+// Get the name of the type of a value, which may be nil.
+func typeString(val interface{}) string {
+	if val == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(val).String()
+}
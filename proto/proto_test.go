@@ -0,0 +1,40 @@
+package proto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/barrucadu/logdb"
+	"github.com/barrucadu/logdb/internal/assert"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestProtoCoder_AppendValue(t *testing.T) {
+	coder := ProtoCoder(&logdb.InMemDB{})
+
+	msg, err := structpb.NewStruct(map[string]interface{}{"event": "login", "user": "alice", "attempts": 3.0})
+	assert.Nil(t, err, "expected no error in struct")
+
+	idx, err := coder.AppendValue(msg)
+	assert.Nil(t, err, "expected no error in append")
+
+	out := &structpb.Struct{}
+	assert.Nil(t, coder.GetValue(idx, out), "expected no error in get")
+	assert.True(t, proto.Equal(msg, out), "expected equal messages")
+}
+
+func TestProtoCoder_NotMessage(t *testing.T) {
+	coder := ProtoCoder(&logdb.InMemDB{})
+
+	_, err := coder.AppendValue("not a message")
+	assert.True(t, errors.Is(err, logdb.ErrWrongDestination), "expected wrong destination error appending a non-message, got %v", err)
+
+	idx, err := coder.Append([]byte{})
+	assert.Nil(t, err, "expected no error in append")
+	var s string
+	err = coder.GetValue(idx, &s)
+	assert.True(t, errors.As(err, new(*logdb.DecodeError)), "expected a 'DecodeError' value, got %v", err)
+	assert.True(t, errors.Is(err, logdb.ErrWrongDestination), "expected wrong destination error decoding into a non-message, got %v", err)
+}
@@ -1,15 +1,15 @@
 package raft
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"testing"
 
 	"github.com/barrucadu/logdb"
+	"github.com/barrucadu/logdb/internal/assert"
 
-	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/raft"
-	"github.com/stretchr/testify/assert"
 )
 
 const numEntries = 255
@@ -34,7 +34,7 @@ func TestRaft_GetBeforeStart(t *testing.T) {
 	})
 
 	err := assertGetLogError(t, db, 3)
-	assert.True(t, errwrap.ContainsType(err, raft.ErrLogNotFound), "expected log not found error")
+	assert.True(t, errors.Is(err, raft.ErrLogNotFound), "expected log not found error")
 }
 
 func TestRaft_StoreLog(t *testing.T) {
@@ -85,10 +85,10 @@ func TestRaft_StoreLog_Discontinuous(t *testing.T) {
 			assertStoreLog(t, db, log)
 		} else if i == 7 {
 			err := assertStoreLogError(t, db, log)
-			assert.True(t, errwrap.ContainsType(err, new(NonincreasingIndexError)), "expected nonincreasing index error")
+			assert.True(t, errors.As(err, new(*NonincreasingIndexError)), "expected nonincreasing index error")
 		} else {
 			err := assertStoreLogError(t, db, log)
-			assert.True(t, errwrap.ContainsType(err, new(NoncontiguousIndexError)), "expected noncontiguous index error")
+			assert.True(t, errors.As(err, new(*NoncontiguousIndexError)), "expected noncontiguous index error")
 			break
 		}
 	}
@@ -105,7 +105,7 @@ func TestRaft_StoreLog_ZeroIndex(t *testing.T) {
 		Data:  []byte("hello world"),
 	})
 
-	assert.True(t, errwrap.ContainsType(err, ErrZeroIndex), "expected zero index error")
+	assert.True(t, errors.Is(err, ErrZeroIndex), "expected zero index error")
 }
 
 func TestRaft_StoreLogs(t *testing.T) {
@@ -150,7 +150,7 @@ func TestRaft_StoreLogs_Noncontiguous(t *testing.T) {
 	logs[7].Index = 9999
 
 	err := assertStoreLogsError(t, db, logs)
-	assert.True(t, errwrap.ContainsType(err, new(NoncontiguousIndexError)), "expected noncontiguous index error")
+	assert.True(t, errors.As(err, new(*NoncontiguousIndexError)), "expected noncontiguous index error")
 
 	assert.Equal(t, uint64(0), assertFirstIndex(t, db))
 	assert.Equal(t, uint64(0), assertLastIndex(t, db))
@@ -173,7 +173,7 @@ func TestRaft_StoreLogs_Nonincreasing(t *testing.T) {
 	logs[7].Index = 3
 
 	err := assertStoreLogsError(t, db, logs)
-	assert.True(t, errwrap.ContainsType(err, new(NonincreasingIndexError)), "expected nonincreasing index error")
+	assert.True(t, errors.As(err, new(*NonincreasingIndexError)), "expected nonincreasing index error")
 
 	assert.Equal(t, uint64(0), assertFirstIndex(t, db))
 	assert.Equal(t, uint64(0), assertLastIndex(t, db))
@@ -212,7 +212,7 @@ func TestRaft_DeleteRange_Middle(t *testing.T) {
 
 	first := assertFirstIndex(t, db)
 	err := assertDeleteRangeError(t, db, first+25, first+50)
-	assert.True(t, errwrap.ContainsType(err, ErrDeleteRange), "expected a delete range error")
+	assert.True(t, errors.Is(err, ErrDeleteRange), "expected a delete range error")
 }
 
 func TestRaft_DeleteRange_End(t *testing.T) {
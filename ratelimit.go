@@ -0,0 +1,67 @@
+package logdb
+
+import "time"
+
+// SetBackupRateLimit is the thread-safe version of 'LockFreeChunkDB.SetBackupRateLimit'.
+func (db *ChunkDB) SetBackupRateLimit(bytesPerSecond uint64) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetBackupRateLimit(bytesPerSecond)
+}
+
+// SetBackupRateLimit limits how quickly 'Backup' and 'BackupTo' copy entries, in bytes per second, so that a
+// backup does not starve other users of the disk. Only the entries count towards the limit, not the metadata.
+// As the thread-safe versions of the backup functions do not hold a lock while copying, a slow backup does not
+// block other operations. 0, the default, means there is no limit.
+//
+// The limit is read when a backup starts, so changing it does not affect a backup in progress.
+func (db *LockFreeChunkDB) SetBackupRateLimit(bytesPerSecond uint64) {
+	db.backupRate = bytesPerSecond
+}
+
+////////// HELPERS //////////
+
+// A token bucket, limiting a rate in bytes per second. Up to a second's worth of bytes can be used at once
+// after a pause. A nil limiter does not limit anything.
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// Make a limiter for a rate, or return nil if the rate is 0.
+func newRateLimiter(bytesPerSecond uint64) *rateLimiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// Wait until 'n' more bytes can be used. More than a second's worth can be used at once, but then the next
+// call waits for longer. Returns false without waiting out the full time if 'cancel' is closed.
+func (l *rateLimiter) wait(n int, cancel <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		return false
+	}
+}
@@ -0,0 +1,67 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var unlimited *rateLimiter
+	assert.True(t, unlimited.wait(1<<30, nil), "expected no limit")
+
+	l := newRateLimiter(10000)
+	start := time.Now()
+	assert.True(t, l.wait(10000, nil), "expected to wait")
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "expected a second's worth to be allowed at once")
+	assert.True(t, l.wait(2000, nil), "expected to wait")
+	assert.True(t, time.Since(start) >= 150*time.Millisecond, "expected to wait for the rate")
+
+	cancel := make(chan struct{})
+	close(cancel)
+	assert.False(t, l.wait(1000000, cancel), "expected cancelled wait")
+}
+
+func TestBackup_RateLimit(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "backup_rate_limit", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	vs := filldb(t, db, numEntries)
+
+	var size uint64
+	for _, v := range vs {
+		size += uint64(len(v))
+	}
+	db.SetBackupRateLimit(size * 4 / 5)
+
+	path := "test_db/backup_rate_limit_copy"
+	_ = os.RemoveAll(path)
+	start := time.Now()
+	assert.Nil(t, db.Backup(path), "expected no error in backup")
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "expected the backup to be limited, took %v", time.Since(start))
+
+	copied, err := OpenWithOptions(path, WithVerify(VerifyAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, copied)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, copied, uint64(i+1)), "expected the same entries")
+	}
+}
+
+func TestMirror_RateLimit(t *testing.T) {
+	src := &InMemDB{}
+	dst := &InMemDB{}
+
+	m := Mirror(src, dst, MirrorOptions{PollInterval: time.Hour, BatchSize: 1, BytesPerSecond: 1})
+	filldb(t, src, numEntries)
+	m.Wake()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	assert.Nil(t, m.Stop(), "expected no error in mirror")
+	assert.True(t, time.Since(start) < time.Second, "expected stopping not to wait for the limit")
+	assert.True(t, m.Status().Copied < uint64(numEntries), "expected the mirror to be limited")
+}
@@ -0,0 +1,299 @@
+package logdb
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// Name of the file which every 'Reader' holds a shared lock on, so that 'Migrate' can tell if there are any.
+const readersLockFile = "readers"
+
+// A Reader is a read-only handle on a database. Any number of processes can have a database open with a
+// 'Reader' at once, alongside the one process which has it open for writing. A 'Reader' sees the entries which
+// had been synced when it was opened or last refreshed: call 'Refresh' to see newer ones.
+//
+// As it does not change the database, a 'Reader' does not recover from a crash as 'Open' does. Instead, it
+// ignores what it cannot use, such as an incomplete final chunk, until the database is next opened for
+// writing. 'Migrate' cannot run while there are readers.
+//
+// Readers are not supported on network filesystems. A 'Reader' is safe for concurrent use.
+type Reader struct {
+	path      string
+	version   uint16
+	chunkSize uint32
+	lockfile  *os.File
+
+	rwlock sync.RWMutex
+	closed bool
+	oldest uint64
+	chunks []*chunk
+
+	// The file each chunk was opened from, so that sealed chunks can be kept when refreshing.
+	files map[*chunk]os.FileInfo
+}
+
+// OpenReader opens a database read-only, which is shared with other readers and with a writer.
+//
+// Unlike 'Open', this can open a database in an older disk format version, without migrating it (see
+// 'SupportedFormats'). Entries written before version 1 have no checksums, so are not checked.
+//
+// Returns 'ErrPathDoesntExist' or 'ErrNotDirectory' if there is no database, a 'LockError' value if it is being
+// migrated, 'ErrUnknownVersion' if it is in a disk format version newer than this library knows about,
+// 'ErrMigrationIncomplete' if a migration was interrupted, and a 'ReadError' or 'FormatError' value if it could
+// not be read.
+func OpenReader(path string) (*Reader, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, ErrPathDoesntExist
+	}
+	if !stat.IsDir() {
+		return nil, ErrNotDirectory
+	}
+
+	lockfile, err := flockShared(path + "/" + readersLockFile)
+	if err != nil {
+		return nil, &LockError{err}
+	}
+	r := &Reader{path: path, lockfile: lockfile}
+	if err := r.init(); err != nil {
+		_ = funlock(lockfile)
+		return nil, err
+	}
+	return r, nil
+}
+
+// Refresh loads the entries the writer has synced since the reader was opened or last refreshed, and drops
+// those it has deleted. Entries which have been forgotten or rolled back may still be readable until this is
+// called.
+//
+// If the writer deletes chunks while this is reading them, this may fail with a 'ReadError' or 'FormatError'
+// value, and should be tried again. The reader is unchanged if this fails. Returns 'ErrClosed' if the handle is
+// closed.
+func (r *Reader) Refresh() error {
+	r.rwlock.Lock()
+	defer r.rwlock.Unlock()
+
+	if r.closed {
+		return ErrClosed
+	}
+	return r.load()
+}
+
+// Get implements the 'ReadOnlyDB' interface.
+//
+// Returns 'ErrIDOutOfRange' if the entry does not exist, 'ErrDownsampled' if it has been dropped by 'Downsample',
+// 'ErrChecksumMismatch' if the entry does not match its checksum (which can happen if the writer rolled it back
+// and replaced it since the last refresh), and 'ErrClosed' if the handle is closed.
+func (r *Reader) Get(id uint64) ([]byte, error) {
+	r.rwlock.RLock()
+	defer r.rwlock.RUnlock()
+
+	if r.closed {
+		return nil, ErrClosed
+	}
+	if len(r.chunks) == 0 || id < r.oldest || id >= r.chunks[len(r.chunks)-1].next() {
+		return nil, ErrIDOutOfRange
+	}
+
+	i := sort.Search(len(r.chunks), func(i int) bool { return r.chunks[i].next() > id })
+	c, start, end := r.chunks[i].find(id)
+	out := make([]byte, end-start)
+	if err := c.readAt(out, start); err != nil {
+		return nil, err
+	}
+	if err := c.check(id, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// OldestID implements the 'ReadOnlyDB' interface.
+func (r *Reader) OldestID() uint64 {
+	r.rwlock.RLock()
+	defer r.rwlock.RUnlock()
+
+	return r.oldest
+}
+
+// NewestID implements the 'ReadOnlyDB' interface.
+func (r *Reader) NewestID() uint64 {
+	r.rwlock.RLock()
+	defer r.rwlock.RUnlock()
+
+	if len(r.chunks) == 0 {
+		return 0
+	}
+	return r.chunks[len(r.chunks)-1].next() - 1
+}
+
+// Close releases the files of the reader.
+//
+// Returns 'ErrClosed' if the handle is already closed.
+func (r *Reader) Close() error {
+	r.rwlock.Lock()
+	defer r.rwlock.Unlock()
+
+	if r.closed {
+		return ErrClosed
+	}
+	for _, c := range r.chunks {
+		closeReaderChunk(c)
+	}
+	r.chunks = nil
+	r.closed = true
+	return funlock(r.lockfile)
+}
+
+////////// HELPERS //////////
+
+// Check the format of the database, and load the chunks for the first time.
+func (r *Reader) init() error {
+	if err := readFile(r.path+"/version", &r.version); err != nil {
+		return &ReadError{err}
+	}
+	if !formatOf(r.version).Read {
+		return ErrUnknownVersion
+	}
+	if _, err := os.Stat(r.path + "/" + migrateDir); err == nil {
+		return ErrMigrationIncomplete
+	}
+	if err := readFile(r.path+"/chunk_size", &r.chunkSize); err != nil {
+		return &ReadError{err}
+	}
+	if r.chunkSize > maxChunkSize {
+		return ErrChunkSizeTooBig
+	}
+	return r.load()
+}
+
+// Load the chunks of the database, keeping the sealed chunks which are already loaded. Assumes the write lock
+// is held.
+func (r *Reader) load() error {
+	// Find the chunk data files, in the database directory and any shard directories.
+	var chunkFiles []os.FileInfo
+	dirs := make(map[string]string)
+	scan := []string{r.path}
+	for i := 0; i < len(scan); i++ {
+		fis, err := ioutil.ReadDir(scan[i])
+		if err != nil {
+			return &ReadError{err}
+		}
+		for _, fi := range fis {
+			switch {
+			case fi.IsDir() && scan[i] == r.path && isBasenameShardDir(fi.Name()):
+				scan = append(scan, r.path+"/"+fi.Name())
+			case !fi.IsDir() && isBasenameChunkDataFile(fi.Name()):
+				chunkFiles = append(chunkFiles, fi)
+				dirs[fi.Name()] = scan[i]
+			}
+		}
+	}
+	sort.Sort(fileInfoSlice(chunkFiles))
+
+	// Use the newest contiguous chunks, as 'Open' does, and not a final chunk which is still being created.
+	for i := len(chunkFiles) - 1; i > 0; i-- {
+		if chunkNumber(chunkFiles[i-1].Name()) != chunkNumber(chunkFiles[i].Name())-1 {
+			chunkFiles = chunkFiles[i:]
+			break
+		}
+	}
+	if n := len(chunkFiles); n > 0 {
+		final := chunkFiles[n-1]
+		if _, err := os.Stat(metaFilePath(dirs[final.Name()] + "/" + final.Name())); final.Size() != int64(r.chunkSize) || err != nil {
+			chunkFiles = chunkFiles[:n-1]
+		}
+	}
+
+	// Open the chunks, keeping those which are still sealed and have not been replaced. A rollback unseals a
+	// chunk before changing it.
+	kept := make(map[string]*chunk)
+	for _, c := range r.chunks {
+		if c.sealed {
+			kept[c.path] = c
+		}
+	}
+	chunks := make([]*chunk, 0, len(chunkFiles))
+	files := make(map[*chunk]os.FileInfo, len(chunkFiles))
+	var prior *chunk
+	for _, fi := range chunkFiles {
+		dir := dirs[fi.Name()]
+		c := kept[dir+"/"+fi.Name()]
+		if c != nil && os.SameFile(fi, r.files[c]) && (prior == nil || c.oldest == prior.next()) && fileExists(c.sealFilePath()) {
+			delete(kept, c.path)
+		} else {
+			opened, err := openChunk(dir, fi, prior, r.chunkSize, false, r.version)
+			if err != nil {
+				if opened.mmapf != nil {
+					closeReaderChunk(&opened)
+				}
+				for _, c := range chunks {
+					if r.files[c] == nil {
+						closeReaderChunk(c)
+					}
+				}
+				return err
+			}
+			c = &opened
+		}
+		chunks = append(chunks, c)
+		files[c] = fi
+		prior = c
+	}
+
+	// The oldest entry may be older than the oldest chunk, if the writer died after deleting chunks.
+	var oldest uint64
+	if len(chunks) > 0 {
+		_ = readFile(r.path+"/oldest", &oldest)
+		if oldest < chunks[0].oldest {
+			oldest = chunks[0].oldest
+		}
+		if next := chunks[len(chunks)-1].next(); oldest > next {
+			oldest = next
+		}
+	}
+
+	// Close the chunks which are no longer used.
+	for _, c := range r.chunks {
+		if _, ok := files[c]; !ok {
+			closeReaderChunk(c)
+		}
+	}
+	r.chunks = chunks
+	r.files = files
+	r.oldest = oldest
+	return nil
+}
+
+// Take an exclusive lock on the readers lock file, if there is one, so that no 'Reader' can open the database
+// while its files are being changed. Fails if there are any readers. The returned file is nil if there is no
+// readers lock file; 'funlock' can be called on it regardless.
+func lockOutReaders(path string) (*os.File, error) {
+	if !fileExists(path + "/" + readersLockFile) {
+		return nil, nil
+	}
+	readers, err := flock(path + "/" + readersLockFile)
+	if err != nil {
+		if readers != nil {
+			_ = funlock(readers)
+		}
+		return nil, err
+	}
+	return readers, nil
+}
+
+// Check if a file exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Unmap and close a chunk opened by a reader.
+func closeReaderChunk(c *chunk) {
+	if c.bytes != nil {
+		_ = syscall.Munmap(c.bytes)
+	}
+	_ = c.mmapf.Close()
+}
@@ -0,0 +1,74 @@
+package logdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestReader(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "reader", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	if err := db.SetSync(-1); err != nil {
+		t.Fatal(err)
+	}
+	vs := filldb(t, db, numEntries)
+	assertSync(t, db)
+
+	// Readers can open the database alongside the writer, and each other.
+	r1, err := OpenReader("test_db/reader")
+	assert.Nil(t, err, "expected no error in open")
+	defer r1.Close()
+	r2, err := OpenReader("test_db/reader")
+	assert.Nil(t, err, "expected no error in second open")
+	assert.Nil(t, r2.Close(), "expected no error in close")
+
+	assert.Equal(t, db.OldestID(), r1.OldestID(), "expected the same oldest entry")
+	assert.Equal(t, db.NewestID(), r1.NewestID(), "expected the same newest entry")
+	for i, v := range vs {
+		got, err := r1.Get(uint64(i + 1))
+		assert.Nil(t, err, "expected no error in get")
+		assert.Equal(t, v, got, "expected the same entry")
+	}
+
+	// Unsynced entries are not seen, even after refreshing.
+	assertAppend(t, db, []byte("unsynced"))
+	assert.Nil(t, r1.Refresh(), "expected no error in refresh")
+	assert.Equal(t, uint64(numEntries), r1.NewestID(), "expected unsynced entries not to be seen")
+
+	// Synced changes are seen once refreshed.
+	assertSync(t, db)
+	assertForget(t, db, 100)
+	assertSync(t, db)
+	assert.Equal(t, uint64(numEntries), r1.NewestID(), "expected no change before refreshing")
+	assert.Nil(t, r1.Refresh(), "expected no error in refresh")
+	assert.Equal(t, db.OldestID(), r1.OldestID(), "expected forgotten entries to be dropped")
+	assert.Equal(t, db.NewestID(), r1.NewestID(), "expected the synced entry to be seen")
+	got, err := r1.Get(db.NewestID())
+	assert.Nil(t, err, "expected no error in get")
+	assert.Equal(t, []byte("unsynced"), got, "expected the synced entry")
+	_, err = r1.Get(1)
+	assert.Equal(t, ErrIDOutOfRange, err, "expected forgotten entries to be out of range")
+
+	assertRollback(t, db, 200)
+	assertSync(t, db)
+	assert.Nil(t, r1.Refresh(), "expected no error in refresh")
+	assert.Equal(t, uint64(200), r1.NewestID(), "expected rolled back entries to be dropped")
+}
+
+func TestReader_BlocksMigrate(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "reader_migrate", chunkSize)
+	filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	r, err := OpenReader("test_db/reader_migrate")
+	assert.Nil(t, err, "expected no error in open")
+	_, err = Migrate("test_db/reader_migrate")
+	assert.True(t, errors.As(err, new(*LockError)), "expected a 'LockError' value, got %v", err)
+
+	assert.Nil(t, r.Close(), "expected no error in close")
+	_, err = Migrate("test_db/reader_migrate")
+	assert.Nil(t, err, "expected no error in migrate without readers")
+	assert.Equal(t, ErrClosed, r.Close(), "expected a second close to fail")
+}
@@ -0,0 +1,172 @@
+package logdb
+
+import "time"
+
+// WithSync sets the sync period, as 'SetSync' does.
+func WithSync(every int) Option {
+	return withSetting(func(db *LockFreeChunkDB) error { return db.SetSync(every) })
+}
+
+// WithForgetBatch sets how many chunks deletions are batched for, as 'SetForgetBatch' does.
+func WithForgetBatch(chunks int) Option {
+	return withSetting(func(db *LockFreeChunkDB) error { return db.SetForgetBatch(chunks) })
+}
+
+// WithLogger sets the logger, as 'SetLogger' does.
+func WithLogger(logger Logger) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetLogger(logger)
+		return nil
+	})
+}
+
+// WithSlowThreshold sets the slow operation threshold, as 'SetSlowThreshold' does.
+func WithSlowThreshold(threshold time.Duration) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetSlowThreshold(threshold)
+		return nil
+	})
+}
+
+// WithTrash sets the trash grace period, as 'SetTrash' does.
+func WithTrash(grace time.Duration) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetTrash(grace)
+		return nil
+	})
+}
+
+// WithSoftRollback sets the soft rollback window, as 'SetSoftRollback' does.
+func WithSoftRollback(window time.Duration) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetSoftRollback(window)
+		return nil
+	})
+}
+
+// WithTruncateLimit sets the truncation limit, as 'SetTruncateLimit' does.
+func WithTruncateLimit(entries uint64) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetTruncateLimit(entries)
+		return nil
+	})
+}
+
+// WithLabelRetention sets how long entries with each label are kept, as 'SetLabelRetention' does.
+func WithLabelRetention(rules map[Label]time.Duration) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetLabelRetention(rules)
+		return nil
+	})
+}
+
+// WithEmergencyRetention sets the emergency retention policy, as 'SetEmergencyRetention' does.
+func WithEmergencyRetention(policy EmergencyRetention) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetEmergencyRetention(policy)
+		return nil
+	})
+}
+
+// WithMemoryLimit sets the memory limit, as 'SetMemoryLimit' does.
+func WithMemoryLimit(limit uint64) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetMemoryLimit(limit)
+		return nil
+	})
+}
+
+// WithSoftLimits sets the thresholds to warn about, as 'SetSoftLimits' does.
+func WithSoftLimits(limits SoftLimits) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetSoftLimits(limits)
+		return nil
+	})
+}
+
+// WithBackupRateLimit sets the backup rate limit, as 'SetBackupRateLimit' does.
+func WithBackupRateLimit(bytesPerSecond uint64) Option {
+	return withSetting(func(db *LockFreeChunkDB) error {
+		db.SetBackupRateLimit(bytesPerSecond)
+		return nil
+	})
+}
+
+// Reconfigure is the thread-safe version of 'LockFreeChunkDB.Reconfigure'.
+func (db *ChunkDB) Reconfigure(opts ...Option) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.Reconfigure(opts...)
+}
+
+// Reconfigure changes the configuration of an open database, without reopening it, by applying options as
+// 'OpenWithOptions' does. Anything which only matters when opening, such as 'WithCreate' and 'WithVerify', is
+// kept for 'Reopen'.
+//
+// Returns 'ErrClosed' if the handle is closed, and 'ErrNotReconfigurable' if an option would change something
+// which is fixed when the database is created: the chunk size, the entries per chunk, or whether it is on a
+// network filesystem. In that case nothing is changed. If the write path, relaxed sync, or durability is changed,
+// the database is synced first, and returns the same errors as 'Sync' if that fails, in which case nothing is
+// changed. Otherwise, returns the same errors as the setters of the options given, which are applied in order.
+func (db *LockFreeChunkDB) Reconfigure(opts ...Option) error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	o := options{
+		chunkSize:    db.chunkSize,
+		hooks:        db.hooks,
+		nfs:          db.nfs,
+		verify:       db.verify,
+		writePath:    db.writePath,
+		relaxedSync:  db.relaxedSync,
+		durability:   db.durability,
+		chunkEntries: db.chunkEntries,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.chunkSize != db.chunkSize || o.nfs != db.nfs || o.chunkEntries != db.chunkEntries {
+		return ErrNotReconfigurable
+	}
+
+	// Entries written so far are flushed as the old write mode expects, as the new one may not flush them: for
+	// example, 'DurabilityDsync' never flushes the memory map.
+	if o.writePath != db.writePath || o.relaxedSync != db.relaxedSync || o.durability != db.durability {
+		if err := db.sync(); err != nil {
+			return err
+		}
+		if o.durability == DurabilityDsync && len(db.chunks) > 0 {
+			if err := db.openDsync(db.chunks[len(db.chunks)-1]); err != nil {
+				return &WriteError{err}
+			}
+		} else if o.durability != DurabilityDsync {
+			db.closeDsync()
+		}
+	}
+
+	db.hooks = o.hooks
+	db.verify = o.verify
+	db.writePath = o.writePath
+	db.relaxedSync = o.relaxedSync
+	db.durability = o.durability
+	return o.applySettings(db)
+}
+
+////////// HELPERS //////////
+
+// An option which changes a setting of an open database, rather than how it is opened.
+func withSetting(set func(*LockFreeChunkDB) error) Option {
+	return func(o *options) { o.settings = append(o.settings, set) }
+}
+
+// Apply the settings to an open database, in order.
+func (o *options) applySettings(db *LockFreeChunkDB) error {
+	for _, set := range o.settings {
+		if err := set(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
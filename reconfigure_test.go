@@ -0,0 +1,64 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestReconfigure(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "reconfigure", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	err := db.Reconfigure(WithSync(-1), WithSoftRollback(time.Hour), WithTruncateLimit(10), WithWritePath(WritePathWrite))
+	assert.Nil(t, err, "expected no error in reconfigure")
+	assert.Equal(t, -1, db.syncEvery, "expected the sync period to change")
+	assert.Equal(t, time.Hour, db.redoWindow, "expected the soft rollback window to change")
+	assert.Equal(t, uint64(10), db.truncateLimit, "expected the truncation limit to change")
+	assert.Equal(t, WritePathWrite, db.writePath, "expected the write path to change")
+
+	vs := filldb(t, db, numEntries)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after reconfiguring")
+	}
+
+	assert.Equal(t, ErrNotReconfigurable, db.Reconfigure(WithChunkSize(chunkSize*2)), "expected the chunk size to be fixed")
+	assert.Equal(t, ErrNotReconfigurable, db.Reconfigure(WithSync(10), WithNetworkFilesystem(true)), "expected nfs mode to be fixed")
+	assert.Equal(t, -1, db.syncEvery, "expected nothing to change")
+	assert.Nil(t, db.Reconfigure(WithChunkSize(chunkSize), WithCreate(true)), "expected unchanged settings to be allowed")
+}
+
+func TestReconfigure_Open(t *testing.T) {
+	path := "test_db/reconfigure_open"
+	_ = os.RemoveAll(path)
+
+	db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(chunkSize), WithForgetBatch(4), WithMemoryLimit(1024))
+	assert.Nil(t, err, "expected no error in open")
+	defer assertClose(t, db)
+	assert.Equal(t, 4, db.forgetBatch, "expected the forget batch to be set")
+	assert.Equal(t, uint64(1024), db.memoryLimit, "expected the memory limit to be set")
+}
+
+func TestReconfigure_Durability(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "reconfigure_durability", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+
+	// Entries written through the memory map are synced before switching to O_DSYNC writes, which never flush
+	// the memory map.
+	assert.Nil(t, db.SetSync(-1), "expected no error in set sync")
+	filldb(t, db, 10)
+	assert.True(t, len(db.syncDirty) > 0, "expected unsynced entries")
+	assert.Nil(t, db.Reconfigure(WithDurability(DurabilityDsync)), "expected no error in reconfigure")
+	assert.Equal(t, 0, len(db.syncDirty), "expected the entries to be synced")
+	assert.NotNil(t, db.dsyncf, "expected the O_DSYNC file descriptor to be opened")
+
+	// And switching back closes the file descriptor.
+	for i := 0; i < 10; i++ {
+		assertAppend(t, db, []byte("dsync"))
+	}
+	assert.Nil(t, db.Reconfigure(WithDurability(DurabilityDataSync)), "expected no error in reconfigure")
+	assert.True(t, db.dsyncf == nil, "expected the O_DSYNC file descriptor to be closed")
+	assert.Equal(t, uint64(20), db.NewestID(), "expected no entries to be lost")
+}
@@ -0,0 +1,48 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestRecovery(t *testing.T) {
+	for name, policy := range map[string]RecoveryPolicy{"fail": RecoverFail, "truncate": RecoverTruncate, "forget": RecoverForget} {
+		t.Run(name, func(t *testing.T) {
+			db := assertOpen(t, dbTypes["lock free chunkdb"], true, "recovery_"+name, chunkSize).(*LockFreeChunkDB)
+			vs := filldb(t, db, numEntries)
+			corrupt := db.chunks[len(db.chunks)/2]
+			assertClose(t, db)
+
+			unprotect(t, corrupt.path)
+			if err := createFile(corrupt.path, 0); err != nil {
+				t.Fatal("failed to truncate chunk file to 0 bytes:", err)
+			}
+
+			db, err := OpenWithOptions("test_db/recovery_"+name, WithRecovery(policy))
+			if policy == RecoverFail {
+				assert.NotNil(t, err, "expected an error in open")
+				return
+			}
+			assert.Nil(t, err, "expected no error in open")
+			defer assertClose(t, db)
+			assert.Equal(t, policy, db.Config().Recovery, "expected the recovery policy")
+			assert.True(t, len(db.OpenReport().Recovery) > 0, "expected the recovery to be reported")
+
+			if policy == RecoverTruncate {
+				assert.Equal(t, uint64(1), db.OldestID(), "expected the oldest entries to be kept")
+				assert.Equal(t, corrupt.oldest-1, db.NewestID(), "expected the entries from the corrupt chunk to be lost")
+			} else {
+				assert.Equal(t, corrupt.next(), db.OldestID(), "expected the entries up to the corrupt chunk to be lost")
+				assert.Equal(t, uint64(numEntries), db.NewestID(), "expected the newest entries to be kept")
+			}
+			for id := db.OldestID(); id <= db.NewestID(); id++ {
+				assert.Equal(t, vs[id-1], assertGet(t, db, id), "expected the entry")
+			}
+			_, err = os.Stat(corrupt.path)
+			assert.True(t, os.IsNotExist(err), "expected the corrupt chunk to be moved")
+			assertAppend(t, db, []byte("after recovery"))
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package logdb
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoRedo means that there are no rolled back entries for 'UnRollback' to restore.
+	ErrNoRedo = errors.New("no rolled back entries to restore")
+
+	// ErrRedoStale means that entries have been appended or removed since the rollback which 'UnRollback'
+	// would undo, so the rolled back entries can no longer be restored with their original IDs.
+	ErrRedoStale = errors.New("log changed since rollback")
+)
+
+// SetSoftRollback is the thread-safe version of 'LockFreeChunkDB.SetSoftRollback'.
+func (db *ChunkDB) SetSoftRollback(window time.Duration) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetSoftRollback(window)
+}
+
+// SetSoftRollback configures 'Rollback' (and 'Truncate', and their variants) to keep a copy of the entries they
+// remove in memory for this long, so that 'RolledBack' can inspect them and 'UnRollback' can restore them. This
+// gives a recovery path when a bug rolls back entries which should have been kept. 0, the default, disables
+// soft rollback.
+//
+// Successive rollbacks with nothing appended in between are combined, so that 'UnRollback' undoes all of them.
+func (db *LockFreeChunkDB) SetSoftRollback(window time.Duration) {
+	db.redoWindow = window
+	if window == 0 {
+		db.redo = nil
+	}
+}
+
+// RolledBack is the thread-safe version of 'LockFreeChunkDB.RolledBack'.
+func (db *ChunkDB) RolledBack() (uint64, [][]byte) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.RolledBack()
+}
+
+// RolledBack gets the entries removed by the most recent rollback, if soft rollback is enabled and the window
+// has not passed, along with the ID the first of them had. If there are none, the ID is 0. Entries which had
+// been dropped by 'Downsample' are nil.
+func (db *LockFreeChunkDB) RolledBack() (uint64, [][]byte) {
+	redo := db.rolledBack()
+	if redo == nil {
+		return 0, nil
+	}
+	entries := make([][]byte, len(redo))
+	for i, r := range redo {
+		if !r.dropped {
+			entries[i] = r.entry
+		}
+	}
+	return db.redoFirst, entries
+}
+
+// UnRollback is the thread-safe version of 'LockFreeChunkDB.UnRollback'.
+func (db *ChunkDB) UnRollback() error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.UnRollback()
+}
+
+// UnRollback restores the entries removed by the most recent rollback, with their original IDs, labels, and
+// headers. Entries which had been dropped by 'Downsample' are restored as dropped.
+//
+// Returns 'ErrNoRedo' if soft rollback is disabled or the window has passed, 'ErrRedoStale' if the newest
+// entry has changed since the rollback, and the same errors as 'AppendEntries'.
+func (db *LockFreeChunkDB) UnRollback() (err error) {
+	start := time.Now()
+	originalNewest := db.next() - 1
+	defer func() {
+		db.newest = db.next() - 1
+		db.observe(&db.latencies.append, start, "append", originalNewest+1, db.newest, db.activeChunkPath())
+		db.countError(err)
+	}()
+
+	if db.closed {
+		return ErrClosed
+	}
+	redo := db.rolledBack()
+	if redo == nil {
+		return ErrNoRedo
+	}
+	if db.newest+1 != db.redoFirst {
+		return ErrRedoStale
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	if db.missing {
+		return ErrChunkMissing
+	}
+
+	for i, r := range redo {
+		if err := db.append(r.entry, r.label, r.header); err != nil {
+			if i > 0 {
+				if rerr := db.rollback(originalNewest); rerr != nil {
+					return &AtomicityError{AppendErr: err, RollbackErr: rerr}
+				}
+			}
+			return err
+		}
+		if r.dropped {
+			// The entry was appended empty, so it is marked as dropped before anything can sync it.
+			c := db.chunks[len(db.chunks)-1]
+			c.sums[len(c.sums)-1] = droppedSum
+		}
+	}
+	db.count(MetricAppends, uint64(len(redo)))
+	db.redo = nil
+	return db.checked("append", db.periodicSync())
+}
+
+////////// HELPERS //////////
+
+// An entry removed by a rollback, as it was stored.
+type redoEntry struct {
+	entry   []byte
+	label   Label
+	header  []byte
+	dropped bool
+}
+
+// Get the entries removed by the most recent rollback, if soft rollback is enabled and the window has not
+// passed.
+func (db *LockFreeChunkDB) rolledBack() []redoEntry {
+	if db.redo == nil || time.Since(db.redoAt) > db.redoWindow {
+		return nil
+	}
+	return db.redo
+}
+
+// Perform a rollback, keeping the removed entries if soft rollback is enabled, and starting a new generation if
+// any entries are removed. Assumes a write lock is held.
+func (db *LockFreeChunkDB) softRollback(newNewestID uint64) error {
+	if newNewestID >= db.oldest && newNewestID < db.newest {
+		if err := db.newGeneration(newNewestID); err != nil {
+			return err
+		}
+	}
+	if db.redoWindow == 0 || newNewestID >= db.newest || newNewestID < db.oldest {
+		return db.rollback(newNewestID)
+	}
+
+	removed := make([]redoEntry, 0, db.newest-newNewestID)
+	for id := newNewestID + 1; id <= db.newest; id++ {
+		c, start, end := db.find(id)
+		entry := make([]byte, end-start)
+		if err := c.readAt(entry, start); err != nil {
+			return err
+		}
+		r := redoEntry{entry: entry, label: c.label(id), header: append([]byte(nil), c.header(id)...)}
+		r.dropped = start == end && c.sums != nil && c.sums[id-c.oldest] == droppedSum
+		removed = append(removed, r)
+	}
+	newest := db.newest
+
+	if err := db.rollback(newNewestID); err != nil {
+		return err
+	}
+
+	// Combine with the previous rollback if it removed the entries directly after these.
+	if redo := db.rolledBack(); redo != nil && db.redoFirst == newest+1 {
+		removed = append(removed, redo...)
+	}
+	db.redo = removed
+	db.redoFirst = newNewestID + 1
+	db.redoAt = time.Now()
+	db.enforceMemoryLimit()
+	return nil
+}
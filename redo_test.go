@@ -0,0 +1,63 @@
+package logdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestSoftRollback(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "soft_rollback", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	vs := filldb(t, db, numEntries)
+	assert.Equal(t, ErrNoRedo, db.UnRollback(), "expected nothing to restore")
+
+	db.SetSoftRollback(time.Hour)
+	assertRollback(t, db, 200)
+	assertRollback(t, db, 100)
+	first, entries := db.RolledBack()
+	assert.Equal(t, uint64(101), first, "expected ID of first rolled back entry")
+	assert.Equal(t, numEntries-100, len(entries), "expected rollbacks to be combined")
+
+	assert.Nil(t, db.UnRollback(), "expected no error in unrollback")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after restoring")
+	}
+	assert.Equal(t, ErrNoRedo, db.UnRollback(), "expected entries to be restored only once")
+
+	// Appending after a rollback means the entries cannot be restored with their IDs.
+	assertRollback(t, db, 200)
+	assertAppend(t, db, []byte("new"))
+	assert.Equal(t, ErrRedoStale, db.UnRollback(), "expected stale rollback")
+}
+
+func TestSoftRollback_Meta(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "soft_rollback_meta", 8).(*ChunkDB)
+	defer assertClose(t, db)
+
+	assertAppend(t, db, []byte("plain"))
+	_, err := db.AppendLabelled(3, []byte("labelled"))
+	assert.Nil(t, err, "expected no error in labelled append")
+	_, err = db.AppendWithHeader([]byte("header"), []byte("headed"))
+	assert.Nil(t, err, "expected no error in append with header")
+	assertAppend(t, db, []byte("dropped"))
+	assertAppend(t, db, []byte("last"))
+	assert.Nil(t, db.Downsample(5, func(id uint64, _ []byte) bool { return id != 4 }), "expected no error in downsample")
+
+	db.SetSoftRollback(time.Hour)
+	assertRollback(t, db, 1)
+	_, entries := db.RolledBack()
+	assert.Nil(t, entries[2], "expected dropped entry to be nil")
+	assert.Nil(t, db.UnRollback(), "expected no error in unrollback")
+
+	_, meta, err := db.GetWithMeta(2)
+	assert.Nil(t, err, "expected no error getting labelled entry")
+	assert.Equal(t, Label(3), meta.Label, "expected label to be restored")
+	_, meta, err = db.GetWithMeta(3)
+	assert.Nil(t, err, "expected no error getting entry with header")
+	assert.Equal(t, []byte("header"), meta.Header, "expected header to be restored")
+	_, err = db.Get(4)
+	assert.Equal(t, ErrDownsampled, err, "expected entry to be restored as dropped")
+}
@@ -0,0 +1,107 @@
+package logdb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Reopen closes and reopens the database. See 'LockFreeChunkDB.Reopen' for details.
+func (db *ChunkDB) Reopen() error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.Reopen()
+}
+
+// Reopen discards the in-memory state of the database and reloads it from disk, as if the handle had been
+// closed and the database opened again. This is how to recover from 'ErrChunkMissing': whatever is left on disk
+// is brought back into a consistent state, and the entries in the missing chunk are lost.
+//
+// Changes which have not been synced are discarded, not synced, as are chunk deletions awaiting the next sync
+// and entries kept for 'UnRollback'. The sync period, logger, slow operation threshold, latency histograms, and chunk
+// hooks are preserved.
+//
+// If reopening fails, the handle is closed.
+func (db *LockFreeChunkDB) Reopen() error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	for _, c := range db.chunks {
+		// A chunk read without mmap has nothing mapped.
+		if c.bytes != nil {
+			_ = syscall.Munmap(c.bytes)
+		}
+		_ = c.mmapf.Close()
+	}
+	db.closeDsync()
+	_ = unlockdb(db.lockfile, db.heartbeat)
+	db.closed = true
+
+	fresh, err := opendb(db.path, options{hooks: db.hooks, nfs: db.nfs, verify: db.verify, recovery: db.recovery, writePath: db.writePath, relaxedSync: db.relaxedSync, durability: db.durability})
+	if err != nil {
+		return err
+	}
+
+	db.lockfile = fresh.lockfile
+	db.heartbeat = fresh.heartbeat
+	db.closed = false
+	db.chunkSize = fresh.chunkSize
+	db.chunkEntries = fresh.chunkEntries
+	db.chunks = fresh.chunks
+	db.oldest = fresh.oldest
+	db.newest = fresh.newest
+	db.sinceLastSync = 0
+	db.syncDirty = fresh.syncDirty
+	db.pendingDeletes = 0
+	db.redo = nil
+	db.chunkStates = nil
+	db.missing = false
+	db.report = fresh.report
+	db.commitPoint = fresh.commitPoint
+	db.generations = fresh.generations
+	db.pendingTruncate = nil
+	return nil
+}
+
+////////// HELPERS //////////
+
+// Check that the files of the active chunk are still present. Once they have been found to be missing, this
+// keeps failing until the database is reopened. This costs a stat per file, so is only done on sync and chunk
+// rollover, and after a write fails in a way which suggests the files have gone. Assumes a lock (read or write)
+// is held.
+func (db *LockFreeChunkDB) checkActiveChunk() error {
+	if db.missing {
+		return ErrChunkMissing
+	}
+	if len(db.chunks) == 0 {
+		return nil
+	}
+
+	c := db.chunks[len(db.chunks)-1]
+	if c.delete {
+		return nil
+	}
+	for _, path := range []string{c.path, c.metaFilePath()} {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			db.missing = true
+			if db.logger != nil {
+				db.logger.Printf("logdb: chunk file %s is missing", path)
+			}
+			return ErrChunkMissing
+		}
+	}
+	return nil
+}
+
+// Turn an error writing an entry to the active chunk into a 'WriteError', or 'ErrChunkMissing' if the write
+// failed because the files of the active chunk have gone. Assumes a write lock is held.
+func (db *LockFreeChunkDB) writeFailed(err error) error {
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ESTALE) {
+		if cerr := db.checkActiveChunk(); cerr != nil {
+			return cerr
+		}
+	}
+	return &WriteError{err}
+}
@@ -0,0 +1,72 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestReopen_ChunkMissing(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "reopen_chunk_missing", chunkSize).(*LockFreeChunkDB)
+	vs := filldb(t, db, numEntries)
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	final := db.chunks[len(db.chunks)-1]
+	if err := os.Remove(final.path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Appending does not check the files are present, but the next sync does, and then appends fail too.
+	assertAppend(t, db, []byte("lost"))
+	assert.Equal(t, ErrChunkMissing, db.Sync(), "expected sync to fail")
+	_, err := db.Append([]byte("lost"))
+	assert.Equal(t, ErrChunkMissing, err, "expected append to fail")
+	_, err = os.Stat(final.metaFilePath())
+	assert.Nil(t, err, "expected meta file to be left alone")
+
+	if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, db)
+
+	assert.Equal(t, final.oldest-1, db.NewestID(), "expected entries in the missing chunk to be lost")
+	for i := uint64(1); i <= db.NewestID(); i++ {
+		assert.Equal(t, vs[i-1], assertGet(t, db, i), "expected entry to survive")
+	}
+
+	id, err := db.Append([]byte("recovered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, final.oldest, id, "expected IDs to continue from the surviving chunks")
+}
+
+func TestReopen_DiscardsPending(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "reopen_discards_pending", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Nil(t, db.SetForgetBatch(100), "expected no error in set forget batch")
+	db.SetSoftRollback(time.Hour)
+	filldb(t, db, numEntries)
+	assertRollback(t, db, numEntries-10)
+	assertForget(t, db, 200)
+	assert.True(t, db.pendingDeletes > 0, "expected chunk deletions to be pending")
+
+	if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, db.pendingDeletes, "expected no pending deletes after reopening")
+	id, entries := db.RolledBack()
+	assert.Equal(t, uint64(0), id, "expected no rolled back entries after reopening")
+	assert.Equal(t, 0, len(entries), "expected no rolled back entries after reopening")
+	assert.Nil(t, db.Verify(), "expected no problems after reopening")
+}
+
+func TestReopen_Closed(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "reopen_closed", chunkSize).(*LockFreeChunkDB)
+	assertClose(t, db)
+	assert.Equal(t, ErrClosed, db.Reopen(), "expected closed handle to not reopen")
+}
@@ -0,0 +1,331 @@
+package logdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the directory the chunk files which 'Repair' could not use are moved to.
+const repairDir = ".repair"
+
+// A RepairReport describes what 'Repair' did to a database.
+type RepairReport struct {
+	// The oldest and next entry IDs of the repaired database.
+	OldestID uint64
+	NextID   uint64
+
+	// Data files of chunks which lost entries, or whose files were fixed up, such as by being resized.
+	Truncated []string
+
+	// Data files of chunks which were discarded, because they had no usable entries, or their entries did not
+	// continue on from those which were kept. These are moved, along with their other files, to 'DiscardDir'.
+	Discarded []string
+
+	// The directory discarded chunk files were moved to. This is empty if none were.
+	DiscardDir string
+
+	// Data files of chunks which were renumbered to close a gap, as "old -> new".
+	Renamed []string
+}
+
+// Repair salvages as many entries as possible from a database which 'Open' cannot recover, such as one with
+// missing metadata files, truncated chunk data files, corrupt entries, or gaps left by chunks which were deleted
+// by hand. The database must not be open, including by a 'Reader'.
+//
+// Each chunk keeps its entries up to the first which is missing or does not match its checksum, and the longest
+// run of chunks whose entries continue on from each other is kept. Everything else is moved to a timestamped
+// subdirectory of ".repair" rather than deleted, so that nothing is lost which could be recovered by hand. The
+// kept chunks are fixed up and renumbered so that the database can be opened.
+//
+// As 'Repair' throws away what it cannot use, the newest entries may be lost: it is a last resort, for when
+// opening the database fails. A database which can be opened is left as it is, apart from the recovery 'Open'
+// would do anyway.
+//
+// Returns 'ErrPathDoesntExist' or 'ErrNotDirectory' if there is no database, a 'LockError' value if it is open,
+// 'ErrUnknownVersion', 'ErrNeedsMigration', or 'ErrMigrationIncomplete' if it is not in the current disk format
+// version, a 'ReadError' value if it could not be read, and a 'WriteError' value if it could not be changed.
+func Repair(path string) (RepairReport, error) {
+	var report RepairReport
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return report, ErrPathDoesntExist
+	}
+	if !stat.IsDir() {
+		return report, ErrNotDirectory
+	}
+
+	var version uint16
+	if err := readFile(path+"/version", &version); err != nil {
+		return report, &ReadError{err}
+	}
+	if err := checkFormat(version); err != nil {
+		return report, err
+	}
+	if _, err := os.Stat(path + "/" + migrateDir); err == nil {
+		return report, ErrMigrationIncomplete
+	}
+
+	lockfile, heartbeat, err := lockdb(path, false)
+	if err != nil {
+		return report, &LockError{err}
+	}
+	defer func() { _ = unlockdb(lockfile, heartbeat) }()
+	readers, err := lockOutReaders(path)
+	if err != nil {
+		return report, &LockError{err}
+	}
+	defer func() { _ = funlock(readers) }()
+
+	var chunkSize uint32
+	if err := readFile(path+"/chunk_size", &chunkSize); err != nil {
+		return report, &ReadError{err}
+	}
+
+	// Get any staged files into place first, so that they are repaired too.
+	if err := finishImport(path); err != nil {
+		return report, &WriteError{err}
+	}
+	if err := finishCompact(path); err != nil {
+		return report, &WriteError{err}
+	}
+
+	salvaged, err := salvageChunks(path, chunkSize)
+	if err != nil {
+		return report, &ReadError{err}
+	}
+
+	// Chunks without entries cannot be kept, as only the final chunk may be empty, and there would be nothing
+	// to check that the next chunk continues on from it.
+	var discard, nonempty []*salvagedChunk
+	for _, s := range salvaged {
+		if len(s.ends) == 0 {
+			discard = append(discard, s)
+		} else {
+			nonempty = append(nonempty, s)
+		}
+	}
+
+	// Keep the run of chunks with the most entries, preferring the newest if there is a tie.
+	var first, keepFrom, keepTo, entries, best int
+	for i, s := range nonempty {
+		if i > 0 && s.oldest != nonempty[i-1].next() {
+			first, entries = i, 0
+		}
+		entries += len(s.ends)
+		if entries >= best {
+			keepFrom, keepTo, best = first, i+1, entries
+		}
+	}
+	kept := nonempty[keepFrom:keepTo]
+	discard = append(discard, nonempty[:keepFrom]...)
+	discard = append(discard, nonempty[keepTo:]...)
+
+	// Move the chunks which are not kept out of the way.
+	if len(discard) > 0 {
+		report.DiscardDir = fmt.Sprintf("%s/%s/%v", path, repairDir, time.Now().UnixNano())
+	}
+	for _, s := range discard {
+		if err := discardChunk(s.path, report.DiscardDir); err != nil {
+			return report, &WriteError{err}
+		}
+		report.Discarded = append(report.Discarded, s.path)
+	}
+
+	// Fix up the files of the chunks which have changed, and renumber the chunks so there are no gaps.
+	for i, s := range kept {
+		if s.changed {
+			if err := s.rewrite(chunkSize); err != nil {
+				return report, &WriteError{err}
+			}
+			report.Truncated = append(report.Truncated, s.path)
+		}
+
+		name := fmt.Sprintf("%s%s%v%s%v", chunkPrefix, sep, chunkNumber(kept[0].path)+uint64(i), sep, s.oldest)
+		if from := s.path; from[strings.LastIndex(from, "/")+1:] != name {
+			s.path = chunkDir(path, name) + "/" + name
+			if err := moveChunk(from, s.path); err != nil {
+				return report, &WriteError{err}
+			}
+			report.Renamed = append(report.Renamed, from+" -> "+s.path)
+		}
+
+		// Every chunk but the final one is sealed, so seal those which were rewritten again.
+		if s.changed && i < len(kept)-1 {
+			c := &chunk{path: s.path, bytes: s.data, ends: s.ends, sums: s.sums, labels: s.labels, headers: s.headers, oldest: s.oldest}
+			if err := c.seal(); err != nil {
+				return report, &WriteError{err}
+			}
+		}
+	}
+
+	// Bring the oldest entry ID into the range of the kept entries.
+	report.OldestID, report.NextID = 1, 1
+	if len(kept) > 0 {
+		report.NextID = kept[len(kept)-1].next()
+		if err := readFile(path+"/oldest", &report.OldestID); err != nil || report.OldestID < kept[0].oldest {
+			report.OldestID = kept[0].oldest
+		}
+		if report.OldestID > report.NextID {
+			report.OldestID = report.NextID
+		}
+	}
+	if err := writeFile(path+"/oldest", report.OldestID); err != nil {
+		return report, &WriteError{err}
+	}
+
+	return report, nil
+}
+
+////////// HELPERS //////////
+
+// What could be salvaged from a chunk.
+type salvagedChunk struct {
+	// Path to the data file.
+	path string
+
+	// ID of the oldest entry, from the file name.
+	oldest uint64
+
+	// The entries which can be used, as in 'chunk'.
+	ends    []int32
+	sums    []uint32
+	labels  []Label
+	headers [][]byte
+
+	// The data file, as far as it was read.
+	data []byte
+
+	// Set if entries were lost, or if the files need fixing up.
+	changed bool
+}
+
+// Get the ID of the entry after the last in the chunk.
+func (s *salvagedChunk) next() uint64 {
+	return s.oldest + uint64(len(s.ends))
+}
+
+// Read every chunk of a database, in order, keeping the entries of each up to the first which cannot be read
+// or does not match its checksum. Chunks without a usable metadata file have no entries.
+func salvageChunks(path string, chunkSize uint32) ([]*salvagedChunk, error) {
+	var chunkFiles []os.FileInfo
+	dirs := make(map[string]string)
+	scan := []string{path}
+	for i := 0; i < len(scan); i++ {
+		fis, err := ioutil.ReadDir(scan[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			switch {
+			case fi.IsDir() && scan[i] == path && isBasenameShardDir(fi.Name()):
+				scan = append(scan, path+"/"+fi.Name())
+			case !fi.IsDir() && isBasenameChunkDataFile(fi.Name()):
+				chunkFiles = append(chunkFiles, fi)
+				dirs[fi.Name()] = scan[i]
+			}
+		}
+	}
+	sort.Sort(fileInfoSlice(chunkFiles))
+
+	salvaged := make([]*salvagedChunk, len(chunkFiles))
+	for i, fi := range chunkFiles {
+		oldest, _ := strconv.ParseUint(strings.Split(fi.Name(), sep)[2], 10, 0)
+		s := &salvagedChunk{path: dirs[fi.Name()] + "/" + fi.Name(), oldest: oldest}
+		salvaged[i] = s
+
+		data, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.data = data
+		s.changed = uint32(len(data)) != chunkSize
+
+		meta, err := ioutil.ReadFile(metaFilePath(s.path))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		m, err := readFullMetadata(bytes.NewReader(meta))
+		if err != nil {
+			s.changed = true
+		}
+
+		var start int32
+		for j, end := range m.ends {
+			if end < start || int64(end) > int64(len(data)) || uint32(end) > chunkSize || !sumMatches(data[start:end], m.sums[j]) {
+				s.changed = true
+				break
+			}
+			s.ends = append(s.ends, end)
+			s.sums = append(s.sums, m.sums[j])
+			if m.labels != nil {
+				s.labels = append(s.labels, m.labels[j])
+			}
+			if m.headers != nil {
+				s.headers = append(s.headers, m.headers[j])
+			}
+			start = end
+		}
+	}
+	return salvaged, nil
+}
+
+// Rewrite the files of a chunk to hold only the salvaged entries: the data file is made writable and resized to
+// the chunk size, the metadata file is replaced, and the seal file is removed.
+func (s *salvagedChunk) rewrite(chunkSize uint32) error {
+	if err := os.Chmod(s.path, 0644); err != nil {
+		return err
+	}
+	if err := os.Truncate(s.path, int64(chunkSize)); err != nil {
+		return err
+	}
+	if err := os.Remove(sealFilePath(s.path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmpPath := metaFilePath(s.path) + tmpSuffix
+	if err := writeFile(tmpPath, encodeMetadata(nil, s.ends, s.sums, s.labels, s.headers, 0)); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, metaFilePath(s.path))
+}
+
+// Rename the files of a chunk, creating the shard directory if need be.
+func moveChunk(from, to string) error {
+	if err := os.MkdirAll(to[:strings.LastIndex(to, "/")], os.ModeDir|0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if err := os.Rename(metaFilePath(from), metaFilePath(to)); err != nil {
+		return err
+	}
+	if err := os.Rename(sealFilePath(from), sealFilePath(to)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Move the files of a chunk into a directory.
+func discardChunk(dataFilePath, dir string) error {
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return err
+	}
+	for _, from := range []string{dataFilePath, metaFilePath(dataFilePath), sealFilePath(dataFilePath)} {
+		name := from[strings.LastIndex(from, "/")+1:]
+		if err := os.Rename(from, dir+"/"+name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package logdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestRepair(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "repair", chunkSize).(*LockFreeChunkDB)
+	vs := filldb(t, db, numEntries)
+	chunks := db.chunks
+	n := len(chunks)
+	assert.True(t, n > 6, "expected enough chunks to damage, got %v", n)
+
+	_, err := Repair("test_db/repair")
+	assert.True(t, errors.As(err, new(*LockError)), "expected a 'LockError' value while open, got %v", err)
+	assertClose(t, db)
+
+	// Lose the metadata of the second chunk, and the end of the data of the third-from-last, leaving three runs
+	// of entries, of which the middle is the longest.
+	if err := os.Remove(chunks[1].metaFilePath()); err != nil {
+		t.Fatal(err)
+	}
+	truncated := chunks[n-3]
+	if err := os.Chmod(truncated.path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(truncated.path, int64(truncated.ends[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Repair("test_db/repair")
+	assert.Nil(t, err, "expected no error in repair")
+	assert.Equal(t, []string{truncated.path}, report.Truncated, "expected the truncated chunk to be reported")
+	assert.Equal(t, []string{chunks[1].path, chunks[0].path, chunks[n-2].path, chunks[n-1].path}, report.Discarded, "expected the other runs to be discarded")
+	assert.Equal(t, chunks[2].oldest, report.OldestID, "expected the oldest entry of the kept run")
+	assert.Equal(t, truncated.oldest+1, report.NextID, "expected the entries after the truncation to be lost")
+	_, err = os.Stat(report.DiscardDir + "/" + chunks[0].path[len("test_db/repair/"):])
+	assert.Nil(t, err, "expected the discarded files to be kept")
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "repair", chunkSize).(*LockFreeChunkDB)
+	assert.Equal(t, 0, len(db.OpenReport().Recovery), "expected nothing left to recover")
+	assert.Equal(t, report.OldestID, db.OldestID(), "expected the reported oldest entry")
+	assert.Equal(t, report.NextID-1, db.NewestID(), "expected the reported newest entry")
+	for id := db.OldestID(); id <= db.NewestID(); id++ {
+		assert.Equal(t, vs[id-1], assertGet(t, db, id), "expected the salvaged entry")
+	}
+	assert.Nil(t, db.Verify(), "expected the repaired database to verify")
+	assertAppend(t, db, []byte("after repair"))
+	assertClose(t, db)
+}
+
+func TestRepair_Renumber(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "repair_renumber", chunkSize).(*LockFreeChunkDB)
+	vs := filldb(t, db, numEntries)
+	final := db.chunks[len(db.chunks)-1]
+	assertClose(t, db)
+
+	// Skip a chunk number, which 'Open' would take to mean that the chunks before it were being deleted.
+	skipped := fmt.Sprintf("test_db/repair_renumber/%s%s%v%s%v", chunkPrefix, sep, chunkNumber(final.path)+1, sep, final.oldest)
+	if err := os.Rename(final.path, skipped); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(final.metaFilePath(), metaFilePath(skipped)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Repair("test_db/repair_renumber")
+	assert.Nil(t, err, "expected no error in repair")
+	assert.Equal(t, []string{skipped + " -> " + final.path}, report.Renamed, "expected the chunk to be renumbered")
+	assert.Equal(t, 0, len(report.Discarded), "expected nothing to be discarded")
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "repair_renumber", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, uint64(1), db.OldestID(), "expected every entry to be kept")
+	assert.Equal(t, uint64(numEntries), db.NewestID(), "expected every entry to be kept")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected the entry")
+	}
+}
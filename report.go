@@ -0,0 +1,77 @@
+package logdb
+
+import "time"
+
+// OpenReport describes what was found on disk when a database was opened, and what was done to bring it into a
+// consistent state.
+type OpenReport struct {
+	// Created is true if the database did not exist, and so was created rather than opened. In which case, only
+	// 'Duration' is also set.
+	Created bool
+
+	// The chunks which were opened, in order. 'ChunkInfo.NextID - ChunkInfo.OldestID' is the number of entries
+	// in each.
+	Chunks []ChunkInfo
+
+	// ID of the oldest entry, and one past the newest.
+	OldestID uint64
+	NextID   uint64
+
+	// Data files of chunks which were deleted because they came before a gap in the chunk files. This happens
+	// if the program died while deleting chunks after a 'Forget'.
+	Gaps []string
+
+	// Files which were deleted because they were left behind by an interrupted operation, such as metadata
+	// files without a data file, or an empty final chunk.
+	Removed []string
+
+	// Files in the database directory which are not part of the database, and so were left alone.
+	Ignored []string
+
+	// Descriptions of the steps taken to recover from the database not having been closed cleanly, in the
+	// order they were taken.
+	Recovery []string
+
+	// How long opening took, including any verification.
+	Duration time.Duration
+}
+
+// OpenReport is the thread-safe version of 'LockFreeChunkDB.OpenReport'.
+func (db *ChunkDB) OpenReport() OpenReport {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.OpenReport()
+}
+
+// OpenReport describes what was found on disk when the database was opened (or last reopened), and what was done
+// to recover from any problems.
+func (db *LockFreeChunkDB) OpenReport() OpenReport {
+	return db.report
+}
+
+////////// HELPERS //////////
+
+// Names of the files in the database directory which are not chunk files.
+var reportKnownFiles = map[string]bool{
+	"version":         true,
+	"chunk_size":      true,
+	"chunk_entries":   true,
+	"oldest":          true,
+	"commit_point":    true,
+	generationFile:    true,
+	fenceFile:         true,
+	heartbeatLockFile: true,
+	importDir:         true,
+	compactDir:        true,
+	trashDir:          true,
+	migrateDir:        true,
+	backupLinks:       true,
+	readersLockFile:   true,
+	repairDir:         true,
+}
+
+// Record a recovery step.
+func (r *OpenReport) recover(step string) {
+	r.Recovery = append(r.Recovery, step)
+}
@@ -0,0 +1,40 @@
+package logdb
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestOpenReport(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "open_report", chunkSize).(*ChunkDB)
+	assert.True(t, db.OpenReport().Created, "expected a new database to be reported as created")
+	filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	// Leave behind an orphaned metadata file, an unrelated file, and a bad "oldest" file.
+	if err := ioutil.WriteFile("test_db/open_report/chunk_999_1_meta", nil, 0644); err != nil {
+		t.Fatal("could not write metadata file:", err)
+	}
+	if err := ioutil.WriteFile("test_db/open_report/notes.txt", nil, 0644); err != nil {
+		t.Fatal("could not write unrelated file:", err)
+	}
+	if err := writeFile("test_db/open_report/oldest", uint64(numEntries+10)); err != nil {
+		t.Fatal("could not write oldest file:", err)
+	}
+
+	db = assertOpen(t, dbTypes["chunkdb"], false, "open_report", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	report := db.OpenReport()
+	assert.False(t, report.Created, "expected an existing database not to be reported as created")
+	assert.Equal(t, len(db.chunks), len(report.Chunks), "expected every chunk to be reported")
+	assert.Equal(t, uint64(1), report.Chunks[0].OldestID, "expected first chunk to start at the first entry")
+	assert.Equal(t, uint64(numEntries+1), report.NextID, "expected next ID")
+	assert.Equal(t, uint64(numEntries+1), report.OldestID, "expected oldest ID to be lowered")
+	assert.Equal(t, []string{"test_db/open_report/chunk_999_1_meta"}, report.Removed, "expected orphaned file to be removed")
+	assert.Equal(t, []string{"test_db/open_report/notes.txt"}, report.Ignored, "expected unrelated file to be ignored")
+	assert.Equal(t, 1, len(report.Recovery), "expected one recovery step, got: %v", report.Recovery)
+	assert.True(t, report.Duration > 0, "expected a duration")
+}
@@ -0,0 +1,298 @@
+package logdb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrArchiveIncomplete means that a backup archive given to 'Restore' ends before its end marker, so it was not
+// completely written.
+var ErrArchiveIncomplete = errors.New("backup archive incomplete")
+
+// Restore recreates a database in a new directory from a backup archive written by 'BackupTo'.
+//
+// Everything is checked before the database is made available: the archive must be complete and in the current
+// disk format version; the chunk size must be one this platform supports; and the chunks must be contiguous,
+// their metadata consistent with their data, and their entries match their checksums. The database is restored
+// into a temporary directory alongside the path, which is renamed into place once everything has been checked
+// and synced, so the path never holds a partial database.
+//
+// Returns a 'PathError' value if the path already exists or the directory could not be created;
+// 'ErrUnknownVersion' or 'ErrNeedsMigration' if the archive is in another version; 'ErrChunkSizeTooBig' if the
+// chunk size is too big for this platform; 'ErrArchiveIncomplete' if the archive ends early; a 'FormatError' value if the archive
+// is invalid; a 'ChecksumError' value if an entry is corrupt; a 'ReadError' value if the archive could not be
+// read; and a 'WriteError' value if the database could not be written.
+func Restore(r io.Reader, path string) error {
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		return &PathError{&os.PathError{Op: "restore", Path: path, Err: os.ErrExist}}
+	}
+	tmp := path + tmpSuffix
+	if err := os.RemoveAll(tmp); err != nil {
+		return &PathError{err}
+	}
+	if err := os.Mkdir(tmp, os.ModeDir|0755); err != nil {
+		return &PathError{err}
+	}
+
+	if err := (&restorer{dir: tmp}).restore(tar.NewReader(r)); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.RemoveAll(tmp)
+		return &PathError{err}
+	}
+	return nil
+}
+
+////////// HELPERS //////////
+
+// A file in a backup archive which is not expected where it is.
+var errUnexpectedArchiveFile = errors.New("unexpected file in backup archive")
+
+// The state of a restore in progress.
+type restorer struct {
+	dir string
+
+	// Set as the files are read. The chunk size and chunk entries limit must come before any chunk.
+	version      bool
+	chunkSize    uint32
+	chunkEntries *uint32
+	oldest       *uint64
+	chunks       uint64
+
+	// The previous chunk, which is sealed once it is known not to be the final chunk, and the buffer for the
+	// next chunk. The two buffers are swapped as each chunk is restored.
+	prev *chunk
+	buf  []byte
+}
+
+// Read and check every file in the archive, writing the database files.
+func (r *restorer) restore(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return ErrArchiveIncomplete
+		} else if err != nil {
+			return archiveReadError(err)
+		}
+
+		name := hdr.Name
+		if !r.version && name != "version" {
+			return &FormatError{FilePath: name, Err: errUnexpectedArchiveFile}
+		}
+		switch {
+		case name == "version":
+			var version uint16
+			if err := r.read(tr, hdr, &version); err != nil {
+				return err
+			}
+			if err := checkFormat(version); err != nil {
+				return err
+			}
+			r.version = true
+			err = writeFile(r.dir+"/version", version)
+		case name == "chunk_size" && r.chunks == 0:
+			if err := r.read(tr, hdr, &r.chunkSize); err != nil {
+				return err
+			}
+			if r.chunkSize > maxChunkSize {
+				return ErrChunkSizeTooBig
+			}
+			r.buf = make([]byte, r.chunkSize)
+			err = writeFile(r.dir+"/chunk_size", r.chunkSize)
+		case name == "chunk_entries" && r.chunks == 0:
+			r.chunkEntries = new(uint32)
+			if err := r.read(tr, hdr, r.chunkEntries); err != nil {
+				return err
+			}
+			err = writeFile(r.dir+"/chunk_entries", *r.chunkEntries)
+		case name == "oldest" || name == "commit_point":
+			var id uint64
+			if err := r.read(tr, hdr, &id); err != nil {
+				return err
+			}
+			if name == "oldest" {
+				r.oldest = &id
+			}
+			err = writeFile(r.dir+"/"+name, id)
+		case name == generationFile:
+			if err := r.restoreGenerations(tr, hdr); err != nil {
+				return err
+			}
+		case name == archiveEnd:
+			var chunks uint64
+			if err := r.read(tr, hdr, &chunks); err != nil {
+				return err
+			}
+			if chunks != r.chunks {
+				return ErrArchiveIncomplete
+			}
+			return r.finish()
+		case isBasenameChunkDataFile(name) && r.buf != nil && r.chunkEntries != nil:
+			if err := r.restoreChunk(tr, hdr); err != nil {
+				return err
+			}
+		default:
+			return &FormatError{FilePath: name, Err: errUnexpectedArchiveFile}
+		}
+		if err != nil {
+			return &WriteError{err}
+		}
+	}
+}
+
+// Read a file from the archive into a fixed-size value, which must be exactly the size of the file.
+func (r *restorer) read(tr *tar.Reader, hdr *tar.Header, data interface{}) error {
+	if int64(binary.Size(data)) != hdr.Size {
+		return &FormatError{FilePath: hdr.Name, Err: errUnexpectedArchiveFile}
+	}
+	if err := binary.Read(tr, binary.LittleEndian, data); err != nil {
+		return archiveReadError(err)
+	}
+	return nil
+}
+
+// Restore the rollback records from the archive, which has reached them. They are copied a record at a time, so
+// the size in the archive does not need to be trusted.
+func (r *restorer) restoreGenerations(tr *tar.Reader, hdr *tar.Header) error {
+	size := int64(binary.Size(generationRecord{}))
+	if hdr.Size%size != 0 {
+		return &FormatError{FilePath: hdr.Name, Err: errUnexpectedArchiveFile}
+	}
+
+	file, err := os.OpenFile(r.dir+"/"+generationFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return &WriteError{err}
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for n := hdr.Size / size; n > 0; n-- {
+		var rec generationRecord
+		if err := binary.Read(tr, binary.LittleEndian, &rec); err == io.EOF {
+			return ErrArchiveIncomplete
+		} else if err != nil {
+			return archiveReadError(err)
+		}
+		if err := binary.Write(w, binary.LittleEndian, rec); err != nil {
+			return &WriteError{err}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return &WriteError{err}
+	}
+	if err := fsync(file); err != nil {
+		return &WriteError{err}
+	}
+	return nil
+}
+
+// Restore a chunk from the archive: its data file, which has been reached, and its metadata file, which must come
+// next.
+func (r *restorer) restoreChunk(tr *tar.Reader, hdr *tar.Header) error {
+	name := hdr.Name
+	if hdr.Size > int64(r.chunkSize) {
+		return &FormatError{FilePath: name, Err: &ChunkSizeError{ChunkFilePath: name, Expected: r.chunkSize, Actual: uint32(hdr.Size)}}
+	}
+	c := &chunk{bytes: r.buf}
+	c.oldest, _ = strconv.ParseUint(strings.Split(name, sep)[2], 10, 0)
+	if _, err := io.ReadFull(tr, c.bytes[:hdr.Size]); err != nil {
+		return archiveReadError(err)
+	}
+	for i := int(hdr.Size); i < len(c.bytes); i++ {
+		c.bytes[i] = 0
+	}
+
+	mhdr, err := tr.Next()
+	if err == io.EOF {
+		return ErrArchiveIncomplete
+	} else if err != nil {
+		return archiveReadError(err)
+	}
+	if mhdr.Name != metaFilePath(name) {
+		return &FormatError{FilePath: mhdr.Name, Err: errUnexpectedArchiveFile}
+	}
+	meta := new(bytes.Buffer)
+	if _, err := io.Copy(meta, tr); err != nil {
+		return archiveReadError(err)
+	}
+	m, err := readFullMetadata(meta)
+	if err != nil {
+		return &FormatError{FilePath: mhdr.Name, Err: &ChunkMetaError{ChunkFilePath: name, Err: err}}
+	}
+	c.ends, c.sums, c.labels, c.headers = m.ends, m.sums, m.labels, m.headers
+
+	var used int32
+	if len(c.ends) > 0 {
+		used = c.ends[len(c.ends)-1]
+	}
+	if int64(used) != hdr.Size {
+		return &FormatError{FilePath: mhdr.Name, Err: &ChunkMetaError{ChunkFilePath: name, Err: &MetaOffsetError{Expected: int32(hdr.Size), Actual: used}}}
+	}
+	if limit := *r.chunkEntries; limit > 0 && uint32(len(c.ends)) > limit {
+		return &FormatError{FilePath: mhdr.Name, Err: &ChunkMetaError{ChunkFilePath: name, Err: &MetaContinuityError{Expected: int32(limit), Actual: int32(len(c.ends))}}}
+	}
+	if r.prev != nil {
+		if len(r.prev.ends) == 0 {
+			return &FormatError{FilePath: metaFilePath(r.prev.path), Err: ErrEmptyNonfinalChunk}
+		}
+		if c.oldest != r.prev.next() {
+			return &FormatError{FilePath: name, Err: &ChunkContinuityError{ChunkFilePath: name, Expected: r.prev.next(), Actual: c.oldest}}
+		}
+	}
+	if err := checkChunkSums(name, c); err != nil {
+		return err
+	}
+
+	dir := chunkDir(r.dir, name)
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return &WriteError{err}
+	}
+	c.path = dir + "/" + name
+	if err := writeFile(c.path, c.bytes); err != nil {
+		return &WriteError{err}
+	}
+	if err := writeFile(c.metaFilePath(), encodeMetadata(nil, c.ends, c.sums, c.labels, c.headers, 0)); err != nil {
+		return &WriteError{err}
+	}
+
+	// Now that the previous chunk is known not to be the final one, seal it.
+	if r.prev != nil {
+		if err := r.prev.seal(); err != nil {
+			return &WriteError{err}
+		}
+		r.buf = r.prev.bytes
+	} else {
+		r.buf = make([]byte, r.chunkSize)
+	}
+	r.prev = c
+	r.chunks++
+	return nil
+}
+
+// Check the files which must be present once the archive has been read.
+func (r *restorer) finish() error {
+	if r.buf == nil || r.oldest == nil {
+		return ErrArchiveIncomplete
+	}
+	if r.prev != nil && *r.oldest > r.prev.next() {
+		return &FormatError{FilePath: "oldest", Err: &OldestDivergenceError{Expected: r.prev.next(), Actual: *r.oldest}}
+	}
+	return nil
+}
+
+// Wrap an error reading the archive. An archive which ends part way through a file is incomplete.
+func archiveReadError(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return ErrArchiveIncomplete
+	}
+	return &ReadError{err}
+}
@@ -0,0 +1,95 @@
+package logdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestRestore(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "restore", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	filldb(t, db, numEntries)
+	assertForget(t, db, 20)
+	assertRollback(t, db, 200)
+
+	archive := new(bytes.Buffer)
+	assert.Nil(t, db.BackupTo(archive), "expected no error in backup")
+
+	path := "test_db/restore_copy"
+	_ = os.RemoveAll(path)
+	assert.Nil(t, Restore(bytes.NewReader(archive.Bytes()), path), "expected no error in restore")
+	err := Restore(bytes.NewReader(archive.Bytes()), path)
+	assert.True(t, errors.As(err, new(*PathError)), "expected an existing path to be an error, got: %s", err)
+
+	restored, err := OpenWithOptions(path, WithVerify(VerifyAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, restored)
+	assert.Equal(t, db.OldestID(), restored.OldestID(), "expected the same oldest entry")
+	assert.Equal(t, db.NewestID(), restored.NewestID(), "expected the same newest entry")
+	assert.Equal(t, db.Generation(), restored.Generation(), "expected the same generation")
+	for id := db.OldestID(); id <= db.NewestID(); id++ {
+		assert.Equal(t, assertGet(t, db, id), assertGet(t, restored, id), "expected the same entries")
+	}
+}
+
+func TestRestore_Invalid(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "restore_invalid", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	filldb(t, db, numEntries)
+	archive := new(bytes.Buffer)
+	assert.Nil(t, db.BackupTo(archive), "expected no error in backup")
+	path := "test_db/restore_invalid_copy"
+	_ = os.RemoveAll(path)
+
+	// The archive ends with the end marker (a 512-byte header and a 512-byte block of data) and then the 1024-byte
+	// tar trailer, so the last case cuts off the end marker data.
+	for _, n := range []int{0, 100, archive.Len() / 2, archive.Len() - 1536} {
+		err := Restore(bytes.NewReader(archive.Bytes()[:n]), path)
+		assert.Equal(t, ErrArchiveIncomplete, err, "expected a truncated archive to be incomplete")
+	}
+
+	corrupt := append([]byte(nil), archive.Bytes()...)
+	i := bytes.Index(corrupt, []byte("entry-100"))
+	corrupt[i] = 'E'
+	err := Restore(bytes.NewReader(corrupt), path)
+	assert.True(t, errors.As(err, new(*ChecksumError)), "expected checksum error, got: %s", err)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "expected nothing to be restored")
+	_, err = os.Stat(path + tmpSuffix)
+	assert.True(t, os.IsNotExist(err), "expected the temporary directory to be removed")
+}
+
+func TestRestore_GenerationSize(t *testing.T) {
+	path := "test_db/restore_generation_size"
+	_ = os.RemoveAll(path)
+
+	// An archive claiming a huge generation file is read as far as it goes, rather than allocated up front.
+	archive := new(bytes.Buffer)
+	tw := tar.NewWriter(archive)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "version", Mode: 0644, Size: 2}), "expected no error in header")
+	assert.Nil(t, binary.Write(tw, binary.LittleEndian, latestVersion), "expected no error in write")
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: generationFile, Mode: 0644, Size: 1 << 50}), "expected no error in header")
+	assert.Nil(t, binary.Write(tw, binary.LittleEndian, generationRecord{Generation: 1, Newest: 5}), "expected no error in write")
+	err := Restore(bytes.NewReader(archive.Bytes()), path)
+	assert.Equal(t, ErrArchiveIncomplete, err, "expected a huge generation file to be incomplete")
+
+	archive.Reset()
+	tw = tar.NewWriter(archive)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "version", Mode: 0644, Size: 2}), "expected no error in header")
+	assert.Nil(t, binary.Write(tw, binary.LittleEndian, latestVersion), "expected no error in write")
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: generationFile, Mode: 0644, Size: 20}), "expected no error in header")
+	_, err = tw.Write(make([]byte, 20))
+	assert.Nil(t, err, "expected no error in write")
+	assert.Nil(t, tw.Close(), "expected no error in close")
+	err = Restore(bytes.NewReader(archive.Bytes()), path)
+	assert.True(t, errors.As(err, new(*FormatError)), "expected a partial record to be invalid, got: %s", err)
+}
@@ -0,0 +1,142 @@
+package logdb
+
+import (
+	"errors"
+	"hash/crc32"
+	"os"
+	"syscall"
+)
+
+// The contents of a chunk seal file, recording the state of the chunk files when the chunk was sealed. If the
+// files are changed by anything other than the database, the modification times or sizes will no longer match.
+type sealRecord struct {
+	DataModTime int64
+	MetaModTime int64
+	MetaSize    int64
+
+	// CRC-32 (IEEE) of the used portion of the data file. This is too expensive to check on every open, so it is
+	// only checked by 'Verify'.
+	Checksum uint32
+}
+
+// Seal a chunk: write out a seal file recording the state of the chunk files, and then make them read-only. The
+// chunk must be fully synced.
+//
+// The seal file is written first so that, if the process dies before the permissions are changed, the chunk is
+// still sealed. Permissions are re-applied when a database is opened.
+func (c *chunk) seal() error {
+	if c.sealed {
+		return nil
+	}
+
+	rec, err := c.sealRecord()
+	if err != nil {
+		return err
+	}
+	if err := writeFile(c.sealFilePath(), rec); err != nil {
+		return err
+	}
+	c.sealed = true
+	return c.makeReadOnly()
+}
+
+// Unseal a chunk, so that it can be written to again: make the files writable, remove the seal file, and map
+// the data file writable.
+func (c *chunk) unseal() error {
+	if !c.sealed {
+		return nil
+	}
+
+	if err := os.Chmod(c.path, 0644); err != nil {
+		return err
+	}
+	if err := os.Chmod(c.metaFilePath(), 0644); err != nil {
+		return err
+	}
+	if err := os.Remove(c.sealFilePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Replace the read-only mapping with a writable one, or the read-only file with a writable one if there was
+	// not the address space to map it.
+	if c.bytes != nil {
+		if err := syscall.Munmap(c.bytes); err != nil {
+			return err
+		}
+	}
+	_ = c.mmapf.Close()
+	mmapf, bytes, err := mmap(c.path, true)
+	if mmapf != nil && errors.Is(err, syscall.ENOMEM) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	c.mmapf = mmapf
+	c.bytes = bytes
+	c.sealed = false
+	return nil
+}
+
+// Make the files of a sealed chunk read-only.
+func (c *chunk) makeReadOnly() error {
+	if err := os.Chmod(c.path, 0444); err != nil {
+		return err
+	}
+	return os.Chmod(c.metaFilePath(), 0444)
+}
+
+// Check that the files of a sealed chunk have not been modified since it was sealed. This only compares file
+// modification times and sizes; the checksum is compared too if 'checksum' is true.
+//
+// Returns a 'ChunkModifiedError' value if the files have been modified.
+func (c *chunk) checkSeal(checksum bool) error {
+	if !c.sealed {
+		return nil
+	}
+
+	var sealed sealRecord
+	if err := readFile(c.sealFilePath(), &sealed); err != nil {
+		return &ReadError{err}
+	}
+	actual, err := c.sealRecord()
+	if err != nil {
+		return &ReadError{err}
+	}
+	if !checksum {
+		actual.Checksum = sealed.Checksum
+	}
+	if actual != sealed {
+		return &ChunkModifiedError{ChunkFilePath: c.path}
+	}
+	return nil
+}
+
+// Compute the seal record for the chunk files as they currently are.
+func (c *chunk) sealRecord() (sealRecord, error) {
+	var rec sealRecord
+
+	dfi, err := os.Stat(c.path)
+	if err != nil {
+		return rec, err
+	}
+	mfi, err := os.Stat(c.metaFilePath())
+	if err != nil {
+		return rec, err
+	}
+
+	var end int32
+	if len(c.ends) > 0 {
+		end = c.ends[len(c.ends)-1]
+	}
+
+	rec.DataModTime = dfi.ModTime().UnixNano()
+	rec.MetaModTime = mfi.ModTime().UnixNano()
+	rec.MetaSize = mfi.Size()
+	data, err := c.slice(0, end)
+	if err != nil {
+		return rec, err
+	}
+	rec.Checksum = crc32.ChecksumIEEE(data)
+	return rec, nil
+}
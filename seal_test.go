@@ -0,0 +1,141 @@
+package logdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestSeal_ReadOnly(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "seal_read_only", chunkSize)
+	filldb(t, db, numEntries)
+	defer assertClose(t, db)
+
+	chunks := db.(*LockFreeChunkDB).chunks
+	for i, c := range chunks {
+		final := i == len(chunks)-1
+		assert.Equal(t, !final, c.sealed, "expected all but the final chunk to be sealed")
+		_, err := os.Stat(c.sealFilePath())
+		assert.Equal(t, !final, err == nil, "expected seal files for sealed chunks only")
+		for _, path := range []string{c.path, c.metaFilePath()} {
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, !final, fi.Mode().Perm()&0222 == 0, "expected sealed chunk files to be read-only")
+		}
+	}
+}
+
+func TestSeal_RollbackUnseals(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "seal_rollback_unseals", chunkSize)
+	filldb(t, db, numEntries)
+	assertRollback(t, db, 5)
+
+	c := db.(*LockFreeChunkDB).chunks[0]
+	assert.False(t, c.sealed, "expected rolled-back chunk to be unsealed")
+	_, err := os.Stat(c.sealFilePath())
+	assert.True(t, os.IsNotExist(err), "expected seal file to be removed")
+
+	vs := [][]byte{[]byte("new-6"), []byte("new-7"), []byte("new-8")}
+	assertAppendEntries(t, db, vs)
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "seal_rollback_unseals", chunkSize)
+	defer assertClose(t, db)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+6)), "expected entry written after rollback")
+	}
+}
+
+func TestSeal_NoOpenModified(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "seal_no_open_modified", chunkSize)
+	filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes("test_db/seal_no_open_modified/"+initialChunkFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, errors.As(assertOpenError(t, false, "seal_no_open_modified"), new(*ChunkModifiedError)))
+}
+
+func TestSeal_VerifyChecksum(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "seal_verify_checksum", chunkSize)
+	filldb(t, db, numEntries)
+	defer assertClose(t, db)
+
+	// Change the data without changing the modification time, which is only caught by the checksum.
+	path := "test_db/seal_verify_checksum/" + initialChunkFile
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unprotect(t, path)
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, errors.As(db.(verifier).Verify(), new(*ChunkModifiedError)))
+}
+
+func TestSeal_OpenVerifyLevels(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "seal_open_verify_levels", chunkSize)
+	filldb(t, db, numEntries)
+	assertClose(t, db)
+
+	// Change the data without changing the modification time, which only 'VerifyAll' catches.
+	path := "test_db/seal_open_verify_levels/" + initialChunkFile
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unprotect(t, path)
+	if err := os.WriteFile(path, make([]byte, chunkSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenWithOptions("test_db/seal_open_verify_levels", WithVerify(VerifyAll))
+	assert.True(t, errors.As(err, new(*ChunkModifiedError)), "expected modified chunk error, got: %s", err)
+
+	db, err = OpenWithOptions("test_db/seal_open_verify_levels")
+	assert.Nil(t, err, "expected modification time check to pass")
+	assertClose(t, db)
+
+	// Now change the modification time, which only 'VerifyNone' ignores.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenWithOptions("test_db/seal_open_verify_levels")
+	assert.True(t, errors.As(err, new(*ChunkModifiedError)), "expected modified chunk error, got: %s", err)
+
+	db, err = OpenWithOptions("test_db/seal_open_verify_levels", WithVerify(VerifyNone))
+	assert.Nil(t, err, "expected no verification")
+	assertClose(t, db)
+}
+
+////////// HELPERS //////////
+
+// Make a sealed chunk file writable again, so that a test can tamper with it.
+func unprotect(t *testing.T, path string) {
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
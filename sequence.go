@@ -0,0 +1,239 @@
+package logdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrSeqNotFound means that no entry has the requested external sequence number.
+	ErrSeqNotFound = errors.New("external sequence number not found")
+
+	// ErrDuplicateSeq means that an external sequence number given to 'AppendSeq' or 'AppendSeqEntries' is
+	// already in use.
+	ErrDuplicateSeq = errors.New("external sequence number already in use")
+
+	// ErrNoSeq means that an entry in the underlying database of a 'SequencedDB' has a header which is not an
+	// external sequence number, and so was not appended through the 'SequencedDB'.
+	ErrNoSeq = errors.New("entry has no external sequence number")
+
+	// ErrSeqMismatch means that 'AppendSeqEntries' was called with different numbers of sequence numbers and
+	// entries.
+	ErrSeqMismatch = errors.New("number of sequence numbers and entries differ")
+)
+
+// A HeaderDB is a 'LogDB' which keeps a header with each entry, which can be read without reading the entry
+// (see 'LockFreeChunkDB.AppendWithHeader'). 'ChunkDB' and 'LockFreeChunkDB' are 'HeaderDB's.
+type HeaderDB interface {
+	LogDB
+
+	AppendEntriesWithHeaders(headers, entries [][]byte) (uint64, error)
+	GetWithMeta(id uint64) ([]byte, EntryMeta, error)
+	ScanMeta(from, to uint64, fn func(id uint64, meta EntryMeta) error) error
+}
+
+// A SequencedDB wraps a 'HeaderDB' to store a caller-provided 64-bit external sequence number (such as a Kafka
+// offset or a database LSN) alongside every entry, and to look entries up by it. This is for systems bridging
+// another log, which need to map positions in both directions.
+//
+// The sequence number is stored as the 8-byte header of the entry in the underlying 'HeaderDB', so entries are
+// stored unchanged. The index from sequence numbers to entry IDs is kept in memory, and is rebuilt by
+// 'Sequenced' when the database is opened, from the chunk metadata alone. Entries must not be appended to the
+// underlying 'HeaderDB' with other headers.
+//
+// Entries appended with 'Append' or 'AppendEntries' have the sequence number 0, which is not indexed, and are
+// stored without a header.
+type SequencedDB struct {
+	HeaderDB
+
+	rwlock sync.RWMutex
+
+	// The index, from external sequence number to entry ID.
+	index map[uint64]uint64
+
+	// Indexed entry IDs in increasing order, with their external sequence numbers. These are used to remove
+	// entries from the index when they are forgotten or rolled back.
+	ids  []uint64
+	seqs []uint64
+}
+
+// Sequenced creates a 'SequencedDB', reading the header of every entry of the underlying 'HeaderDB' to build the
+// index. The entries themselves are not read.
+//
+// Returns 'ErrNoSeq' if an entry has a header which is not a sequence number, and the same errors as
+// 'ScanMeta'.
+func Sequenced(logdb HeaderDB) (*SequencedDB, error) {
+	db := &SequencedDB{HeaderDB: logdb, index: make(map[uint64]uint64)}
+
+	oldest := logdb.OldestID()
+	if oldest == 0 {
+		return db, nil
+	}
+	err := logdb.ScanMeta(oldest, logdb.NewestID(), func(id uint64, meta EntryMeta) error {
+		seq, err := decodeSeq(meta.Header)
+		if err != nil {
+			return err
+		}
+		db.insert(id, seq)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Append implements the 'LogDB' interface, with the external sequence number 0.
+func (db *SequencedDB) Append(entry []byte) (uint64, error) {
+	return db.AppendSeqEntries([]uint64{0}, [][]byte{entry})
+}
+
+// AppendEntries implements the 'LogDB' interface, with the external sequence number 0 for every entry.
+func (db *SequencedDB) AppendEntries(entries [][]byte) (uint64, error) {
+	return db.AppendSeqEntries(make([]uint64, len(entries)), entries)
+}
+
+// AppendSeq appends an entry with an external sequence number.
+//
+// Returns 'ErrDuplicateSeq' if the sequence number is not 0 and is already in use, and the same errors as
+// 'Append'.
+func (db *SequencedDB) AppendSeq(seq uint64, entry []byte) (uint64, error) {
+	return db.AppendSeqEntries([]uint64{seq}, [][]byte{entry})
+}
+
+// AppendSeqEntries atomically appends a collection of entries with their external sequence numbers, returning
+// the ID of the first.
+//
+// Returns 'ErrSeqMismatch' if the slices have different lengths, 'ErrDuplicateSeq' if any sequence number is
+// not 0 and is already in use (or is given twice), and the same errors as 'AppendEntries'.
+func (db *SequencedDB) AppendSeqEntries(seqs []uint64, entries [][]byte) (uint64, error) {
+	if len(seqs) != len(entries) {
+		return 0, ErrSeqMismatch
+	}
+
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	given := make(map[uint64]bool, len(seqs))
+	headers := make([][]byte, len(entries))
+	for i, seq := range seqs {
+		if seq == 0 {
+			continue
+		}
+		if _, ok := db.index[seq]; ok || given[seq] {
+			return 0, ErrDuplicateSeq
+		}
+		given[seq] = true
+		headers[i] = binary.LittleEndian.AppendUint64(nil, seq)
+	}
+
+	first, err := db.HeaderDB.AppendEntriesWithHeaders(headers, entries)
+	if err != nil {
+		return 0, err
+	}
+	for i, seq := range seqs {
+		db.insert(first+uint64(i), seq)
+	}
+	return first, nil
+}
+
+// ExternalSeq gets the external sequence number of an entry, which is 0 if it was appended without one.
+//
+// Returns 'ErrNoSeq' if the entry has a header which is not a sequence number, and the same errors as 'Get'.
+func (db *SequencedDB) ExternalSeq(id uint64) (uint64, error) {
+	_, meta, err := db.HeaderDB.GetWithMeta(id)
+	if err != nil {
+		return 0, err
+	}
+	return decodeSeq(meta.Header)
+}
+
+// FindByExternalSeq gets the ID of the entry with an external sequence number.
+//
+// Returns 'ErrSeqNotFound' if there is no such entry.
+func (db *SequencedDB) FindByExternalSeq(seq uint64) (uint64, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	id, ok := db.index[seq]
+	if !ok || seq == 0 {
+		return 0, ErrSeqNotFound
+	}
+	return id, nil
+}
+
+// Forget implements the 'LogDB' interface.
+func (db *SequencedDB) Forget(newOldestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	defer db.prune()
+	return db.HeaderDB.Forget(newOldestID)
+}
+
+// Rollback implements the 'LogDB' interface.
+func (db *SequencedDB) Rollback(newNewestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	defer db.prune()
+	return db.HeaderDB.Rollback(newNewestID)
+}
+
+// Truncate implements the 'LogDB' interface.
+func (db *SequencedDB) Truncate(newOldestID, newNewestID uint64) error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	defer db.prune()
+	return db.HeaderDB.Truncate(newOldestID, newNewestID)
+}
+
+// StoredSize implements the 'SizedDB' interface. The sequence number is kept in the chunk metadata, so is not
+// included.
+func (db *SequencedDB) StoredSize(id uint64) (uint64, error) {
+	return storedSize(db.HeaderDB, id)
+}
+
+////////// HELPERS //////////
+
+// Add an entry to the index, if it has a sequence number. Assumes the write lock is held.
+func (db *SequencedDB) insert(id, seq uint64) {
+	if seq == 0 {
+		return
+	}
+	db.index[seq] = id
+	db.ids = append(db.ids, id)
+	db.seqs = append(db.seqs, seq)
+}
+
+// Remove entries which are no longer in the log from the index. Assumes the write lock is held.
+func (db *SequencedDB) prune() {
+	oldest, newest := db.HeaderDB.OldestID(), db.HeaderDB.NewestID()
+
+	from := 0
+	for from < len(db.ids) && db.ids[from] < oldest {
+		delete(db.index, db.seqs[from])
+		from++
+	}
+	to := len(db.ids)
+	for to > from && db.ids[to-1] > newest {
+		delete(db.index, db.seqs[to-1])
+		to--
+	}
+	db.ids = db.ids[from:to]
+	db.seqs = db.seqs[from:to]
+}
+
+// Decode the external sequence number in an entry header, which is 0 if there is no header.
+func decodeSeq(header []byte) (uint64, error) {
+	switch len(header) {
+	case 0:
+		return 0, nil
+	case 8:
+		return binary.LittleEndian.Uint64(header), nil
+	default:
+		return 0, ErrNoSeq
+	}
+}
@@ -0,0 +1,57 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestSequenced(t *testing.T) {
+	chunkdb := assertOpen(t, dbTypes["chunkdb"], true, "sequenced", chunkSize).(*ChunkDB)
+	db, err := Sequenced(chunkdb)
+	assert.Nil(t, err, "expected no error in wrapping")
+
+	first, err := db.AppendSeqEntries([]uint64{100, 200, 300}, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	assert.Nil(t, err, "expected no error in append")
+	_, err = db.Append([]byte("d"))
+	assert.Nil(t, err, "expected no error in append")
+
+	v, err := db.Get(first + 1)
+	assert.Nil(t, err, "expected no error in get")
+	assert.Equal(t, []byte("b"), v, "expected entry to be stored unchanged")
+	seq, err := db.ExternalSeq(first + 1)
+	assert.Nil(t, err, "expected no error in getting sequence number")
+	assert.Equal(t, uint64(200), seq, "expected sequence number")
+	id, err := db.FindByExternalSeq(300)
+	assert.Nil(t, err, "expected no error in lookup")
+	assert.Equal(t, first+2, id, "expected ID of entry")
+	_, err = db.FindByExternalSeq(0)
+	assert.Equal(t, ErrSeqNotFound, err, "expected entries without a sequence number not to be indexed")
+
+	_, err = db.AppendSeq(200, []byte("e"))
+	assert.Equal(t, ErrDuplicateSeq, err, "expected duplicate to be rejected")
+	_, err = db.AppendSeqEntries([]uint64{1}, nil)
+	assert.Equal(t, ErrSeqMismatch, err, "expected mismatched lengths to be rejected")
+
+	// Removed entries leave the index, and the index is rebuilt from the underlying database when it is reopened.
+	assert.Nil(t, db.Truncate(first+1, first+1), "expected no error in truncate")
+	assertClose(t, chunkdb)
+	chunkdb = assertOpen(t, dbTypes["chunkdb"], false, "sequenced", chunkSize).(*ChunkDB)
+	defer assertClose(t, chunkdb)
+	db, err = Sequenced(chunkdb)
+	assert.Nil(t, err, "expected no error in rewrapping")
+	for _, seq := range []uint64{100, 300} {
+		_, err = db.FindByExternalSeq(seq)
+		assert.Equal(t, ErrSeqNotFound, err, "expected removed entry not to be found")
+	}
+	id, err = db.FindByExternalSeq(200)
+	assert.Nil(t, err, "expected no error in lookup")
+	assert.Equal(t, first+1, id, "expected ID of entry")
+	_, err = db.AppendSeq(300, []byte("c"))
+	assert.Nil(t, err, "expected sequence number of removed entry to be reusable")
+
+	_, err = chunkdb.AppendWithHeader([]byte("other"), []byte("f"))
+	assert.Nil(t, err, "expected no error in append")
+	_, err = Sequenced(chunkdb)
+	assert.Equal(t, ErrNoSeq, err, "expected a header which is not a sequence number to be rejected")
+}
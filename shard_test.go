@@ -0,0 +1,33 @@
+package logdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestShard_Reopen(t *testing.T) {
+	defer func(n uint64) { chunksPerDir = n }(chunksPerDir)
+	chunksPerDir = 2
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "shard_reopen", chunkSize).(*LockFreeChunkDB)
+	vs := filldb(t, db, numEntries)
+	assert.True(t, len(db.chunks) > 4, "expected enough chunks to need several shards")
+	_, err := os.Stat("test_db/shard_reopen/shard1")
+	assert.Nil(t, err, "expected a shard directory")
+	assertClose(t, db)
+
+	db = assertOpen(t, dbTypes["lock free chunkdb"], false, "shard_reopen", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after reopening")
+	}
+	assert.Nil(t, db.Verify(), "expected no problems")
+
+	// Forgetting everything but the final chunk removes the emptied shard directories.
+	assertForget(t, db, db.chunks[len(db.chunks)-1].oldest)
+	assertSync(t, db)
+	_, err = os.Stat("test_db/shard_reopen/shard1")
+	assert.True(t, os.IsNotExist(err), "expected the shard directory to be removed, got: %s", err)
+}
@@ -2,6 +2,7 @@ package logdb
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -22,7 +23,8 @@ func (fis fileInfoSlice) Less(i, j int) bool {
 	return lessFileName(fis[i].Name(), fis[j].Name())
 }
 
-// Lexicographic sorting by data file path.
+// Lexicographic sorting by data file name. The directory is ignored, as chunks may be in different shard
+// directories.
 type chunkSlice []*chunk
 
 func (cs chunkSlice) Len() int {
@@ -34,7 +36,7 @@ func (cs chunkSlice) Swap(i, j int) {
 }
 
 func (cs chunkSlice) Less(i, j int) bool {
-	return lessFileName(cs[i].path, cs[j].path)
+	return lessFileName(filepath.Base(cs[i].path), filepath.Base(cs[j].path))
 }
 
 // Compare two filenames with splitting.
@@ -0,0 +1,134 @@
+package logdb
+
+import "fmt"
+
+// SoftLimits are thresholds which, unlike hard limits, never cause an operation to fail: crossing one only
+// calls a function, so that a warning can be raised well before the database runs out of space or memory. A
+// zero threshold is not checked.
+type SoftLimits struct {
+	// The bytes of entries in the chunk files, including forgotten entries in chunks not yet deleted.
+	Bytes uint64
+
+	// The number of entries, from the oldest to the newest.
+	Entries uint64
+
+	// The number of chunk files, including those awaiting deletion.
+	Chunks uint64
+
+	// The number of changes (entries appended, forgotten, or rolled back) since the last sync.
+	Unsynced uint64
+
+	// Called when a threshold is exceeded, and again when the value drops back to or below it. Like the
+	// 'ChunkHooks', this is called with any database locks held, so it must not use the database.
+	Warning func(SoftLimitEvent)
+}
+
+// A SoftLimit identifies one of the thresholds of 'SoftLimits'.
+type SoftLimit int
+
+// The thresholds of 'SoftLimits'.
+const (
+	SoftLimitBytes SoftLimit = iota
+	SoftLimitEntries
+	SoftLimitChunks
+	SoftLimitUnsynced
+)
+
+func (l SoftLimit) String() string {
+	switch l {
+	case SoftLimitBytes:
+		return "bytes"
+	case SoftLimitEntries:
+		return "entries"
+	case SoftLimitChunks:
+		return "chunks"
+	case SoftLimitUnsynced:
+		return "unsynced"
+	}
+	return fmt.Sprintf("SoftLimit(%d)", int(l))
+}
+
+// SoftLimitEvent describes a threshold of 'SoftLimits' being crossed.
+type SoftLimitEvent struct {
+	// The threshold crossed.
+	Limit     SoftLimit
+	Threshold uint64
+
+	// The value now, and whether it is over the threshold (or has dropped back to or below it).
+	Value    uint64
+	Exceeded bool
+}
+
+// SetSoftLimits is the thread-safe version of 'LockFreeChunkDB.SetSoftLimits'.
+func (db *ChunkDB) SetSoftLimits(limits SoftLimits) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetSoftLimits(limits)
+}
+
+// SetSoftLimits configures thresholds to warn about. They are checked after every successful change
+// ('Append', 'Forget', 'Rollback', 'Truncate', 'Sync', and their variants), and immediately, so that a
+// database which is already over a threshold is reported. Each time a threshold is exceeded, and each time the
+// value drops back, the warning function is called and, if a logger is set, a message logged: a value which
+// stays over a threshold is only reported once.
+func (db *LockFreeChunkDB) SetSoftLimits(limits SoftLimits) {
+	db.softLimits = limits
+	db.softExceeded = [numSoftLimits]bool{}
+	db.checkSoftLimits()
+}
+
+////////// HELPERS //////////
+
+// The number of thresholds of 'SoftLimits'.
+const numSoftLimits = 4
+
+// Check if any threshold is set.
+func (l SoftLimits) enabled() bool {
+	return l.Bytes > 0 || l.Entries > 0 || l.Chunks > 0 || l.Unsynced > 0
+}
+
+// Check the soft limits, reporting any which have been crossed since the last check. Assumes a lock (read or
+// write) and the sync lock are held.
+func (db *LockFreeChunkDB) checkSoftLimits() {
+	l := db.softLimits
+	if !l.enabled() {
+		return
+	}
+
+	var bytes, chunks, entries uint64
+	for _, c := range db.chunks {
+		if c.removed {
+			continue
+		}
+		chunks++
+		if len(c.ends) > 0 {
+			bytes += uint64(c.ends[len(c.ends)-1])
+		}
+	}
+	if len(db.chunks) > 0 {
+		entries = db.next() - db.oldest
+	}
+
+	thresholds := [numSoftLimits]uint64{l.Bytes, l.Entries, l.Chunks, l.Unsynced}
+	values := [numSoftLimits]uint64{bytes, entries, chunks, db.sinceLastSync}
+	for i, threshold := range thresholds {
+		exceeded := threshold > 0 && values[i] > threshold
+		if exceeded == db.softExceeded[i] {
+			continue
+		}
+		db.softExceeded[i] = exceeded
+
+		ev := SoftLimitEvent{Limit: SoftLimit(i), Threshold: threshold, Value: values[i], Exceeded: exceeded}
+		if db.logger != nil {
+			if exceeded {
+				db.logger.Printf("logdb: %v %v over soft limit of %v", ev.Value, ev.Limit, ev.Threshold)
+			} else {
+				db.logger.Printf("logdb: %v %v back within soft limit of %v", ev.Value, ev.Limit, ev.Threshold)
+			}
+		}
+		if l.Warning != nil {
+			l.Warning(ev)
+		}
+	}
+}
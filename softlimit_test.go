@@ -0,0 +1,58 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestSoftLimits_Entries(t *testing.T) {
+	var events []SoftLimitEvent
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "soft_limits_entries", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	db.SetSoftLimits(SoftLimits{Entries: 200, Warning: func(ev SoftLimitEvent) { events = append(events, ev) }})
+
+	filldb(t, db, numEntries)
+	assert.Equal(t, 1, len(events), "expected one event")
+	assert.Equal(t, SoftLimitEvent{Limit: SoftLimitEntries, Threshold: 200, Value: numEntries, Exceeded: true}, events[0], "expected entries exceeded")
+
+	assertAppend(t, db, []byte("still over"))
+	assert.Equal(t, 1, len(events), "expected no event while still over the threshold")
+
+	assertForget(t, db, 100)
+	assert.Equal(t, 2, len(events), "expected another event")
+	assert.Equal(t, SoftLimitEvent{Limit: SoftLimitEntries, Threshold: 200, Value: 157, Exceeded: false}, events[1], "expected entries back within")
+}
+
+func TestSoftLimits_Unsynced(t *testing.T) {
+	var events []SoftLimitEvent
+	db := assertOpen(t, dbTypes["chunkdb"], true, "soft_limits_unsynced", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assert.Nil(t, db.SetSync(-1), "expected no error in set sync")
+	db.SetSoftLimits(SoftLimits{Unsynced: 10, Warning: func(ev SoftLimitEvent) { events = append(events, ev) }})
+
+	for i := 0; i < 20; i++ {
+		assertAppend(t, db, []byte("entry"))
+	}
+	assert.Equal(t, 1, len(events), "expected one event")
+	assert.True(t, events[0].Exceeded, "expected unsynced exceeded")
+	assert.Equal(t, SoftLimitUnsynced, events[0].Limit, "expected unsynced limit")
+
+	assertSync(t, db)
+	assert.Equal(t, 2, len(events), "expected another event")
+	assert.False(t, events[1].Exceeded, "expected unsynced back within")
+	assert.Equal(t, uint64(0), events[1].Value, "expected nothing unsynced")
+}
+
+func TestSoftLimits_Immediate(t *testing.T) {
+	var events []SoftLimitEvent
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "soft_limits_immediate", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	filldb(t, db, numEntries)
+
+	db.SetSoftLimits(SoftLimits{Bytes: 100, Chunks: 2, Warning: func(ev SoftLimitEvent) { events = append(events, ev) }})
+	assert.Equal(t, 2, len(events), "expected two events")
+	assert.Equal(t, SoftLimitBytes, events[0].Limit, "expected bytes limit")
+	assert.Equal(t, SoftLimitChunks, events[1].Limit, "expected chunks limit")
+	assert.Equal(t, uint64(len(db.chunks)), events[1].Value, "expected number of chunks")
+}
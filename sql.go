@@ -0,0 +1,621 @@
+package logdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLOptions configure 'SQLConnector'.
+type SQLOptions struct {
+	// Timestamp gets the time of an entry, for the 'timestamp' column, such as by decoding it from the header.
+	// If nil, or if it returns the zero time, the column is NULL.
+	Timestamp func(id uint64, meta EntryMeta, entry []byte) time.Time
+}
+
+// SQLConnector makes a database queryable with SQL, for ad-hoc inspection, as a read-only table called
+// 'entries'. Pass the connector to 'sql.OpenDB' from 'database/sql':
+//
+//	sdb := sql.OpenDB(logdb.SQLConnector(db, logdb.SQLOptions{}))
+//	rows, err := sdb.Query("SELECT id, payload FROM entries WHERE id >= ? ORDER BY id DESC LIMIT 10", from)
+//
+// The table has the columns 'id', 'timestamp' (see 'SQLOptions.Timestamp'), 'metadata' (the header of the
+// entry, see 'AppendWithHeader', or NULL if it has none or the database does not keep headers), and
+// 'payload'. There is no SQL engine behind it, so it understands only queries of the form:
+//
+//	SELECT columns FROM entries [WHERE condition [AND condition]...] [ORDER BY id [ASC|DESC]] [LIMIT n]
+//
+// where 'columns' is '*', 'COUNT(*)', or a list of column names, and each condition compares 'id' or
+// 'timestamp' with a value using one of '=', '!=', '<>', '<', '<=', '>', or '>='. A value is a '?'
+// placeholder, an integer (for 'id'), or a quoted RFC 3339 time (for 'timestamp'). Keywords are not case
+// sensitive. Conditions on 'id' narrow the range of entries read, so they are cheap; conditions on 'timestamp'
+// are checked against every entry in the range. Entries which have been dropped by 'Downsample' are left out.
+//
+// Entries are read one at a time as the rows are, so the database must be safe for concurrent use if anything
+// else uses it at the same time. Statements which are not queries, and transactions, return 'ErrSQLReadOnly';
+// queries which the table does not understand, or which are given arguments of the wrong type, return a
+// 'SQLError' value.
+func SQLConnector(db ReadOnlyDB, opts SQLOptions) driver.Connector {
+	return &sqlConnector{db: db, opts: opts}
+}
+
+////////// HELPERS //////////
+
+// The columns of the table, in the order of 'SELECT *'.
+const (
+	sqlID = iota
+	sqlTimestamp
+	sqlMetadata
+	sqlPayload
+)
+
+var sqlColumns = []string{"id", "timestamp", "metadata", "payload"}
+
+// A database which can read the metadata of an entry along with it.
+type sqlMetaDB interface {
+	GetWithMeta(id uint64) ([]byte, EntryMeta, error)
+}
+
+// Opens connections to the table. Connections share the database, and have no state of their own.
+type sqlConnector struct {
+	db   ReadOnlyDB
+	opts SQLOptions
+}
+
+func (c *sqlConnector) Connect(context.Context) (driver.Conn, error) {
+	return &sqlConn{c}, nil
+}
+
+func (c *sqlConnector) Driver() driver.Driver {
+	return sqlDriver{}
+}
+
+// The driver of a connector. Tables can only be opened with 'SQLConnector', so this has no names to open.
+type sqlDriver struct{}
+
+func (sqlDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("logdb: open an SQL table with 'SQLConnector'")
+}
+
+type sqlConn struct{ *sqlConnector }
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	q, err := parseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{conn: c, text: query, query: q}, nil
+}
+
+func (c *sqlConn) Close() error {
+	return nil
+}
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return nil, ErrSQLReadOnly
+}
+
+type sqlStmt struct {
+	conn  *sqlConn
+	text  string
+	query *sqlQuery
+}
+
+func (s *sqlStmt) Close() error {
+	return nil
+}
+
+func (s *sqlStmt) NumInput() int {
+	return s.query.inputs
+}
+
+func (s *sqlStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, ErrSQLReadOnly
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	q := s.query
+	rows := &sqlRows{conn: s.conn, query: q, lo: s.conn.db.OldestID(), hi: s.conn.db.NewestID()}
+	if rows.lo == 0 {
+		rows.lo = 1
+	}
+	for _, cond := range q.conds {
+		value := cond.value
+		if cond.arg >= 0 {
+			var err error
+			if value, err = sqlArg(cond.column, args[cond.arg]); err != nil {
+				return nil, &SQLError{Query: s.text, Msg: fmt.Sprintf("argument %v: %s", cond.arg+1, err.Error())}
+			}
+		}
+		if cond.column == sqlID {
+			rows.narrow(cond.op, value.(uint64))
+		}
+		rows.conds = append(rows.conds, sqlCond{column: cond.column, op: cond.op, value: value, arg: -1})
+	}
+	rows.next = rows.lo
+	if q.desc {
+		rows.next = rows.hi
+	}
+	return rows, nil
+}
+
+// Rows are read from the database as they are needed.
+type sqlRows struct {
+	conn  *sqlConn
+	query *sqlQuery
+
+	// The conditions, with their arguments filled in.
+	conds []sqlCond
+
+	// The range of IDs which the conditions on 'id' allow, and the next ID to read.
+	lo, hi, next uint64
+
+	returned int64
+	done     bool
+}
+
+func (r *sqlRows) Columns() []string {
+	if r.query.count {
+		return []string{"COUNT(*)"}
+	}
+	cols := make([]string, len(r.query.columns))
+	for i, col := range r.query.columns {
+		cols[i] = sqlColumns[col]
+	}
+	return cols
+}
+
+func (r *sqlRows) Close() error {
+	r.done = true
+	return nil
+}
+
+func (r *sqlRows) Next(dest []driver.Value) error {
+	if r.done || (r.query.limit >= 0 && r.returned >= r.query.limit) {
+		return io.EOF
+	}
+	if r.query.count {
+		var n int64
+		for {
+			_, ok, err := r.row()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			n++
+		}
+		dest[0] = n
+		r.done = true
+		return nil
+	}
+
+	values, ok, err := r.row()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		r.done = true
+		return io.EOF
+	}
+	for i, col := range r.query.columns {
+		dest[i] = values[col]
+	}
+	r.returned++
+	return nil
+}
+
+// Narrow the range of IDs to those which satisfy a condition on 'id'.
+func (r *sqlRows) narrow(op string, id uint64) {
+	switch op {
+	case "=":
+		if id > r.lo {
+			r.lo = id
+		}
+		if id < r.hi {
+			r.hi = id
+		}
+	case "<":
+		if id == 0 {
+			r.lo, r.hi = 1, 0
+		} else if id-1 < r.hi {
+			r.hi = id - 1
+		}
+	case "<=":
+		if id < r.hi {
+			r.hi = id
+		}
+	case ">":
+		if id+1 > r.lo {
+			r.lo = id + 1
+		}
+	case ">=":
+		if id > r.lo {
+			r.lo = id
+		}
+	}
+}
+
+// Read the next entry in the range which satisfies the conditions, returning the values of every column, or
+// false if there are none left.
+func (r *sqlRows) row() ([]driver.Value, bool, error) {
+	for r.next >= r.lo && r.next <= r.hi && r.next != 0 {
+		id := r.next
+		if r.query.desc {
+			r.next--
+		} else {
+			r.next++
+		}
+
+		var entry []byte
+		var meta EntryMeta
+		var err error
+		if mdb, ok := r.conn.db.(sqlMetaDB); ok {
+			entry, meta, err = mdb.GetWithMeta(id)
+		} else {
+			entry, err = r.conn.db.Get(id)
+		}
+		if err == ErrIDOutOfRange || err == ErrDownsampled {
+			// Forgotten or rolled back since the query started, or dropped.
+			continue
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		values := make([]driver.Value, len(sqlColumns))
+		values[sqlID] = int64(id)
+		if r.conn.opts.Timestamp != nil {
+			if ts := r.conn.opts.Timestamp(id, meta, entry); !ts.IsZero() {
+				values[sqlTimestamp] = ts
+			}
+		}
+		if meta.Header != nil {
+			values[sqlMetadata] = meta.Header
+		}
+		values[sqlPayload] = entry
+
+		if r.matches(id, values[sqlTimestamp]) {
+			return values, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Check if an entry satisfies the conditions. A condition on a NULL timestamp is never satisfied.
+func (r *sqlRows) matches(id uint64, ts driver.Value) bool {
+	for _, cond := range r.conds {
+		var cmp int
+		if cond.column == sqlID {
+			want := cond.value.(uint64)
+			switch {
+			case id < want:
+				cmp = -1
+			case id > want:
+				cmp = 1
+			}
+		} else {
+			t, ok := ts.(time.Time)
+			if !ok {
+				return false
+			}
+			want := cond.value.(time.Time)
+			switch {
+			case t.Before(want):
+				cmp = -1
+			case t.After(want):
+				cmp = 1
+			}
+		}
+		var ok bool
+		switch cond.op {
+		case "=":
+			ok = cmp == 0
+		case "!=", "<>":
+			ok = cmp != 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert an argument to the type of the column it is compared with.
+func sqlArg(column int, arg driver.Value) (interface{}, error) {
+	if column == sqlID {
+		if n, ok := arg.(int64); ok && n >= 0 {
+			return uint64(n), nil
+		}
+		return nil, errors.New("id must be a non-negative integer")
+	}
+	switch v := arg.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	}
+	return nil, errors.New("timestamp must be a time")
+}
+
+// A parsed query.
+type sqlQuery struct {
+	// The columns to return, unless the query is 'COUNT(*)'.
+	columns []int
+	count   bool
+
+	conds []sqlCond
+	desc  bool
+
+	// The maximum number of rows to return, or -1 if there is no limit.
+	limit int64
+
+	// The number of placeholders.
+	inputs int
+}
+
+// A condition of a query: a comparison of a column with a value, or with the argument of a placeholder if 'arg'
+// is not -1.
+type sqlCond struct {
+	column int
+	op     string
+	value  interface{}
+	arg    int
+}
+
+// Parse a query.
+func parseSQL(query string) (*sqlQuery, error) {
+	p := &sqlParser{query: query, toks: lexSQL(query)}
+	q, err := p.parse()
+	if err != nil {
+		return nil, &SQLError{Query: query, Msg: err.Error()}
+	}
+	return q, nil
+}
+
+type sqlParser struct {
+	query string
+	toks  []string
+	pos   int
+}
+
+func (p *sqlParser) parse() (*sqlQuery, error) {
+	q := &sqlQuery{limit: -1}
+	if err := p.keyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.peek() == "*":
+		p.pos++
+		q.columns = []int{sqlID, sqlTimestamp, sqlMetadata, sqlPayload}
+	case strings.EqualFold(p.peek(), "COUNT"):
+		p.pos++
+		for _, tok := range []string{"(", "*", ")"} {
+			if err := p.expect(tok); err != nil {
+				return nil, err
+			}
+		}
+		q.count = true
+	default:
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			q.columns = append(q.columns, col)
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+	}
+
+	if err := p.keyword("FROM"); err != nil {
+		return nil, err
+	}
+	if err := p.keyword("entries"); err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(p.peek(), "WHERE") {
+		p.pos++
+		for {
+			cond, err := p.condition(q)
+			if err != nil {
+				return nil, err
+			}
+			q.conds = append(q.conds, cond)
+			if !strings.EqualFold(p.peek(), "AND") {
+				break
+			}
+			p.pos++
+		}
+	}
+
+	if strings.EqualFold(p.peek(), "ORDER") {
+		p.pos++
+		if err := p.keyword("BY"); err != nil {
+			return nil, err
+		}
+		if err := p.keyword("id"); err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.EqualFold(p.peek(), "ASC"):
+			p.pos++
+		case strings.EqualFold(p.peek(), "DESC"):
+			p.pos++
+			q.desc = true
+		}
+	}
+
+	if strings.EqualFold(p.peek(), "LIMIT") {
+		p.pos++
+		n, err := strconv.ParseInt(p.peek(), 10, 64)
+		if err != nil || n < 0 {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		q.limit = n
+	}
+
+	if p.peek() == ";" {
+		p.pos++
+	}
+	if p.pos < len(p.toks) {
+		return nil, p.unexpected()
+	}
+	return q, nil
+}
+
+// Parse a condition: a column, a comparison, and a value.
+func (p *sqlParser) condition(q *sqlQuery) (sqlCond, error) {
+	cond := sqlCond{arg: -1}
+	col, err := p.column()
+	if err != nil {
+		return cond, err
+	}
+	if col != sqlID && col != sqlTimestamp {
+		p.pos--
+		return cond, p.unexpected()
+	}
+	cond.column = col
+
+	switch op := p.peek(); op {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		cond.op = op
+		p.pos++
+	default:
+		return cond, p.unexpected()
+	}
+
+	tok := p.peek()
+	switch {
+	case tok == "?":
+		cond.arg = q.inputs
+		q.inputs++
+	case col == sqlID:
+		id, err := strconv.ParseUint(tok, 10, 64)
+		if err != nil {
+			return cond, p.unexpected()
+		}
+		cond.value = id
+	case len(tok) >= 2 && tok[0] == '\'' && tok[len(tok)-1] == '\'':
+		ts, err := time.Parse(time.RFC3339Nano, tok[1:len(tok)-1])
+		if err != nil {
+			return cond, p.unexpected()
+		}
+		cond.value = ts
+	default:
+		return cond, p.unexpected()
+	}
+	p.pos++
+	return cond, nil
+}
+
+// Parse a column name.
+func (p *sqlParser) column() (int, error) {
+	for i, name := range sqlColumns {
+		if strings.EqualFold(p.peek(), name) {
+			p.pos++
+			return i, nil
+		}
+	}
+	return 0, p.unexpected()
+}
+
+// Parse a keyword, which is not case sensitive.
+func (p *sqlParser) keyword(kw string) error {
+	if !strings.EqualFold(p.peek(), kw) {
+		return p.unexpected()
+	}
+	p.pos++
+	return nil
+}
+
+// Parse a symbol.
+func (p *sqlParser) expect(tok string) error {
+	if p.peek() != tok {
+		return p.unexpected()
+	}
+	p.pos++
+	return nil
+}
+
+// Get the next token, or "" at the end of the query.
+func (p *sqlParser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+// The error for an unexpected token.
+func (p *sqlParser) unexpected() error {
+	if p.pos >= len(p.toks) {
+		return errors.New("unexpected end of query")
+	}
+	return fmt.Errorf("unexpected %q", p.toks[p.pos])
+}
+
+// Split a query into tokens: words and numbers, quoted strings (with their quotes), and symbols.
+func lexSQL(query string) []string {
+	var toks []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			j := strings.IndexByte(query[i+1:], '\'')
+			if j < 0 {
+				toks = append(toks, query[i:])
+				return toks
+			}
+			toks = append(toks, query[i:i+j+2])
+			i += j + 2
+		case isSQLWordByte(c):
+			j := i + 1
+			for j < len(query) && isSQLWordByte(query[j]) {
+				j++
+			}
+			toks = append(toks, query[i:j])
+			i = j
+		case i+1 < len(query) && isSQLOperator(query[i:i+2]):
+			toks = append(toks, query[i:i+2])
+			i += 2
+		default:
+			toks = append(toks, query[i:i+1])
+			i++
+		}
+	}
+	return toks
+}
+
+// Check if a byte can be part of a word or number.
+func isSQLWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// Check if a string is a two-byte operator.
+func isSQLOperator(s string) bool {
+	switch s {
+	case "<=", ">=", "!=", "<>":
+		return true
+	}
+	return false
+}
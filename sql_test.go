@@ -0,0 +1,123 @@
+package logdb
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestSQL_Query(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "sql_query", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		header := binary.LittleEndian.AppendUint64(nil, uint64(base.Add(time.Duration(i)*time.Minute).Unix()))
+		_, err := db.AppendWithHeader(header, []byte(fmt.Sprintf("entry-%v", i+1)))
+		assert.Nil(t, err, "expected no error in append")
+	}
+
+	sdb := sql.OpenDB(SQLConnector(db, SQLOptions{Timestamp: func(_ uint64, meta EntryMeta, _ []byte) time.Time {
+		return time.Unix(int64(binary.LittleEndian.Uint64(meta.Header)), 0).UTC()
+	}}))
+	defer sdb.Close()
+
+	// Every column.
+	var id int64
+	var ts time.Time
+	var metadata, payload []byte
+	err := sdb.QueryRow("SELECT * FROM entries WHERE id = 3").Scan(&id, &ts, &metadata, &payload)
+	assert.Nil(t, err, "expected no error in query")
+	assert.Equal(t, int64(3), id, "expected id")
+	assert.Equal(t, base.Add(2*time.Minute), ts, "expected timestamp")
+	assert.Equal(t, 8, len(metadata), "expected header")
+	assert.Equal(t, []byte("entry-3"), payload, "expected payload")
+
+	// Ranges, ordering, and limits.
+	assert.Equal(t, []int64{15, 14, 13}, queryIDs(t, sdb, "select id from entries where id >= ? and id < 16 order by id desc limit 3", 5), "expected newest in range first")
+	assert.Equal(t, []int64{1, 2, 4}, queryIDs(t, sdb, "SELECT id FROM entries WHERE id <> 3 AND id <= 4;"), "expected inequality to be checked")
+	assert.Equal(t, []int64(nil), queryIDs(t, sdb, "SELECT id FROM entries WHERE id > 20"), "expected no rows")
+
+	// Timestamps, as literals and as arguments.
+	assert.Equal(t, []int64{19, 20}, queryIDs(t, sdb, "SELECT id FROM entries WHERE timestamp >= '2020-01-01T00:18:00Z'"), "expected entries since time")
+	assert.Equal(t, []int64{1, 2}, queryIDs(t, sdb, "SELECT id FROM entries WHERE timestamp < ?", base.Add(2*time.Minute)), "expected entries before time")
+
+	// Counting.
+	var n int64
+	err = sdb.QueryRow("SELECT COUNT(*) FROM entries WHERE id > ? AND timestamp <= ?", 10, base.Add(15*time.Minute)).Scan(&n)
+	assert.Nil(t, err, "expected no error in count")
+	assert.Equal(t, int64(6), n, "expected count")
+}
+
+func TestSQL_NoMeta(t *testing.T) {
+	db := &InMemDB{}
+	filldb(t, db, 5)
+
+	sdb := sql.OpenDB(SQLConnector(db, SQLOptions{}))
+	defer sdb.Close()
+
+	var ts *time.Time
+	var metadata []byte
+	var payload string
+	err := sdb.QueryRow("SELECT timestamp, metadata, payload FROM entries ORDER BY id DESC").Scan(&ts, &metadata, &payload)
+	assert.Nil(t, err, "expected no error in query")
+	assert.True(t, ts == nil, "expected NULL timestamp")
+	assert.True(t, metadata == nil, "expected NULL metadata")
+	assert.Equal(t, "entry-4", payload, "expected newest entry")
+}
+
+func TestSQL_Errors(t *testing.T) {
+	sdb := sql.OpenDB(SQLConnector(&InMemDB{}, SQLOptions{}))
+	defer sdb.Close()
+
+	for _, query := range []string{
+		"DELETE FROM entries",
+		"SELECT id FROM other",
+		"SELECT id, FROM entries",
+		"SELECT id FROM entries WHERE payload = 1",
+		"SELECT id FROM entries WHERE id = 'x'",
+		"SELECT id FROM entries ORDER BY timestamp",
+		"SELECT id FROM entries LIMIT",
+	} {
+		_, err := sdb.Query(query)
+		assert.True(t, errors.As(err, new(*SQLError)), "expected an error for query: "+query)
+	}
+
+	_, err := sdb.Query("SELECT id FROM entries WHERE id > ?", -1)
+	assert.True(t, errors.As(err, new(*SQLError)), "expected an error for a negative id")
+	_, err = sdb.Query("SELECT id FROM entries WHERE timestamp > ?", 1)
+	assert.True(t, errors.As(err, new(*SQLError)), "expected an error for an integer timestamp")
+
+	_, err = sdb.Exec("SELECT id FROM entries")
+	assert.Equal(t, ErrSQLReadOnly, err, "expected statements to be refused")
+	_, err = sdb.Begin()
+	assert.Equal(t, ErrSQLReadOnly, err, "expected transactions to be refused")
+}
+
+////////// HELPERS //////////
+
+// Run a query returning IDs.
+func queryIDs(t *testing.T, sdb *sql.DB, query string, args ...interface{}) []int64 {
+	rows, err := sdb.Query(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
@@ -0,0 +1,72 @@
+package logdb
+
+import "time"
+
+// Stats describes the contents of a database and the disk space it uses, as returned by 'Stats'.
+type Stats struct {
+	// The number of entries, and the IDs of the oldest and newest. If there are no entries, all are 0.
+	Entries  uint64
+	OldestID uint64
+	NewestID uint64
+
+	// The number of chunks, including those awaiting deletion.
+	Chunks int
+
+	// The disk space used by the chunk files, including those awaiting deletion, but not those in the trash. As
+	// the space for data files is reserved in advance, every data file takes up the full chunk size. The size of
+	// the metadata files is estimated.
+	DiskBytes uint64
+
+	// The bytes of the entries from the oldest to the newest: this is what remains after 'Forget', and is at most
+	// 'DiskBytes'.
+	LiveBytes uint64
+
+	// The number of changes (entries appended, forgotten, or rolled back) since the last sync, and when that
+	// was. If there has been no sync since the database was opened, the time is zero.
+	Unsynced uint64
+	LastSync time.Time
+}
+
+// Stats is the thread-safe version of 'LockFreeChunkDB.Stats'.
+func (db *ChunkDB) Stats() Stats {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.Stats()
+}
+
+// Stats gets statistics about the database. This only uses the in-memory state, so is cheap enough to call
+// for monitoring.
+func (db *LockFreeChunkDB) Stats() Stats {
+	// A sync can run with only a read lock held, so hold the sync lock to stop chunks changing.
+	db.slock.Lock()
+	defer db.slock.Unlock()
+
+	s := Stats{Unsynced: db.sinceLastSync, LastSync: db.lastSync}
+	for _, c := range db.chunks {
+		if c.removed {
+			continue
+		}
+		s.Chunks++
+		s.DiskBytes += uint64(db.chunkSize) + uint64(len(c.ends))*metaRecordSize
+		if len(c.ends) == 0 || c.next() <= db.oldest {
+			continue
+		}
+		var start int32
+		if db.oldest > c.oldest {
+			start = c.ends[db.oldest-c.oldest-1]
+		}
+		s.LiveBytes += uint64(c.ends[len(c.ends)-1] - start)
+	}
+	if len(db.chunks) > 0 && db.next() > db.oldest {
+		s.OldestID = db.oldest
+		s.NewestID = db.next() - 1
+		s.Entries = db.next() - db.oldest
+	}
+	return s
+}
+
+////////// HELPERS //////////
+
+// The size of the metadata record of an entry: its index, end offset, and checksum.
+const metaRecordSize = 12
@@ -0,0 +1,36 @@
+package logdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestStats(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "stats", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	assert.Equal(t, Stats{}, db.Stats(), "expected empty stats")
+
+	vs := filldb(t, db, numEntries)
+	assertForget(t, db, 101)
+	assertSync(t, db)
+
+	stats := db.Stats()
+	assert.Equal(t, uint64(numEntries-100), stats.Entries, "expected number of entries")
+	assert.Equal(t, uint64(101), stats.OldestID, "expected oldest ID")
+	assert.Equal(t, uint64(numEntries), stats.NewestID, "expected newest ID")
+	assert.Equal(t, len(db.chunks), stats.Chunks, "expected number of chunks")
+	assert.True(t, stats.DiskBytes >= uint64(stats.Chunks)*chunkSize, "expected at least a chunk size per chunk")
+
+	var live uint64
+	for _, v := range vs[100:] {
+		live += uint64(len(v))
+	}
+	assert.Equal(t, live, stats.LiveBytes, "expected live bytes")
+	assert.Equal(t, uint64(0), stats.Unsynced, "expected nothing unsynced")
+	assert.True(t, time.Since(stats.LastSync) < time.Minute, "expected recent sync, got %v", stats.LastSync)
+
+	assertAppend(t, db, []byte("unsynced"))
+	assert.Equal(t, uint64(1), db.Stats().Unsynced, "expected one unsynced change")
+}
@@ -0,0 +1,95 @@
+package logdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStaleReadToken means that entries a 'ReadToken' covers have since been rolled back, so a reader holding it
+// has seen entries which no longer exist.
+var ErrStaleReadToken = errors.New("read token refers to rolled back entries")
+
+// A ReadToken records the history of a database as seen by a reader: the generation (see 'GenerationDB') and the
+// ID of the newest entry when it was issued. A server can hand a token out with the entries it serves, and have
+// clients echo it back, so that a client which has read entries that were then rolled back is told so with
+// 'ErrStaleReadToken', rather than silently reading the entries which replaced them.
+//
+// Read tokens are only meaningful for the database which issued them.
+type ReadToken struct {
+	Generation uint64
+	Newest     uint64
+}
+
+// String encodes a read token as text, in the form "<generation>.<newest>", for passing over the wire.
+func (t ReadToken) String() string {
+	return fmt.Sprintf("%v.%v", t.Generation, t.Newest)
+}
+
+// ParseReadToken decodes a read token encoded with 'ReadToken.String'.
+func ParseReadToken(s string) (ReadToken, error) {
+	var t ReadToken
+	if _, err := fmt.Sscanf(s, "%d.%d", &t.Generation, &t.Newest); err != nil || s != t.String() {
+		return ReadToken{}, fmt.Errorf("invalid read token %q", s)
+	}
+	return t, nil
+}
+
+// ReadToken is the thread-safe version of 'LockFreeChunkDB.ReadToken'.
+func (db *ChunkDB) ReadToken() ReadToken {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.ReadToken()
+}
+
+// ReadToken gets a read token for the current state of the database.
+func (db *LockFreeChunkDB) ReadToken() ReadToken {
+	return ReadToken{Generation: db.Generation(), Newest: db.newest}
+}
+
+// CheckReadToken is the thread-safe version of 'LockFreeChunkDB.CheckReadToken'.
+func (db *ChunkDB) CheckReadToken(tok ReadToken) error {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.CheckReadToken(tok)
+}
+
+// CheckReadToken checks that the entries a read token covers are unchanged since it was issued: that no rollback
+// since has removed any entry up to and including the token's newest entry. Entries which have been forgotten
+// are not changed, only gone. A zero token, for a reader which has not read anything yet, is always valid.
+//
+// Returns 'ErrStaleReadToken' if the entries have changed, or if the token is from a later generation than the
+// database (so was not issued by it), and 'ErrClosed' if the handle is closed.
+func (db *LockFreeChunkDB) CheckReadToken(tok ReadToken) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if tok.Generation > db.Generation() || db.RolledBackTo(tok.Generation) < tok.Newest {
+		return ErrStaleReadToken
+	}
+	return nil
+}
+
+// GetAt is the thread-safe version of 'LockFreeChunkDB.GetAt'.
+func (db *ChunkDB) GetAt(tok ReadToken, id uint64) ([]byte, ReadToken, error) {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.GetAt(tok, id)
+}
+
+// GetAt checks a read token with 'CheckReadToken' and then gets an entry, returning a read token for the current
+// state of the database to be echoed back with the next read.
+//
+// Returns the same errors as 'CheckReadToken' and 'Get'.
+func (db *LockFreeChunkDB) GetAt(tok ReadToken, id uint64) ([]byte, ReadToken, error) {
+	if err := db.CheckReadToken(tok); err != nil {
+		return nil, ReadToken{}, err
+	}
+	entry, err := db.Get(id)
+	if err != nil {
+		return nil, ReadToken{}, err
+	}
+	return entry, db.ReadToken(), nil
+}
@@ -0,0 +1,45 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestReadToken(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "read_token", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+	vs := filldb(t, db, numEntries)
+
+	entry, tok, err := db.GetAt(ReadToken{}, 100)
+	assert.Nil(t, err, "expected a zero token to be valid")
+	assert.Equal(t, vs[99], entry, "expected the entry")
+	assert.Equal(t, ReadToken{Generation: 0, Newest: numEntries}, tok, "expected a token for the current state")
+
+	// Forgetting, and rolling back entries newer than the token, leave it valid.
+	assertAppend(t, db, []byte("new"))
+	assertForget(t, db, 50)
+	assertRollback(t, db, numEntries)
+	_, tok2, err := db.GetAt(tok, 100)
+	assert.Nil(t, err, "expected the token to still be valid")
+
+	// Rolling back entries the token covers makes it stale, even once they have been replaced.
+	assertRollback(t, db, 200)
+	assertAppendEntries(t, db, vs)
+	_, _, err = db.GetAt(tok2, 100)
+	assert.Equal(t, ErrStaleReadToken, err, "expected the token to be stale")
+	assert.Nil(t, db.CheckReadToken(ReadToken{Generation: tok2.Generation, Newest: 200}), "expected a token covering only unchanged entries to be valid")
+	assert.Equal(t, ErrStaleReadToken, db.CheckReadToken(ReadToken{Generation: 100}), "expected a token from a later generation to be stale")
+}
+
+func TestReadToken_String(t *testing.T) {
+	tok := ReadToken{Generation: 3, Newest: 255}
+	parsed, err := ParseReadToken(tok.String())
+	assert.Nil(t, err, "expected no error parsing")
+	assert.Equal(t, tok, parsed, "expected the token to round-trip")
+
+	for _, s := range []string{"", "3", "3.", "3.255x", "-3.255", "3.255.1"} {
+		_, err := ParseReadToken(s)
+		assert.NotNil(t, err, "expected %q to be invalid", s)
+	}
+}
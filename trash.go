@@ -0,0 +1,230 @@
+package logdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Name of the directory deleted chunk files are moved to, if the trash is enabled.
+const trashDir = ".trash"
+
+// SetTrash is the thread-safe version of 'LockFreeChunkDB.SetTrash'.
+func (db *ChunkDB) SetTrash(grace time.Duration) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.LockFreeChunkDB.SetTrash(grace)
+}
+
+// SetTrash configures a recovery window for deleted chunks. When a sync would delete the files of a chunk
+// (because it was forgotten or rolled back), they are instead moved to the ".trash" subdirectory of the
+// database, and only deleted once they have been there for the grace period. While they remain, 'Undelete' can
+// bring forgotten entries back. 0, the default, disables the trash and deletes files immediately.
+//
+// Files are deleted from the trash when syncing. This does not persist when the database is closed, and files
+// left in the trash are not deleted until it is enabled again.
+func (db *LockFreeChunkDB) SetTrash(grace time.Duration) {
+	db.trashGrace = grace
+}
+
+// Undelete is the thread-safe version of 'LockFreeChunkDB.Undelete'.
+func (db *ChunkDB) Undelete() error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	return db.LockFreeChunkDB.Undelete()
+}
+
+// Undelete restores as many forgotten entries as possible: those in chunks which have not yet been deleted,
+// and those in chunks still in the trash (see 'SetTrash'). As chunks are restored whole, this may also bring
+// back entries which were forgotten before the most recent 'Forget'. Entries which have been rolled back are
+// not restored.
+//
+// Returns 'ErrClosed' if the handle is closed, a 'ReadError' or 'FormatError' value if a chunk in the trash
+// could not be opened, and a 'WriteError' value if its files could not be moved back.
+func (db *LockFreeChunkDB) Undelete() error {
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.checkFence(); err != nil {
+		return err
+	}
+	db.prune()
+	if len(db.chunks) == 0 {
+		return nil
+	}
+
+	// Chunks which are awaiting deletion can simply be kept.
+	for _, c := range db.chunks {
+		if !c.delete {
+			break
+		}
+		c.delete = false
+		db.pendingDeletes--
+		delete(db.syncDirty, c)
+	}
+	db.oldest = db.chunks[0].oldest
+
+	// Then restore chunks from the trash, newest-first, for as long as they continue on to the oldest chunk.
+	trashed, err := db.trashedChunks()
+	if err != nil {
+		return &ReadError{err}
+	}
+	for {
+		first := db.chunks[0]
+		num, _ := strconv.ParseUint(strings.Split(filepath.Base(first.path), sep)[1], 10, 0)
+		if num == 0 {
+			break
+		}
+		prefix := chunkPrefix + sep + strconv.FormatUint(num-1, 10) + sep
+		var restored *chunk
+		for name, dir := range trashed {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if restored, err = db.restoreChunk(dir, name); err != nil {
+				return err
+			}
+			if restored != nil {
+				delete(trashed, name)
+				break
+			}
+		}
+		if restored == nil {
+			break
+		}
+		db.chunks = append([]*chunk{restored}, db.chunks...)
+		db.oldest = restored.oldest
+	}
+
+	if err := writeFile(db.path+"/oldest", db.oldest); err != nil {
+		return &WriteError{err}
+	}
+	return db.checked("undelete", nil)
+}
+
+////////// HELPERS //////////
+
+// Move the files associated with a chunk into a directory.
+func (c *chunk) closeAndTrash(dir string) error {
+	_ = syscall.Munmap(c.bytes)
+	if err := c.mmapf.Close(); err != nil {
+		return err
+	}
+	return c.moveFiles(dir)
+}
+
+// Move the files associated with a closed chunk into a directory.
+func (c *chunk) moveFiles(dir string) error {
+	for _, path := range []string{c.path, c.metaFilePath(), c.sealFilePath()} {
+		if err := os.Rename(path, dir+"/"+filepath.Base(path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if dir := filepath.Dir(c.path); isBasenameShardDir(filepath.Base(dir)) {
+		_ = os.Remove(dir)
+	}
+	return nil
+}
+
+// Make a new directory in the trash for the chunks deleted by a sync. The directory is named after the current
+// time, so that it can be deleted once the grace period is over.
+func (db *LockFreeChunkDB) newTrashDir() (string, error) {
+	dir := db.path + "/" + trashDir + "/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	return dir, os.MkdirAll(dir, os.ModeDir|0755)
+}
+
+// Delete everything in the trash which has been there for longer than the grace period.
+func (db *LockFreeChunkDB) purgeTrash() error {
+	fis, err := ioutil.ReadDir(db.path + "/" + trashDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	cutoff := time.Now().Add(-db.trashGrace).UnixNano()
+	for _, fi := range fis {
+		trashed, err := strconv.ParseInt(fi.Name(), 10, 64)
+		if err != nil || trashed > cutoff {
+			continue
+		}
+		if err := os.RemoveAll(db.path + "/" + trashDir + "/" + fi.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find the chunk data files in the trash, as a map from name to directory. If a name has been trashed more than
+// once, the most recent is used.
+func (db *LockFreeChunkDB) trashedChunks() (map[string]string, error) {
+	trashed := make(map[string]string)
+	dirs, err := ioutil.ReadDir(db.path + "/" + trashDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return trashed, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, len(dirs))
+	for i, fi := range dirs {
+		names[i] = fi.Name()
+	}
+	sort.Sort(sort.StringSlice(names))
+	for _, name := range names {
+		dir := db.path + "/" + trashDir + "/" + name
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			if isBasenameChunkDataFile(fi.Name()) {
+				trashed[fi.Name()] = dir
+			}
+		}
+	}
+	return trashed, nil
+}
+
+// Move a chunk out of the trash, if it continues on to the oldest chunk in the database. Returns nil if it does
+// not.
+func (db *LockFreeChunkDB) restoreChunk(dir, name string) (*chunk, error) {
+	fi, err := os.Stat(dir + "/" + name)
+	if err != nil {
+		return nil, &ReadError{err}
+	}
+	c, err := openChunkFile(dir, fi, nil, db.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	_ = syscall.Munmap(c.bytes)
+	_ = c.mmapf.Close()
+	if len(c.ends) == 0 || c.next() != db.chunks[0].oldest {
+		return nil, nil
+	}
+
+	to := chunkDir(db.path, name)
+	if err := os.MkdirAll(to, os.ModeDir|0755); err != nil {
+		return nil, &WriteError{err}
+	}
+	if err := c.moveFiles(to); err != nil {
+		return nil, &WriteError{err}
+	}
+	fi, err = os.Stat(to + "/" + name)
+	if err != nil {
+		return nil, &ReadError{err}
+	}
+	c, err = openChunkFile(to, fi, nil, db.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,50 @@
+package logdb
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestTrash_Undelete(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "trash_undelete", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	vs := filldb(t, db, numEntries)
+	db.SetTrash(time.Hour)
+	assertForget(t, db, 200)
+	assertSync(t, db)
+
+	fis, err := ioutil.ReadDir("test_db/trash_undelete/" + trashDir)
+	assert.Nil(t, err, "expected a trash directory")
+	assert.Equal(t, 1, len(fis), "expected deleted chunks to be in the trash")
+
+	assert.Nil(t, db.Undelete(), "expected no error in undelete")
+	assert.Equal(t, uint64(1), db.OldestID(), "expected all entries to be restored")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after undeleting")
+	}
+	assert.Nil(t, db.Verify(), "expected no problems")
+}
+
+func TestTrash_Purge(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "trash_purge", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	db.SetTrash(time.Nanosecond)
+	assertForget(t, db, 200)
+	assertSync(t, db)
+	assertSync(t, db)
+
+	fis, err := ioutil.ReadDir("test_db/trash_purge/" + trashDir)
+	assert.Nil(t, err, "expected a trash directory")
+	assert.Equal(t, 0, len(fis), "expected the trash to be emptied")
+
+	oldest := db.OldestID()
+	assert.Nil(t, db.Undelete(), "expected no error in undelete")
+	assert.True(t, db.OldestID() > 1, "expected purged entries not to be restored")
+	assert.True(t, db.OldestID() <= oldest, "expected oldest entry not to move forwards")
+}
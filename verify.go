@@ -0,0 +1,293 @@
+package logdb
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Verify checks the database files for consistency. See 'LockFreeChunkDB.Verify' for details.
+func (db *ChunkDB) Verify() error {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.Verify()
+}
+
+// Verify cross-checks the files on disk against the in-memory state of the database, and reports every
+// divergence found. There are no indexes which are not part of the primary data, so this checks that:
+//
+//   - every chunk data file exists and is the correct size;
+//   - every chunk metadata file can be read, and agrees with the in-memory entry offsets for all entries
+//     which have been synced;
+//   - entry offsets are monotonically increasing;
+//   - chunks contain a contiguous sequence of entries;
+//   - the "oldest" file, if it can be read, does not refer to an entry newer than the oldest entry; and
+//   - sealed chunks have not been modified since they were sealed, including their data checksum.
+//
+// Returns a 'VerifyError' value wrapping all the problems found, and 'ErrClosed' if the handle is closed.
+func (db *LockFreeChunkDB) Verify() error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	results, _ := db.verifyChunks(VerifyOptions{}, func() {})
+	var collected []VerifyResult
+	for r := range results {
+		collected = append(collected, r)
+	}
+	sort.Slice(collected, func(i, j int) bool { return collected[i].index < collected[j].index })
+
+	var errs []error
+	for _, r := range collected {
+		errs = append(errs, r.Errs...)
+	}
+	if len(errs) > 0 {
+		return &VerifyError{errs}
+	}
+	return nil
+}
+
+// VerifyIntegrity checks every entry against its checksum. See 'LockFreeChunkDB.VerifyIntegrity' for details.
+func (db *ChunkDB) VerifyIntegrity() error {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return db.LockFreeChunkDB.VerifyIntegrity()
+}
+
+// VerifyIntegrity checks every entry against the checksum recorded when it was written, to detect silent
+// corruption of the chunk data files. This reads the whole database, so can be slow. 'Get' checks the entry it
+// reads in the same way, and opening with 'WithVerify(VerifyAll)' calls this.
+//
+// Returns a 'VerifyError' value wrapping a 'ChecksumError' value for each corrupt entry, and 'ErrClosed' if the
+// handle is closed.
+func (db *LockFreeChunkDB) VerifyIntegrity() error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	var errs []error
+	for _, c := range db.chunks {
+		var start int32
+		for i, end := range c.ends {
+			entry, err := c.slice(start, end)
+			if err != nil {
+				return err
+			}
+			if !sumMatches(entry, c.sums[i]) {
+				errs = append(errs, &ChecksumError{ChunkFilePath: c.path, ID: c.oldest + uint64(i)})
+			}
+			start = end
+		}
+	}
+	if len(errs) > 0 {
+		return &VerifyError{errs}
+	}
+	return nil
+}
+
+// VerifyOptions configure 'VerifyChunks'.
+type VerifyOptions struct {
+	// Maximum number of chunks to check at once. If this is <= 0, 'runtime.NumCPU()' is used.
+	Concurrency int
+
+	// Stop checking once a problem has been found. Chunks which are already being checked are finished.
+	StopOnError bool
+}
+
+// VerifyResult is the outcome of one check made by 'VerifyChunks'.
+type VerifyResult struct {
+	// Path to the chunk data file checked, or to the database directory for the checks which are not specific
+	// to a chunk.
+	Path string
+
+	// The problems found, if any.
+	Errs []error
+
+	// Position in the order 'Verify' reports problems.
+	index int
+}
+
+// VerifyChunks performs the same checks as 'Verify', but checks chunks in parallel. See
+// 'LockFreeChunkDB.VerifyChunks' for details.
+func (db *ChunkDB) VerifyChunks(opts VerifyOptions) (<-chan VerifyResult, func()) {
+	db.rwlock.RLock()
+	return db.LockFreeChunkDB.verifyChunks(opts, db.rwlock.RUnlock)
+}
+
+// VerifyChunks performs the same checks as 'Verify', but checks chunks in parallel, sending a result for each
+// chunk as it completes. The checks which are not specific to a chunk are made first, and sent as a result for
+// the database directory. The channel is closed once all checks are done, or checking stops early.
+//
+// Checking can be aborted by calling the returned function, which may be called more than once. Chunks which
+// are already being checked are finished, but their results are not sent. Until the channel is closed, the
+// database cannot be synced, so the caller must either read until the channel is closed, or abort.
+//
+// If the handle is closed, a single result with 'ErrClosed' is sent.
+func (db *LockFreeChunkDB) VerifyChunks(opts VerifyOptions) (<-chan VerifyResult, func()) {
+	return db.verifyChunks(opts, func() {})
+}
+
+// Check a single chunk against its files, returning all the problems found. Assumes the sync lock is held.
+func (db *LockFreeChunkDB) verifyChunk(c *chunk, prior *chunk) []error {
+	var errs []error
+
+	if fi, err := os.Stat(c.path); err != nil {
+		errs = append(errs, &ReadError{err})
+	} else if fi.Size() != int64(db.chunkSize) {
+		errs = append(errs, &ChunkSizeError{
+			ChunkFilePath: c.path,
+			Expected:      db.chunkSize,
+			Actual:        uint32(fi.Size()),
+		})
+	}
+
+	if prior != nil && c.oldest != prior.next() {
+		errs = append(errs, &ChunkContinuityError{
+			ChunkFilePath: c.path,
+			Expected:      prior.next(),
+			Actual:        c.oldest,
+		})
+	}
+
+	var lastEnd int32
+	for _, end := range c.ends {
+		if end < lastEnd {
+			errs = append(errs, &ChunkMetaError{
+				ChunkFilePath: c.path,
+				Err:           &MetaOffsetError{Expected: lastEnd, Actual: end},
+			})
+		}
+		lastEnd = end
+	}
+
+	if err := c.checkSeal(true); err != nil {
+		errs = append(errs, err)
+	}
+
+	mfile, err := os.Open(c.metaFilePath())
+	if err != nil {
+		return append(errs, &ReadError{err})
+	}
+	defer mfile.Close()
+	ends, _, err := readMetadata(mfile)
+	if err != nil {
+		return append(errs, &ChunkMetaError{ChunkFilePath: c.path, Err: err})
+	}
+
+	// Entries from 'newFrom' onwards have not been synced, so the metadata file may legitimately disagree
+	// about them. Everything before must match.
+	synced := c.newFrom
+	if synced > len(c.ends) {
+		synced = len(c.ends)
+	}
+	if len(ends) < synced {
+		errs = append(errs, &ChunkMetaError{
+			ChunkFilePath: c.path,
+			Err:           &MetaContinuityError{Expected: int32(synced), Actual: int32(len(ends))},
+		})
+		synced = len(ends)
+	}
+	for i := 0; i < synced; i++ {
+		if ends[i] != c.ends[i] {
+			errs = append(errs, &MetaDivergenceError{
+				ChunkFilePath: c.path,
+				Index:         int32(i),
+				Expected:      c.ends[i],
+				Actual:        ends[i],
+			})
+		}
+	}
+
+	return errs
+}
+
+// Check chunks in parallel, calling 'unlock' once done.
+func (db *LockFreeChunkDB) verifyChunks(opts VerifyOptions, unlock func()) (<-chan VerifyResult, func()) {
+	out := make(chan VerifyResult)
+	stop := make(chan struct{})
+	var once sync.Once
+	cancel := func() { once.Do(func() { close(stop) }) }
+
+	send := func(r VerifyResult) bool {
+		select {
+		case out <- r:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	if db.closed {
+		go func() {
+			defer unlock()
+			defer close(out)
+			send(VerifyResult{Path: db.path, Errs: []error{ErrClosed}})
+		}()
+		return out, cancel
+	}
+
+	// Syncing writes out metadata, so hold the sync lock to get a consistent view. This is claimed before
+	// returning so that the view is of the database as it is now.
+	db.slock.Lock()
+	chunks := db.chunks
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	go func() {
+		defer unlock()
+		defer db.slock.Unlock()
+		defer close(out)
+
+		var errs []error
+		var oldest uint64
+		if err := readFile(db.path+"/oldest", &oldest); err == nil && oldest > db.oldest {
+			errs = append(errs, &OldestDivergenceError{Expected: db.oldest, Actual: oldest})
+		}
+		if !send(VerifyResult{Path: db.path, Errs: errs, index: len(chunks)}) || (opts.StopOnError && len(errs) > 0) {
+			return
+		}
+
+		var failed int32
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+		var prior *chunk
+	loop:
+		for i, c := range chunks {
+			// Chunks awaiting deletion have been forgotten, and so aren't checked.
+			if c.delete {
+				continue
+			}
+			if opts.StopOnError && atomic.LoadInt32(&failed) != 0 {
+				break
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				break loop
+			}
+
+			wg.Add(1)
+			go func(i int, c, prior *chunk) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				errs := db.verifyChunk(c, prior)
+				if len(errs) > 0 {
+					atomic.StoreInt32(&failed, 1)
+				}
+				send(VerifyResult{Path: c.path, Errs: errs, index: i})
+			}(i, c, prior)
+			prior = c
+		}
+		wg.Wait()
+	}()
+
+	return out, cancel
+}
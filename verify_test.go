@@ -0,0 +1,174 @@
+package logdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestVerify_Ok(t *testing.T) {
+	for dbName, dbType := range dbTypes {
+		if _, ok := dbType.(PersistDB); !ok {
+			continue
+		}
+
+		t.Logf("Database: %s\n", dbName)
+		func() {
+			db := assertOpen(t, dbType, true, "verify_ok", chunkSize)
+			defer assertClose(t, db)
+
+			filldb(t, db, numEntries)
+			assertTruncate(t, db, 20, 200)
+			assert.Nil(t, db.(verifier).Verify(), "expected no problems before sync")
+
+			assertSync(t, db.(PersistDB))
+			assert.Nil(t, db.(verifier).Verify(), "expected no problems after sync")
+		}()
+	}
+}
+
+func TestVerify_MetaDivergence(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "verify_meta_divergence", chunkSize)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	assertSync(t, db.(PersistDB))
+
+	// Rewrite the first entry of the first chunk with a bogus end. The chunk is sealed, so its files are
+	// read-only.
+	metaPath := "test_db/verify_meta_divergence/" + initialMetaFile
+	if err := os.Chmod(metaPath, 0644); err != nil {
+		t.Fatal("could not make metadata writable:", err)
+	}
+	if err := appendFile(metaPath, []int32{0, 3, 0}); err != nil {
+		t.Fatal("could not write metadata:", err)
+	}
+
+	err := db.(verifier).Verify()
+	assert.True(t, errors.As(err, new(*VerifyError)), "expected verify error, got: %s", err)
+	assert.True(t, errors.As(err, new(*MetaDivergenceError)), "expected divergence error, got: %s", err)
+}
+
+func TestVerify_MissingChunk(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "verify_missing_chunk", chunkSize)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	assertSync(t, db.(PersistDB))
+
+	if err := os.Remove("test_db/verify_missing_chunk/" + initialChunkFile); err != nil {
+		t.Fatal("could not delete chunk file:", err)
+	}
+
+	err := db.(verifier).Verify()
+	assert.True(t, errors.As(err, new(*ReadError)), "expected read error, got: %s", err)
+}
+
+func TestVerify_OldestDivergence(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "verify_oldest_divergence", chunkSize)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	assertSync(t, db.(PersistDB))
+
+	if err := writeFile("test_db/verify_oldest_divergence/oldest", uint64(numEntries)); err != nil {
+		t.Fatal("could not write oldest file:", err)
+	}
+
+	err := db.(verifier).Verify()
+	assert.True(t, errors.As(err, new(*OldestDivergenceError)), "expected oldest divergence error, got: %s", err)
+}
+
+func TestVerify_Chunks(t *testing.T) {
+	db := assertOpen(t, dbTypes["chunkdb"], true, "verify_chunks", chunkSize).(*ChunkDB)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	assertSync(t, db)
+
+	results, _ := db.VerifyChunks(VerifyOptions{Concurrency: 3})
+	paths := make(map[string]bool)
+	for r := range results {
+		assert.Equal(t, 0, len(r.Errs), "expected no problems in %s", r.Path)
+		paths[r.Path] = true
+	}
+	assert.Equal(t, len(db.chunks)+1, len(paths), "expected a result for every chunk and the database")
+	assert.True(t, paths["test_db/verify_chunks"], "expected a result for the database")
+}
+
+func TestVerify_ChunksStopOnError(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "verify_chunks_stop", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+	assertSync(t, db)
+
+	if err := writeFile("test_db/verify_chunks_stop/oldest", uint64(numEntries)); err != nil {
+		t.Fatal("could not write oldest file:", err)
+	}
+
+	results, _ := db.VerifyChunks(VerifyOptions{StopOnError: true})
+	var count int
+	for r := range results {
+		assert.Equal(t, 1, len(r.Errs), "expected oldest divergence")
+		count++
+	}
+	assert.Equal(t, 1, count, "expected checking to stop after the first problem")
+}
+
+func TestVerify_ChunksAbort(t *testing.T) {
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "verify_chunks_abort", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+
+	filldb(t, db, numEntries)
+
+	results, cancel := db.VerifyChunks(VerifyOptions{Concurrency: 1})
+	<-results
+	cancel()
+	cancel()
+	for range results {
+	}
+
+	// The sync lock must have been released.
+	assertSync(t, db)
+}
+
+type verifier interface {
+	Verify() error
+}
+
+func TestVerify_OpenFailureClosesChunks(t *testing.T) {
+	before := countFDs(t)
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "verify_open_failure", chunkSize).(*LockFreeChunkDB)
+	filldb(t, db, numEntries)
+	final := db.chunks[len(db.chunks)-1]
+	assertClose(t, db)
+
+	// Corrupt an entry of the final chunk, so that full verification fails once every chunk has been opened.
+	file, err := os.OpenFile(final.path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{'x'}, 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	for i := 0; i < 10; i++ {
+		_, err := OpenWithOptions("test_db/verify_open_failure", WithVerify(VerifyAll))
+		assert.True(t, errors.As(err, new(*ChecksumError)), "expected checksum error, got: %s", err)
+	}
+	assert.Equal(t, before, countFDs(t), "expected the chunks to be closed after full verification fails")
+
+	// Delete the final chunk data file once it has been opened, so that only final chunk verification notices.
+	remove := ChunkHooks{Opened: func(c ChunkInfo) {
+		if c.DataFilePath == final.path {
+			_ = os.Remove(c.DataFilePath)
+		}
+	}}
+	_, err = OpenWithOptions("test_db/verify_open_failure", WithVerify(VerifyFinalChunk), WithChunkHooks(remove))
+	assert.True(t, errors.As(err, new(*VerifyError)), "expected verify error, got: %s", err)
+	assert.Equal(t, before, countFDs(t), "expected the chunks to be closed after final chunk verification fails")
+}
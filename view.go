@@ -0,0 +1,104 @@
+package logdb
+
+// AppendOnly wraps a 'LogDB' so that entries can only be appended and read. The underlying database is not
+// reachable from the result, so a type assertion cannot recover 'Forget', 'Rollback', or 'Truncate': give this
+// to components which must never destroy history.
+func AppendOnly(db LogDB) AppendOnlyDB {
+	return appendOnlyView{readOnlyView{db}}
+}
+
+// ReadOnlyView wraps a 'LogDB' so that entries can only be read. As with 'AppendOnly', the underlying database
+// cannot be recovered from the result.
+func ReadOnlyView(db LogDB) ReadOnlyDB {
+	return readOnlyView{db}
+}
+
+// WriterView wraps a 'LogDB' so that entries can only be appended, read, and synced. As with 'AppendOnly', the
+// underlying database cannot be recovered from the result. If the database is not a 'PersistDB', 'Sync' does
+// nothing.
+func WriterView(db LogDB) WriterDB {
+	return writerView{appendOnlyView{readOnlyView{db}}}
+}
+
+// AdminView wraps a 'LogDB' so that it can be read, forgotten, rolled back, truncated, and synced, but not
+// appended to or closed. As with 'AppendOnly', the underlying database cannot be recovered from the result. If
+// the database is not a 'PersistDB', 'SetSync' and 'Sync' do nothing.
+//
+// Appending is left to the writer, so 'Append' and 'AppendEntries' always fail with 'ErrNotPermitted'.
+func AdminView(db LogDB) PersistDB {
+	return adminView{readOnlyView{db}}
+}
+
+////////// HELPERS //////////
+
+// The views hold the database in an unexported field, and each only has the methods it permits, so that a view
+// cannot be converted into a more powerful one.
+
+type readOnlyView struct{ db LogDB }
+
+func (v readOnlyView) Get(id uint64) ([]byte, error) {
+	return v.db.Get(id)
+}
+
+func (v readOnlyView) OldestID() uint64 {
+	return v.db.OldestID()
+}
+
+func (v readOnlyView) NewestID() uint64 {
+	return v.db.NewestID()
+}
+
+type appendOnlyView struct{ readOnlyView }
+
+func (v appendOnlyView) Append(entry []byte) (uint64, error) {
+	return v.db.Append(entry)
+}
+
+func (v appendOnlyView) AppendEntries(entries [][]byte) (uint64, error) {
+	return v.db.AppendEntries(entries)
+}
+
+type writerView struct{ appendOnlyView }
+
+func (v writerView) Sync() error {
+	if pdb, ok := v.db.(PersistDB); ok {
+		return pdb.Sync()
+	}
+	return nil
+}
+
+type adminView struct{ readOnlyView }
+
+func (v adminView) Append(entry []byte) (uint64, error) {
+	return 0, ErrNotPermitted
+}
+
+func (v adminView) AppendEntries(entries [][]byte) (uint64, error) {
+	return 0, ErrNotPermitted
+}
+
+func (v adminView) Forget(newOldestID uint64) error {
+	return v.db.Forget(newOldestID)
+}
+
+func (v adminView) Rollback(newNewestID uint64) error {
+	return v.db.Rollback(newNewestID)
+}
+
+func (v adminView) Truncate(newOldestID, newNewestID uint64) error {
+	return v.db.Truncate(newOldestID, newNewestID)
+}
+
+func (v adminView) SetSync(every int) error {
+	if pdb, ok := v.db.(PersistDB); ok {
+		return pdb.SetSync(every)
+	}
+	return nil
+}
+
+func (v adminView) Sync() error {
+	if pdb, ok := v.db.(PersistDB); ok {
+		return pdb.Sync()
+	}
+	return nil
+}
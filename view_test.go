@@ -0,0 +1,52 @@
+package logdb
+
+import (
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestAppendOnly(t *testing.T) {
+	inmem := &InMemDB{}
+	db := AppendOnly(inmem)
+
+	idx, err := db.AppendEntries([][]byte{[]byte("one"), []byte("two")})
+	assert.Nil(t, err, "expected no error in append")
+	assert.Equal(t, uint64(1), idx, "expected first ID")
+	v, err := db.Get(2)
+	assert.Nil(t, err, "expected no error in get")
+	assert.Equal(t, []byte("two"), v, "expected equal '[]byte' values")
+	assert.Equal(t, inmem.NewestID(), db.NewestID(), "expected newest ID of the underlying database")
+
+	_, ok := db.(LogDB)
+	assert.False(t, ok, "expected append-only handle not to be a 'LogDB'")
+
+	// Every 'LogDB' is an 'AppendOnlyDB'.
+	var _ AppendOnlyDB = inmem
+}
+
+func TestViews(t *testing.T) {
+	inmem := &InMemDB{}
+
+	writer := WriterView(inmem)
+	_, err := writer.AppendEntries([][]byte{[]byte("one"), []byte("two"), []byte("three")})
+	assert.Nil(t, err, "expected no error in append")
+	assert.Nil(t, writer.Sync(), "expected sync of a non-persistent database to do nothing")
+	_, ok := writer.(PersistDB)
+	assert.False(t, ok, "expected writer handle not to be a 'PersistDB'")
+
+	reader := ReadOnlyView(inmem)
+	v, err := reader.Get(1)
+	assert.Nil(t, err, "expected no error in get")
+	assert.Equal(t, []byte("one"), v, "expected equal '[]byte' values")
+	_, ok = reader.(AppendOnlyDB)
+	assert.False(t, ok, "expected read-only handle not to be an 'AppendOnlyDB'")
+
+	admin := AdminView(inmem)
+	_, err = admin.Append([]byte("four"))
+	assert.Equal(t, ErrNotPermitted, err, "expected admin handle not to append")
+	assert.Nil(t, admin.Forget(2), "expected no error in forget")
+	assert.Equal(t, uint64(2), reader.OldestID(), "expected forgetting to be seen through other views")
+	_, ok = admin.(CloseDB)
+	assert.False(t, ok, "expected admin handle not to be a 'CloseDB'")
+}
@@ -0,0 +1,117 @@
+package logdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+var writePaths = map[string]WritePath{
+	"mmap":  WritePathMmap,
+	"msync": WritePathMsync,
+	"write": WritePathWrite,
+}
+
+func TestWritePath(t *testing.T) {
+	for name, writePath := range writePaths {
+		t.Logf("Write path: %s\n", name)
+		path := "test_db/write_path_" + name
+		_ = os.RemoveAll(path)
+
+		db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(chunkSize), WithWritePath(writePath))
+		assert.Nil(t, err, "expected no error in open")
+		vs := filldb(t, db, numEntries)
+		assertClose(t, db)
+
+		db, err = OpenWithOptions(path, WithVerify(VerifyAll))
+		assert.Nil(t, err, "expected no error in reopen")
+		for i, v := range vs {
+			assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after reopening")
+		}
+		assertClose(t, db)
+	}
+}
+
+func TestRelaxedSync(t *testing.T) {
+	path := "test_db/relaxed_sync"
+	_ = os.RemoveAll(path)
+
+	db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(chunkSize), WithRelaxedSync(true))
+	assert.Nil(t, err, "expected no error in open")
+	vs := filldb(t, db, numEntries)
+	assertSync(t, db)
+	assert.Nil(t, db.Reopen(), "expected no error in reopen")
+	assert.True(t, db.relaxedSync, "expected relaxed sync to be kept")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after reopening")
+	}
+	assertClose(t, db)
+}
+
+func TestDurability(t *testing.T) {
+	durabilities := map[string]Durability{
+		"data sync": DurabilityDataSync,
+		"full":      DurabilityFull,
+		"dsync":     DurabilityDsync,
+		"none":      DurabilityNone,
+	}
+	for name, durability := range durabilities {
+		t.Logf("Durability: %s\n", name)
+		path := "test_db/durability_" + name
+		_ = os.RemoveAll(path)
+
+		db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(chunkSize), WithDurability(durability))
+		assert.Nil(t, err, "expected no error in open")
+		vs := filldb(t, db, numEntries)
+		assertSync(t, db)
+		assert.Nil(t, db.Reopen(), "expected no error in reopen")
+		assert.Equal(t, durability, db.Config().Durability, "expected the durability to be kept")
+		for i, v := range vs {
+			assert.Equal(t, v, assertGet(t, db, uint64(i+1)), "expected equal values after reopening")
+		}
+		assertAppend(t, db, []byte("after reopening"))
+		assertClose(t, db)
+
+		db, err = OpenWithOptions(path, WithVerify(VerifyAll))
+		assert.Nil(t, err, "expected no error in open")
+		assert.Equal(t, []byte("after reopening"), assertGet(t, db, numEntries+1), "expected the entry appended after reopening")
+		assertClose(t, db)
+	}
+}
+
+func benchWritePath(b *testing.B, writePath WritePath) {
+	path := fmt.Sprintf("test_db/bench_write_path_%v", writePath)
+	_ = os.RemoveAll(path)
+	defer os.RemoveAll(path)
+
+	db, err := OpenWithOptions(path, WithCreate(true), WithWritePath(writePath))
+	if err != nil {
+		b.Fatal("could not open database:", err)
+	}
+	defer db.Close()
+	if err := db.SetSync(16); err != nil {
+		b.Fatal("could not set sync period:", err)
+	}
+
+	entry := []byte("tiny")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := db.Append(entry); err != nil {
+			b.Fatal("could not append:", err)
+		}
+	}
+}
+
+func BenchmarkWritePath_Mmap(b *testing.B) {
+	benchWritePath(b, WritePathMmap)
+}
+
+func BenchmarkWritePath_Msync(b *testing.B) {
+	benchWritePath(b, WritePathMsync)
+}
+
+func BenchmarkWritePath_Write(b *testing.B) {
+	benchWritePath(b, WritePathWrite)
+}
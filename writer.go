@@ -0,0 +1,148 @@
+package logdb
+
+import (
+	"fmt"
+	"os"
+)
+
+// A ChunkWriter writes entries into chunk data and metadata files in the format used by 'LockFreeChunkDB',
+// without opening a database: there is no lock, and no sync policy. The files can then be adopted into a
+// database with 'ImportChunks'. This makes it possible to build many ranges of a large database in parallel.
+//
+// Each chunk is built up in memory and written out, and synced, once full. The final chunk is written out by
+// 'Close'. A 'ChunkWriter' is not safe for concurrent use.
+type ChunkWriter struct {
+	dir          string
+	chunkSize    uint32
+	chunkEntries uint32
+
+	// Number of the next chunk file, and the ID of the next entry.
+	num  uint64
+	next uint64
+
+	// The chunk being built: its oldest entry ID, contents, and entry ends and checksums.
+	oldest uint64
+	buf    []byte
+	ends   []int32
+	sums   []uint32
+
+	paths  []string
+	closed bool
+}
+
+// NewChunkWriter creates a 'ChunkWriter' writing chunks of the given size into the given directory, which is
+// created if it does not exist. Entry IDs start from 'firstID', which must continue on from the newest entry of
+// the database the chunks will be imported into.
+//
+// Returns a 'PathError' value if the directory could not be created, 'ErrIDOutOfRange' if 'firstID' is 0, and
+// 'ErrChunkSizeTooBig' if the chunk size is too big for this platform.
+func NewChunkWriter(dir string, chunkSize uint32, firstID uint64) (*ChunkWriter, error) {
+	if firstID == 0 {
+		return nil, ErrIDOutOfRange
+	}
+	if chunkSize > maxChunkSize {
+		return nil, ErrChunkSizeTooBig
+	}
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return nil, &PathError{err}
+	}
+	return &ChunkWriter{
+		dir:       dir,
+		chunkSize: chunkSize,
+		next:      firstID,
+		oldest:    firstID,
+		buf:       make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// SetChunkEntries sets the most entries a chunk may hold, which must be no more than the limit of the database
+// the chunks will be imported into (see 'WithChunkEntries'). The default, 0, is no limit beyond the most entries
+// the chunk metadata can record.
+func (w *ChunkWriter) SetChunkEntries(entries uint32) {
+	w.chunkEntries = entries
+}
+
+// Append adds an entry to the chunk being built, writing it out and starting a new one if there is not enough
+// space or the chunk has as many entries as it may hold, and returns the ID of the entry.
+//
+// Returns 'ErrTooBig' if the entry is larger than the chunk size, a 'WriteError' value if a chunk could not be
+// written, and 'ErrClosed' if the writer is closed.
+func (w *ChunkWriter) Append(entry []byte) (uint64, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if uint32(len(entry)) > w.chunkSize {
+		return 0, ErrTooBig
+	}
+
+	if uint32(len(w.buf)+len(entry)) > w.chunkSize || w.full() {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	w.buf = append(w.buf, entry...)
+	w.ends = append(w.ends, int32(len(w.buf)))
+	w.sums = append(w.sums, checksum(entry))
+	w.next++
+	return w.next - 1, nil
+}
+
+// NextID gets the ID the next entry appended will have.
+func (w *ChunkWriter) NextID() uint64 {
+	return w.next
+}
+
+// Paths gets the paths of the chunk data files written so far, in order. This is the argument to give to
+// 'ImportChunks' after closing the writer.
+func (w *ChunkWriter) Paths() []string {
+	return append([]string(nil), w.paths...)
+}
+
+// Close writes out the final chunk, if it has any entries.
+//
+// Returns a 'WriteError' value if the chunk could not be written, and 'ErrClosed' if the writer is already
+// closed.
+func (w *ChunkWriter) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	if len(w.ends) == 0 {
+		return nil
+	}
+	return w.flush()
+}
+
+////////// HELPERS //////////
+
+// Check if the chunk being built is full, as 'LockFreeChunkDB.chunkFull'.
+func (w *ChunkWriter) full() bool {
+	entries := len(w.ends)
+	return entries >= maxChunkEntries || w.chunkEntries > 0 && uint32(entries) >= w.chunkEntries
+}
+
+// Write out the chunk being built, and start a new one.
+func (w *ChunkWriter) flush() error {
+	path := fmt.Sprintf("%s/%s%s%v%s%v", w.dir, chunkPrefix, sep, w.num, sep, w.oldest)
+
+	// As in a database, the data is written before the metadata.
+	data := w.buf[:cap(w.buf)]
+	for i := len(w.buf); i < len(data); i++ {
+		data[i] = 0
+	}
+	if err := writeFile(path, data); err != nil {
+		return &WriteError{err}
+	}
+	if err := writeFile(metaFilePath(path), encodeMetadata(nil, w.ends, w.sums, nil, nil, 0)); err != nil {
+		return &WriteError{err}
+	}
+
+	w.paths = append(w.paths, path)
+	w.num++
+	w.oldest = w.next
+	w.buf = w.buf[:0]
+	w.ends = nil
+	w.sums = nil
+	return nil
+}
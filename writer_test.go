@@ -0,0 +1,84 @@
+package logdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/barrucadu/logdb/internal/assert"
+)
+
+func TestWriter_Import(t *testing.T) {
+	_ = os.RemoveAll("test_db/writer_import_chunks")
+
+	db := assertOpen(t, dbTypes["lock free chunkdb"], true, "writer_import", chunkSize).(*LockFreeChunkDB)
+	defer assertClose(t, db)
+	assertAppend(t, db, []byte("existing"))
+
+	w, err := NewChunkWriter("test_db/writer_import_chunks", chunkSize, db.NewestID()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := make([][]byte, numEntries)
+	for i := range vs {
+		vs[i] = []byte(fmt.Sprintf("written-%v", i))
+		id, err := w.Append(vs[i])
+		assert.Nil(t, err, "expected no error in append")
+		assert.Equal(t, uint64(i+2), id, "expected IDs to continue from the database")
+	}
+	_, err = w.Append(make([]byte, chunkSize+1))
+	assert.Equal(t, ErrTooBig, err, "expected too-big entry to be rejected")
+	assert.Nil(t, w.Close(), "expected no error in close")
+	assert.Equal(t, ErrClosed, w.Close(), "expected second close to fail")
+
+	assert.Nil(t, db.ImportChunks(w.Paths()), "expected no error in import")
+	assert.Equal(t, uint64(numEntries+1), db.NewestID(), "expected all written entries to be imported")
+	for i, v := range vs {
+		assert.Equal(t, v, assertGet(t, db, uint64(i+2)), "expected written entry")
+	}
+	assert.Nil(t, db.Verify(), "expected no problems after import")
+}
+
+func TestWriter_ChunkEntries(t *testing.T) {
+	_ = os.RemoveAll("test_db/writer_chunk_entries_chunks")
+
+	path := "test_db/writer_chunk_entries"
+	_ = os.RemoveAll(path)
+	db, err := OpenWithOptions(path, WithCreate(true), WithChunkSize(1024), WithChunkEntries(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer assertClose(t, db)
+
+	// Chunks with more entries than the database allows are rejected.
+	w, err := NewChunkWriter("test_db/writer_chunk_entries_chunks", 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 15; i++ {
+		_, err := w.Append([]byte("entry"))
+		assert.Nil(t, err, "expected no error in append")
+	}
+	assert.Nil(t, w.Close(), "expected no error in close")
+	err = db.ImportChunks(w.Paths())
+	var cerr *ChunkEntriesError
+	assert.True(t, errors.As(err, &cerr), "expected a 'ChunkEntriesError' value, got %v", err)
+	assert.Equal(t, uint32(15), cerr.Actual, "expected the number of entries in the chunk")
+
+	// With the same limit as the database, the writer starts a new chunk at the limit.
+	_ = os.RemoveAll("test_db/writer_chunk_entries_chunks")
+	w, err = NewChunkWriter("test_db/writer_chunk_entries_chunks", 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetChunkEntries(10)
+	for i := 0; i < 15; i++ {
+		_, err := w.Append([]byte("entry"))
+		assert.Nil(t, err, "expected no error in append")
+	}
+	assert.Nil(t, w.Close(), "expected no error in close")
+	assert.Equal(t, 2, len(w.Paths()), "expected a new chunk at the entry limit")
+	assert.Nil(t, db.ImportChunks(w.Paths()), "expected no error in import")
+	assert.Equal(t, uint64(15), db.NewestID(), "expected all written entries to be imported")
+}